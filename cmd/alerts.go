@@ -0,0 +1,92 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+const defaultSMTPPort = 587
+
+// syncAlert represents the anomalies of a sync run with the run and the
+// earlier ones it's compared with
+type syncAlert struct {
+	Sample    syncSample
+	Previous  *syncSample
+	Baseline  syncBaseline
+	Anomalies []string
+}
+
+// subject returns the subject line of the alert
+func (a syncAlert) subject() string {
+	return fmt.Sprintf("heatmap: %d anomalies in sync %s", len(a.Anomalies), a.Sample.RunID)
+}
+
+// text renders the alert as the differential report of the run: its
+// counts next to the ones of the previous run and of the baseline
+func (a syncAlert) text() string {
+	b := &bytes.Buffer{}
+	fmt.Fprintf(b, "The sync %s at %s found:\n", a.Sample.RunID, a.Sample.Time.Format(time.RFC3339))
+	for _, anomaly := range a.Anomalies {
+		fmt.Fprintf(b, "  - %s\n", anomaly)
+	}
+
+	previous := func(n func(syncSample) int64) string {
+		if a.Previous == nil {
+			return "-"
+		}
+		return strconv.FormatInt(n(*a.Previous), 10)
+	}
+	fmt.Fprintf(b, "\n%-14s %8s %9s %9s\n", "", "this run", "previous", "baseline")
+	fmt.Fprintf(b, "%-14s %8d %9s %9.1f\n", "new mappings", a.Sample.NewMappings, previous(func(s syncSample) int64 { return int64(s.NewMappings) }), a.Baseline.NewMappings)
+	fmt.Fprintf(b, "%-14s %8d %9s %9.1f\n", "new PRs", a.Sample.NewPRs, previous(func(s syncSample) int64 { return int64(s.NewPRs) }), a.Baseline.NewPRs)
+	fmt.Fprintf(b, "%-14s %8d %9s %9s\n", "requests", a.Sample.Requests, previous(func(s syncSample) int64 { return s.Requests }), "-")
+	fmt.Fprintf(b, "%-14s %8d %9s %9s\n", "failures", a.Sample.Failures, previous(func(s syncSample) int64 { return s.Failures }), "-")
+	if a.Baseline.Runs > 0 {
+		fmt.Fprintf(b, "\nThe baseline is the mean of the last %d successful runs.\n", a.Baseline.Runs)
+	}
+
+	return b.String()
+}
+
+// sendSyncAlert emails the alert to alerts.email.to, if it's set. The
+// SMTP host must be in network.allow, and no mail is sent offline.
+func sendSyncAlert(a syncAlert) error {
+	to := viper.GetStringSlice("alerts.email.to")
+	if len(to) == 0 {
+		return nil
+	}
+
+	viper.SetDefault("alerts.email.smtp.port", defaultSMTPPort)
+	host := viper.GetString("alerts.email.smtp.host")
+	from := viper.GetString("alerts.email.from")
+	if host == "" || from == "" {
+		return fmt.Errorf("alerts.email.smtp.host and alerts.email.from must be set with alerts.email.to")
+	}
+	if err := newPolicyTransport(nil).check(host); err != nil {
+		return err
+	}
+
+	var auth smtp.Auth
+	if user := viper.GetString("alerts.email.smtp.username"); user != "" {
+		auth = smtp.PlainAuth("", user, viper.GetString("alerts.email.smtp.password"), host)
+	}
+
+	msg := &bytes.Buffer{}
+	fmt.Fprintf(msg, "From: %s\r\n", from)
+	fmt.Fprintf(msg, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(msg, "Subject: %s\r\n", a.subject())
+	fmt.Fprintf(msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	msg.WriteString(strings.ReplaceAll(a.text(), "\n", "\r\n"))
+
+	addr := net.JoinHostPort(host, strconv.Itoa(viper.GetInt("alerts.email.smtp.port")))
+
+	return smtp.SendMail(addr, auth, from, to, msg.Bytes())
+}
@@ -0,0 +1,37 @@
+package cmd
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// analyzeCmd represents the analyze command
+var analyzeCmd = &cobra.Command{
+	Use:   "analyze",
+	Short: "Analyzes the collected bug heat",
+	Long: `Groups the analyses which are built on top of the
+collected mappings and diffs.`,
+}
+
+func init() {
+	rootCmd.AddCommand(analyzeCmd)
+}
+
+// parsePRKey parses a PR given as owner/name#id, the format of prKey
+func parsePRKey(s string) (Repo, int, error) {
+	i := strings.LastIndex(s, "#")
+	j := strings.LastIndex(s[:i+1], "/")
+	if i < 0 || j <= 0 || j+1 >= i {
+		return Repo{}, 0, fmt.Errorf("PR %q is not in the owner/name#id format", s)
+	}
+
+	id, err := strconv.Atoi(s[i+1:])
+	if err != nil {
+		return Repo{}, 0, fmt.Errorf("PR %q is not in the owner/name#id format", s)
+	}
+
+	return Repo{Owner: s[:j], Name: s[j+1 : i]}, id, nil
+}
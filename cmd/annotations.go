@@ -0,0 +1,346 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v2"
+)
+
+// annotateCmd represents the annotate command
+var annotateCmd = &cobra.Command{
+	Use:   "annotate",
+	Short: "Imports and exports the annotations of the files",
+	Long: `Groups the commands keeping the annotations of the files in the
+store: the accepted risks, the team mappings and the ignores. They're
+kept in a YAML file, e.g. in the repo of the workspace, so they can be
+reviewed in a PR and applied with annotate import.
+
+  accepted_risks:
+    - path: acme/billing/legacy/**
+      note: replaced by svc-invoices in Q3
+      by: alice
+      until: 2025-09-30
+  teams:
+    payments:
+      - svc-payments/**
+  ignores:
+    - path: "**/*_generated.go"
+      note: generated code
+
+The paths are patterns of owner/name/file or name/file, like the ones
+of the teams config key. The files of an accepted risk are marked as
+such in the reports, the files of an ignore are left out of them and
+the team mappings add to the ones of the teams config key. An
+annotation with until lapses after that day.`,
+}
+
+// annotateImportCmd represents the annotate import command
+var annotateImportCmd = &cobra.Command{
+	Use:   "import <file>",
+	Short: "Replaces the annotations with the ones of a YAML file",
+	Long: `Reads the annotations of the YAML file, see annotate, and
+replaces all annotations of the store with them, so the file is the
+only source of the annotations. With --dry-run the file is only
+validated, e.g. in the CI of the PR changing it.`,
+	Args: cobra.ExactArgs(1),
+	RunE: annotateImport,
+}
+
+// annotateExportCmd represents the annotate export command
+var annotateExportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Writes the annotations as a YAML file",
+	Long: `Writes the annotations of the store as the YAML file read by
+annotate import, to the file of --out or the standard output.`,
+	RunE: annotateExport,
+}
+
+// The kinds of the annotations
+const (
+	annotationAcceptedRisk = "accepted_risk"
+	annotationTeam         = "team"
+	annotationIgnore       = "ignore"
+)
+
+// annotationDate is the layout of until in the YAML file
+const annotationDate = "2006-01-02"
+
+var (
+	annotateDryRun bool
+	annotateOut    string
+	// heatAnnotations holds the active annotations, loaded by loadHeat
+	heatAnnotations []annotation
+)
+
+// annotation represents an annotation of the files matching a path
+// pattern
+type annotation struct {
+	Kind string `bson:"kind" json:"kind"`
+	Path string `bson:"path" json:"path"`
+	// Team is the team of a team mapping
+	Team string `bson:"team,omitempty" json:"team,omitempty"`
+	Note string `bson:"note,omitempty" json:"note,omitempty"`
+	By   string `bson:"by,omitempty" json:"by,omitempty"`
+	// Until is the last day of the annotation, zero if it doesn't lapse
+	Until time.Time `bson:"until,omitempty" json:"until,omitempty"`
+}
+
+// annotationEntry represents an accepted risk or an ignore of the YAML file
+type annotationEntry struct {
+	Path  string `yaml:"path"`
+	Note  string `yaml:"note,omitempty"`
+	By    string `yaml:"by,omitempty"`
+	Until string `yaml:"until,omitempty"`
+}
+
+// annotationFile represents the YAML file of the annotations
+type annotationFile struct {
+	AcceptedRisks []annotationEntry   `yaml:"accepted_risks,omitempty"`
+	Teams         map[string][]string `yaml:"teams,omitempty"`
+	Ignores       []annotationEntry   `yaml:"ignores,omitempty"`
+}
+
+func init() {
+	rootCmd.AddCommand(annotateCmd)
+	annotateCmd.AddCommand(annotateImportCmd)
+	annotateCmd.AddCommand(annotateExportCmd)
+	annotateImportCmd.Flags().BoolVar(&annotateDryRun, "dry-run", false, "only validate the file")
+	annotateExportCmd.Flags().StringVar(&annotateOut, "out", "", "file to write the annotations to (default is stdout)")
+}
+
+// parseAnnotations reads the annotations of the YAML file. The unknown
+// keys are rejected, so a typo doesn't drop an annotation silently.
+func parseAnnotations(raw []byte) ([]annotation, error) {
+	f := annotationFile{}
+	if err := yaml.UnmarshalStrict(raw, &f); err != nil {
+		return nil, err
+	}
+
+	annotations := make([]annotation, 0)
+	entries := func(kind string, list []annotationEntry) error {
+		for i, e := range list {
+			a := annotation{Kind: kind, Path: e.Path, Note: e.Note, By: e.By}
+			if e.Until != "" {
+				until, err := time.Parse(annotationDate, e.Until)
+				if err != nil {
+					return fmt.Errorf("%s %d: invalid until %q, expected YYYY-MM-DD", kind, i+1, e.Until)
+				}
+				a.Until = until
+			}
+			if err := checkAnnotationPath(a.Path); err != nil {
+				return fmt.Errorf("%s %d: %w", kind, i+1, err)
+			}
+			annotations = append(annotations, a)
+		}
+		return nil
+	}
+	if err := entries(annotationAcceptedRisk, f.AcceptedRisks); err != nil {
+		return nil, err
+	}
+
+	teams := make([]string, 0, len(f.Teams))
+	for team := range f.Teams {
+		teams = append(teams, team)
+	}
+	sort.Strings(teams)
+	for _, team := range teams {
+		for _, p := range f.Teams[team] {
+			if err := checkAnnotationPath(p); err != nil {
+				return nil, fmt.Errorf("team %s: %w", team, err)
+			}
+			annotations = append(annotations, annotation{Kind: annotationTeam, Path: p, Team: team})
+		}
+	}
+
+	if err := entries(annotationIgnore, f.Ignores); err != nil {
+		return nil, err
+	}
+
+	return annotations, nil
+}
+
+// checkAnnotationPath checks the path pattern of an annotation
+func checkAnnotationPath(p string) error {
+	if strings.Trim(p, "/") == "" {
+		return fmt.Errorf("the path is missing")
+	}
+	for _, segment := range strings.Split(p, "/") {
+		if _, err := path.Match(segment, ""); err != nil {
+			return fmt.Errorf("invalid path %q: %w", p, err)
+		}
+	}
+
+	return nil
+}
+
+// formatAnnotations writes the annotations as the YAML file
+func formatAnnotations(annotations []annotation) ([]byte, error) {
+	f := annotationFile{}
+	entry := func(a annotation) annotationEntry {
+		e := annotationEntry{Path: a.Path, Note: a.Note, By: a.By}
+		if !a.Until.IsZero() {
+			e.Until = a.Until.Format(annotationDate)
+		}
+		return e
+	}
+	for _, a := range annotations {
+		switch a.Kind {
+		case annotationAcceptedRisk:
+			f.AcceptedRisks = append(f.AcceptedRisks, entry(a))
+		case annotationTeam:
+			if f.Teams == nil {
+				f.Teams = make(map[string][]string)
+			}
+			f.Teams[a.Team] = append(f.Teams[a.Team], a.Path)
+		case annotationIgnore:
+			f.Ignores = append(f.Ignores, entry(a))
+		}
+	}
+
+	return yaml.Marshal(f)
+}
+
+// activeAnnotations drops the annotations which lapsed before the day of now
+func activeAnnotations(annotations []annotation, now time.Time) []annotation {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	active := make([]annotation, 0, len(annotations))
+	for _, a := range annotations {
+		if a.Until.IsZero() || !a.Until.Before(today) {
+			active = append(active, a)
+		}
+	}
+
+	return active
+}
+
+// annotationOf returns the first annotation of the kind matching the file
+func annotationOf(annotations []annotation, kind string, repo Repo, file string) (annotation, bool) {
+	paths := []string{
+		strings.Join([]string{repo.Owner, repo.Name, file}, "/"),
+		strings.Join([]string{repo.Name, file}, "/"),
+	}
+	for _, a := range annotations {
+		if a.Kind == kind && matchesAny([]string{a.Path}, paths) {
+			return a, true
+		}
+	}
+
+	return annotation{}, false
+}
+
+// ignoreAnnotated drops the diffs of the files of the ignores
+func ignoreAnnotated(prs []pr, annotations []annotation) []pr {
+	result := make([]pr, 0, len(prs))
+	for _, p := range prs {
+		diffs := make([]diff, 0, len(p.Diff))
+		for _, d := range p.Diff {
+			if _, ok := annotationOf(annotations, annotationIgnore, p.Repo, d.File); !ok {
+				diffs = append(diffs, d)
+			}
+		}
+		p.Diff = diffs
+		result = append(result, p)
+	}
+
+	return result
+}
+
+// annotatedTeams adds the team mappings of the annotations to the teams
+func annotatedTeams(teams map[string][]string, annotations []annotation) map[string][]string {
+	for _, a := range annotations {
+		if a.Kind == annotationTeam {
+			teams[a.Team] = append(teams[a.Team], a.Path)
+		}
+	}
+
+	return teams
+}
+
+// markAccepted marks the heat of the files of the accepted risks with the
+// notes of the risks
+func markAccepted(heat []fileHeat, annotations []annotation) {
+	for i := range heat {
+		if heat[i].Group != "" {
+			continue
+		}
+		if a, ok := annotationOf(annotations, annotationAcceptedRisk, heat[i].Repo, heat[i].File); ok {
+			heat[i].AcceptedRisk = a.Note
+			if heat[i].AcceptedRisk == "" {
+				heat[i].AcceptedRisk = "accepted"
+			}
+		}
+	}
+}
+
+func annotateImport(cmd *cobra.Command, args []string) error {
+	raw, err := os.ReadFile(args[0])
+	if err != nil {
+		return configError(err)
+	}
+	annotations, err := parseAnnotations(raw)
+	if err != nil {
+		return configError(fmt.Errorf("reading %s failed: %w", args[0], err))
+	}
+
+	counts := make(map[string]int)
+	for _, a := range annotations {
+		counts[a.Kind]++
+	}
+	if annotateDryRun {
+		slog.Info("annotations are valid", "accepted_risks", counts[annotationAcceptedRisk], "team_mappings", counts[annotationTeam], "ignores", counts[annotationIgnore])
+		return nil
+	}
+
+	ctx, cancel, st, err := openStore()
+	if err != nil {
+		return err
+	}
+	defer cancel()
+	defer closeStore(ctx, st)
+
+	if err := st.ReplaceAnnotations(ctx, annotations); err != nil {
+		return storageError(fmt.Errorf("writing annotations failed: %w", err))
+	}
+	slog.Info("annotations imported", "accepted_risks", counts[annotationAcceptedRisk], "team_mappings", counts[annotationTeam], "ignores", counts[annotationIgnore])
+
+	return nil
+}
+
+func annotateExport(cmd *cobra.Command, args []string) error {
+	ctx, cancel, st, err := openReadStore()
+	if err != nil {
+		return err
+	}
+	defer cancel()
+	defer closeStore(ctx, st)
+
+	annotations, err := st.Annotations(ctx)
+	if err != nil {
+		return storageError(fmt.Errorf("reading annotations failed: %w", err))
+	}
+	raw, err := formatAnnotations(annotations)
+	if err != nil {
+		return err
+	}
+
+	w := io.Writer(os.Stdout)
+	if annotateOut != "" {
+		f, err := os.Create(annotateOut)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	_, err = w.Write(raw)
+
+	return err
+}
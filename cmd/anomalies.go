@@ -0,0 +1,216 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+const (
+	defaultAnomalyQuietDays    = 21
+	defaultAnomalyVolumeFactor = 10.0
+	defaultAnomalyErrorRate    = 0.2
+	// anomalyMinRequests is the fewest requests of a run whose error rate
+	// counts
+	anomalyMinRequests = 20
+	// anomalyBaselineRuns is the number of the earlier successful runs
+	// the volume of a run is compared with
+	anomalyBaselineRuns = 10
+)
+
+// syncSample represents the outcome of a sync run
+type syncSample struct {
+	Time        time.Time `json:"time"`
+	RunID       string    `json:"run_id,omitempty"`
+	NewMappings int       `json:"new_mappings"`
+	NewPRs      int       `json:"new_prs"`
+	Requests    int64     `json:"requests"`
+	Failures    int64     `json:"failures"`
+	Error       string    `json:"error,omitempty"`
+}
+
+// syncBaseline represents the means of the earlier successful runs
+type syncBaseline struct {
+	Runs        int
+	NewMappings float64
+	NewPRs      float64
+}
+
+// anomalyRules represents the thresholds of the anomalies
+type anomalyRules struct {
+	quietDays    int
+	volumeFactor float64
+	errorRate    float64
+}
+
+func newAnomalyRules() anomalyRules {
+	viper.SetDefault("anomalies.quiet_days", defaultAnomalyQuietDays)
+	viper.SetDefault("anomalies.volume_factor", defaultAnomalyVolumeFactor)
+	viper.SetDefault("anomalies.error_rate", defaultAnomalyErrorRate)
+
+	return anomalyRules{
+		quietDays:    viper.GetInt("anomalies.quiet_days"),
+		volumeFactor: viper.GetFloat64("anomalies.volume_factor"),
+		errorRate:    viper.GetFloat64("anomalies.error_rate"),
+	}
+}
+
+func syncHistoryPath() string {
+	viper.SetDefault("manifest.dir", ".")
+
+	return filepath.Join(viper.GetString("manifest.dir"), ".heatmap-syncs.json")
+}
+
+func loadSyncHistory() ([]syncSample, error) {
+	path := syncHistoryPath()
+	raw, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return []syncSample{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	history := make([]syncSample, 0)
+	if err := json.Unmarshal(raw, &history); err != nil {
+		return nil, fmt.Errorf("reading %s failed: %w", path, err)
+	}
+
+	return history, nil
+}
+
+// saveSyncHistory appends the sample to the history, dropping the samples
+// older than storage.history
+func saveSyncHistory(history []syncSample, sample syncSample) error {
+	viper.SetDefault("storage.history", defaultStorageHistory)
+	since := sample.Time.Add(-viper.GetDuration("storage.history"))
+	kept := make([]syncSample, 0, len(history)+1)
+	for _, s := range history {
+		if s.Time.After(since) {
+			kept = append(kept, s)
+		}
+	}
+
+	path := syncHistoryPath()
+	raw, err := json.MarshalIndent(append(kept, sample), "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path+".tmp", raw, 0o600); err != nil {
+		return err
+	}
+
+	return os.Rename(path+".tmp", path)
+}
+
+// startSyncSample starts the sample of a run, which finishSyncSample
+// completes with the requests sent since
+func startSyncSample() syncSample {
+	return syncSample{
+		Time:     time.Now().UTC(),
+		RunID:    runID(),
+		Requests: atomic.LoadInt64(&httpAttempts.sent),
+		Failures: atomic.LoadInt64(&httpAttempts.failed),
+	}
+}
+
+func finishSyncSample(sample *syncSample, runErr error) {
+	sample.Requests = atomic.LoadInt64(&httpAttempts.sent) - sample.Requests
+	sample.Failures = atomic.LoadInt64(&httpAttempts.failed) - sample.Failures
+	if runErr != nil {
+		sample.Error = runErr.Error()
+	}
+}
+
+// baselineOf returns the means of the last successful runs of the history
+func baselineOf(history []syncSample) syncBaseline {
+	b := syncBaseline{}
+	for i := len(history) - 1; i >= 0 && b.Runs < anomalyBaselineRuns; i-- {
+		if history[i].Error != "" {
+			continue
+		}
+		b.Runs++
+		b.NewMappings += float64(history[i].NewMappings)
+		b.NewPRs += float64(history[i].NewPRs)
+	}
+	if b.Runs > 0 {
+		b.NewMappings /= float64(b.Runs)
+		b.NewPRs /= float64(b.Runs)
+	}
+
+	return b
+}
+
+// detect returns the anomalies of the run against the earlier runs
+func (r anomalyRules) detect(sample syncSample, history []syncSample) []string {
+	anomalies := make([]string, 0)
+	if sample.Error != "" {
+		anomalies = append(anomalies, fmt.Sprintf("the run failed: %s", sample.Error))
+	}
+
+	if r.quietDays > 0 && len(history) > 0 && sample.NewMappings == 0 {
+		quiet := history[0].Time
+		for _, s := range history {
+			if s.NewMappings > 0 {
+				quiet = s.Time
+			}
+		}
+		if days := int(sample.Time.Sub(quiet).Hours() / 24); days >= r.quietDays {
+			anomalies = append(anomalies, fmt.Sprintf("no new bugs mapped for %d days", days))
+		}
+	}
+
+	b := baselineOf(history)
+	if r.volumeFactor > 0 && b.NewPRs > 0 && float64(sample.NewPRs) >= r.volumeFactor*b.NewPRs && float64(sample.NewPRs) >= r.volumeFactor {
+		anomalies = append(anomalies, fmt.Sprintf("%d new PRs, %.1fx the mean of %.1f of the last %d runs", sample.NewPRs, float64(sample.NewPRs)/b.NewPRs, b.NewPRs, b.Runs))
+	}
+
+	if r.errorRate > 0 && sample.Requests >= anomalyMinRequests {
+		if rate := float64(sample.Failures) / float64(sample.Requests); rate >= r.errorRate {
+			anomalies = append(anomalies, fmt.Sprintf("%d of %d requests failed (%.0f%%)", sample.Failures, sample.Requests, rate*100))
+		}
+	}
+
+	return anomalies
+}
+
+// watchSync records the sample of the run, detects its anomalies and
+// alerts the owner of them. The ephemeral runs have no history, so only
+// the anomalies of the run itself are detected. A failure is only
+// logged, it mustn't fail the run.
+func watchSync(sample syncSample) {
+	history := []syncSample{}
+	if !ephemeral {
+		var err error
+		if history, err = loadSyncHistory(); err != nil {
+			slog.Warn("reading the sync history failed", "err", err)
+			history = []syncSample{}
+		}
+		if err := saveSyncHistory(history, sample); err != nil {
+			slog.Warn("recording the sync failed", "err", err)
+		}
+	}
+
+	anomalies := newAnomalyRules().detect(sample, history)
+	if len(anomalies) == 0 {
+		return
+	}
+	for _, a := range anomalies {
+		slog.Warn("sync anomaly", "anomaly", a)
+	}
+
+	var previous *syncSample
+	if len(history) > 0 {
+		previous = &history[len(history)-1]
+	}
+	if err := sendSyncAlert(syncAlert{Sample: sample, Previous: previous, Baseline: baselineOf(history), Anomalies: anomalies}); err != nil {
+		slog.Warn("sending the alert failed", "err", err)
+	}
+}
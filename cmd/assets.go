@@ -0,0 +1,50 @@
+package cmd
+
+import (
+	"embed"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/viper"
+)
+
+// reportAssets holds the template, the styles and the scripts of report
+// --format html. They're embedded into the binary and inlined into the
+// page, so neither the binary nor the page fetches anything from a CDN
+// and both work air-gapped.
+//
+//go:embed reportHTML.tmpl reportHTML.css reportHTML.js
+var reportAssets embed.FS
+
+// assetNames are the names of the embedded assets, as looked up in
+// assets.dir
+var assetNames = []string{"reportHTML.tmpl", "reportHTML.css", "reportHTML.js"}
+
+// readAsset returns the asset of the name from assets.dir if it's set and
+// has a file of the name, or the embedded one otherwise, so the directory
+// only needs the assets it customizes
+func readAsset(name string) ([]byte, error) {
+	if dir := viper.GetString("assets.dir"); dir != "" {
+		raw, err := os.ReadFile(filepath.Join(dir, name))
+		if err == nil {
+			return raw, nil
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return nil, configError(fmt.Errorf("reading the asset %s of assets.dir failed: %w", name, err))
+		}
+	}
+
+	return reportAssets.ReadFile(name)
+}
+
+// embeddedAsset returns the embedded asset of the name
+func embeddedAsset(name string) string {
+	raw, err := reportAssets.ReadFile(name)
+	if err != nil {
+		panic(err)
+	}
+
+	return string(raw)
+}
@@ -0,0 +1,301 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// unlabelCmd represents the unlabel command
+var unlabelCmd = &cobra.Command{
+	Use:   "unlabel",
+	Short: "Removes the labels applied by the labeling rules",
+	Long: `Removes the labels which the labeling rules of sync applied to
+the Jira issues, all of them or only the ones given with --label.
+The labels the issues had before are never removed.`,
+	RunE: unlabel,
+}
+
+const defaultLabelTop = 0.1
+
+// labelRule represents a rule of the labels.rules config key. The label
+// is applied to the new bugs whose PRs touch one of the top fraction of
+// the hottest files with at least min_bugs bugs.
+type labelRule struct {
+	Label   string  `mapstructure:"label"`
+	Top     float64 `mapstructure:"top"`
+	MinBugs int     `mapstructure:"min_bugs"`
+}
+
+// appliedLabel represents a label applied to an issue by a rule
+type appliedLabel struct {
+	Key     string    `json:"key"`
+	Label   string    `json:"label"`
+	Applied time.Time `json:"applied"`
+}
+
+// jiraLabelUpdate represents the body of a request adding or removing labels
+type jiraLabelUpdate struct {
+	Update struct {
+		Labels []map[string]string `json:"labels"`
+	} `json:"update"`
+}
+
+var (
+	labelDryRun   bool
+	unlabelLabels []string
+)
+
+func init() {
+	rootCmd.AddCommand(unlabelCmd)
+	unlabelCmd.Flags().StringSliceVar(&unlabelLabels, "label", nil, "only remove these labels")
+	unlabelCmd.Flags().BoolVar(&labelDryRun, "dry-run", false, "log the labels to remove without removing them")
+}
+
+// labelRules returns the rules of the labels.rules config key
+func labelRules() ([]labelRule, error) {
+	rules := make([]labelRule, 0)
+	if err := viper.UnmarshalKey("labels.rules", &rules); err != nil {
+		return nil, fmt.Errorf("invalid labels.rules: %w", err)
+	}
+	viper.SetDefault("tracker.type", defaultTrackerType)
+	if len(rules) > 0 && viper.GetString("tracker.type") != "jira" {
+		return nil, fmt.Errorf("labels.rules needs the jira tracker")
+	}
+
+	for i, r := range rules {
+		if r.Label == "" {
+			return nil, fmt.Errorf("rule %d of labels.rules has no label", i+1)
+		}
+		if r.Top == 0 {
+			rules[i].Top = defaultLabelTop
+		}
+		if rules[i].Top < 0 || rules[i].Top > 1 {
+			return nil, fmt.Errorf("top of label %s is not between 0 and 1", r.Label)
+		}
+	}
+
+	return rules, nil
+}
+
+func labelJournalPath() string {
+	viper.SetDefault("manifest.dir", ".")
+
+	return filepath.Join(viper.GetString("manifest.dir"), ".heatmap-labels.json")
+}
+
+func loadAppliedLabels() ([]appliedLabel, error) {
+	path := labelJournalPath()
+	raw, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return []appliedLabel{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	applied := make([]appliedLabel, 0)
+	if err := json.Unmarshal(raw, &applied); err != nil {
+		return nil, fmt.Errorf("reading %s failed: %w", path, err)
+	}
+
+	return applied, nil
+}
+
+func saveAppliedLabels(applied []appliedLabel) error {
+	path := labelJournalPath()
+	raw, err := json.MarshalIndent(applied, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path+".tmp", raw, 0o600); err != nil {
+		return err
+	}
+
+	return os.Rename(path+".tmp", path)
+}
+
+// hotFiles returns the keys of the top fraction of the files with at
+// least minBugs bugs, at least one file if there are any
+func hotFiles(heat []fileHeat, top float64, minBugs int) map[string]bool {
+	eligible := make([]fileHeat, 0, len(heat))
+	for _, h := range heat {
+		if h.Bugs >= minBugs {
+			eligible = append(eligible, h)
+		}
+	}
+
+	n := int(math.Ceil(top * float64(len(eligible))))
+	if n > len(eligible) {
+		n = len(eligible)
+	}
+	hot := make(map[string]bool, n)
+	for _, h := range eligible[:n] {
+		hot[fileKey(h.Repo, h.File)] = true
+	}
+
+	return hot
+}
+
+// planLabels returns the labels of the rules to apply to the bugs mapped
+// after the given ones. The bugs which had the label already are skipped.
+func planLabels(rules []labelRule, heat []fileHeat, mappings []mongoMapping, prs []pr, mapped map[string]bool) []appliedLabel {
+	files := make(map[string][]string)
+	for _, p := range prs {
+		k := prKey(p.Repo, p.PRID)
+		for _, d := range p.Diff {
+			files[k] = append(files[k], fileKey(p.Repo, d.File))
+		}
+	}
+
+	planned := make(map[appliedLabel]bool)
+	for _, r := range rules {
+		hot := hotFiles(heat, r.Top, r.MinBugs)
+		for _, m := range mappings {
+			if mapped[m.issueRef()] || m.IssueKey == "" || hasLabel(m.Labels, r.Label) {
+				continue
+			}
+			for _, f := range files[prKey(m.Repo, m.PRID)] {
+				if hot[f] {
+					planned[appliedLabel{Key: m.IssueKey, Label: r.Label}] = true
+					break
+				}
+			}
+		}
+	}
+
+	labels := make([]appliedLabel, 0, len(planned))
+	for l := range planned {
+		labels = append(labels, l)
+	}
+	sort.Slice(labels, func(i, j int) bool {
+		if labels[i].Key != labels[j].Key {
+			return labels[i].Key < labels[j].Key
+		}
+		return labels[i].Label < labels[j].Label
+	})
+
+	return labels
+}
+
+func hasLabel(labels []string, label string) bool {
+	for _, l := range labels {
+		if l == label {
+			return true
+		}
+	}
+
+	return false
+}
+
+// runAutoLabel applies the labels of the rules to the bugs mapped after
+// the given ones and returns the number of the applied labels. Every
+// applied label is recorded, so unlabel can remove it.
+func runAutoLabel(ctx context.Context, st store, rules []labelRule, mapped map[string]bool) (int, error) {
+	heat, prs, err := loadHeat(ctx, st)
+	if err != nil {
+		return 0, err
+	}
+	mappings, err := st.Mappings(ctx)
+	if err != nil {
+		return 0, storageError(fmt.Errorf("reading mappings failed: %w", err))
+	}
+
+	labels := planLabels(rules, heat, mappings, prs, mapped)
+	if labelDryRun {
+		for _, l := range labels {
+			slog.Info("would label issue", "issue", l.Key, "label", l.Label)
+		}
+		return 0, nil
+	}
+	if len(labels) == 0 {
+		return 0, nil
+	}
+
+	auth, err := jiraAuth()
+	if err != nil {
+		return 0, configError(err)
+	}
+	applied, err := loadAppliedLabels()
+	if err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for _, l := range labels {
+		if err := updateLabel(auth, l, "add"); err != nil {
+			return n, jiraError(err)
+		}
+		l.Applied = time.Now()
+		applied = append(applied, l)
+		if err := saveAppliedLabels(applied); err != nil {
+			return n, err
+		}
+		slog.Debug("issue labeled", "issue", l.Key, "label", l.Label)
+		n++
+	}
+
+	return n, nil
+}
+
+// updateLabel adds or removes the label of the issue
+func updateLabel(auth string, l appliedLabel, op string) error {
+	body := jiraLabelUpdate{}
+	body.Update.Labels = []map[string]string{{op: l.Label}}
+	if _, err := jiraPut(auth, "/rest/api/2/issue/"+l.Key, body); err != nil {
+		return fmt.Errorf("issue %s: updating labels failed: %w", l.Key, err)
+	}
+
+	return nil
+}
+
+func unlabel(cmd *cobra.Command, args []string) error {
+	applied, err := loadAppliedLabels()
+	if err != nil {
+		return err
+	}
+
+	auth := ""
+	if !labelDryRun {
+		if auth, err = jiraAuth(); err != nil {
+			return configError(err)
+		}
+	}
+
+	kept := make([]appliedLabel, 0)
+	removed := 0
+	for i, l := range applied {
+		if len(unlabelLabels) > 0 && !hasLabel(unlabelLabels, l.Label) {
+			kept = append(kept, l)
+			continue
+		}
+		if labelDryRun {
+			slog.Info("would remove label", "issue", l.Key, "label", l.Label)
+			continue
+		}
+		if err := updateLabel(auth, l, "remove"); err != nil {
+			kept = append(kept, applied[i:]...)
+			if err := saveAppliedLabels(kept); err != nil {
+				slog.Warn("recording the removed labels failed", "err", err)
+			}
+			return jiraError(err)
+		}
+		removed++
+	}
+	if labelDryRun {
+		return nil
+	}
+	slog.Info("labels removed", "count", removed)
+
+	return saveAppliedLabels(kept)
+}
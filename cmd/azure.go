@@ -0,0 +1,228 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+const (
+	defaultAzureHost  = "https://dev.azure.com"
+	azureAPIVersion   = "7.0"
+	defaultAzureQuery = "[System.WorkItemType] = 'Bug'"
+)
+
+// pullRequestArtifact matches the artifact link of an Azure Repos PR, which
+// ends with the escaped project ID, repo ID and PR number. None of the
+// VCS providers hosts the Azure Repos, so these PRs are skipped.
+var pullRequestArtifact = regexp.MustCompile(`(?i)^vstfs:///Git/PullRequestId/[^%/]+%2F[^%/]+%2F(\d+)$`)
+
+// azureMentionPattern matches the mentions of the work items in the PRs
+// which Azure Boards links, e.g. AB#123
+var azureMentionPattern = regexp.MustCompile(`\bAB#[0-9]+\b`)
+
+// azureKeyPattern matches the key of a work item, capturing its prefix
+// and its ID
+var azureKeyPattern = regexp.MustCompile(`^(.+)#([0-9]+)$`)
+
+// azureTracker finds the bugs with WIQL in Azure DevOps Boards and their
+// PRs in the hyperlinks of the work items
+type azureTracker struct {
+	host         string
+	organization string
+	auth         string
+	provider     vcsProvider
+}
+
+// wiqlResponse represents the result of a WIQL query
+type wiqlResponse struct {
+	WorkItems []struct {
+		ID int `json:"id"`
+	} `json:"workItems"`
+}
+
+// azureWorkItem represents the relations of a work item
+type azureWorkItem struct {
+	Relations []struct {
+		Rel        string `json:"rel"`
+		URL        string `json:"url"`
+		Attributes struct {
+			Name string `json:"name"`
+		} `json:"attributes"`
+	} `json:"relations"`
+}
+
+func newAzureTracker(provider vcsProvider) (*azureTracker, error) {
+	viper.SetDefault("azure.host", defaultAzureHost)
+
+	organization := viper.GetString("azure.organization")
+	if organization == "" {
+		return nil, fmt.Errorf("azure.organization is not set")
+	}
+
+	// A personal access token is sent as the password of an empty user
+	token := viper.GetString("azure.token")
+
+	return &azureTracker{
+		host:         strings.TrimSuffix(viper.GetString("azure.host"), "/"),
+		organization: organization,
+		auth:         base64.StdEncoding.EncodeToString([]byte(":" + token)),
+		provider:     provider,
+	}, nil
+}
+
+// searchBugs runs the WIQL of azure.query, by default selecting the
+// work items of the Bug type, in the team project
+func (t *azureTracker) searchBugs(project string, since time.Time) (*[]bug, error) {
+	condition := ""
+	if !since.IsZero() {
+		condition = fmt.Sprintf("[System.ChangedDate] >= '%s'", since.UTC().Add(-watermarkOverlap).Format("2006-01-02"))
+	}
+
+	bugs, err := t.queryBugs(project, condition)
+	if err != nil {
+		return nil, err
+	}
+
+	slog.Info("bugs found", "project", project, "count", len(bugs))
+
+	return &bugs, nil
+}
+
+// queryBugs returns the work items of azure.query in the team project
+// which match the WIQL condition too, unless it's empty
+func (t *azureTracker) queryBugs(project, condition string) ([]bug, error) {
+	viper.SetDefault("azure.query", defaultAzureQuery)
+
+	wiql := fmt.Sprintf("SELECT [System.Id] FROM WorkItems WHERE [System.TeamProject] = '%s' AND (%s)",
+		strings.ReplaceAll(project, "'", "''"), viper.GetString("azure.query"))
+	if condition != "" {
+		wiql += " AND " + condition
+	}
+
+	body, err := json.Marshal(map[string]string{"query": wiql})
+	if err != nil {
+		return nil, err
+	}
+
+	result := &wiqlResponse{}
+	endpoint := fmt.Sprintf("%s/%s/%s/_apis/wit/wiql", t.host, url.PathEscape(t.organization), url.PathEscape(project))
+	if err := t.do("POST", endpoint, body, result); err != nil {
+		return nil, err
+	}
+
+	bugs := make([]bug, 0, len(result.WorkItems))
+	for _, wi := range result.WorkItems {
+		bugs = append(bugs, bug{ID: wi.ID, Key: fmt.Sprintf("%s#%d", project, wi.ID), Tracker: "azure"})
+	}
+
+	return bugs, nil
+}
+
+// findBug returns the work item of a key, AB#123 like in the mentions of
+// Azure Boards or <project>#123 like the keys of the bugs, if it's one of
+// the bugs of the team project, or nil
+func (t *azureTracker) findBug(project, key string) (*bug, error) {
+	m := azureKeyPattern.FindStringSubmatch(key)
+	if m == nil || (m[1] != "AB" && m[1] != project) {
+		return nil, nil
+	}
+
+	bugs, err := t.queryBugs(project, "[System.Id] = "+m[2])
+	if err != nil || len(bugs) == 0 {
+		return nil, err
+	}
+
+	return &bugs[0], nil
+}
+
+// mentions returns the keys of the work items mentioned in the text of a
+// PR like Azure Boards links them, e.g. AB#123
+func (t *azureTracker) mentions(text string) []string {
+	return azureMentionPattern.FindAllString(text, -1)
+}
+
+// ping requests the first team project of the organization
+func (t *azureTracker) ping(ctx context.Context) error {
+	endpoint := fmt.Sprintf("%s/%s/_apis/projects?$top=1", t.host, url.PathEscape(t.organization))
+
+	return t.do("GET", endpoint, nil, &struct{}{})
+}
+
+// linkedPRs returns the PRs of the hosts of the VCS provider in the
+// hyperlinks of the work item, with their states as reported by the
+// provider. The Azure Repos PRs of the artifact links are skipped, as no
+// provider can collect their diffs.
+func (t *azureTracker) linkedPRs(b bug) (*[]jiraPR, error) {
+	wi := &azureWorkItem{}
+	endpoint := fmt.Sprintf("%s/%s/_apis/wit/workitems/%d?$expand=relations", t.host, url.PathEscape(t.organization), b.ID)
+	if err := t.do("GET", endpoint, nil, wi); err != nil {
+		return nil, err
+	}
+
+	prs := make([]jiraPR, 0)
+	for _, r := range wi.Relations {
+		switch {
+		case r.Rel == "ArtifactLink" && pullRequestArtifact.MatchString(r.URL):
+			slog.Debug("skipping Azure Repos PR", "issue", b.Key, "artifact", r.URL)
+		case r.Rel == "Hyperlink":
+			p := jiraPR{URL: strings.TrimSuffix(r.URL, "/")}
+			repo, id, err := t.provider.parsePR(p)
+			if err != nil {
+				continue
+			}
+
+			info, err := t.provider.info(context.Background(), repo, id)
+			if err != nil {
+				return nil, fmt.Errorf("resolving PR %s failed: %w", p.URL, err)
+			}
+			p.ID, p.Status = fmt.Sprintf("#%d", id), "OPEN"
+			if !info.MergedAt.IsZero() {
+				p.Status = "MERGED"
+			}
+			prs = append(prs, p)
+		}
+	}
+
+	if len(prs) == 0 {
+		return nil, errNoDevStatus
+	}
+
+	return &prs, nil
+}
+
+// do sends a request to the Azure DevOps API and decodes the response into v
+func (t *azureTracker) do(method, endpoint string, body []byte, v interface{}) error {
+	req, err := http.NewRequest(method, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Add("Authorization", fmt.Sprintf("Basic %s", t.auth))
+	req.Header.Add("Content-Type", "application/json")
+
+	q := req.URL.Query()
+	q.Set("api-version", azureAPIVersion)
+	req.URL.RawQuery = q.Encode()
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return responseError("Azure DevOps", resp)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(v)
+}
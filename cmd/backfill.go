@@ -2,31 +2,34 @@ package cmd
 
 import (
 	"context"
-	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
-	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 // backfillCmd represents the backfill command
 var backfillCmd = &cobra.Command{
 	Use:   "backfill",
 	Short: "Generates the mappings of Jira Issues and GitHub PRs",
-	Long: `Finds all current bugs in the specified Jira project
+	Long: `Finds all current bugs in the specified Jira projects
 and their corresponding GitHub PRs. After that writes these
-mappings into a MongoDB collection.`,
-	Run: backfill,
+mappings into the store.
+
+The bugs come from the tracker of tracker.type (jira, azure or
+linear) and are selected by --jql or jira.jql. The PRs linked to
+them are filtered by repos.allow and repos.deny; --repo limits the
+run to one repo. See the README for the configuration.`,
+	RunE: backfill,
 }
 
 var (
@@ -34,20 +37,32 @@ var (
 	jiraHost    string
 	jiraProject string
 	dbname      string
+	concurrency int
+	resume      bool
+	full        bool
+	jiraJQL     string
+)
+
+const (
+	defaultConcurrency           = 4
+	defaultJiraRequestsPerSecond = 10
+	watermarkOverlap             = 24 * time.Hour
+	defaultJiraJQL               = "type = Bug"
 )
 
+// errNoDevStatus is returned for issues without any linked PRs
+var errNoDevStatus = errors.New("dev status not found")
+
 // bug represents a separate jira issue/bug
 type bug struct {
-	ID  int    `json:"id,string"`
-	Key string `json:"key"`
-}
-
-// issuesResponse represents a response with issues
-type issuesResponse struct {
-	StartAt    int   `json:"startAt"`
-	MaxResults int   `json:"maxResults"`
-	Total      int   `json:"total"`
-	Issues     []bug `json:"issues"`
+	ID     int                        `json:"id,string"`
+	Key    string                     `json:"key"`
+	Fields map[string]json.RawMessage `json:"fields,omitempty"`
+	// Tracker is the issue tracker of the bug, empty for Jira
+	Tracker string `json:"tracker,omitempty"`
+	// UID is the ID of the bug in the trackers whose IDs aren't numbers,
+	// e.g. the UUID of a Linear issue, whose ID is 0 then
+	UID string `json:"uid,omitempty"`
 }
 
 // jiraPR is a representation of a PR data in Jira
@@ -66,198 +81,438 @@ type devStatusResponse struct {
 
 // mongoMapping represents a mapping of a Jira Isuse and a GitHub PR
 type mongoMapping struct {
-	ID      string `bson:"_id,omitempty"`
-	Project string `bson:"project"`
-	IssueID int    `bson:"issue_id"`
-	Repo    Repo   `bson:"repo"`
-	PRID    int    `bson:"pr_id"`
+	ID          string `bson:"_id,omitempty" json:"id,omitempty"`
+	Project     string `bson:"project" json:"project"`
+	Tracker     string `bson:"tracker,omitempty" json:"tracker,omitempty"`
+	IssueID     int    `bson:"issue_id" json:"issue_id"`
+	IssueUID    string `bson:"issue_uid,omitempty" json:"issue_uid,omitempty"`
+	IssueKey    string `bson:"issue_key,omitempty" json:"issue_key,omitempty"`
+	Repo        Repo   `bson:"repo" json:"repo"`
+	PRID        int    `bson:"pr_id" json:"pr_id"`
+	RequestType string `bson:"request_type,omitempty" json:"request_type,omitempty"`
+	SLABreached bool   `bson:"sla_breached,omitempty" json:"sla_breached,omitempty"`
+	// Origin tells whether the bug was reported by a customer or found
+	// internally, empty if it's not known
+	Origin string `bson:"origin,omitempty" json:"origin,omitempty"`
+
+	Summary     string       `bson:"summary,omitempty" json:"summary,omitempty"`
+	Priority    string       `bson:"priority,omitempty" json:"priority,omitempty"`
+	Components  []string     `bson:"components,omitempty" json:"components,omitempty"`
+	Labels      []string     `bson:"labels,omitempty" json:"labels,omitempty"`
+	FixVersions []fixVersion `bson:"fix_versions,omitempty" json:"fix_versions,omitempty"`
+	CreatedAt   time.Time    `bson:"created_at,omitempty" json:"created_at,omitempty"`
+	ResolvedAt  time.Time    `bson:"resolved_at,omitempty" json:"resolved_at,omitempty"`
+
+	Attachments       int `bson:"attachments,omitempty" json:"attachments,omitempty"`
+	DescriptionLength int `bson:"description_length,omitempty" json:"description_length,omitempty"`
+
+	// DuplicateOf is the key of the canonical issue whose PR the
+	// duplicate bug is folded into
+	DuplicateOf string `bson:"duplicate_of,omitempty" json:"duplicate_of,omitempty"`
 }
 
 func init() {
 	rootCmd.AddCommand(backfillCmd)
 	// TODO: take the default value from the config somehow
-	backfillCmd.Flags().StringVarP(&jiraProject, "project", "p", "Memberships", "Jira project name")
+	backfillCmd.Flags().StringVarP(&jiraProject, "project", "p", "Memberships", "Jira project name or a comma separated list of names")
+	backfillCmd.Flags().IntVar(&concurrency, "concurrency", defaultConcurrency, "number of parallel dev-status requests")
+	backfillCmd.Flags().BoolVar(&resume, "resume", false, "resume the interrupted run from its manifest")
+	backfillCmd.Flags().BoolVar(&full, "full", false, "check all bugs, ignoring the watermark of the last run")
+	backfillCmd.Flags().StringVar(&repoScope, "repo", "", "only map the PRs of this owner/name repo")
+	backfillCmd.Flags().StringVar(&jiraJQL, "jql", "", fmt.Sprintf("JQL selecting the issues of a project (default is jira.jql or %q)", defaultJiraJQL))
 }
 
-func backfill(cmd *cobra.Command, args []string) {
-	jiraHost = viper.GetString("jira.host")
-	jiraEmail := viper.GetString("jira.auth.email")
-	jiraToken := viper.GetString("jira.auth.token")
-	auth := base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%s:%s", jiraEmail, jiraToken)))
+func backfill(cmd *cobra.Command, args []string) error {
+	ctx, cancel, st, err := openStore()
+	if err != nil {
+		return err
+	}
+	defer cancel()
+	defer closeStore(ctx, st)
 
-	bugs := collectBugs(auth)
+	_, err = runBackfill(ctx, st, backfillProjects(cmd))
 
-	ctx, cancel, mongoClient := connectToMongo()
-	defer cancel()
-	defer func() {
-		if err := mongoClient.Disconnect(ctx); err != nil {
-			panic(err)
+	return err
+}
+
+// runBackfill maps the bugs of the projects and returns the number of
+// the new mappings
+func runBackfill(ctx context.Context, st store, projects []string) (total int, err error) {
+	defer func() { recordRun("backfill", total, err) }()
+
+	if err := checkRepoScope(); err != nil {
+		return 0, err
+	}
+	provider, err := newVCSProvider(ctx)
+	if err != nil {
+		return 0, configError(err)
+	}
+
+	tracker, err := newIssueTracker(provider)
+	if err != nil {
+		return 0, configError(err)
+	}
+
+	for _, project := range projects {
+		n, err := backfillProject(ctx, st, tracker, provider, project)
+		if err != nil {
+			return total, err
 		}
-	}()
+		total += n
+	}
 
-	jiraCollName := viper.GetString("mongo.collections.jira")
-	coll := mongoClient.Database(dbname).Collection(jiraCollName)
+	return total, nil
+}
 
-	alreadyMapped := getAlreadyMappedIssueIDs(ctx, coll)
-	newMappingsByIssueID := make(map[int]*[]jiraPR)
-	for _, b := range *bugs {
-		if _, ok := alreadyMapped[b.ID]; !ok {
-			if ds, err := findDevStatus(b, auth); err == nil {
-				newMappingsByIssueID[b.ID] = ds
-			}
+// backfillProjects returns the projects of the --project flag, a comma
+// separated list, or the ones in jira.projects if the flag isn't set
+func backfillProjects(cmd *cobra.Command) []string {
+	if !cmd.Flags().Changed("project") && viper.IsSet("jira.projects") {
+		return viper.GetStringSlice("jira.projects")
+	}
+
+	projects := make([]string, 0)
+	for _, p := range strings.Split(jiraProject, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			projects = append(projects, p)
+		}
+	}
+
+	return projects
+}
+
+// backfillProject maps the bugs of a single project. The mappings are
+// tagged with the project, while the diffs of the PRs shared with other
+// projects are still collected only once.
+func backfillProject(ctx context.Context, st store, tracker issueTracker, provider vcsProvider, project string) (int, error) {
+	var (
+		m   *manifest
+		err error
+	)
+	if resume {
+		m, err = loadManifest("backfill", project)
+		if errors.Is(err, errNoManifest) {
+			slog.Info("nothing to resume", "project", project)
+			return 0, nil
 		}
+		if err != nil {
+			return 0, err
+		}
+	} else if m, err = planBackfill(ctx, st, tracker, project); err != nil {
+		return 0, err
+	}
+	defer m.close()
+
+	rules, err := prSearchRules()
+	if err != nil {
+		return 0, configError(err)
+	}
+	var rule *prSearchRule
+	if r, ok := rules[project]; ok {
+		rule = &r
 	}
 
-	if len(newMappingsByIssueID) == 0 {
-		fmt.Println("No new mappings found")
-		return
+	if err := findDevStatuses(ctx, m, tracker, provider, rule); err != nil {
+		return 0, fmt.Errorf("project %s: %w", project, err)
 	}
 
-	newMappings := convertJiraMappingsToMongoMappings(newMappingsByIssueID)
+	newMappingsByIssueID, bugs, err := devStatusesFromManifest(m)
+	if err != nil {
+		return 0, err
+	}
+
+	newMappings := &[]mongoMapping{}
+	if len(newMappingsByIssueID) > 0 {
+		newMappings = convertJiraMappingsToMongoMappings(newMappingsByIssueID, bugs, provider, project)
+		setServiceDeskContext(*newMappings, bugs)
+		setIssueMetadata(*newMappings, bugs)
+	}
+	if foldDuplicates() {
+		folded, err := foldDuplicateMappings(ctx, st, m, *newMappings, project)
+		if err != nil {
+			return 0, fmt.Errorf("project %s: %w", project, err)
+		}
+		if len(folded) > 0 {
+			slog.Info("duplicates folded", "project", project, "mappings", len(folded))
+		}
+		*newMappings = append(*newMappings, folded...)
+	}
+
+	if len(newMappingsByIssueID) == 0 && len(*newMappings) == 0 {
+		slog.Info("no new mappings found", "project", project)
+		return 0, finishBackfill(ctx, st, m)
+	}
 	if len(*newMappings) == 0 {
-		fmt.Println("No new merged PRs found")
-		return
+		slog.Info("no new merged PRs found", "project", project)
+		return 0, finishBackfill(ctx, st, m)
 	}
 
-	docs := make([]interface{}, len(*newMappings))
-	for i, v := range *newMappings {
-		docs[i] = v
+	if err := st.InsertMappings(ctx, *newMappings); err != nil {
+		return 0, storageError(fmt.Errorf("project %s: writing mappings failed: %w", project, err))
 	}
+	savePayloads(ctx, st)
 
-	writeItemsToMongo(ctx, coll, docs)
+	return len(*newMappings), finishBackfill(ctx, st, m)
 }
 
-func collectBugs(auth string) *[]bug {
-	req, err := http.NewRequest("GET", fmt.Sprintf("%s/rest/api/latest/search", jiraHost), nil)
-	if err != nil {
-		panic(err)
+// finishBackfill moves the watermark of the project to the start of
+// the completed run and removes its manifest. A run limited by --repo
+// leaves the watermark, the PRs of the other repos aren't collected.
+func finishBackfill(ctx context.Context, st store, m *manifest) error {
+	if repoScope != "" {
+		return m.remove()
+	}
+	if err := st.SetWatermark(ctx, m.Scope, m.Created); err != nil {
+		return storageError(fmt.Errorf("writing watermark failed: %w", err))
 	}
-	req.Header.Add("Authorization", fmt.Sprintf("Basic %s", auth))
-	req.Header.Add("Content-Type", "application/json")
 
-	q := req.URL.Query()
-	// q.Add("jql", fmt.Sprintf("project = %q and type = Bug and statusCategory = Done", jiraProject))
-	q.Add("jql", fmt.Sprintf("project = %q and type = Bug", jiraProject))
-	q.Add("fields", "id,key")
-	q.Add("maxResults", "150")
-	req.URL.RawQuery = q.Encode()
+	return m.remove()
+}
 
-	resp, err := client.Do(req)
-	if err != nil {
-		panic(err)
+// planBackfill writes the manifest of the bugs which are not mapped yet.
+// Unless --full is set, only the bugs updated since the watermark of the
+// project are checked.
+func planBackfill(ctx context.Context, st store, tracker issueTracker, project string) (*manifest, error) {
+	// The run starts before collecting the bugs, so the next run
+	// doesn't miss the bugs updated in the meantime
+	m := newManifest("backfill", project)
+
+	var since time.Time
+	if !full {
+		var err error
+		if since, err = st.Watermark(ctx, project); err != nil {
+			return nil, storageError(fmt.Errorf("reading watermark failed: %w", err))
+		}
 	}
-	defer resp.Body.Close()
 
-	decoder := json.NewDecoder(resp.Body)
+	bugs, err := tracker.searchBugs(project, since)
+	if err != nil {
+		return nil, jiraError(fmt.Errorf("project %s: collecting bugs failed: %w", project, err))
+	}
 
-	bugs := &issuesResponse{}
-	err = decoder.Decode(bugs)
+	alreadyMapped, err := st.MappedIssues(ctx)
 	if err != nil {
-		panic(err)
+		return nil, storageError(fmt.Errorf("reading mapped issues failed: %w", err))
 	}
 
-	fmt.Printf("%+v\n", bugs)
+	for _, b := range *bugs {
+		if _, ok := alreadyMapped[b.ref()]; !ok {
+			if err := m.add(b.Key, b); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if err := m.start(); err != nil {
+		return nil, fmt.Errorf("writing manifest failed: %w", err)
+	}
 
-	return &bugs.Issues
+	return m, nil
 }
 
-func connectToMongo() (context.Context, context.CancelFunc, *mongo.Client) {
-	srv := viper.GetString("mongo.srv")
-	user := viper.GetString("mongo.user")
-	pass := viper.GetString("mongo.password")
-	dbname = viper.GetString("mongo.dbname")
+// devStatusesFromManifest collects the PRs found by the current and
+// the interrupted runs, along with their bugs, by the refs of the bugs
+func devStatusesFromManifest(m *manifest) (map[string]*[]jiraPR, map[string]bug, error) {
+	result := make(map[string]*[]jiraPR)
+	bugs := make(map[string]bug)
+	for _, item := range m.Items {
+		b := bug{}
+		if err := json.Unmarshal(item.Data, &b); err != nil {
+			return nil, nil, err
+		}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-	client, err := mongo.Connect(ctx, options.Client().ApplyURI(
-		fmt.Sprintf(srv, user, pass, dbname),
-	))
-	if err != nil {
-		log.Fatal(err)
+		prs := make([]jiraPR, 0)
+		if err := json.Unmarshal(item.Result, &prs); err != nil {
+			return nil, nil, err
+		}
+
+		if len(prs) > 0 {
+			result[b.ref()] = &prs
+			bugs[b.ref()] = b
+		}
 	}
 
-	return ctx, cancel, client
+	return result, bugs, nil
 }
 
-func getAlreadyMappedIssueIDs(ctx context.Context, collection *mongo.Collection) map[int]bool {
-	projection := options.Find().SetProjection(bson.M{"_id": 0, "issue_id": 1})
+// collectBugs searches the bugs of the project, updated since the given
+// time unless it's zero
+func collectBugs(auth, project string, since time.Time) (*[]bug, error) {
+	jql := projectJQL(project)
+	if !since.IsZero() {
+		// JQL dates are in the time zone of the user, so the overlap
+		// covers any offset from UTC
+		jql += fmt.Sprintf(" and updated >= %q", since.UTC().Add(-watermarkOverlap).Format("2006/01/02 15:04"))
+	}
 
-	cur, err := collection.Find(ctx, bson.D{}, projection)
+	bugs, err := searchIssues(auth, jql, bugFields())
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
-	defer cur.Close(ctx)
 
-	mappings := make(map[int]bool, 0)
-	for cur.Next(ctx) {
-		result := &mongoMapping{}
-		err := cur.Decode(&result)
-		if err != nil {
-			log.Fatal(err)
-		}
+	slog.Info("bugs found", "project", project, "count", len(bugs))
 
-		mappings[result.IssueID] = false
+	return &bugs, nil
+}
+
+// projectJQL returns the JQL selecting the bugs of the project
+func projectJQL(project string) string {
+	// The filter is wrapped, so an OR in it doesn't escape the project
+	return fmt.Sprintf("project = %q and (%s)", project, jqlFilter())
+}
+
+// bugFields returns the comma separated fields requested with the bugs
+func bugFields() string {
+	fields := append(append(append([]string{"id", "key"}, issueFields...), jsmFields()...), originFields()...)
+	if foldDuplicates() {
+		fields = append(fields, "issuelinks")
 	}
 
-	if err := cur.Err(); err != nil {
-		log.Fatal(err)
+	return strings.Join(fields, ",")
+}
+
+// jqlFilter returns the JQL selecting the issues of a project
+func jqlFilter() string {
+	if jiraJQL != "" {
+		return jiraJQL
 	}
+	viper.SetDefault("jira.jql", defaultJiraJQL)
 
-	return mappings
+	return viper.GetString("jira.jql")
 }
 
-func findDevStatus(b bug, auth string) (*[]jiraPR, error) {
-	req, err := http.NewRequest("GET", fmt.Sprintf("%s/rest/dev-status/latest/issue/detail", jiraHost), nil)
-	if err != nil {
-		panic(err)
+// findDevStatuses fetches the linked PRs of the pending bugs of the
+// manifest using a pool of workers, throttled to jira.requests_per_second
+// requests in total. The PRs of the bugs with none linked are searched by
+// the rule if there's one. The first failed fetch stops the pool.
+func findDevStatuses(ctx context.Context, m *manifest, tracker issueTracker, provider vcsProvider, rule *prSearchRule) error {
+	viper.SetDefault("jira.requests_per_second", defaultJiraRequestsPerSecond)
+	rps := viper.GetInt("jira.requests_per_second")
+	if rps < 1 {
+		rps = defaultJiraRequestsPerSecond
 	}
-	req.Header.Add("Content-Type", "application/json")
-	req.Header.Add("Authorization", fmt.Sprintf("Basic %s", auth))
+	throttle := time.NewTicker(time.Second / time.Duration(rps))
+	defer throttle.Stop()
 
-	q := req.URL.Query()
-	q.Add("issueId", strconv.Itoa(b.ID))
-	q.Add("applicationType", "GitHub")
-	q.Add("dataType", "pullrequest")
-	req.URL.RawQuery = q.Encode()
+	workers := concurrency
+	if workers < 1 {
+		workers = 1
+	}
 
-	resp, err := client.Do(req)
-	if err != nil {
-		panic(err)
+	queue := make(chan *manifestItem)
+	stop := make(chan struct{})
+	go func() {
+		defer close(queue)
+		for _, item := range m.pending() {
+			select {
+			case queue <- item:
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	var (
+		wg       sync.WaitGroup
+		stopOnce sync.Once
+		firstErr error
+	)
+	fail := func(err error) {
+		stopOnce.Do(func() {
+			firstErr = err
+			close(stop)
+		})
+	}
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for item := range queue {
+				b := bug{}
+				if err := json.Unmarshal(item.Data, &b); err != nil {
+					fail(err)
+					continue
+				}
+
+				<-throttle.C
+				ds, err := tracker.linkedPRs(b)
+				if errors.Is(err, errNoDevStatus) {
+					ds, err = searchLinkedPRs(ctx, provider, rule, b)
+					if err == nil {
+						slog.Debug("PRs found by search", "issue", b.Key, "prs", len(*ds))
+					} else if !errors.Is(err, errNoDevStatus) {
+						fail(vcsError(fmt.Errorf("issue %s: PR search failed: %w", b.Key, err)))
+						continue
+					}
+				}
+				if errors.Is(err, errNoDevStatus) {
+					ds, err = &[]jiraPR{}, nil
+				}
+				if err != nil {
+					fail(jiraError(fmt.Errorf("issue %s: dev-status fetch failed: %w", b.Key, err)))
+					continue
+				}
+
+				if err := m.markDone(item.Key, ds); err != nil {
+					fail(fmt.Errorf("writing manifest failed: %w", err))
+				}
+			}
+		}()
 	}
-	defer resp.Body.Close()
+	wg.Wait()
 
-	decoder := json.NewDecoder(resp.Body)
+	return firstErr
+}
+
+func findDevStatus(b bug, auth string, provider vcsProvider) (*[]jiraPR, error) {
+	q := url.Values{}
+	q.Add("issueId", strconv.Itoa(b.ID))
+	q.Add("applicationType", provider.applicationType())
+	q.Add("dataType", "pullrequest")
 
 	devStatus := &devStatusResponse{}
-	err = decoder.Decode(devStatus)
-	if err != nil {
-		panic(err)
+	if _, err := jiraGet(auth, "/rest/dev-status/latest/issue/detail", q, devStatus); err != nil {
+		return nil, err
 	}
 
-	if len(devStatus.Detail[0].PRs) == 0 {
-		return nil, errors.New("Dev status not found")
+	if len(devStatus.Detail) == 0 || len(devStatus.Detail[0].PRs) == 0 {
+		return nil, errNoDevStatus
 	}
 
 	return &devStatus.Detail[0].PRs, nil
 }
 
-func convertJiraMappingsToMongoMappings(jiraMappings map[int]*[]jiraPR) *[]mongoMapping {
+// convertJiraMappingsToMongoMappings maps the bugs, by their refs, to
+// their merged PRs
+func convertJiraMappingsToMongoMappings(jiraMappings map[string]*[]jiraPR, bugs map[string]bug, provider vcsProvider, project string) *[]mongoMapping {
 	result := make([]mongoMapping, 0)
+	repos := newRepoFilter()
 
 	for k, v := range jiraMappings {
+		b := bugs[k]
 		for _, pr := range *v {
 			if pr.Status != "MERGED" {
 				continue
 			}
 
-			repoURL := strings.Split(pr.URL, "/pull")[0]
-			repo := strings.Split(repoURL, "github.com/")[1]
-			repoParts := strings.Split(repo, "/")
+			repo, id, err := provider.parsePR(pr)
+			if err != nil {
+				slog.Warn("skipping PR", "issue_id", k, "err", err)
+				continue
+			}
+			if !repos.allows(repo) {
+				slog.Debug("skipping PR of a filtered repo", "issue_id", k, "repo", repo.Owner+"/"+repo.Name, "pr_id", id)
+				continue
+			}
 
 			var m mongoMapping
-			m.Project = jiraProject
-			m.IssueID = k
-			m.Repo = Repo{Owner: repoParts[0], Name: repoParts[1]}
-			m.PRID, _ = strconv.Atoi(pr.ID[1:])
+			m.Project = project
+			m.Tracker = b.Tracker
+			m.IssueID = b.ID
+			m.IssueUID = b.UID
+			m.Repo = repo
+			m.PRID = id
 
 			result = append(result, m)
 		}
@@ -265,12 +520,3 @@ func convertJiraMappingsToMongoMappings(jiraMappings map[int]*[]jiraPR) *[]mongo
 
 	return &result
 }
-
-func writeItemsToMongo(ctx context.Context, coll *mongo.Collection, docs []interface{}) {
-	res, err := coll.InsertMany(ctx, docs, nil)
-	if err != nil {
-		panic(err)
-	}
-
-	fmt.Printf("Inserted IDs (%d): %s\n", len(res.InsertedIDs), res.InsertedIDs)
-}
@@ -0,0 +1,257 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+const defaultBitbucketAPI = "https://api.bitbucket.org/2.0"
+
+// pullRequestURL matches a Bitbucket Cloud PR URL, capturing the
+// workspace, the repo slug and the PR number
+var pullRequestURL = regexp.MustCompile(`^https?://bitbucket\.org/([^/]+)/([^/]+)/pull-requests/(\d+)`)
+
+// bitbucketProvider fetches PR data from Bitbucket Cloud
+type bitbucketProvider struct {
+	api      string
+	username string
+	password string
+	mu       sync.Mutex
+	used     apiUsage
+}
+
+// bitbucketDiffstat is a representation of a page of the diffstat of a PR
+type bitbucketDiffstat struct {
+	Values []struct {
+		Status       string `json:"status"`
+		LinesAdded   int    `json:"lines_added"`
+		LinesRemoved int    `json:"lines_removed"`
+		Old          *struct {
+			Path string `json:"path"`
+		} `json:"old"`
+		New *struct {
+			Path string `json:"path"`
+		} `json:"new"`
+	} `json:"values"`
+	Next string `json:"next"`
+}
+
+// bitbucketPullRequest is a representation of the PR fields in use
+type bitbucketPullRequest struct {
+	State  string `json:"state"`
+	Author struct {
+		Nickname    string `json:"nickname"`
+		DisplayName string `json:"display_name"`
+	} `json:"author"`
+	UpdatedOn   time.Time `json:"updated_on"`
+	Destination struct {
+		Branch struct {
+			Name string `json:"name"`
+		} `json:"branch"`
+	} `json:"destination"`
+	Source struct {
+		Commit struct {
+			Hash string `json:"hash"`
+		} `json:"commit"`
+	} `json:"source"`
+}
+
+func newBitbucketProvider() *bitbucketProvider {
+	viper.SetDefault("bitbucket.api", defaultBitbucketAPI)
+
+	return &bitbucketProvider{
+		api:      strings.TrimSuffix(viper.GetString("bitbucket.api"), "/"),
+		username: viper.GetString("bitbucket.username"),
+		password: viper.GetString("bitbucket.app_password"),
+	}
+}
+
+func (b *bitbucketProvider) applicationType() string {
+	return "bitbucket"
+}
+
+// parsePR stores the workspace of a PR URL as the repo owner and the
+// repo slug as its name
+func (b *bitbucketProvider) parsePR(p jiraPR) (Repo, int, error) {
+	m := pullRequestURL.FindStringSubmatch(p.URL)
+	if m == nil {
+		return Repo{}, 0, fmt.Errorf("not a Bitbucket PR URL: %s", p.URL)
+	}
+
+	id, err := prNumber(p, m[3])
+	if err != nil {
+		return Repo{}, 0, err
+	}
+
+	return Repo{Owner: m[1], Name: m[2]}, id, nil
+}
+
+func (b *bitbucketProvider) listFiles(ctx context.Context, repo Repo, id int) ([]diff, error) {
+	diffs := make([]diff, 0)
+	next := fmt.Sprintf("%s/repositories/%s/%s/pullrequests/%d/diffstat?pagelen=100", b.api, url.PathEscape(repo.Owner), url.PathEscape(repo.Name), id)
+	for next != "" {
+		page := &bitbucketDiffstat{}
+		if err := b.get(ctx, next, page); err != nil {
+			return nil, err
+		}
+
+		for _, v := range page.Values {
+			d := diff{Status: v.Status, Additions: v.LinesAdded, Deletions: v.LinesRemoved}
+			if v.New != nil {
+				d.File = v.New.Path
+			} else if v.Old != nil {
+				d.File = v.Old.Path
+			}
+			d.Changes = d.Additions + d.Deletions
+
+			diffs = append(diffs, d)
+		}
+
+		next = page.Next
+	}
+
+	return diffs, nil
+}
+
+func (b *bitbucketProvider) patch(ctx context.Context, repo Repo, id int) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("%s/repositories/%s/%s/pullrequests/%d/diff", b.api, url.PathEscape(repo.Owner), url.PathEscape(repo.Name), id), nil)
+	if err != nil {
+		return "", err
+	}
+	req.SetBasicAuth(b.username, b.password)
+
+	raw, _, err := readRaw(req, b.record)
+
+	return string(raw), err
+}
+
+func (b *bitbucketProvider) headSHA(ctx context.Context, repo Repo, id int) (string, error) {
+	p := &bitbucketPullRequest{}
+	if err := b.get(ctx, fmt.Sprintf("%s/repositories/%s/%s/pullrequests/%d", b.api, url.PathEscape(repo.Owner), url.PathEscape(repo.Name), id), p); err != nil {
+		return "", err
+	}
+
+	return p.Source.Commit.Hash, nil
+}
+
+func (b *bitbucketProvider) fileAt(ctx context.Context, repo Repo, sha, file string) ([]byte, error) {
+	segments := strings.Split(file, "/")
+	for i := range segments {
+		segments[i] = url.PathEscape(segments[i])
+	}
+	endpoint := fmt.Sprintf("%s/repositories/%s/%s/src/%s/%s", b.api, url.PathEscape(repo.Owner), url.PathEscape(repo.Name), url.PathEscape(sha), strings.Join(segments, "/"))
+	req, err := http.NewRequestWithContext(ctx, "GET", endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.SetBasicAuth(b.username, b.password)
+
+	raw, found, err := readRaw(req, b.record)
+	if err != nil {
+		return nil, fmt.Errorf("Bitbucket: %w", err)
+	}
+	if !found {
+		return nil, nil
+	}
+
+	return raw, nil
+}
+
+// info takes the last update of a merged PR as its merge time, since
+// Bitbucket doesn't report the merge time itself
+func (b *bitbucketProvider) info(ctx context.Context, repo Repo, id int) (prInfo, error) {
+	p := &bitbucketPullRequest{}
+	if err := b.get(ctx, fmt.Sprintf("%s/repositories/%s/%s/pullrequests/%d", b.api, url.PathEscape(repo.Owner), url.PathEscape(repo.Name), id), p); err != nil {
+		return prInfo{}, err
+	}
+
+	info := prInfo{Author: p.Author.Nickname, BaseBranch: p.Destination.Branch.Name}
+	if info.Author == "" {
+		info.Author = p.Author.DisplayName
+	}
+	if p.State == "MERGED" {
+		info.MergedAt = p.UpdatedOn
+	}
+
+	return info, nil
+}
+
+// ping requests the user of the app password
+func (b *bitbucketProvider) ping(ctx context.Context) error {
+	return b.get(ctx, b.api+"/user", &struct{}{})
+}
+
+func (b *bitbucketProvider) codeowners(ctx context.Context, repo Repo) ([]byte, error) {
+	for _, p := range codeownersPaths {
+		endpoint := fmt.Sprintf("%s/repositories/%s/%s/src/HEAD/%s", b.api, url.PathEscape(repo.Owner), url.PathEscape(repo.Name), p)
+		req, err := http.NewRequestWithContext(ctx, "GET", endpoint, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.SetBasicAuth(b.username, b.password)
+
+		raw, found, err := readRaw(req, b.record)
+		if err != nil {
+			return nil, fmt.Errorf("Bitbucket: %w", err)
+		}
+		if found {
+			return raw, nil
+		}
+	}
+
+	return nil, nil
+}
+
+func (b *bitbucketProvider) usage() apiUsage {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.used
+}
+
+// get sends an authenticated GET request and decodes the response into v
+func (b *bitbucketProvider) get(ctx context.Context, endpoint string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", endpoint, nil)
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(b.username, b.password)
+
+	resp, err := client.Do(req)
+	b.record(resp)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return responseError("Bitbucket", resp)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// record counts a request and keeps the rate limit reported in its headers
+func (b *bitbucketProvider) record(resp *http.Response) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.used.Requests++
+	if resp == nil {
+		return
+	}
+	if limit, err := strconv.Atoi(resp.Header.Get("X-RateLimit-Limit")); err == nil {
+		b.used.Limit = limit
+		b.used.Remaining, _ = strconv.Atoi(resp.Header.Get("X-RateLimit-Remaining"))
+	}
+}
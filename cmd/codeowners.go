@@ -0,0 +1,263 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// enrichCmd represents the enrich command
+var enrichCmd = &cobra.Command{
+	Use:   "enrich",
+	Short: "Resolves the owners of the changed files and the metadata of the repos",
+	Long: `Reads the CODEOWNERS file of every repo of the collected PRs, from
+.github/, the root or docs/ of the default branch, and stores the
+owners of every changed file with its diff, so report --group-by
+owner shows which teams' areas take the most bug fixes.
+
+The last matching rule of CODEOWNERS wins, like on GitHub; the files
+without an owner are grouped as unowned. With diffs.codeowners set,
+collectDiffs resolves the owners of the new PRs too, so enrich is
+only needed for the PRs collected before, or after CODEOWNERS
+changed.
+
+On GitHub the topics, the languages and the archived state of every
+repo are stored too, so report --exclude-archived leaves out the
+repos archived since their fixes and report --group-by topic
+compares the heat of the topics across the org. The metadata is
+read again on every run.`,
+	RunE: enrich,
+}
+
+// codeownersPaths are the locations of CODEOWNERS, looked up in order
+var codeownersPaths = []string{".github/CODEOWNERS", "CODEOWNERS", "docs/CODEOWNERS"}
+
+func init() {
+	rootCmd.AddCommand(enrichCmd)
+}
+
+// codeownersRule represents a line of CODEOWNERS
+type codeownersRule struct {
+	pattern []string
+	owners  []string
+}
+
+// parseCodeowners reads the rules of a CODEOWNERS file. The sections of
+// GitLab are skipped, their rules still apply.
+func parseCodeowners(raw []byte) []codeownersRule {
+	rules := make([]codeownersRule, 0)
+	scanner := bufio.NewScanner(bytes.NewReader(raw))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if i := strings.Index(line, " #"); i >= 0 {
+			line = line[:i]
+		}
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "[") || strings.HasPrefix(line, "^[") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		rules = append(rules, codeownersRule{pattern: codeownersPattern(fields[0]), owners: fields[1:]})
+	}
+
+	return rules
+}
+
+// codeownersPattern converts a gitignore-like pattern to the segments of
+// matchGlob. A pattern with a slash other than a trailing one is relative
+// to the root, any other matches at any depth. A pattern matches the
+// files under the directories it matches too.
+func codeownersPattern(p string) []string {
+	anchored := strings.Contains(strings.TrimSuffix(p, "/"), "/")
+	p = strings.Trim(p, "/")
+	if !anchored {
+		p = "**/" + p
+	}
+
+	return strings.Split(p+"/**", "/")
+}
+
+// ownersOf returns the owners of the last rule matching the file, none if
+// the rule has no owners
+func ownersOf(rules []codeownersRule, file string) []string {
+	segments := strings.Split(file, "/")
+	for i := len(rules) - 1; i >= 0; i-- {
+		if matchGlob(rules[i].pattern, segments) {
+			return rules[i].owners
+		}
+	}
+
+	return nil
+}
+
+// readRaw sends the request of a raw file and returns its content, or
+// false if it's not found. record counts the request.
+func readRaw(req *http.Request, record func(*http.Response)) ([]byte, bool, error) {
+	resp, err := client.Do(req)
+	record(resp)
+	if err != nil {
+		return nil, false, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, false, nil
+	default:
+		return nil, false, fmt.Errorf("responded with %s", resp.Status)
+	}
+
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return raw, true, nil
+}
+
+// codeownersCache keeps the rules of every repo read so far
+type codeownersCache struct {
+	reader codeownersReader
+	rules  map[Repo][]codeownersRule
+}
+
+func newCodeownersCache(provider vcsProvider) (*codeownersCache, error) {
+	reader, ok := provider.(codeownersReader)
+	if !ok {
+		return nil, fmt.Errorf("%s doesn't read CODEOWNERS", provider.applicationType())
+	}
+
+	return &codeownersCache{reader: reader, rules: make(map[Repo][]codeownersRule)}, nil
+}
+
+func (c *codeownersCache) repoRules(ctx context.Context, repo Repo) ([]codeownersRule, error) {
+	if rules, ok := c.rules[repo]; ok {
+		return rules, nil
+	}
+
+	raw, err := c.reader.codeowners(ctx, repo)
+	if err != nil {
+		return nil, fmt.Errorf("reading CODEOWNERS of %s/%s failed: %w", repo.Owner, repo.Name, err)
+	}
+	if raw == nil {
+		slog.Debug("no CODEOWNERS found", "repo", repo.Owner+"/"+repo.Name)
+	}
+	rules := parseCodeowners(raw)
+	c.rules[repo] = rules
+
+	return rules, nil
+}
+
+// setOwners sets the owners of the files changed by the PRs and returns
+// the PRs whose owners changed
+func (c *codeownersCache) setOwners(ctx context.Context, prs []pr) ([]pr, error) {
+	privacy := newAuthorPrivacy()
+	changed := make([]pr, 0)
+	for i := range prs {
+		rules, err := c.repoRules(ctx, prs[i].Repo)
+		if err != nil {
+			return nil, err
+		}
+
+		diffs := [][]diff{prs[i].Diff}
+		for _, commit := range prs[i].Commits {
+			diffs = append(diffs, commit.Diff)
+		}
+		updated := false
+		for _, ds := range diffs {
+			for j := range ds {
+				owners := privacy.owners(ownersOf(rules, ds[j].File))
+				if strings.Join(owners, " ") != strings.Join(ds[j].Owners, " ") {
+					ds[j].Owners = owners
+					updated = true
+				}
+			}
+		}
+		if updated {
+			changed = append(changed, prs[i])
+		}
+	}
+
+	return changed, nil
+}
+
+// codeownersEnabled tells whether collectDiffs resolves the owners
+func codeownersEnabled() bool {
+	return viper.GetBool("diffs.codeowners")
+}
+
+// fileOwners returns the owners of the file, or unowned
+func fileOwners(h fileHeat) []string {
+	if len(h.owners) == 0 {
+		return []string{unownedTeam}
+	}
+
+	result := make([]string, 0, len(h.owners))
+	for o := range h.owners {
+		result = append(result, o)
+	}
+	sort.Strings(result)
+
+	return result
+}
+
+func enrich(cmd *cobra.Command, args []string) error {
+	ctx, cancel, st, err := openStore()
+	if err != nil {
+		return err
+	}
+	defer cancel()
+	defer closeStore(ctx, st)
+
+	provider, err := newVCSProvider(ctx)
+	if err != nil {
+		return configError(err)
+	}
+	cache, err := newCodeownersCache(provider)
+	if err != nil {
+		return configError(err)
+	}
+
+	prs, err := st.PRs(ctx)
+	if err != nil {
+		return storageError(fmt.Errorf("reading diffs failed: %w", err))
+	}
+
+	changed, err := cache.setOwners(ctx, prs)
+	if err != nil {
+		return vcsError(err)
+	}
+
+	slog.Info("owners resolved", "repos", len(cache.rules), "prs", len(prs), "updated", len(changed))
+	if len(changed) > 0 {
+		if err := st.InsertPRs(ctx, changed); err != nil {
+			return storageError(fmt.Errorf("writing diffs failed: %w", err))
+		}
+	}
+
+	describer, ok := provider.(repoDescriber)
+	if !ok {
+		slog.Debug("the provider doesn't describe the repos", "provider", provider.applicationType())
+		return nil
+	}
+	repos, err := describeRepos(ctx, describer, prs)
+	if err != nil {
+		return vcsError(err)
+	}
+	if err := st.SaveRepos(ctx, repos); err != nil {
+		return storageError(fmt.Errorf("writing repos failed: %w", err))
+	}
+	slog.Info("repos described", "repos", len(repos))
+
+	return nil
+}
@@ -2,15 +2,18 @@ package cmd
 
 import (
 	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
-	"log"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 
-	"github.com/google/go-github/github"
 	"github.com/spf13/cobra"
-	"github.com/spf13/viper"
-	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/mongo"
-	"golang.org/x/oauth2"
+	"golang.org/x/sync/errgroup"
 )
 
 // collectDiffsCmd represents the collectDiffs command
@@ -18,156 +21,392 @@ var collectDiffsCmd = &cobra.Command{
 	Use:   "collectDiffs",
 	Short: "Collects the diffs of the PRs that are not already analyzed",
 	Long: `Gets all not already analyzed PRs and collects
-their diff info which then writes into a MongoDB collection`,
-	Run: collectDiffs,
-}
+their diff info which then writes into the store.
 
-var (
-	jiraCollName   string
-	githubCollName string
-)
+With --granularity commit only the commits referencing the bugs of
+a PR count as its diff. The files of diffs.exclude are dropped and
+the PRs of the repos outside repos.allow are skipped. See the
+README for the configuration.`,
+	RunE: collectDiffs,
+}
 
 type diff struct {
-	File      string `bson:"file"`
-	Status    string `bson:"status"`
-	Additions int    `bson:"additions"`
-	Deletions int    `bson:"deletions"`
-	Changes   int    `bson:"changes"`
+	File      string `bson:"file" json:"file"`
+	Status    string `bson:"status" json:"status"`
+	Additions int    `bson:"additions" json:"additions"`
+	Deletions int    `bson:"deletions" json:"deletions"`
+	Changes   int    `bson:"changes" json:"changes"`
+
+	// Owners are the owners of the file per CODEOWNERS, set by enrich
+	Owners []string `bson:"owners,omitempty" json:"owners,omitempty"`
+	// Language is detected from the extension of the file
+	Language string `bson:"language,omitempty" json:"language,omitempty"`
+	// Hunks are the line ranges changed in the file, with diffs.hunks
+	Hunks []lineRange `bson:"hunks,omitempty" json:"hunks,omitempty"`
+	// Functions are the functions enclosing the hunks, set by analyze
+	// functions
+	Functions []functionChange `bson:"functions,omitempty" json:"functions,omitempty"`
+
+	// patch is the unified diff of the file if the provider returns it.
+	// It's only used to compute the patch ID, not stored.
+	patch string
+}
+
+// prStats represents the totals of all files changed by a PR
+type prStats struct {
+	FilesChanged int `bson:"files_changed" json:"files_changed"`
+	Additions    int `bson:"additions" json:"additions"`
+	Deletions    int `bson:"deletions" json:"deletions"`
 }
 
 type pr struct {
-	ID   string `bson:"_id,omitempty"`
-	Repo Repo   `bson:"repo"`
-	PRID int    `bson:"pr_id"`
-	Diff []diff `bson:"diff,omitempty"`
+	ID       string    `bson:"_id,omitempty" json:"id,omitempty"`
+	Repo     Repo      `bson:"repo" json:"repo"`
+	PRID     int       `bson:"pr_id" json:"pr_id"`
+	Author   string    `bson:"author,omitempty" json:"author,omitempty"`
+	MergedAt time.Time `bson:"merged_at,omitempty" json:"merged_at,omitempty"`
+	Branch   string    `bson:"base_branch,omitempty" json:"base_branch,omitempty"`
+	PatchID  string    `bson:"patch_id,omitempty" json:"patch_id,omitempty"`
+	Stats    prStats   `bson:"stats" json:"stats"`
+	Diff     []diff    `bson:"diff,omitempty" json:"diff,omitempty"`
+
+	// Commits holds the commits referencing the bugs of the PR when it's
+	// collected by commit
+	Commits []prCommit `bson:"commits,omitempty" json:"commits,omitempty"`
+
+	// FetchStatus is forbidden or not_found if the PR couldn't be read,
+	// its repo being private to the credentials or deleted, with the
+	// error of FetchError. Such a PR has no diff until retry-failed reads
+	// it.
+	FetchStatus string `bson:"fetch_status,omitempty" json:"fetch_status,omitempty"`
+	FetchError  string `bson:"fetch_error,omitempty" json:"fetch_error,omitempty"`
 }
 
+// prCommit represents a commit of a PR with its diff
+type prCommit struct {
+	SHA     string `bson:"sha" json:"sha"`
+	Message string `bson:"message" json:"message"`
+	Diff    []diff `bson:"diff,omitempty" json:"diff,omitempty"`
+}
+
+// diffTask represents a pending PR of the manifest with the keys of its
+// bugs, which select its commits by commit
+type diffTask struct {
+	pr
+	Keys []string `json:"keys,omitempty"`
+}
+
+var (
+	maxRequests     int
+	diffGranularity string
+)
+
 func init() {
 	rootCmd.AddCommand(collectDiffsCmd)
+	collectDiffsCmd.Flags().BoolVar(&resume, "resume", false, "resume the interrupted run from its manifest")
+	collectDiffsCmd.Flags().IntVar(&maxRequests, "max-requests", 0, "stop after this many provider requests, to be continued with --resume (0 means no limit)")
+	collectDiffsCmd.Flags().IntVar(&concurrency, "concurrency", defaultConcurrency, "number of PRs fetched in parallel")
+	collectDiffsCmd.Flags().StringVar(&repoScope, "repo", "", "only collect the PRs of this owner/name repo")
+	collectDiffsCmd.Flags().StringVar(&diffGranularity, "granularity", "pr", "unit of the collected diffs: pr or commit")
 }
 
-func collectDiffs(cmd *cobra.Command, args []string) {
-	ctx, cancel, mongoClient := connectToMongo()
+func collectDiffs(cmd *cobra.Command, args []string) error {
+	ctx, cancel, st, err := openStore()
+	if err != nil {
+		return err
+	}
 	defer cancel()
-	defer func() {
-		if err := mongoClient.Disconnect(ctx); err != nil {
-			panic(err)
+	defer closeStore(ctx, st)
+
+	_, err = runCollectDiffs(ctx, st)
+
+	return err
+}
+
+// runCollectDiffs collects the diffs of the not analyzed PRs and returns
+// the number of the collected PRs
+func runCollectDiffs(ctx context.Context, st store) (n int, err error) {
+	defer func() { recordRun("collectDiffs", n, err) }()
+
+	if err := checkRepoScope(); err != nil {
+		return 0, err
+	}
+
+	var m *manifest
+	if resume {
+		if m, err = loadManifest("collectDiffs", ""); err != nil {
+			return 0, err
 		}
-	}()
-
-	jiraCollName = viper.GetString("mongo.collections.jira")
-	githubCollName = viper.GetString("mongo.collections.github")
-	jiraColl := mongoClient.Database(dbname).Collection(jiraCollName)
-	prs := getNotAnalyzedPRs(ctx, jiraColl)
-	fmt.Printf("New PRs found: %d\n", len(*prs))
-	if len(*prs) == 0 {
-		return
-	}
-
-	client := connectToGitHub(ctx)
-	setPRsDiffs(ctx, client, prs)
-
-	if len(*prs) == 0 {
-		fmt.Println("No new PR changes")
-	}
-
-	docs := make([]interface{}, len(*prs))
-	for i, v := range *prs {
-		docs[i] = v
-	}
-
-	ghColl := mongoClient.Database(dbname).Collection(githubCollName)
-	writeItemsToMongo(ctx, ghColl, docs)
-}
-
-func getNotAnalyzedPRs(ctx context.Context, collection *mongo.Collection) *[]pr {
-	lookup := bson.D{{
-		Key: "$lookup",
-		Value: bson.M{
-			"from":         githubCollName,
-			"localField":   "pr_id",
-			"foreignField": "pr_id",
-			"as":           "pr",
-		},
-	}}
-
-	match := bson.D{{
-		Key: "$match",
-		Value: bson.M{
-			"pr": bson.M{
-				"$size": 0,
-			},
-		},
-	}}
-
-	project := bson.D{{
-		Key: "$project",
-		Value: bson.M{
-			"_id":   0,
-			"repo":  1,
-			"pr_id": 1,
-		},
-	}}
-
-	cur, err := collection.Aggregate(ctx, mongo.Pipeline{lookup, match, project})
+	} else if m, err = planCollectDiffs(ctx, st); err != nil {
+		return 0, err
+	}
+	defer m.close()
+
+	slog.Info("new PRs found", "count", len(m.Items))
+	if len(m.Items) == 0 {
+		return 0, m.remove()
+	}
+
+	provider, err := newVCSProvider(ctx)
 	if err != nil {
-		log.Fatal(err)
+		return 0, configError(err)
+	}
+	if diffGranularity == "commit" {
+		if _, ok := provider.(commitLister); !ok {
+			return 0, configError(fmt.Errorf("%s doesn't list the commits of the PRs", provider.applicationType()))
+		}
+	}
+	if err := setPRsDiffs(ctx, provider, m); err != nil {
+		return 0, vcsError(err)
+	}
+
+	prs, err := storeCollectedPRs(ctx, st, provider, m)
+	if err != nil {
+		return 0, err
+	}
+	if failed := len(failedPRs(prs)); failed > 0 {
+		slog.Warn("PRs of private or deleted repos skipped, see retry-failed", "count", failed)
 	}
-	defer cur.Close(ctx)
 
-	prs := make([]pr, 0)
-	for cur.Next(ctx) {
-		p := &pr{}
-		err := cur.Decode(&p)
+	return len(prs), m.remove()
+}
+
+// storeCollectedPRs writes the PRs collected by the manifest, with the
+// languages and, if diffs.codeowners is set, the owners of their files
+func storeCollectedPRs(ctx context.Context, st store, provider vcsProvider, m *manifest) ([]pr, error) {
+	prs := make([]pr, len(m.Items))
+	for i, item := range m.Items {
+		if err := json.Unmarshal(item.Result, &prs[i]); err != nil {
+			return nil, err
+		}
+	}
+	setLanguages(languageExtensions(), prs)
+	if codeownersEnabled() {
+		cache, err := newCodeownersCache(provider)
 		if err != nil {
-			log.Fatal(err)
+			return nil, configError(err)
+		}
+		if _, err := cache.setOwners(ctx, prs); err != nil {
+			return nil, vcsError(err)
 		}
-
-		prs = append(prs, *p)
 	}
 
-	if err := cur.Err(); err != nil {
-		log.Fatal(err)
+	if err := st.InsertPRs(ctx, prs); err != nil {
+		return nil, storageError(fmt.Errorf("writing diffs failed: %w", err))
 	}
+	savePayloads(ctx, st)
 
-	return &prs
+	return prs, nil
 }
 
-func connectToGitHub(ctx context.Context) *github.Client {
-	token := viper.GetString("github.token")
-	ts := oauth2.StaticTokenSource(
-		&oauth2.Token{AccessToken: token},
-	)
-	tc := oauth2.NewClient(ctx, ts)
-	client := github.NewClient(tc)
+// planCollectDiffs writes the manifest of the PRs which are not analyzed yet
+func planCollectDiffs(ctx context.Context, st store) (*manifest, error) {
+	switch diffGranularity {
+	case "pr", "commit":
+	default:
+		return nil, configError(fmt.Errorf("unknown granularity %q", diffGranularity))
+	}
+
+	var err error
+	keys := make(map[string][]string)
+	if diffGranularity == "commit" {
+		if keys, err = prIssueKeys(ctx, st); err != nil {
+			return nil, storageError(fmt.Errorf("reading mappings failed: %w", err))
+		}
+	}
+
+	archived, err := archivedRepos(ctx, st)
+	if err != nil {
+		return nil, storageError(fmt.Errorf("reading repos failed: %w", err))
+	}
+
+	m := newManifest("collectDiffs", "")
+	repos := newRepoFilter()
+	skipped := 0
+	var added error
+	err = st.NotAnalyzedPRs(ctx, func(p pr) error {
+		if !repos.allows(p.Repo) {
+			return nil
+		}
+		if archived[p.Repo] {
+			skipped++
+			return nil
+		}
+		k := prKey(p.Repo, p.PRID)
+		// The errors of the manifest aren't the store's
+		added = m.add(k, diffTask{pr: p, Keys: keys[k]})
+		return added
+	})
+	if added != nil {
+		return nil, added
+	}
+	if err != nil {
+		return nil, storageError(fmt.Errorf("reading not analyzed PRs failed: %w", err))
+	}
 
-	return client
+	if skipped > 0 {
+		slog.Info("skipped the PRs of the archived repos", "prs", skipped, "repos", len(archived))
+	}
+
+	if err := m.start(); err != nil {
+		return nil, fmt.Errorf("writing manifest failed: %w", err)
+	}
+
+	return m, nil
 }
 
-func setPRsDiffs(ctx context.Context, client *github.Client, prs *[]pr) {
-	for k, p := range *prs {
-		fmt.Printf("%+v\n", p)
+// setPRsDiffs fetches the diffs and the details of the pending PRs of the
+// manifest, --concurrency PRs at a time. The files excluded by the diff
+// filter are dropped. The first failed PR stops the others; the PRs done
+// so far are kept in the manifest.
+func setPRsDiffs(ctx context.Context, provider vcsProvider, m *manifest) error {
+	filter := diffFilter()
+	pending := m.pending()
+	prog := newProgress(len(pending))
+	defer prog.finish()
 
-		files, _, err := client.PullRequests.ListFiles(ctx, p.Repo.Owner, p.Repo.Name, p.PRID, &github.ListOptions{PerPage: 100})
-		if err != nil {
-			panic(err)
+	workers := concurrency
+	if workers < 1 {
+		workers = 1
+	}
+
+	var mu sync.Mutex
+	g, ctx := errgroup.WithContext(ctx)
+	slots := make(chan struct{}, workers)
+	for _, item := range pending {
+		item := item
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+			return g.Wait()
 		}
 
-		diffs := make([]diff, 0)
-		for _, f := range files {
-			fmt.Printf("File: %s\nadditions: %d; deletions: %d; changes: %d\n", *f.Filename, *f.Additions, *f.Deletions, *f.Changes)
+		g.Go(func() error {
+			defer func() { <-slots }()
 
-			diff := &diff{
-				File:      *f.Filename,
-				Status:    *f.Status,
-				Additions: *f.Additions,
-				Deletions: *f.Deletions,
-				Changes:   *f.Changes,
+			// The requests in flight can spend a few more than the budget
+			if maxRequests > 0 && provider.usage().Requests >= maxRequests {
+				return fmt.Errorf("the budget of %d requests is spent with %d PRs left, continue with --resume", maxRequests, len(m.pending()))
 			}
+			if err := setPRDiffs(ctx, provider, filter, m, item); err != nil {
+				return err
+			}
+
+			mu.Lock()
+			prog.step(provider.usage())
+			mu.Unlock()
+
+			return nil
+		})
+	}
+
+	return g.Wait()
+}
+
+// setPRDiffs fetches the diffs and the details of a PR of the manifest
+// and marks it as done. A PR of a repo which is private to the
+// credentials or deleted is marked done with its fetch status, so the
+// other PRs are still collected.
+func setPRDiffs(ctx context.Context, provider vcsProvider, filter pathFilter, m *manifest, item *manifestItem) error {
+	task := diffTask{}
+	if err := json.Unmarshal(item.Data, &task); err != nil {
+		return err
+	}
+
+	p, err := fetchPRDiffs(ctx, provider, filter, task, item.Key)
+	if status := fetchStatus(err); status != "" && ctx.Err() == nil {
+		slog.Warn("PR can't be read, skipping it", "pr", item.Key, "status", status, "err", err)
+		p = task.pr
+		p.FetchStatus, p.FetchError = status, err.Error()
+	} else if err != nil {
+		return err
+	}
+
+	if err := m.markDone(item.Key, p); err != nil {
+		return fmt.Errorf("writing manifest failed: %w", err)
+	}
 
-			diffs = append(diffs, *diff)
+	return nil
+}
+
+// fetchPRDiffs fetches the diffs and the details of the PR of the task
+func fetchPRDiffs(ctx context.Context, provider vcsProvider, filter pathFilter, task diffTask, key string) (pr, error) {
+	p := task.pr
+	p.FetchStatus, p.FetchError = "", ""
+
+	diffs, err := provider.listFiles(ctx, p.Repo, p.PRID)
+	if err != nil {
+		return p, fmt.Errorf("PR %s: listing files failed: %w", key, err)
+	}
+	diffs = cleanDiffs(diffs)
+	if hunksEnabled() {
+		if err := setHunks(ctx, provider, p.Repo, p.PRID, diffs); err != nil {
+			return p, fmt.Errorf("PR %s: %w", key, err)
 		}
+	}
 
-		(*prs)[k].Diff = diffs
+	info, err := provider.info(ctx, p.Repo, p.PRID)
+	if err != nil {
+		return p, fmt.Errorf("PR %s: fetching details failed: %w", key, err)
 	}
+	p.Author = info.Author
+	p.MergedAt = info.MergedAt
+	p.Branch = info.BaseBranch
+
+	p.Stats = summarizeDiffs(diffs)
+	p.PatchID = patchID(diffs)
+	p.Diff = diffs
+	if lister, ok := provider.(commitLister); ok && diffGranularity == "commit" {
+		if err := setCommitDiffs(ctx, lister, &p, task.Keys); err != nil {
+			return p, fmt.Errorf("PR %s: %w", key, err)
+		}
+	}
+	filter.apply(&p)
+	newAuthorPrivacy().apply(&p)
+
+	return p, nil
+}
+
+// summarizeDiffs computes the PR totals. They have to be computed from
+// the complete list of files, before any file rows are dropped, so the
+// high-level metrics stay accurate.
+func summarizeDiffs(diffs []diff) prStats {
+	stats := prStats{FilesChanged: len(diffs)}
+	for _, d := range diffs {
+		stats.Additions += d.Additions
+		stats.Deletions += d.Deletions
+	}
+
+	return stats
+}
+
+// patchID fingerprints the change of a PR like git patch-id does: the
+// changed lines are hashed without the line numbers and the whitespace,
+// so a cherry-pick onto another branch gets the same ID. A PR with a file
+// without a patch, e.g. from Bitbucket or too large for GitHub, has no
+// patch ID, as its totals can't tell two different changes apart.
+func patchID(diffs []diff) string {
+	if len(diffs) == 0 {
+		return ""
+	}
+	sorted := make([]diff, len(diffs))
+	copy(sorted, diffs)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].File < sorted[j].File })
+
+	h := sha1.New()
+	for _, d := range sorted {
+		if d.patch == "" {
+			return ""
+		}
+		fmt.Fprintf(h, "%s\n", d.File)
+
+		for _, line := range patchBody(d.patch) {
+			if !strings.HasPrefix(line, "+") && !strings.HasPrefix(line, "-") {
+				continue
+			}
+			fmt.Fprintf(h, "%c%s\n", line[0], strings.Join(strings.Fields(line[1:]), ""))
+		}
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
 }
@@ -0,0 +1,109 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+)
+
+// prIssueKeys returns the keys of the bugs of every mapped PR. The
+// mappings written before the keys were kept have none.
+func prIssueKeys(ctx context.Context, st store) (map[string][]string, error) {
+	mappings, err := st.Mappings(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string][]string)
+	for _, m := range mappings {
+		if m.IssueKey != "" {
+			k := prKey(m.Repo, m.PRID)
+			keys[k] = append(keys[k], m.IssueKey)
+		}
+	}
+
+	return keys, nil
+}
+
+// setCommitDiffs replaces the diff of the PR by the files of its commits
+// referencing the keys, keeping the diff of the PR if there are none
+func setCommitDiffs(ctx context.Context, lister commitLister, p *pr, keys []string) error {
+	commits, err := lister.commits(ctx, p.Repo, p.PRID)
+	if err != nil {
+		return fmt.Errorf("listing commits failed: %w", err)
+	}
+
+	fixing := fixingCommits(commits, keys)
+	if len(fixing) == 0 {
+		slog.Debug("no commit references the bugs, keeping the diff of the PR", "pr", prKey(p.Repo, p.PRID))
+		return nil
+	}
+
+	for i := range fixing {
+		if fixing[i].Diff, err = lister.commitFiles(ctx, p.Repo, fixing[i].SHA); err != nil {
+			return fmt.Errorf("commit %s: listing files failed: %w", fixing[i].SHA, err)
+		}
+		fixing[i].Diff = cleanDiffs(fixing[i].Diff)
+		if hunksEnabled() {
+			parsePatches(fixing[i].Diff)
+		}
+	}
+	p.Commits = fixing
+	p.Diff = mergeCommitDiffs(fixing)
+
+	return nil
+}
+
+// fixingCommits returns the commits whose messages reference one of the
+// keys, with the first lines of their messages. Without keys any issue
+// key counts.
+func fixingCommits(commits []prCommit, keys []string) []prCommit {
+	wanted := make(map[string]bool, len(keys))
+	for _, k := range keys {
+		wanted[strings.ToUpper(k)] = true
+	}
+
+	fixing := make([]prCommit, 0)
+	for _, c := range commits {
+		for _, k := range issueKeyPattern.FindAllString(strings.ToUpper(c.Message), -1) {
+			if len(wanted) == 0 || wanted[k] {
+				c.Message = commitSummary(c.Message)
+				fixing = append(fixing, c)
+				break
+			}
+		}
+	}
+
+	return fixing
+}
+
+// mergeCommitDiffs sums the diffs of the commits by file, the status of a
+// file being the one of its last commit
+func mergeCommitDiffs(commits []prCommit) []diff {
+	merged := make([]diff, 0)
+	index := make(map[string]int)
+	for _, c := range commits {
+		for _, d := range c.Diff {
+			i, ok := index[d.File]
+			if !ok {
+				index[d.File] = len(merged)
+				merged = append(merged, diff{File: d.File})
+				i = len(merged) - 1
+			}
+
+			merged[i].Status = d.Status
+			merged[i].Additions += d.Additions
+			merged[i].Deletions += d.Deletions
+			merged[i].Changes += d.Changes
+			merged[i].Hunks = append(merged[i].Hunks, d.Hunks...)
+		}
+	}
+
+	return merged
+}
+
+// commitSummary returns the first line of a commit message
+func commitSummary(message string) string {
+	return strings.TrimSpace(strings.SplitN(message, "\n", 2)[0])
+}
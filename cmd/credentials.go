@@ -0,0 +1,92 @@
+package cmd
+
+import (
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+const defaultCredentialsWarnDays = 14
+
+// credentialExpiry keeps the expiry times of the credentials, either
+// reported by the services or configured in <service>.token_expires
+var credentialExpiry = struct {
+	sync.Mutex
+	times map[string]time.Time
+}{times: make(map[string]time.Time)}
+
+// githubExpiryLayouts are the formats of the token expiration header
+var githubExpiryLayouts = []string{"2006-01-02 15:04:05 MST", "2006-01-02 15:04:05 -0700"}
+
+// noteExpiry records the expiry time of the credentials of a service
+func noteExpiry(service string, t time.Time) {
+	if t.IsZero() {
+		return
+	}
+
+	credentialExpiry.Lock()
+	defer credentialExpiry.Unlock()
+	credentialExpiry.times[service] = t
+}
+
+// noteGitHubExpiry parses the GitHub-Authentication-Token-Expiration
+// header, sent for the tokens with an expiry date
+func noteGitHubExpiry(value string) {
+	for _, layout := range githubExpiryLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			noteExpiry("github", t)
+			return
+		}
+	}
+}
+
+// expiringCredentials returns the services whose credentials expire within
+// credentials.warn_days days, with their expiry times
+func expiringCredentials() map[string]time.Time {
+	viper.SetDefault("credentials.warn_days", defaultCredentialsWarnDays)
+	limit := time.Now().Add(time.Duration(viper.GetInt("credentials.warn_days")) * 24 * time.Hour)
+
+	credentialExpiry.Lock()
+	defer credentialExpiry.Unlock()
+
+	// The times reported by the services win over the configured ones
+	for _, service := range []string{"jira", "github", "gitlab", "bitbucket", "azure", "linear"} {
+		if t := viper.GetTime(service + ".token_expires"); !t.IsZero() {
+			if _, ok := credentialExpiry.times[service]; !ok {
+				credentialExpiry.times[service] = t
+			}
+		}
+	}
+
+	expiring := make(map[string]time.Time)
+	for service, t := range credentialExpiry.times {
+		if t.Before(limit) {
+			expiring[service] = t
+		}
+	}
+
+	return expiring
+}
+
+// warnExpiringCredentials logs a warning for every credential expiring soon
+func warnExpiringCredentials() {
+	expiring := expiringCredentials()
+
+	services := make([]string, 0, len(expiring))
+	for service := range expiring {
+		services = append(services, service)
+	}
+	sort.Strings(services)
+
+	for _, service := range services {
+		t := expiring[service]
+		if t.Before(time.Now()) {
+			slog.Warn("credentials expired", "service", service, "expired", t)
+			continue
+		}
+		slog.Warn("credentials expire soon", "service", service, "expires", t, "days_left", int(time.Until(t).Hours()/24))
+	}
+}
@@ -0,0 +1,158 @@
+package cmd
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronSchedule represents a parsed five-field cron expression: minute,
+// hour, day of the month, month and day of the week, 0 being Sunday
+type cronSchedule struct {
+	fields [5]map[int]bool
+	// anyDom and anyDow tell whether the day of the month and of the week
+	// are unrestricted; when both are restricted, a day matching either
+	// of them matches, as in cron
+	anyDom, anyDow bool
+}
+
+// cronRanges are the allowed values of the fields
+var cronRanges = [5][2]int{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 7}}
+
+// cronMacros are the supported shorthands of the expressions
+var cronMacros = map[string]string{
+	"@hourly":  "0 * * * *",
+	"@daily":   "0 0 * * *",
+	"@weekly":  "0 0 * * 0",
+	"@monthly": "0 0 1 * *",
+}
+
+// parseCron parses an expression of five fields of values, ranges,
+// steps and lists, e.g. "0 */6 * * *" or "30 8 * * 1-5", or a macro
+func parseCron(expr string) (*cronSchedule, error) {
+	if macro, ok := cronMacros[strings.TrimSpace(expr)]; ok {
+		expr = macro
+	}
+
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron expression %q doesn't have 5 fields", expr)
+	}
+
+	s := &cronSchedule{anyDom: fields[2] == "*", anyDow: fields[4] == "*"}
+	for i, f := range fields {
+		values, err := parseCronField(f, cronRanges[i][0], cronRanges[i][1])
+		if err != nil {
+			return nil, fmt.Errorf("cron expression %q: %w", expr, err)
+		}
+		s.fields[i] = values
+	}
+	// Sunday is both 0 and 7
+	if s.fields[4][7] {
+		s.fields[4][0] = true
+	}
+
+	return s, nil
+}
+
+func parseCronField(f string, min, max int) (map[int]bool, error) {
+	values := make(map[int]bool)
+	for _, part := range strings.Split(f, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			n, err := strconv.Atoi(part[i+1:])
+			if err != nil || n < 1 {
+				return nil, fmt.Errorf("invalid step in %q", part)
+			}
+			step, part = n, part[:i]
+		}
+
+		lo, hi := min, max
+		if part != "*" {
+			bounds := strings.SplitN(part, "-", 2)
+			var err error
+			if lo, err = strconv.Atoi(bounds[0]); err != nil {
+				return nil, fmt.Errorf("invalid value %q", part)
+			}
+			hi = lo
+			if len(bounds) == 2 {
+				if hi, err = strconv.Atoi(bounds[1]); err != nil {
+					return nil, fmt.Errorf("invalid range %q", part)
+				}
+			} else if step > 1 {
+				hi = max
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return nil, fmt.Errorf("%q is out of the range %d-%d", part, min, max)
+		}
+
+		for v := lo; v <= hi; v += step {
+			values[v] = true
+		}
+	}
+
+	return values, nil
+}
+
+// matchesDay tells whether the day of t is scheduled
+func (s *cronSchedule) matchesDay(t time.Time) bool {
+	dom, dow := s.fields[2][t.Day()], s.fields[4][int(t.Weekday())]
+	switch {
+	case s.anyDom && s.anyDow:
+		return true
+	case s.anyDom:
+		return dow
+	case s.anyDow:
+		return dom
+	default:
+		return dom || dow
+	}
+}
+
+// next returns the first scheduled minute after t, or zero time if
+// there's none within five years, e.g. for February 30
+func (s *cronSchedule) next(t time.Time) time.Time {
+	t = cronStep(t, time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		switch {
+		case !s.fields[3][int(t.Month())]:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		case !s.matchesDay(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		case !s.fields[1][t.Hour()]:
+			t = cronStep(t, time.Hour)
+		case !s.fields[0][t.Minute()]:
+			t = cronStep(t, time.Minute)
+		default:
+			return t
+		}
+	}
+
+	return time.Time{}
+}
+
+// cronStep returns the start of the minute or the hour after the one of t
+// on the wall clock of its location, as the zones with a half-hour offset
+// don't start the hours with the absolute ones. The wall clock moves on
+// from the first instance of the hour repeated when the clocks go back to
+// the hour after it, so its times are scheduled once. Within the second
+// instance, the wall clock time can name the first one, so the elapsed
+// time is added instead to never step back.
+func cronStep(t time.Time, unit time.Duration) time.Time {
+	var next time.Time
+	elapsed := time.Duration(t.Second())*time.Second + time.Duration(t.Nanosecond())
+	if unit == time.Hour {
+		next = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+		elapsed += time.Duration(t.Minute()) * time.Minute
+	} else {
+		next = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute()+1, 0, 0, t.Location())
+	}
+	if next.After(t) {
+		return next
+	}
+
+	return t.Add(unit - elapsed)
+}
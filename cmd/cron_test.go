@@ -0,0 +1,52 @@
+package cmd
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCronNext(t *testing.T) {
+	zone := func(name string) *time.Location {
+		loc, err := time.LoadLocation(name)
+		if err != nil {
+			t.Skipf("time zone %s not available: %v", name, err)
+		}
+		return loc
+	}
+	kolkata, berlin := zone("Asia/Kolkata"), zone("Europe/Berlin")
+	// The clocks of Berlin go forward from 02:00 to 03:00 on 2024-03-31
+	// and back from 03:00 to 02:00 on 2024-10-27
+	cest, cet := time.FixedZone("CEST", 2*60*60), time.FixedZone("CET", 60*60)
+
+	for _, tt := range []struct {
+		name string
+		expr string
+		from time.Time
+		want time.Time
+	}{
+		{"next minute", "* * * * *", time.Date(2024, 1, 1, 10, 0, 30, 0, time.UTC), time.Date(2024, 1, 1, 10, 1, 0, 0, time.UTC)},
+		{"every six hours", "0 */6 * * *", time.Date(2024, 1, 1, 7, 10, 0, 0, time.UTC), time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)},
+		{"weekdays", "30 8 * * 1-5", time.Date(2024, 1, 6, 9, 0, 0, 0, time.UTC), time.Date(2024, 1, 8, 8, 30, 0, 0, time.UTC)},
+		{"half-hour offset daily", "0 12 * * *", time.Date(2024, 1, 1, 10, 0, 0, 0, kolkata), time.Date(2024, 1, 1, 12, 0, 0, 0, kolkata)},
+		{"half-hour offset every six hours", "0 */6 * * *", time.Date(2024, 1, 1, 7, 10, 0, 0, kolkata), time.Date(2024, 1, 1, 12, 0, 0, 0, kolkata)},
+		{"half-hour offset minutes", "15 * * * *", time.Date(2024, 1, 1, 7, 20, 0, 0, kolkata), time.Date(2024, 1, 1, 8, 15, 0, 0, kolkata)},
+		{"skipped hour", "30 2 * * *", time.Date(2024, 3, 31, 1, 0, 0, 0, berlin), time.Date(2024, 4, 1, 2, 30, 0, 0, berlin)},
+		{"after the skipped hour", "0 3 * * *", time.Date(2024, 3, 31, 1, 30, 0, 0, berlin), time.Date(2024, 3, 31, 3, 0, 0, 0, berlin)},
+		{"after the repeated hour", "0 3 * * *", time.Date(2024, 10, 27, 1, 30, 0, 0, berlin), time.Date(2024, 10, 27, 3, 0, 0, 0, berlin)},
+		{"in the repeated hour", "*/30 * * * *", time.Date(2024, 10, 27, 2, 40, 0, 0, cet).In(berlin), time.Date(2024, 10, 27, 3, 0, 0, 0, cet)},
+		// A wall clock time is scheduled once, in the first instance of
+		// the repeated hour
+		{"over the repeated hour", "45 * * * *", time.Date(2024, 10, 27, 2, 50, 0, 0, cest).In(berlin), time.Date(2024, 10, 27, 3, 45, 0, 0, cet)},
+		{"never", "0 0 30 2 *", time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), time.Time{}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := parseCron(tt.expr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := s.next(tt.from); !got.Equal(tt.want) {
+				t.Errorf("next(%q, %s) = %s, want %s", tt.expr, tt.from, got, tt.want)
+			}
+		})
+	}
+}
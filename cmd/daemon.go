@@ -0,0 +1,197 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// daemonCmd represents the daemon command
+var daemonCmd = &cobra.Command{
+	Use:   "daemon",
+	Short: "Runs the sync pipeline on a cron schedule",
+	Long: `Runs sync at the times of the cron expression of --schedule or
+daemon.schedule, e.g. "0 */6 * * *" for every 6 hours, in the local
+time of the host. The five fields are minute, hour, day of the
+month, month and day of the week; the macros @hourly, @daily,
+@weekly and @monthly are accepted too.
+
+A run takes the lock file of --lock-file first and is skipped if
+another run holds it, so the replicas of a deployment sharing the
+manifest directory never sync at the same time. A lock older than
+daemon.lock_ttl (default 24h) is taken over, as left by a killed
+run.
+
+A failed run, e.g. one taking longer than --run-timeout, is logged and
+the daemon waits for the next one. The global --timeout limits the
+daemon itself rather than its runs. On SIGTERM or SIGINT, or at the
+--timeout, the current run is canceled and the daemon exits;
+the manifests of its stages keep the progress, so the next run
+continues where it stopped.`,
+	RunE: daemon,
+}
+
+const defaultLockTTL = 24 * time.Hour
+
+var (
+	daemonSchedule string
+	daemonNow      bool
+	daemonLockFile string
+)
+
+func init() {
+	rootCmd.AddCommand(daemonCmd)
+	daemonCmd.Flags().StringVar(&daemonSchedule, "schedule", "", "cron expression of the runs (default is daemon.schedule)")
+	daemonCmd.Flags().BoolVar(&daemonNow, "now", false, "run once right away, before the first scheduled run")
+	daemonCmd.Flags().StringVar(&daemonLockFile, "lock-file", "", "lock file preventing overlapping runs (default is .heatmap-daemon.lock in manifest.dir)")
+	daemonCmd.Flags().StringVarP(&jiraProject, "project", "p", "Memberships", "Jira project name or a comma separated list of names")
+	daemonCmd.Flags().IntVar(&concurrency, "concurrency", defaultConcurrency, "number of parallel dev-status requests and of PRs fetched in parallel")
+	daemonCmd.Flags().BoolVar(&full, "full", false, "check all bugs in every run, ignoring the watermark of the last run")
+	daemonCmd.Flags().StringVar(&jiraJQL, "jql", "", fmt.Sprintf("JQL selecting the issues of a project (default is jira.jql or %q)", defaultJiraJQL))
+	daemonCmd.Flags().BoolVar(&syncReport, "report", false, "print the report after every run")
+	daemonCmd.Flags().IntVar(&maxRequests, "max-requests", 0, "stop collecting the diffs of a run after this many provider requests (0 means no limit)")
+	daemonCmd.Flags().DurationVar(&syncTimeout, "run-timeout", 0, "time limit of every run (0 means no limit)")
+}
+
+// daemonLock represents the content of the lock file
+type daemonLock struct {
+	Host    string    `json:"host"`
+	PID     int       `json:"pid"`
+	Started time.Time `json:"started"`
+}
+
+func lockFilePath() string {
+	if daemonLockFile != "" {
+		return daemonLockFile
+	}
+	viper.SetDefault("manifest.dir", ".")
+
+	return filepath.Join(viper.GetString("manifest.dir"), ".heatmap-daemon.lock")
+}
+
+// acquireLock creates the lock file, taking over one older than the TTL.
+// It returns false if another run holds the lock.
+func acquireLock(path string, ttl time.Duration) (bool, error) {
+	host, _ := os.Hostname()
+	raw, err := json.Marshal(daemonLock{Host: host, PID: os.Getpid(), Started: time.Now()})
+	if err != nil {
+		return false, err
+	}
+
+	for attempt := 0; attempt < 2; attempt++ {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err == nil {
+			_, err = f.Write(raw)
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+			return err == nil, err
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return false, err
+		}
+
+		held := daemonLock{}
+		if raw, err := os.ReadFile(path); err == nil {
+			json.Unmarshal(raw, &held)
+		}
+		info, err := os.Stat(path)
+		if err != nil || time.Since(info.ModTime()) < ttl {
+			slog.Warn("lock held by another run", "lock", path, "host", held.Host, "pid", held.PID, "started", held.Started)
+			return false, nil
+		}
+
+		slog.Warn("taking over a stale lock", "lock", path, "host", held.Host, "pid", held.PID, "started", held.Started)
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return false, err
+		}
+	}
+
+	return false, nil
+}
+
+func daemon(cmd *cobra.Command, args []string) error {
+	expr := daemonSchedule
+	if expr == "" {
+		expr = viper.GetString("daemon.schedule")
+	}
+	if expr == "" {
+		return configError(errors.New("neither --schedule nor daemon.schedule is set"))
+	}
+	schedule, err := parseCron(expr)
+	if err != nil {
+		return configError(err)
+	}
+
+	viper.SetDefault("daemon.lock_ttl", defaultLockTTL)
+	ttl := viper.GetDuration("daemon.lock_ttl")
+
+	rules, err := labelRules()
+	if err != nil {
+		return configError(err)
+	}
+
+	ctx, cancel, st, err := openStore()
+	if err != nil {
+		return err
+	}
+	defer cancel()
+	defer closeStore(context.Background(), st)
+
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	projects := backfillProjects(cmd)
+	lock := lockFilePath()
+	run := func() {
+		ok, err := acquireLock(lock, ttl)
+		if err != nil {
+			slog.Error("taking the lock failed", "lock", lock, "err", err)
+			return
+		}
+		if !ok {
+			return
+		}
+		defer os.Remove(lock)
+
+		slog.Info("run started", "schedule", expr, "run_id", startRun())
+		start := time.Now()
+		if err := runSync(ctx, st, projects, rules); err != nil {
+			slog.Error("run failed", "err", err, "elapsed", time.Since(start).Round(time.Millisecond))
+			return
+		}
+		slog.Info("run finished", "elapsed", time.Since(start).Round(time.Millisecond))
+	}
+
+	if daemonNow {
+		run()
+	}
+	for ctx.Err() == nil {
+		next := schedule.next(time.Now())
+		if next.IsZero() {
+			return configError(fmt.Errorf("cron expression %q never matches", expr))
+		}
+		slog.Info("next run scheduled", "at", next.Format(time.RFC3339))
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+		case <-timer.C:
+			run()
+		}
+	}
+	slog.Info("daemon stopped")
+
+	return nil
+}
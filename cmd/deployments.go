@@ -0,0 +1,196 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// deploymentsCmd represents the analyze deployments command
+var deploymentsCmd = &cobra.Command{
+	Use:   "deployments",
+	Short: "Shows how quickly the fixes of the hottest files reach production",
+	Long: `Fetches the deployments of the repos to --environment and
+correlates them with the merge times of the bug fixes. A fix counts
+as delivered by the first successful deployment after its merge,
+and its lead time is the time between the two.
+
+The hottest files of the report are printed with the number of
+their fixes, how many of them are deployed and the median and 90th
+percentile of the lead times in hours. Only GitHub knows the
+deployments, and the PRs collected without a merge time are not
+considered.`,
+	RunE: deliveries,
+}
+
+var (
+	deploymentsEnvironment string
+	deploymentsTop         int
+	deploymentsFormat      string
+)
+
+const defaultDeploymentsEnvironment = "production"
+
+// fileDelivery represents the delivery of the fixes of a single file
+type fileDelivery struct {
+	Repo     Repo    `json:"repo"`
+	File     string  `json:"file"`
+	Score    float64 `json:"score"`
+	Fixes    int     `json:"fixes"`
+	Deployed int     `json:"deployed"`
+	Median   float64 `json:"median_lead_hours"`
+	P90      float64 `json:"p90_lead_hours"`
+}
+
+func init() {
+	analyzeCmd.AddCommand(deploymentsCmd)
+	deploymentsCmd.Flags().StringVar(&deploymentsEnvironment, "environment", defaultDeploymentsEnvironment, "environment of the deployments")
+	deploymentsCmd.Flags().IntVar(&deploymentsTop, "top", defaultReportTop, "number of the hottest files to print (0 prints all)")
+	deploymentsCmd.Flags().StringVar(&deploymentsFormat, "format", "table", "output format: table or json")
+}
+
+func deliveries(cmd *cobra.Command, args []string) error {
+	write, ok := deliveryWriters[deploymentsFormat]
+	if !ok {
+		return configError(fmt.Errorf("unknown report format %q", deploymentsFormat))
+	}
+
+	ctx, cancel, st, err := openReadStore()
+	if err != nil {
+		return err
+	}
+	defer cancel()
+	defer closeStore(ctx, st)
+
+	provider, err := newVCSProvider(ctx)
+	if err != nil {
+		return configError(err)
+	}
+	lister, ok := provider.(deploymentLister)
+	if !ok {
+		return configError(fmt.Errorf("%s doesn't provide deployments", provider.applicationType()))
+	}
+
+	heat, prs, err := loadHeat(ctx, st)
+	if err != nil {
+		return err
+	}
+	if deploymentsTop > 0 && len(heat) > deploymentsTop {
+		heat = heat[:deploymentsTop]
+	}
+
+	leads, err := fixLeadTimes(ctx, lister, prs, deploymentsEnvironment)
+	if err != nil {
+		return vcsError(err)
+	}
+
+	return write(os.Stdout, computeDeliveries(heat, leads))
+}
+
+// fixLeadTimes returns the time from the merge of every bug fix to its
+// first deployment to the environment. The fixes which aren't deployed
+// yet are left out.
+func fixLeadTimes(ctx context.Context, lister deploymentLister, prs []pr, environment string) (map[string]time.Duration, error) {
+	byRepo := make(map[Repo][]pr)
+	for _, p := range prs {
+		if !p.MergedAt.IsZero() {
+			byRepo[p.Repo] = append(byRepo[p.Repo], p)
+		}
+	}
+
+	leads := make(map[string]time.Duration)
+	for repo, repoPRs := range byRepo {
+		deployments, err := lister.deployments(ctx, repo, environment)
+		if err != nil {
+			return nil, fmt.Errorf("listing the deployments of %s/%s failed: %w", repo.Owner, repo.Name, err)
+		}
+
+		for _, p := range repoPRs {
+			if i := firstDeployment(deployments, p.MergedAt); i >= 0 {
+				leads[prKey(p.Repo, p.PRID)] = deployments[i].DeployedAt.Sub(p.MergedAt)
+			}
+		}
+	}
+
+	return leads, nil
+}
+
+// firstDeployment returns the index of the first of the sorted deployments
+// at or after the time, -1 if there's none
+func firstDeployment(deployments []deployment, t time.Time) int {
+	i := sort.Search(len(deployments), func(i int) bool { return !deployments[i].DeployedAt.Before(t) })
+	if i == len(deployments) {
+		return -1
+	}
+
+	return i
+}
+
+// computeDeliveries summarizes the lead times of the fixes of the files
+func computeDeliveries(heat []fileHeat, leads map[string]time.Duration) []fileDelivery {
+	result := make([]fileDelivery, 0, len(heat))
+	for _, h := range heat {
+		d := fileDelivery{Repo: h.Repo, File: h.File, Score: h.Score, Fixes: len(h.prs)}
+		hours := make([]float64, 0, len(h.prs))
+		for p := range h.prs {
+			if lead, ok := leads[p]; ok {
+				hours = append(hours, lead.Hours())
+			}
+		}
+		d.Deployed = len(hours)
+		d.Median = percentile(hours, 0.5)
+		d.P90 = percentile(hours, 0.9)
+
+		result = append(result, d)
+	}
+
+	return result
+}
+
+// percentile returns the nearest-rank percentile of the values, zero if
+// there are none
+func percentile(values []float64, p float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	rank := int(math.Ceil(p*float64(len(sorted)))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+
+	return sorted[rank]
+}
+
+// deliveryWriters holds the writers of the supported delivery formats
+var deliveryWriters = map[string]func(io.Writer, []fileDelivery) error{
+	"table": writeDeliveryTable,
+	"json":  writeDeliveryJSON,
+}
+
+func writeDeliveryTable(w io.Writer, deliveries []fileDelivery) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "SCORE\tFIXES\tDEPLOYED\tMEDIAN H\tP90 H\tREPO\tFILE")
+	for _, d := range deliveries {
+		fmt.Fprintf(tw, "%.2f\t%d\t%d\t%.1f\t%.1f\t%s/%s\t%s\n", d.Score, d.Fixes, d.Deployed, d.Median, d.P90, d.Repo.Owner, d.Repo.Name, d.File)
+	}
+
+	return tw.Flush()
+}
+
+func writeDeliveryJSON(w io.Writer, deliveries []fileDelivery) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+
+	return enc.Encode(deliveries)
+}
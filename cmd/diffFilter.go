@@ -0,0 +1,87 @@
+package cmd
+
+import (
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// pathFilter represents the include and exclude patterns of the files
+// kept in the diffs. A file is kept if it matches one of the include
+// patterns, or there are none, and none of the exclude patterns.
+type pathFilter struct {
+	include []string
+	exclude []string
+}
+
+// diffFilter returns the filter of the diffs.include and diffs.exclude
+// config keys. A pattern with a slash is matched against the path of the
+// file from the root of the repo, where ** matches any number of path
+// segments. A pattern without one matches the name of the file in any
+// directory, e.g. *.pb.go or package-lock.json.
+func diffFilter() pathFilter {
+	return pathFilter{
+		include: viper.GetStringSlice("diffs.include"),
+		exclude: viper.GetStringSlice("diffs.exclude"),
+	}
+}
+
+func (f pathFilter) empty() bool {
+	return len(f.include) == 0 && len(f.exclude) == 0
+}
+
+// keep reports whether the file passes the filter
+func (f pathFilter) keep(file string) bool {
+	if len(f.include) > 0 && !matchesPath(f.include, file) {
+		return false
+	}
+
+	return !matchesPath(f.exclude, file)
+}
+
+func matchesPath(patterns []string, file string) bool {
+	segments := strings.Split(file, "/")
+	for _, pattern := range patterns {
+		pattern = strings.Trim(pattern, "/")
+		if !strings.Contains(pattern, "/") {
+			pattern = "**/" + pattern
+		}
+		if matchGlob(strings.Split(pattern, "/"), segments) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// filterDiffs returns the diffs of the files passing the filter
+func (f pathFilter) filterDiffs(diffs []diff) []diff {
+	kept := make([]diff, 0, len(diffs))
+	for _, d := range diffs {
+		if f.keep(d.File) {
+			kept = append(kept, d)
+		}
+	}
+
+	return kept
+}
+
+// apply drops the files not passing the filter from the diffs of the PR
+// and of its commits. The totals of the PR are left as they are. It
+// reports whether any file was dropped.
+func (f pathFilter) apply(p *pr) bool {
+	if f.empty() {
+		return false
+	}
+
+	n := len(p.Diff)
+	p.Diff = f.filterDiffs(p.Diff)
+	changed := len(p.Diff) != n
+	for i := range p.Commits {
+		n := len(p.Commits[i].Diff)
+		p.Commits[i].Diff = f.filterDiffs(p.Commits[i].Diff)
+		changed = changed || len(p.Commits[i].Diff) != n
+	}
+
+	return changed
+}
@@ -0,0 +1,210 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// doraCmd represents the analyze dora command
+var doraCmd = &cobra.Command{
+	Use:   "dora",
+	Short: "Estimates DORA metrics of the repos from the bug fixes",
+	Long: `Estimates the DORA metrics of every repo over the period of
+--since from the mappings, the diffs and the deployments to
+--environment:
+
+  deploys/week  successful deployments per week
+  lead time     median hours from the merge of a fix to its deployment
+  failure rate  share of the deployments shipping a bug fix, the
+                proxy of the deployments that failed before
+  MTTR          median hours from the creation of a bug to the
+                deployment of its last fix, or its merge if the
+                deployments aren't known
+
+Only GitHub knows the deployments; for the other providers only the
+fixes and MTTR, up to the merges, are estimated.`,
+	RunE: dora,
+}
+
+var (
+	doraEnvironment string
+	doraSince       string
+	doraFormat      string
+)
+
+const defaultDoraSince = "90d"
+
+// repoDORA represents the DORA metrics of a single repo
+type repoDORA struct {
+	Repo         Repo    `json:"repo"`
+	Deployments  int     `json:"deployments"`
+	PerWeek      float64 `json:"deployments_per_week"`
+	Fixes        int     `json:"fixes"`
+	LeadTime     float64 `json:"median_lead_hours"`
+	FailureRate  float64 `json:"change_failure_rate"`
+	MTTR         float64 `json:"mttr_hours"`
+	Restorations int     `json:"restorations"`
+}
+
+func init() {
+	analyzeCmd.AddCommand(doraCmd)
+	doraCmd.Flags().StringVar(&doraEnvironment, "environment", defaultDeploymentsEnvironment, "environment of the deployments")
+	doraCmd.Flags().StringVar(&doraSince, "since", defaultDoraSince, "length of the period before now, e.g. 90d")
+	doraCmd.Flags().StringVar(&doraFormat, "format", "table", "output format: table or json")
+}
+
+func dora(cmd *cobra.Command, args []string) error {
+	write, ok := doraWriters[doraFormat]
+	if !ok {
+		return configError(fmt.Errorf("unknown report format %q", doraFormat))
+	}
+	period, err := parseDays(doraSince)
+	if err != nil || period <= 0 {
+		return configError(fmt.Errorf("invalid period %q", doraSince))
+	}
+
+	ctx, cancel, st, err := openReadStore()
+	if err != nil {
+		return err
+	}
+	defer cancel()
+	defer closeStore(ctx, st)
+
+	provider, err := newVCSProvider(ctx)
+	if err != nil {
+		return configError(err)
+	}
+	lister, ok := provider.(deploymentLister)
+	if !ok {
+		slog.Warn("the provider doesn't know the deployments, only the fixes and MTTR are estimated", "provider", provider.applicationType())
+	}
+
+	mappings, err := st.Mappings(ctx)
+	if err != nil {
+		return storageError(fmt.Errorf("reading mappings failed: %w", err))
+	}
+	prs, err := st.PRs(ctx)
+	if err != nil {
+		return storageError(fmt.Errorf("reading diffs failed: %w", err))
+	}
+
+	metrics, err := computeDORA(ctx, lister, mappings, prs, doraEnvironment, time.Now().Add(-period), time.Now())
+	if err != nil {
+		return vcsError(err)
+	}
+
+	return write(os.Stdout, metrics)
+}
+
+// computeDORA estimates the metrics of the repos of the fixes merged in
+// the period. Without a lister the deployments are taken as unknown.
+func computeDORA(ctx context.Context, lister deploymentLister, mappings []mongoMapping, prs []pr, environment string, from, to time.Time) ([]repoDORA, error) {
+	fixes := make(map[Repo][]pr)
+	for _, p := range prs {
+		if !p.MergedAt.Before(from) && p.MergedAt.Before(to) {
+			fixes[p.Repo] = append(fixes[p.Repo], p)
+		}
+	}
+
+	bugsByPR := make(map[string][]mongoMapping)
+	for _, m := range mappings {
+		k := prKey(m.Repo, m.PRID)
+		bugsByPR[k] = append(bugsByPR[k], m)
+	}
+
+	weeks := to.Sub(from).Hours() / (24 * 7)
+	result := make([]repoDORA, 0, len(fixes))
+	for repo, repoFixes := range fixes {
+		var deployments []deployment
+		if lister != nil {
+			all, err := lister.deployments(ctx, repo, environment)
+			if err != nil {
+				return nil, fmt.Errorf("listing the deployments of %s/%s failed: %w", repo.Owner, repo.Name, err)
+			}
+			for _, d := range all {
+				if !d.DeployedAt.Before(from) && d.DeployedAt.Before(to) {
+					deployments = append(deployments, d)
+				}
+			}
+		}
+
+		r := repoDORA{Repo: repo, Deployments: len(deployments), PerWeek: float64(len(deployments)) / weeks, Fixes: len(repoFixes)}
+
+		leads := make([]float64, 0)
+		shipping := make(map[int]bool)
+		restored := make(map[string]time.Time)
+		created := make(map[string]time.Time)
+		for _, p := range repoFixes {
+			done := p.MergedAt
+			if i := firstDeployment(deployments, p.MergedAt); i >= 0 {
+				shipping[i] = true
+				leads = append(leads, deployments[i].DeployedAt.Sub(p.MergedAt).Hours())
+				done = deployments[i].DeployedAt
+			}
+
+			for _, m := range bugsByPR[prKey(p.Repo, p.PRID)] {
+				b := m.bugKey()
+				restored[b] = latest(restored[b], done)
+				if !m.CreatedAt.IsZero() {
+					created[b] = m.CreatedAt
+				}
+			}
+		}
+
+		r.LeadTime = percentile(leads, 0.5)
+		if len(deployments) > 0 {
+			r.FailureRate = float64(len(shipping)) / float64(len(deployments))
+		}
+
+		recovery := make([]float64, 0)
+		for b, t := range created {
+			recovery = append(recovery, math.Max(0, restored[b].Sub(t).Hours()))
+		}
+		r.MTTR = percentile(recovery, 0.5)
+		r.Restorations = len(recovery)
+
+		result = append(result, r)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Fixes != result[j].Fixes {
+			return result[i].Fixes > result[j].Fixes
+		}
+		return result[i].Repo.Owner+"/"+result[i].Repo.Name < result[j].Repo.Owner+"/"+result[j].Repo.Name
+	})
+
+	return result, nil
+}
+
+// doraWriters holds the writers of the supported DORA formats
+var doraWriters = map[string]func(io.Writer, []repoDORA) error{
+	"table": writeDORATable,
+	"json":  writeDORAJSON,
+}
+
+func writeDORATable(w io.Writer, metrics []repoDORA) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "REPO\tDEPLOYS\tDEPLOYS/WEEK\tFIXES\tLEAD H\tFAILURE RATE\tMTTR H")
+	for _, m := range metrics {
+		fmt.Fprintf(tw, "%s/%s\t%d\t%.1f\t%d\t%.1f\t%.0f%%\t%.1f\n", m.Repo.Owner, m.Repo.Name, m.Deployments, m.PerWeek, m.Fixes, m.LeadTime, 100*m.FailureRate, m.MTTR)
+	}
+
+	return tw.Flush()
+}
+
+func writeDORAJSON(w io.Writer, metrics []repoDORA) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+
+	return enc.Encode(metrics)
+}
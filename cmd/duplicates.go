@@ -0,0 +1,164 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// defaultFoldLinks are the relations of the links, as read from the
+// duplicate, which fold it into the linked issue
+var defaultFoldLinks = []string{"duplicates", "is caused by"}
+
+// issueLink is a representation of a Jira issue link. Only one of the
+// issues is set, the other end of the link being the issue itself.
+type issueLink struct {
+	Type struct {
+		Inward  string `json:"inward"`
+		Outward string `json:"outward"`
+	} `json:"type"`
+	InwardIssue  *linkedIssue `json:"inwardIssue"`
+	OutwardIssue *linkedIssue `json:"outwardIssue"`
+}
+
+// linkedIssue is a representation of the issue at the other end of a link
+type linkedIssue struct {
+	ID     int    `json:"id,string"`
+	Key    string `json:"key"`
+	Fields struct {
+		Summary  string    `json:"summary"`
+		Priority jiraNamed `json:"priority"`
+	} `json:"fields"`
+}
+
+// duplicatePair represents an issue folded into its canonical issue
+type duplicatePair struct {
+	duplicate linkedIssue
+	canonical string
+}
+
+// foldDuplicates tells whether backfill.fold_duplicates is set
+func foldDuplicates() bool {
+	return viper.GetBool("backfill.fold_duplicates")
+}
+
+// foldLinks returns the relations of backfill.fold_links, lower-cased
+func foldLinks() map[string]bool {
+	viper.SetDefault("backfill.fold_links", defaultFoldLinks)
+
+	links := make(map[string]bool)
+	for _, l := range viper.GetStringSlice("backfill.fold_links") {
+		links[strings.ToLower(strings.TrimSpace(l))] = true
+	}
+
+	return links
+}
+
+// duplicatePairs returns the pairs of the links of the bug: the bug is the
+// duplicate if the relation read from it is one of the links, e.g. it
+// duplicates the other issue, and the other issue is if the reverse
+// relation is, e.g. the bug is duplicated by it
+func (b bug) duplicatePairs(links map[string]bool) []duplicatePair {
+	issueLinks := make([]issueLink, 0)
+	if err := json.Unmarshal(b.Fields["issuelinks"], &issueLinks); err != nil {
+		return nil
+	}
+
+	self := linkedIssue{ID: b.ID, Key: b.Key}
+	self.Fields.Summary = b.summary()
+	self.Fields.Priority.Name = b.priority()
+
+	pairs := make([]duplicatePair, 0)
+	for _, l := range issueLinks {
+		// The relation from the bug to the other issue and back
+		other, relation, reverse := l.OutwardIssue, l.Type.Outward, l.Type.Inward
+		if other == nil {
+			other, relation, reverse = l.InwardIssue, l.Type.Inward, l.Type.Outward
+		}
+		if other == nil {
+			continue
+		}
+
+		switch {
+		case links[strings.ToLower(relation)]:
+			pairs = append(pairs, duplicatePair{duplicate: self, canonical: other.Key})
+		case links[strings.ToLower(reverse)]:
+			pairs = append(pairs, duplicatePair{duplicate: *other, canonical: b.Key})
+		}
+	}
+
+	return pairs
+}
+
+// foldDuplicateMappings maps the duplicates among the bugs of the manifest,
+// and the duplicates linked to them, to the PRs of their canonical issues,
+// so they add to the heat of the files of the original fix. The canonical
+// issues are looked up in the new mappings and in the store; a duplicate
+// of an issue not mapped yet is folded when the issue is.
+func foldDuplicateMappings(ctx context.Context, st store, m *manifest, newMappings []mongoMapping, project string) ([]mongoMapping, error) {
+	links := foldLinks()
+	pairs := make([]duplicatePair, 0)
+	bugs := make(map[string]bug)
+	for _, item := range m.Items {
+		b := bug{}
+		if err := json.Unmarshal(item.Data, &b); err != nil {
+			return nil, err
+		}
+		bugs[b.ref()] = b
+		pairs = append(pairs, b.duplicatePairs(links)...)
+	}
+	if len(pairs) == 0 {
+		return nil, nil
+	}
+
+	stored, err := st.Mappings(ctx)
+	if err != nil {
+		return nil, storageError(fmt.Errorf("reading mappings failed: %w", err))
+	}
+
+	type mappingKey struct {
+		issue string
+		repo  Repo
+		prID  int
+	}
+	seen := make(map[mappingKey]bool)
+	byKey := make(map[string][]mongoMapping)
+	for _, mappings := range [][]mongoMapping{stored, newMappings} {
+		for _, mm := range mappings {
+			seen[mappingKey{mm.issueRef(), mm.Repo, mm.PRID}] = true
+			if mm.IssueKey != "" {
+				byKey[mm.IssueKey] = append(byKey[mm.IssueKey], mm)
+			}
+		}
+	}
+
+	folded := make([]mongoMapping, 0)
+	for _, p := range pairs {
+		for _, c := range byKey[p.canonical] {
+			// the linked issues are the ones of Jira
+			k := mappingKey{issueRef("", p.duplicate.ID, ""), c.Repo, c.PRID}
+			if p.duplicate.ID == 0 || seen[k] {
+				continue
+			}
+			seen[k] = true
+
+			folded = append(folded, mongoMapping{
+				Project:     project,
+				IssueID:     p.duplicate.ID,
+				IssueKey:    p.duplicate.Key,
+				Repo:        c.Repo,
+				PRID:        c.PRID,
+				Summary:     p.duplicate.Fields.Summary,
+				Priority:    p.duplicate.Fields.Priority.Name,
+				DuplicateOf: p.canonical,
+			})
+		}
+	}
+	// The duplicates found in the manifest get all of their metadata
+	setIssueMetadata(folded, bugs)
+
+	return folded, nil
+}
@@ -0,0 +1,62 @@
+package cmd
+
+import "errors"
+
+// Exit codes of the process for the different classes of errors
+const (
+	exitFailure = 1
+	exitConfig  = 2
+	exitJira    = 3
+	exitVCS     = 4
+	exitStorage = 5
+	exitGate    = 6
+)
+
+// classifiedError attaches the exit code of its class to an error
+type classifiedError struct {
+	code int
+	err  error
+}
+
+func (e *classifiedError) Error() string {
+	return e.err.Error()
+}
+
+func (e *classifiedError) Unwrap() error {
+	return e.err
+}
+
+// configError marks an error caused by a missing or invalid configuration
+func configError(err error) error {
+	return &classifiedError{code: exitConfig, err: err}
+}
+
+// jiraError marks an error returned while talking to Jira
+func jiraError(err error) error {
+	return &classifiedError{code: exitJira, err: err}
+}
+
+// vcsError marks an error returned while talking to the VCS provider
+func vcsError(err error) error {
+	return &classifiedError{code: exitVCS, err: err}
+}
+
+// storageError marks an error returned while talking to the database
+func storageError(err error) error {
+	return &classifiedError{code: exitStorage, err: err}
+}
+
+// gateError marks a quality gate which didn't pass
+func gateError(err error) error {
+	return &classifiedError{code: exitGate, err: err}
+}
+
+// exitCode returns the exit code of the class of the error
+func exitCode(err error) int {
+	var ce *classifiedError
+	if errors.As(err, &ce) {
+		return ce.code
+	}
+
+	return exitFailure
+}
@@ -0,0 +1,552 @@
+package cmd
+
+import (
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// exportCmd represents the export command
+var exportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Exports a collection of the store",
+	Long: `Streams the documents of a collection of the store. The
+collections are mappings (or jira), prs (or github or diffs), sync,
+reports, archive, repos, annotations and payloads.
+
+With --format ndjson, the default, one JSON document per line is
+written into multi-part files of --chunk-size documents named
+<collection>-0001.ndjson.gz, <collection>-0002.ndjson.gz and so on
+in --dir. The progress is kept in <collection>.export.json after
+every completed part, so an interrupted export can be continued
+with --resume from the part it stopped in.
+
+With --format json or csv the collection is written to the single
+file of --out, or the standard output, as a JSON array or as CSV
+with a row per mapping, per changed file of a PR, per watermark,
+per file of a report, per archived document, per repo or per
+annotation.
+
+With --format sarif the --top hottest files, ranked like by report,
+are written as the results of a SARIF log instead, with their bugs,
+PRs and scores, for GitHub code scanning or an IDE to annotate the
+files the reviewers should pay extra attention to. --collection
+isn't needed. The paths of the files are relative to their repos,
+so a log uploaded for a repo should be limited to it with --repo.
+
+If signing is configured, every part, or the file of --out, is
+signed into <file>.sig; see verify-signature.`,
+	RunE: export,
+}
+
+const defaultExportChunkSize = 100000
+
+var (
+	exportCollection string
+	exportDir        string
+	exportChunkSize  int
+	exportFormat     string
+	exportOut        string
+)
+
+// exportState represents the progress of an export: the number of the
+// completed parts and the resume token of the last exported document
+type exportState struct {
+	Collection string `json:"collection"`
+	Parts      int    `json:"parts"`
+	Docs       int    `json:"docs"`
+	Token      string `json:"token"`
+}
+
+func init() {
+	rootCmd.AddCommand(exportCmd)
+	exportCmd.Flags().StringVar(&exportCollection, "collection", "", "collection to export")
+	exportCmd.Flags().StringVar(&exportDir, "dir", ".", "directory to write the parts to")
+	exportCmd.Flags().IntVar(&exportChunkSize, "chunk-size", defaultExportChunkSize, "number of documents of a part")
+	exportCmd.Flags().BoolVar(&resume, "resume", false, "continue the interrupted export")
+	exportCmd.Flags().StringVar(&exportFormat, "format", "ndjson", "output format: ndjson, json, csv or sarif")
+	exportCmd.Flags().StringVar(&exportOut, "out", "", "file to write the json, csv or sarif export to (default is the standard output)")
+	exportCmd.Flags().IntVar(&reportTop, "top", defaultReportTop, "number of the hottest files of --format sarif (0 exports all)")
+	exportCmd.Flags().StringVar(&repoScope, "repo", "", "only export the files of this owner/name repo with --format sarif")
+}
+
+func export(cmd *cobra.Command, args []string) error {
+	if exportFormat == "sarif" {
+		return exportSARIF()
+	}
+	if exportCollection == "" {
+		return configError(fmt.Errorf("no collection is set, see --collection"))
+	}
+	collection, err := storeCollection(exportCollection)
+	if err != nil {
+		return configError(err)
+	}
+	if err := checkSigner(); err != nil {
+		return err
+	}
+	if exportFormat != "ndjson" {
+		return exportFlat(collection)
+	}
+	if exportChunkSize <= 0 {
+		return configError(fmt.Errorf("invalid chunk size %d", exportChunkSize))
+	}
+	prefix := unsafeFileChars.ReplaceAllString(exportCollection, "_")
+
+	state := &exportState{Collection: collection}
+	statePath := filepath.Join(exportDir, prefix+".export.json")
+	if resume {
+		if state, err = loadExportState(statePath); err != nil {
+			return err
+		}
+		slog.Info("resuming export", "collection", collection, "parts", state.Parts, "docs", state.Docs)
+	}
+
+	ctx, cancel, st, err := openReadStore()
+	if err != nil {
+		return err
+	}
+	defer cancel()
+	defer closeStore(ctx, st)
+
+	part := &exportPart{}
+	err = st.Export(ctx, collection, state.Token, func(doc []byte, token string) error {
+		if part.gz == nil {
+			if err := part.create(filepath.Join(exportDir, fmt.Sprintf("%s-%04d.ndjson.gz", prefix, state.Parts+1))); err != nil {
+				return err
+			}
+		}
+		if err := part.write(doc); err != nil {
+			return err
+		}
+		part.token = token
+
+		if part.docs == exportChunkSize {
+			return finishExportPart(part, state, statePath)
+		}
+
+		return nil
+	})
+	if err == nil && part.gz != nil {
+		err = finishExportPart(part, state, statePath)
+	}
+	if err != nil {
+		part.close()
+		return storageError(fmt.Errorf("exporting %s failed: %w", collection, err))
+	}
+
+	slog.Info("collection exported", "collection", collection, "parts", state.Parts, "docs", state.Docs)
+
+	if err := os.Remove(statePath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	return nil
+}
+
+// exportFlat writes the collection to a single file in the json or csv format
+func exportFlat(collection string) error {
+	newWriter, ok := flatExportWriters[exportFormat]
+	if !ok {
+		return configError(fmt.Errorf("unknown export format %q", exportFormat))
+	}
+
+	ctx, cancel, st, err := openReadStore()
+	if err != nil {
+		return err
+	}
+	defer cancel()
+	defer closeStore(ctx, st)
+
+	out := os.Stdout
+	if exportOut != "" && exportOut != "-" {
+		if out, err = os.Create(exportOut); err != nil {
+			return err
+		}
+		defer out.Close()
+	}
+
+	w := newWriter(out, collection)
+	docs := 0
+	err = st.Export(ctx, collection, "", func(doc []byte, token string) error {
+		docs++
+		return w.write(doc)
+	})
+	if err == nil {
+		err = w.close()
+	}
+	if err != nil {
+		return storageError(fmt.Errorf("exporting %s failed: %w", collection, err))
+	}
+
+	slog.Info("collection exported", "collection", collection, "docs", docs)
+
+	if out != os.Stdout {
+		if err := out.Close(); err != nil {
+			return err
+		}
+		return signFiles(exportOut)
+	}
+
+	return nil
+}
+
+// docWriter writes the exported documents in a format
+type docWriter interface {
+	write(doc []byte) error
+	close() error
+}
+
+// flatExportWriters holds the writers of the single file export formats
+var flatExportWriters = map[string]func(io.Writer, string) docWriter{
+	"json": func(w io.Writer, collection string) docWriter { return &jsonArrayWriter{w: w} },
+	"csv": func(w io.Writer, collection string) docWriter {
+		return &csvDocWriter{w: csv.NewWriter(w), rows: csvExports[collection]}
+	},
+}
+
+// jsonArrayWriter writes the documents as the elements of a JSON array
+type jsonArrayWriter struct {
+	w    io.Writer
+	docs int
+}
+
+func (j *jsonArrayWriter) write(doc []byte) error {
+	sep := ",\n"
+	if j.docs == 0 {
+		sep = "[\n"
+	}
+	j.docs++
+
+	if _, err := io.WriteString(j.w, sep); err != nil {
+		return err
+	}
+	_, err := j.w.Write(doc)
+
+	return err
+}
+
+func (j *jsonArrayWriter) close() error {
+	end := "\n]\n"
+	if j.docs == 0 {
+		end = "[]\n"
+	}
+	_, err := io.WriteString(j.w, end)
+
+	return err
+}
+
+// csvExport represents the CSV layout of a collection
+type csvExport struct {
+	header []string
+	rows   func(doc []byte) ([][]string, error)
+}
+
+// csvDocWriter writes the documents as CSV rows
+type csvDocWriter struct {
+	w      *csv.Writer
+	rows   csvExport
+	header bool
+}
+
+func (c *csvDocWriter) write(doc []byte) error {
+	if !c.header {
+		c.header = true
+		if err := c.w.Write(c.rows.header); err != nil {
+			return err
+		}
+	}
+
+	rows, err := c.rows.rows(doc)
+	if err != nil {
+		return err
+	}
+
+	return c.w.WriteAll(rows)
+}
+
+func (c *csvDocWriter) close() error {
+	if !c.header {
+		c.w.Write(c.rows.header)
+	}
+	c.w.Flush()
+
+	return c.w.Error()
+}
+
+// csvExports holds the CSV layouts of the collections
+var csvExports = map[string]csvExport{
+	"mappings": {
+		header: []string{"project", "tracker", "issue_id", "issue_uid", "owner", "repo", "pr_id", "summary", "priority", "components", "labels", "fix_versions", "request_type", "sla_breached", "origin", "resolved_at", "attachments", "description_length"},
+		rows: func(doc []byte) ([][]string, error) {
+			m := mongoMapping{}
+			if err := json.Unmarshal(doc, &m); err != nil {
+				return nil, err
+			}
+
+			return [][]string{{
+				m.Project,
+				m.Tracker,
+				strconv.Itoa(m.IssueID),
+				m.IssueUID,
+				m.Repo.Owner,
+				m.Repo.Name,
+				strconv.Itoa(m.PRID),
+				m.Summary,
+				m.Priority,
+				strings.Join(m.Components, ";"),
+				strings.Join(m.Labels, ";"),
+				strings.Join(fixVersionNames(m.FixVersions), ";"),
+				m.RequestType,
+				strconv.FormatBool(m.SLABreached),
+				m.Origin,
+				csvTime(m.ResolvedAt),
+				strconv.Itoa(m.Attachments),
+				strconv.Itoa(m.DescriptionLength),
+			}}, nil
+		},
+	},
+	"prs": {
+		header: []string{"owner", "repo", "pr_id", "author", "merged_at", "base_branch", "patch_id", "file", "status", "additions", "deletions", "changes"},
+		rows: func(doc []byte) ([][]string, error) {
+			p := pr{}
+			if err := json.Unmarshal(doc, &p); err != nil {
+				return nil, err
+			}
+
+			rows := make([][]string, 0, len(p.Diff))
+			for _, d := range p.Diff {
+				rows = append(rows, []string{
+					p.Repo.Owner,
+					p.Repo.Name,
+					strconv.Itoa(p.PRID),
+					p.Author,
+					csvTime(p.MergedAt),
+					p.Branch,
+					p.PatchID,
+					d.File,
+					d.Status,
+					strconv.Itoa(d.Additions),
+					strconv.Itoa(d.Deletions),
+					strconv.Itoa(d.Changes),
+				})
+			}
+
+			return rows, nil
+		},
+	},
+	"sync": {
+		header: []string{"project", "last_sync"},
+		rows: func(doc []byte) ([][]string, error) {
+			s := syncState{}
+			if err := json.Unmarshal(doc, &s); err != nil {
+				return nil, err
+			}
+
+			return [][]string{{s.Project, csvTime(s.LastSync)}}, nil
+		},
+	},
+	"reports": {
+		header: []string{"created", "owner", "repo", "file", "group", "score", "risk", "bugs", "prs", "changes", "first_seen", "last_seen"},
+		rows: func(doc []byte) ([][]string, error) {
+			r := heatReport{}
+			if err := json.Unmarshal(doc, &r); err != nil {
+				return nil, err
+			}
+
+			rows := make([][]string, 0, len(r.Files))
+			for _, h := range r.Files {
+				rows = append(rows, []string{
+					csvTime(r.Created),
+					h.Repo.Owner,
+					h.Repo.Name,
+					h.File,
+					h.Group,
+					strconv.FormatFloat(h.Score, 'f', 2, 64),
+					strconv.FormatFloat(h.Risk, 'f', 1, 64),
+					strconv.Itoa(h.Bugs),
+					strconv.Itoa(h.PRs),
+					strconv.Itoa(h.Changes),
+					csvTime(h.FirstSeen),
+					csvTime(h.LastSeen),
+				})
+			}
+
+			return rows, nil
+		},
+	},
+	"archive": {
+		header: []string{"kind", "archived_at", "project", "issue_id", "owner", "repo", "pr_id", "resolved_at", "merged_at"},
+		rows: func(doc []byte) ([][]string, error) {
+			a := archivedDoc{}
+			if err := json.Unmarshal(doc, &a); err != nil {
+				return nil, err
+			}
+
+			row := []string{a.Kind, csvTime(a.ArchivedAt), "", "", "", "", "", "", ""}
+			switch {
+			case a.Mapping != nil:
+				m := a.Mapping
+				copy(row[2:], []string{m.Project, strconv.Itoa(m.IssueID), m.Repo.Owner, m.Repo.Name, strconv.Itoa(m.PRID), csvTime(m.ResolvedAt)})
+			case a.PR != nil:
+				p := a.PR
+				copy(row[4:], []string{p.Repo.Owner, p.Repo.Name, strconv.Itoa(p.PRID), "", csvTime(p.MergedAt)})
+			}
+
+			return [][]string{row}, nil
+		},
+	},
+	"annotations": {
+		header: []string{"kind", "path", "team", "note", "by", "until"},
+		rows: func(doc []byte) ([][]string, error) {
+			a := annotation{}
+			if err := json.Unmarshal(doc, &a); err != nil {
+				return nil, err
+			}
+
+			until := ""
+			if !a.Until.IsZero() {
+				until = a.Until.Format(annotationDate)
+			}
+
+			return [][]string{{a.Kind, a.Path, a.Team, a.Note, a.By, until}}, nil
+		},
+	},
+	"payloads": {
+		header: []string{"source", "key", "url", "fetched_at", "expires_at", "bytes"},
+		rows: func(doc []byte) ([][]string, error) {
+			p := rawPayload{}
+			if err := json.Unmarshal(doc, &p); err != nil {
+				return nil, err
+			}
+
+			return [][]string{{p.Source, p.Key, p.URL, csvTime(p.FetchedAt), csvTime(p.ExpiresAt), strconv.Itoa(len(p.Data))}}, nil
+		},
+	},
+	"repos": {
+		header: []string{"owner", "repo", "archived", "topics", "languages", "fetched_at"},
+		rows: func(doc []byte) ([][]string, error) {
+			r := repoMeta{}
+			if err := json.Unmarshal(doc, &r); err != nil {
+				return nil, err
+			}
+
+			languages := make([]string, 0, len(r.Languages))
+			for l := range r.Languages {
+				languages = append(languages, l)
+			}
+			sort.Strings(languages)
+
+			return [][]string{{r.Repo.Owner, r.Repo.Name, strconv.FormatBool(r.Archived), strings.Join(r.Topics, " "), strings.Join(languages, " "), csvTime(r.FetchedAt)}}, nil
+		},
+	},
+}
+
+// csvTime formats a time as RFC 3339, leaving an unknown time empty
+func csvTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+
+	return t.UTC().Format(time.RFC3339)
+}
+
+// exportPart represents the part file being written
+type exportPart struct {
+	path  string
+	file  *os.File
+	gz    *gzip.Writer
+	docs  int
+	token string
+}
+
+func (p *exportPart) create(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	p.path, p.file, p.gz, p.docs = path, f, gzip.NewWriter(f), 0
+
+	return nil
+}
+
+func (p *exportPart) write(doc []byte) error {
+	if _, err := p.gz.Write(append(doc, '\n')); err != nil {
+		return err
+	}
+	p.docs++
+
+	return nil
+}
+
+// close flushes and closes the part file
+func (p *exportPart) close() error {
+	if p.gz == nil {
+		return nil
+	}
+	err := p.gz.Close()
+	if cerr := p.file.Close(); err == nil {
+		err = cerr
+	}
+	p.file, p.gz = nil, nil
+
+	return err
+}
+
+// finishExportPart closes and signs the part and records it in the state
+func finishExportPart(p *exportPart, state *exportState, path string) error {
+	if err := p.close(); err != nil {
+		return err
+	}
+	if err := signFiles(p.path); err != nil {
+		return err
+	}
+
+	state.Parts++
+	state.Docs += p.docs
+	state.Token = p.token
+	slog.Debug("export part written", "collection", state.Collection, "part", state.Parts, "docs", p.docs)
+
+	return saveExportState(path, state)
+}
+
+func loadExportState(path string) (*exportState, error) {
+	raw, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, configError(fmt.Errorf("no export to resume in %s", path))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	state := &exportState{}
+	if err := json.Unmarshal(raw, state); err != nil {
+		return nil, fmt.Errorf("reading %s failed: %w", path, err)
+	}
+
+	return state, nil
+}
+
+func saveExportState(path string, state *exportState) error {
+	raw, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path+".tmp", raw, 0o600); err != nil {
+		return err
+	}
+
+	return os.Rename(path+".tmp", path)
+}
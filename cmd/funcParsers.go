@@ -0,0 +1,229 @@
+package cmd
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"regexp"
+	"strings"
+)
+
+// functionSpan represents the lines of a function in a file
+type functionSpan struct {
+	Name  string
+	Start int
+	End   int
+}
+
+// functionParsers holds the parsers of the functions of a file by the
+// language of the file
+var functionParsers = map[string]func(src []byte) []functionSpan{
+	"go":     goFunctions,
+	"java":   javaFunctions,
+	"python": pythonFunctions,
+}
+
+// goFunctions returns the functions and the methods of a Go file, named
+// Type.Method. A file which doesn't parse keeps the functions parsed
+// before the error.
+func goFunctions(src []byte) []functionSpan {
+	fset := token.NewFileSet()
+	file, _ := parser.ParseFile(fset, "", src, parser.SkipObjectResolution)
+	if file == nil {
+		return nil
+	}
+
+	spans := make([]functionSpan, 0)
+	for _, decl := range file.Decls {
+		fn, ok := decl.(*ast.FuncDecl)
+		if !ok || fn.Body == nil {
+			continue
+		}
+
+		name := fn.Name.Name
+		if fn.Recv != nil && len(fn.Recv.List) > 0 {
+			if recv := goReceiver(fn.Recv.List[0].Type); recv != "" {
+				name = recv + "." + name
+			}
+		}
+		spans = append(spans, functionSpan{Name: name, Start: fset.Position(fn.Pos()).Line, End: fset.Position(fn.End()).Line})
+	}
+
+	return spans
+}
+
+// goReceiver returns the name of the type of a method receiver, without
+// the pointer and the type parameters
+func goReceiver(expr ast.Expr) string {
+	switch t := expr.(type) {
+	case *ast.StarExpr:
+		return goReceiver(t.X)
+	case *ast.IndexExpr:
+		return goReceiver(t.X)
+	case *ast.IndexListExpr:
+		return goReceiver(t.X)
+	case *ast.Ident:
+		return t.Name
+	}
+
+	return ""
+}
+
+var (
+	pythonDef   = regexp.MustCompile(`^(?:async\s+)?def\s+(\w+)`)
+	pythonClass = regexp.MustCompile(`^class\s+(\w+)`)
+)
+
+// pythonFunctions returns the functions of a Python file, named by their
+// enclosing classes and functions, e.g. Client.send. The blocks are told
+// by their indentation, so the lines of a multi-line string indented less
+// than its block end the block early.
+func pythonFunctions(src []byte) []functionSpan {
+	type block struct {
+		indent int
+		name   string
+		def    bool
+		start  int
+	}
+
+	spans := make([]functionSpan, 0)
+	stack := make([]block, 0)
+	last := 0
+	closeBlocks := func(indent int) {
+		for len(stack) > 0 && stack[len(stack)-1].indent >= indent {
+			b := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			if b.def {
+				names := make([]string, 0, len(stack)+1)
+				for _, outer := range stack {
+					names = append(names, outer.name)
+				}
+				spans = append(spans, functionSpan{Name: strings.Join(append(names, b.name), "."), Start: b.start, End: last})
+			}
+		}
+	}
+
+	for i, line := range strings.Split(string(src), "\n") {
+		code := strings.TrimLeft(line, " \t")
+		if code == "" || strings.HasPrefix(code, "#") {
+			continue
+		}
+		indent := len(line) - len(code)
+		closeBlocks(indent)
+
+		if m := pythonDef.FindStringSubmatch(code); m != nil {
+			stack = append(stack, block{indent: indent, name: m[1], def: true, start: i + 1})
+		} else if m := pythonClass.FindStringSubmatch(code); m != nil {
+			stack = append(stack, block{indent: indent, name: m[1], start: i + 1})
+		}
+		last = i + 1
+	}
+	closeBlocks(0)
+
+	return spans
+}
+
+var (
+	javaMethod = regexp.MustCompile(`(\w+)\s*\([^()]*(?:\([^()]*\)[^()]*)*\)\s*(?:throws\s+[\w.,\s]+)?$`)
+	javaType   = regexp.MustCompile(`\b(?:class|interface|enum|record)\s+(\w+)`)
+)
+
+// javaKeywords are the keywords which are followed by parentheses and a
+// block like the methods
+var javaKeywords = map[string]bool{
+	"if": true, "for": true, "while": true, "switch": true, "catch": true,
+	"synchronized": true, "try": true, "return": true, "new": true, "else": true,
+}
+
+// javaFunctions returns the methods and the constructors of a Java file,
+// named by their enclosing types, e.g. Client.send. The blocks are told
+// by their braces, skipping the comments and the literals.
+func javaFunctions(src []byte) []functionSpan {
+	type block struct {
+		kind  string
+		name  string
+		start int
+	}
+
+	spans := make([]functionSpan, 0)
+	stack := make([]block, 0)
+	stmt := &strings.Builder{}
+	stmtLine, line := 0, 1
+	s := string(src)
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '\n':
+			line++
+			stmt.WriteByte(' ')
+			continue
+		case strings.HasPrefix(s[i:], "//"):
+			for i < len(s) && s[i] != '\n' {
+				i++
+			}
+			i--
+			continue
+		case strings.HasPrefix(s[i:], "/*"):
+			end := strings.Index(s[i+2:], "*/")
+			if end < 0 {
+				end = len(s) - i - 4
+			}
+			line += strings.Count(s[i:i+end+4], "\n")
+			i += end + 3
+			continue
+		case c == '"' || c == '\'':
+			for i++; i < len(s) && s[i] != c && s[i] != '\n'; i++ {
+				if s[i] == '\\' {
+					i++
+				}
+			}
+			stmt.WriteString("_")
+			continue
+		}
+
+		switch c {
+		case '{':
+			text := strings.TrimSpace(stmt.String())
+			b := block{kind: "block"}
+			if m := javaType.FindStringSubmatch(text); m != nil {
+				b = block{kind: "type", name: m[1]}
+			} else if m := javaMethod.FindStringSubmatchIndex(text); m != nil {
+				name := text[m[2]:m[3]]
+				before := strings.Fields(text[:m[2]])
+				if !javaKeywords[name] && (len(before) == 0 || before[len(before)-1] != "new") {
+					b = block{kind: "method", name: name, start: stmtLine}
+				}
+			}
+			stack = append(stack, b)
+			stmt.Reset()
+		case '}':
+			if len(stack) > 0 {
+				b := stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+				if b.kind == "method" {
+					names := make([]string, 0, len(stack)+1)
+					for _, outer := range stack {
+						if outer.kind == "type" {
+							names = append(names, outer.name)
+						}
+					}
+					spans = append(spans, functionSpan{Name: strings.Join(append(names, b.name), "."), Start: b.start, End: line})
+				}
+			}
+			stmt.Reset()
+		case ';':
+			stmt.Reset()
+		default:
+			if stmt.Len() == 0 || strings.TrimSpace(stmt.String()) == "" {
+				if c == ' ' || c == '\t' || c == '\r' {
+					continue
+				}
+				stmt.Reset()
+				stmtLine = line
+			}
+			stmt.WriteByte(c)
+		}
+	}
+
+	return spans
+}
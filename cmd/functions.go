@@ -0,0 +1,311 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+
+	"github.com/spf13/cobra"
+)
+
+// functionsCmd represents the analyze functions command
+var functionsCmd = &cobra.Command{
+	Use:   "functions",
+	Short: "Maps the changed lines of the diffs to their functions",
+	Long: `Reads the changed files of the collected PRs as of their head
+commits, or of their commits with --granularity commit, parses their
+functions and stores with every diff the functions enclosing the lines
+of its hunks, so report --granularity function shows the hottest
+functions rather than the hottest files.
+
+The hunks are only stored with diffs.hunks set, see collectDiffs. Go
+files are parsed with go/parser, the methods named Type.Method; Java
+and Python files are split into their methods and functions by their
+braces and indentation, named by their enclosing types, e.g.
+Client.send. The lines outside of any function, e.g. the imports,
+count for (top level), the lines of a nested function for the
+innermost one.
+
+The files are analyzed once; --force analyzes them again, e.g. after a
+rebase of the PRs. Only the PRs of --repo are analyzed if it's set.`,
+	RunE: analyzeFunctions,
+}
+
+const (
+	// topLevelFunction is the function of the lines outside of any function
+	topLevelFunction = "(top level)"
+	// functionsBatchSize is the number of the analyzed PRs written at once
+	functionsBatchSize = 100
+)
+
+var functionsForce bool
+
+// functionChange represents the lines of the hunks of a diff within a
+// function
+type functionChange struct {
+	Name  string `bson:"name" json:"name"`
+	Lines int    `bson:"lines" json:"lines"`
+}
+
+func init() {
+	analyzeCmd.AddCommand(functionsCmd)
+	functionsCmd.Flags().BoolVar(&functionsForce, "force", false, "analyze the files which are already analyzed again")
+	functionsCmd.Flags().StringVar(&repoScope, "repo", "", "only analyze the PRs of this owner/name repo")
+}
+
+// touchedFunctions returns the functions containing the lines of the hunks,
+// the innermost one for every line, in the order they're first touched.
+// A hunk only removing lines touches the line before them.
+func touchedFunctions(spans []functionSpan, hunks []lineRange) []functionChange {
+	changes := make([]functionChange, 0)
+	index := make(map[string]int)
+	for _, h := range hunks {
+		lines := h.Lines
+		if lines == 0 {
+			lines = 1
+		}
+		for l := h.Start; l < h.Start+lines; l++ {
+			name, size := topLevelFunction, math.MaxInt
+			for _, s := range spans {
+				if s.Start <= l && l <= s.End && s.End-s.Start < size {
+					name, size = s.Name, s.End-s.Start
+				}
+			}
+
+			i, ok := index[name]
+			if !ok {
+				i = len(changes)
+				index[name] = i
+				changes = append(changes, functionChange{Name: name})
+			}
+			changes[i].Lines++
+		}
+	}
+
+	return changes
+}
+
+// functionAnalyzer reads the files of the PRs and keeps their functions
+type functionAnalyzer struct {
+	reader    fileReader
+	languages map[string]string
+	force     bool
+	files     int
+}
+
+// pending tells whether the diff is analyzed: it has hunks, its file is
+// of a parsed language and it's there after the change
+func (a *functionAnalyzer) pending(d diff) bool {
+	if len(d.Hunks) == 0 || d.Status == "removed" || (len(d.Functions) > 0 && !a.force) {
+		return false
+	}
+	_, ok := functionParsers[a.language(d)]
+
+	return ok
+}
+
+func (a *functionAnalyzer) language(d diff) string {
+	if d.Language != "" {
+		return d.Language
+	}
+
+	return languageOf(a.languages, d.File)
+}
+
+// analyzeDiffs sets the functions of the pending diffs from their files as
+// of the commit. It tells whether any diff changed.
+func (a *functionAnalyzer) analyzeDiffs(ctx context.Context, repo Repo, sha string, diffs []diff) (bool, error) {
+	changed := false
+	for i := range diffs {
+		if !a.pending(diffs[i]) {
+			continue
+		}
+
+		src, err := a.reader.fileAt(ctx, repo, sha, diffs[i].File)
+		if err != nil {
+			return changed, fmt.Errorf("reading %s at %s failed: %w", diffs[i].File, sha, err)
+		}
+		if src == nil {
+			slog.Debug("file not found", "repo", repo.Owner+"/"+repo.Name, "file", diffs[i].File, "sha", sha)
+			continue
+		}
+
+		spans := functionParsers[a.language(diffs[i])](src)
+		diffs[i].Functions = touchedFunctions(spans, diffs[i].Hunks)
+		a.files++
+		changed = true
+	}
+
+	return changed, nil
+}
+
+// analyze sets the functions of the diffs of the PR. The diffs of its
+// commits are read as of the commits and summed into the diff of the PR.
+func (a *functionAnalyzer) analyze(ctx context.Context, p *pr) (bool, error) {
+	if len(p.Commits) > 0 {
+		changed := false
+		for i := range p.Commits {
+			ok, err := a.analyzeDiffs(ctx, p.Repo, p.Commits[i].SHA, p.Commits[i].Diff)
+			if err != nil {
+				return false, err
+			}
+			changed = changed || ok
+		}
+		if changed {
+			mergeCommitFunctions(p)
+		}
+		return changed, nil
+	}
+
+	pending := false
+	for _, d := range p.Diff {
+		pending = pending || a.pending(d)
+	}
+	if !pending {
+		return false, nil
+	}
+	sha, err := a.reader.headSHA(ctx, p.Repo, p.PRID)
+	if err != nil {
+		return false, fmt.Errorf("reading the head of %s failed: %w", prKey(p.Repo, p.PRID), err)
+	}
+
+	return a.analyzeDiffs(ctx, p.Repo, sha, p.Diff)
+}
+
+// mergeCommitFunctions sums the functions of the diffs of the commits into
+// the diff of the PR
+func mergeCommitFunctions(p *pr) {
+	byFile := make(map[string][]functionChange)
+	for _, c := range p.Commits {
+		for _, d := range c.Diff {
+			byFile[d.File] = append(byFile[d.File], d.Functions...)
+		}
+	}
+
+	for i := range p.Diff {
+		merged := make([]functionChange, 0)
+		index := make(map[string]int)
+		for _, f := range byFile[p.Diff[i].File] {
+			j, ok := index[f.Name]
+			if !ok {
+				j = len(merged)
+				index[f.Name] = j
+				merged = append(merged, functionChange{Name: f.Name})
+			}
+			merged[j].Lines += f.Lines
+		}
+		if len(merged) > 0 {
+			p.Diff[i].Functions = merged
+		}
+	}
+}
+
+// functionDiffs splits the diffs of the PRs by their functions, a diff per
+// function named file#function with the share of the changes of its lines,
+// for report --granularity function. The diffs which aren't analyzed are
+// dropped.
+func functionDiffs(prs []pr) []pr {
+	result := make([]pr, 0, len(prs))
+	for _, p := range prs {
+		diffs := make([]diff, 0, len(p.Diff))
+		for _, d := range p.Diff {
+			total := 0
+			for _, f := range d.Functions {
+				total += f.Lines
+			}
+			if total == 0 {
+				continue
+			}
+
+			share := func(n, lines int) int {
+				return int(math.Round(float64(n) * float64(lines) / float64(total)))
+			}
+			for _, f := range d.Functions {
+				fd := d
+				fd.File = d.File + "#" + f.Name
+				fd.Additions = share(d.Additions, f.Lines)
+				fd.Deletions = share(d.Deletions, f.Lines)
+				fd.Changes = share(d.Changes, f.Lines)
+				fd.Hunks, fd.Functions = nil, nil
+				diffs = append(diffs, fd)
+			}
+		}
+		p.Diff = diffs
+		result = append(result, p)
+	}
+
+	return result
+}
+
+func analyzeFunctions(cmd *cobra.Command, args []string) error {
+	if err := checkRepoScope(); err != nil {
+		return err
+	}
+
+	ctx, cancel, st, err := openStore()
+	if err != nil {
+		return err
+	}
+	defer cancel()
+	defer closeStore(ctx, st)
+
+	provider, err := newVCSProvider(ctx)
+	if err != nil {
+		return configError(err)
+	}
+	reader, ok := provider.(fileReader)
+	if !ok {
+		return configError(fmt.Errorf("%s doesn't read the files of the PRs", provider.applicationType()))
+	}
+
+	prs, err := st.PRs(ctx)
+	if err != nil {
+		return storageError(fmt.Errorf("reading diffs failed: %w", err))
+	}
+
+	a := &functionAnalyzer{reader: reader, languages: languageExtensions(), force: functionsForce}
+	repos := newRepoFilter()
+	changed := make([]pr, 0, functionsBatchSize)
+	analyzed := 0
+	flush := func() error {
+		if len(changed) == 0 {
+			return nil
+		}
+		if err := st.InsertPRs(ctx, changed); err != nil {
+			return storageError(fmt.Errorf("writing diffs failed: %w", err))
+		}
+		analyzed += len(changed)
+		changed = changed[:0]
+
+		return nil
+	}
+	for i := range prs {
+		if !repos.allows(prs[i].Repo) {
+			continue
+		}
+
+		ok, err := a.analyze(ctx, &prs[i])
+		if err != nil {
+			if ferr := flush(); ferr != nil {
+				return ferr
+			}
+			return vcsError(err)
+		}
+		if !ok {
+			continue
+		}
+		if changed = append(changed, prs[i]); len(changed) >= functionsBatchSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		return err
+	}
+
+	slog.Info("functions analyzed", "prs", analyzed, "files", a.files)
+
+	return nil
+}
@@ -0,0 +1,136 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// gateCmd represents the gate command
+var gateCmd = &cobra.Command{
+	Use:   "gate",
+	Short: "Fails when a team exceeds its heat budget",
+	Long: `Groups the heat by the teams of the teams config key and
+compares the score of every team with its budget in budgets, e.g.
+  "budgets": {"payments": 500}
+The command prints the state of every budget and exits with code 6
+if any of them is exceeded, so it can gate a CI pipeline. sync and
+daemon check the budgets after every run too, see sync.`,
+	RunE: gate,
+}
+
+// budgetState represents the heat of a team compared with its budget
+type budgetState struct {
+	Team     string
+	Score    float64
+	Budget   float64
+	Exceeded bool
+}
+
+func init() {
+	rootCmd.AddCommand(gateCmd)
+	gateCmd.Flags().StringVar(&reportHalfLife, "half-life", "", "decay the weight of a bug by half every period since its fix, e.g. 90d")
+}
+
+func gate(cmd *cobra.Command, args []string) error {
+	budgets := heatBudgets()
+	if len(budgets) == 0 {
+		return configError(fmt.Errorf("no budgets are configured"))
+	}
+
+	ctx, cancel, st, err := openReadStore()
+	if err != nil {
+		return err
+	}
+	defer cancel()
+	defer closeStore(ctx, st)
+
+	reportGroup = "team"
+	heat, _, err := loadHeat(ctx, st)
+	if err != nil {
+		return err
+	}
+
+	states := checkBudgets(heat, budgets)
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "TEAM\tSCORE\tBUDGET\tSTATE")
+	exceeded := 0
+	for _, s := range states {
+		state := "ok"
+		if s.Exceeded {
+			state = "exceeded"
+			exceeded++
+		}
+		fmt.Fprintf(tw, "%s\t%.2f\t%.2f\t%s\n", s.Team, s.Score, s.Budget, state)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	if exceeded > 0 {
+		return gateError(fmt.Errorf("%d of %d heat budgets exceeded", exceeded, len(states)))
+	}
+
+	return nil
+}
+
+// heatBudgets returns the budgets of the teams, the maximal scores
+func heatBudgets() map[string]float64 {
+	budgets := make(map[string]float64)
+	for team := range viper.GetStringMap("budgets") {
+		budgets[team] = viper.GetFloat64("budgets." + team)
+	}
+
+	return budgets
+}
+
+// exceededBudgets returns the budgets which the heat of the teams
+// exceeds, logging them
+func exceededBudgets(ctx context.Context, st store, budgets map[string]float64) ([]budgetState, error) {
+	group := reportGroup
+	reportGroup = "team"
+	heat, _, err := loadHeat(ctx, st)
+	reportGroup = group
+	if err != nil {
+		return nil, err
+	}
+
+	exceeded := make([]budgetState, 0)
+	for _, s := range checkBudgets(heat, budgets) {
+		if s.Exceeded {
+			slog.Warn("heat budget exceeded", "team", s.Team, "score", s.Score, "budget", s.Budget)
+			exceeded = append(exceeded, s)
+		}
+	}
+
+	return exceeded, nil
+}
+
+// checkBudgets compares the heat grouped by team with the budgets.
+// A team without any heat stays within its budget.
+func checkBudgets(heat []fileHeat, budgets map[string]float64) []budgetState {
+	scores := make(map[string]float64, len(heat))
+	for _, h := range heat {
+		scores[h.Group] = h.Score
+	}
+
+	states := make([]budgetState, 0, len(budgets))
+	for team, budget := range budgets {
+		states = append(states, budgetState{
+			Team:     team,
+			Score:    scores[team],
+			Budget:   budget,
+			Exceeded: scores[team] > budget,
+		})
+	}
+	sort.Slice(states, func(i, j int) bool { return states[i].Team < states[j].Team })
+
+	return states
+}
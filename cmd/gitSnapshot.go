@@ -0,0 +1,227 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+const (
+	defaultSnapshotBranch = "heatmap-snapshots"
+	defaultSnapshotFile   = "heat.ndjson"
+	defaultSnapshotAuthor = "heatmap"
+	defaultSnapshotEmail  = "heatmap@localhost"
+)
+
+// gitSnapshot represents the branch of a Git repo the heat snapshots are
+// committed to
+type gitSnapshot struct {
+	repo   string
+	branch string
+	file   string
+	remote string
+	env    []string
+}
+
+// gitSnapshotConfig reads snapshot.git.repo, the path of the repo, and
+// the branch, the file and the remote the snapshots are pushed to. It
+// returns false unless the repo is set.
+func gitSnapshotConfig() (gitSnapshot, bool, error) {
+	viper.SetDefault("snapshot.git.branch", defaultSnapshotBranch)
+	viper.SetDefault("snapshot.git.file", defaultSnapshotFile)
+	viper.SetDefault("snapshot.git.author_name", defaultSnapshotAuthor)
+	viper.SetDefault("snapshot.git.author_email", defaultSnapshotEmail)
+
+	s := gitSnapshot{
+		repo:   viper.GetString("snapshot.git.repo"),
+		branch: viper.GetString("snapshot.git.branch"),
+		file:   viper.GetString("snapshot.git.file"),
+		remote: viper.GetString("snapshot.git.remote"),
+	}
+	if s.repo == "" {
+		return s, false, nil
+	}
+	if s.file == "" || strings.ContainsAny(s.file, "/\\") {
+		return s, false, fmt.Errorf("snapshot.git.file %q must be a file name at the root of the branch", s.file)
+	}
+
+	name, email := viper.GetString("snapshot.git.author_name"), viper.GetString("snapshot.git.author_email")
+	s.env = []string{
+		"GIT_AUTHOR_NAME=" + name, "GIT_AUTHOR_EMAIL=" + email,
+		"GIT_COMMITTER_NAME=" + name, "GIT_COMMITTER_EMAIL=" + email,
+	}
+
+	return s, true, nil
+}
+
+// git runs a git command in the repo and returns its trimmed output
+func (s gitSnapshot) git(ctx context.Context, stdin []byte, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, "git", append([]string{"-C", s.repo}, args...)...)
+	cmd.Env = append(os.Environ(), s.env...)
+	if stdin != nil {
+		cmd.Stdin = bytes.NewReader(stdin)
+	}
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("git %s failed: %w: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+// heatNDJSON encodes the heat one file per line, ordered by the repo and
+// the path rather than by the score, so that the diffs of the snapshots
+// show the changes of the files. The scores are rounded, their last
+// digits depend on the order the bugs are summed in.
+func heatNDJSON(heat []fileHeat) ([]byte, error) {
+	sorted := make([]fileHeat, len(heat))
+	copy(sorted, heat)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		a, b := sorted[i], sorted[j]
+		if a.Repo.Owner != b.Repo.Owner {
+			return a.Repo.Owner < b.Repo.Owner
+		}
+		if a.Repo.Name != b.Repo.Name {
+			return a.Repo.Name < b.Repo.Name
+		}
+		if a.File != b.File {
+			return a.File < b.File
+		}
+		return a.Group < b.Group
+	})
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, h := range sorted {
+		h.Score = math.Round(h.Score*1e4) / 1e4
+		h.Risk = math.Round(h.Risk*1e4) / 1e4
+		if err := enc.Encode(h); err != nil {
+			return nil, err
+		}
+	}
+
+	return buf.Bytes(), nil
+}
+
+// commit writes the snapshot over the file in the tree of the last commit
+// of the branch, keeping the other files, and commits it with the plumbing
+// commands, so the working tree of the repo is never touched. It returns
+// false if the heat didn't change.
+func (s gitSnapshot) commit(ctx context.Context, snapshot []byte, message string) (bool, error) {
+	ref := "refs/heads/" + s.branch
+	if head, err := s.git(ctx, nil, "symbolic-ref", "-q", "HEAD"); err == nil && head == ref {
+		return false, fmt.Errorf("%s is checked out in %s, use a bare repo or another branch", s.branch, s.repo)
+	}
+
+	blob, err := s.git(ctx, snapshot, "hash-object", "-w", "--stdin")
+	if err != nil {
+		return false, err
+	}
+
+	// A missing branch is created without a parent
+	parent, _ := s.git(ctx, nil, "rev-parse", "--verify", "-q", ref+"^{commit}")
+	entries := make([]string, 0)
+	if parent != "" {
+		tree, err := s.git(ctx, nil, "ls-tree", parent)
+		if err != nil {
+			return false, err
+		}
+		for _, e := range strings.Split(tree, "\n") {
+			if e != "" && !strings.HasSuffix(e, "\t"+s.file) {
+				entries = append(entries, e)
+			}
+		}
+	}
+	entries = append(entries, fmt.Sprintf("100644 blob %s\t%s", blob, s.file))
+
+	tree, err := s.git(ctx, []byte(strings.Join(entries, "\n")+"\n"), "mktree")
+	if err != nil {
+		return false, err
+	}
+	if parent != "" {
+		if old, err := s.git(ctx, nil, "rev-parse", parent+"^{tree}"); err == nil && old == tree {
+			return false, nil
+		}
+	}
+
+	args := []string{"commit-tree", tree, "-m", message}
+	if parent != "" {
+		args = append(args, "-p", parent)
+	}
+	commit, err := s.git(ctx, nil, args...)
+	if err != nil {
+		return false, err
+	}
+	// The old value fails the update if another run moved the branch
+	if _, err := s.git(ctx, nil, "update-ref", ref, commit, parent); err != nil {
+		return false, err
+	}
+	slog.Debug("heat snapshot committed", "repo", s.repo, "branch", s.branch, "commit", commit)
+
+	return true, nil
+}
+
+// push pushes the branch to the remote. In offline mode nothing is pushed.
+func (s gitSnapshot) push(ctx context.Context) error {
+	if s.remote == "" {
+		return nil
+	}
+	if offline {
+		slog.Warn("offline mode, the heat snapshot isn't pushed", "remote", s.remote)
+		return nil
+	}
+
+	ref := "refs/heads/" + s.branch
+	_, err := s.git(ctx, nil, "push", s.remote, ref+":"+ref)
+
+	return err
+}
+
+// runGitSnapshot commits the heat to snapshot.git.repo and returns the
+// number of the files of the snapshot, or 0 if the heat didn't change
+func runGitSnapshot(ctx context.Context, st store, s gitSnapshot) (int, error) {
+	heat, _, err := loadHeat(ctx, st)
+	if err != nil {
+		return 0, err
+	}
+	snapshot, err := heatNDJSON(heat)
+	if err != nil {
+		return 0, err
+	}
+
+	bugs := make(map[string]bool)
+	for _, h := range heat {
+		for b := range h.bugs {
+			bugs[b] = true
+		}
+	}
+	message := fmt.Sprintf("Heat snapshot of %s\n\n%d files, %d bugs", time.Now().UTC().Format(time.RFC3339), len(heat), len(bugs))
+
+	changed, err := s.commit(ctx, snapshot, message)
+	if err != nil {
+		return 0, storageError(fmt.Errorf("committing the heat snapshot failed: %w", err))
+	}
+	// The branch is pushed even if the heat didn't change, in case the
+	// push of the last snapshot failed
+	if err := s.push(ctx); err != nil {
+		return 0, storageError(fmt.Errorf("pushing the heat snapshot failed: %w", err))
+	}
+	if !changed {
+		slog.Info("heat unchanged, no snapshot committed", "branch", s.branch)
+		return 0, nil
+	}
+
+	return len(heat), nil
+}
@@ -0,0 +1,503 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/github"
+	"github.com/spf13/viper"
+	"golang.org/x/oauth2"
+)
+
+// githubProvider fetches PR data from GitHub
+type githubProvider struct {
+	client  *github.Client
+	mu      sync.Mutex
+	used    apiUsage
+	reset   time.Time
+	reserve int
+	// app is set when authenticating as a GitHub App, whose installation
+	// tokens expire every hour by design
+	app bool
+
+	searchRemaining int
+	searchReset     time.Time
+}
+
+const (
+	defaultGitHubRateLimitReserve = 10
+	defaultGitHubWeb              = "https://github.com"
+)
+
+// githubPullURL matches a GitHub PR URL, capturing the host, the owner,
+// the repo and the number of the PR
+var githubPullURL = regexp.MustCompile(`https?://([^/]+)/([^/]+)/([^/]+)/pull/([0-9]+)`)
+
+// githubEnterprise returns the API and the upload endpoints of the GitHub
+// Enterprise Server of github.base_url and github.upload_url, or empty
+// strings for github.com. A base URL without a path gets the /api/v3/
+// of the server and the upload URL defaults to its /api/uploads/.
+func githubEnterprise() (string, string, error) {
+	base := viper.GetString("github.base_url")
+	if base == "" {
+		return "", "", nil
+	}
+	u, err := url.Parse(base)
+	if err != nil || u.Host == "" {
+		return "", "", fmt.Errorf("invalid github.base_url %q", base)
+	}
+	if strings.Trim(u.Path, "/") == "" {
+		u.Path = "/api/v3/"
+	}
+
+	upload := viper.GetString("github.upload_url")
+	if upload == "" {
+		upload = u.Scheme + "://" + u.Host + "/api/uploads/"
+	}
+
+	return u.String(), upload, nil
+}
+
+// githubAPI returns the root of the REST API, without the trailing slash
+func githubAPI() string {
+	if base, _, err := githubEnterprise(); err == nil && base != "" {
+		return strings.TrimSuffix(base, "/")
+	}
+
+	return defaultGitHubAPI
+}
+
+// githubWeb returns the root of the web pages, e.g. of the PRs
+func githubWeb() string {
+	if base, _, err := githubEnterprise(); err == nil && base != "" {
+		u, _ := url.Parse(base)
+		return u.Scheme + "://" + u.Host
+	}
+
+	return defaultGitHubWeb
+}
+
+// githubPRPage returns the page of the PR
+func githubPRPage(repo Repo, id int) string {
+	return fmt.Sprintf("%s/%s/%s/pull/%d", githubWeb(), repo.Owner, repo.Name, id)
+}
+
+func newGitHubProvider(ctx context.Context) (*githubProvider, error) {
+	viper.SetDefault("github.rate_limit_reserve", defaultGitHubRateLimitReserve)
+
+	gh, err := connectToGitHub(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &githubProvider{client: gh, reserve: viper.GetInt("github.rate_limit_reserve"), app: viper.GetString("github.app.id") != ""}, nil
+}
+
+// connectToGitHub authenticates as the installation of the GitHub App of
+// github.app.id if it's set and with the personal access token of
+// github.token otherwise. It connects to the GitHub Enterprise Server of
+// github.base_url if it's set.
+func connectToGitHub(ctx context.Context) (*github.Client, error) {
+	base, upload, err := githubEnterprise()
+	if err != nil {
+		return nil, err
+	}
+
+	var ts oauth2.TokenSource
+	if viper.GetString("github.app.id") != "" {
+		if ts, err = newGitHubAppTokenSource(); err != nil {
+			return nil, err
+		}
+	} else {
+		ts = oauth2.StaticTokenSource(
+			&oauth2.Token{AccessToken: viper.GetString("github.token")},
+		)
+	}
+	// The token is sent through the shared client, so the network policy applies
+	tc := oauth2.NewClient(context.WithValue(ctx, oauth2.HTTPClient, client), ts)
+	if base != "" {
+		return github.NewEnterpriseClient(base, upload, tc)
+	}
+
+	return github.NewClient(tc), nil
+}
+
+func (g *githubProvider) applicationType() string {
+	return "GitHub"
+}
+
+func (g *githubProvider) parsePR(p jiraPR) (Repo, int, error) {
+	m := githubPullURL.FindStringSubmatch(p.URL)
+	if m == nil || !githubHost(m[1]) {
+		return Repo{}, 0, fmt.Errorf("not a GitHub PR URL: %s", p.URL)
+	}
+
+	id, err := prNumber(p, m[4])
+	if err != nil {
+		return Repo{}, 0, err
+	}
+
+	return Repo{Owner: m[2], Name: m[3]}, id, nil
+}
+
+// githubHost tells whether the host of a PR URL is the one of the server
+// of github.base_url, or of github.com if it's not set
+func githubHost(host string) bool {
+	u, err := url.Parse(githubWeb())
+	if err != nil {
+		return false
+	}
+
+	return strings.EqualFold(host, u.Host) || (u.Host == "github.com" && strings.EqualFold(host, "www.github.com"))
+}
+
+func (g *githubProvider) listFiles(ctx context.Context, repo Repo, id int) ([]diff, error) {
+	diffs := make([]diff, 0)
+	opt := &github.ListOptions{PerPage: 100}
+	for {
+		if err := g.throttle(ctx); err != nil {
+			return nil, err
+		}
+
+		files, resp, err := g.client.PullRequests.ListFiles(ctx, repo.Owner, repo.Name, id, opt)
+		g.record(resp)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, f := range files {
+			diff := &diff{
+				File:      *f.Filename,
+				Status:    *f.Status,
+				Additions: *f.Additions,
+				Deletions: *f.Deletions,
+				Changes:   *f.Changes,
+				patch:     f.GetPatch(),
+			}
+
+			diffs = append(diffs, *diff)
+		}
+
+		if resp.NextPage == 0 {
+			break
+		}
+		opt.Page = resp.NextPage
+	}
+
+	return diffs, nil
+}
+
+func (g *githubProvider) info(ctx context.Context, repo Repo, id int) (prInfo, error) {
+	if err := g.throttle(ctx); err != nil {
+		return prInfo{}, err
+	}
+
+	p, resp, err := g.client.PullRequests.Get(ctx, repo.Owner, repo.Name, id)
+	g.record(resp)
+	if err != nil {
+		return prInfo{}, err
+	}
+
+	return prInfo{
+		Author:     p.GetUser().GetLogin(),
+		MergedAt:   p.GetMergedAt(),
+		BaseBranch: p.GetBase().GetRef(),
+	}, nil
+}
+
+// ping requests the rate limits, which don't count against them
+func (g *githubProvider) ping(ctx context.Context) error {
+	_, resp, err := g.client.RateLimits(ctx)
+	g.record(resp)
+
+	return err
+}
+
+func (g *githubProvider) codeowners(ctx context.Context, repo Repo) ([]byte, error) {
+	for _, p := range codeownersPaths {
+		if err := g.throttle(ctx); err != nil {
+			return nil, err
+		}
+
+		file, _, resp, err := g.client.Repositories.GetContents(ctx, repo.Owner, repo.Name, p, nil)
+		g.record(resp)
+		if resp != nil && resp.StatusCode == http.StatusNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if file == nil {
+			continue
+		}
+
+		content, err := file.GetContent()
+		if err != nil {
+			return nil, err
+		}
+		return []byte(content), nil
+	}
+
+	return nil, nil
+}
+
+func (g *githubProvider) describeRepo(ctx context.Context, repo Repo) (repoMeta, error) {
+	if err := g.throttle(ctx); err != nil {
+		return repoMeta{}, err
+	}
+	r, resp, err := g.client.Repositories.Get(ctx, repo.Owner, repo.Name)
+	g.record(resp)
+	if err != nil {
+		return repoMeta{}, err
+	}
+
+	if err := g.throttle(ctx); err != nil {
+		return repoMeta{}, err
+	}
+	languages, resp, err := g.client.Repositories.ListLanguages(ctx, repo.Owner, repo.Name)
+	g.record(resp)
+	if err != nil {
+		return repoMeta{}, err
+	}
+
+	return repoMeta{Topics: r.Topics, Languages: languages, Archived: r.GetArchived()}, nil
+}
+
+func (g *githubProvider) usage() apiUsage {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	return g.used
+}
+
+// record counts a request and keeps the rate limit reported with its response
+func (g *githubProvider) record(resp *github.Response) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.used.Requests++
+	if resp != nil && resp.Response != nil {
+		if value := resp.Header.Get("GitHub-Authentication-Token-Expiration"); value != "" && !g.app {
+			noteGitHubExpiry(value)
+		}
+	}
+	if resp != nil && resp.Rate.Limit > 0 {
+		g.used.Remaining = resp.Rate.Remaining
+		g.used.Limit = resp.Rate.Limit
+		g.reset = resp.Rate.Reset.Time
+		slog.Debug("GitHub rate limit", "remaining", g.used.Remaining, "limit", g.used.Limit, "reset", g.reset)
+	}
+}
+
+// throttle sleeps until the rate limit resets once the remaining requests
+// drop to github.rate_limit_reserve, so the quota is never exhausted
+func (g *githubProvider) throttle(ctx context.Context) error {
+	g.mu.Lock()
+	wait := time.Duration(0)
+	if g.used.Limit > 0 && g.used.Remaining <= g.reserve {
+		// A second more covers the clock skew to GitHub
+		wait = time.Until(g.reset) + time.Second
+	}
+	g.mu.Unlock()
+
+	if wait <= 0 {
+		return nil
+	}
+
+	slog.Info("GitHub rate limit almost exhausted, waiting for the reset", "remaining", g.usage().Remaining, "wait", wait.Round(time.Second))
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+	}
+
+	g.mu.Lock()
+	g.used.Remaining = g.used.Limit
+	g.mu.Unlock()
+
+	return nil
+}
+
+// deployments lists the successful deployments of the repo to the
+// environment, the deployment time being the one of its first success
+// status
+func (g *githubProvider) deployments(ctx context.Context, repo Repo, environment string) ([]deployment, error) {
+	result := make([]deployment, 0)
+	opt := &github.DeploymentsListOptions{Environment: environment, ListOptions: github.ListOptions{PerPage: 100}}
+	for {
+		if err := g.throttle(ctx); err != nil {
+			return nil, err
+		}
+
+		deployments, resp, err := g.client.Repositories.ListDeployments(ctx, repo.Owner, repo.Name, opt)
+		g.record(resp)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, d := range deployments {
+			deployedAt, err := g.deployedAt(ctx, repo, d.GetID())
+			if err != nil {
+				return nil, err
+			}
+			if !deployedAt.IsZero() {
+				result = append(result, deployment{SHA: d.GetSHA(), DeployedAt: deployedAt})
+			}
+		}
+
+		if resp.NextPage == 0 {
+			break
+		}
+		opt.Page = resp.NextPage
+	}
+
+	sort.Slice(result, func(i, j int) bool { return result[i].DeployedAt.Before(result[j].DeployedAt) })
+
+	return result, nil
+}
+
+// deployedAt returns the time of the first success status of the
+// deployment or zero time if it never succeeded
+func (g *githubProvider) deployedAt(ctx context.Context, repo Repo, id int64) (time.Time, error) {
+	var first time.Time
+	opt := &github.ListOptions{PerPage: 100}
+	for {
+		if err := g.throttle(ctx); err != nil {
+			return time.Time{}, err
+		}
+
+		statuses, resp, err := g.client.Repositories.ListDeploymentStatuses(ctx, repo.Owner, repo.Name, id, opt)
+		g.record(resp)
+		if err != nil {
+			return time.Time{}, err
+		}
+
+		for _, s := range statuses {
+			if s.GetState() != "success" {
+				continue
+			}
+			if t := s.GetCreatedAt().Time; first.IsZero() || t.Before(first) {
+				first = t
+			}
+		}
+
+		if resp.NextPage == 0 {
+			return first, nil
+		}
+		opt.Page = resp.NextPage
+	}
+}
+
+func (g *githubProvider) commits(ctx context.Context, repo Repo, id int) ([]prCommit, error) {
+	commits := make([]prCommit, 0)
+	opt := &github.ListOptions{PerPage: 100}
+	for {
+		if err := g.throttle(ctx); err != nil {
+			return nil, err
+		}
+
+		page, resp, err := g.client.PullRequests.ListCommits(ctx, repo.Owner, repo.Name, id, opt)
+		g.record(resp)
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range page {
+			commits = append(commits, prCommit{SHA: c.GetSHA(), Message: c.GetCommit().GetMessage()})
+		}
+
+		if resp.NextPage == 0 {
+			return commits, nil
+		}
+		opt.Page = resp.NextPage
+	}
+}
+
+// commitFiles fetches the commit, since the list of the commits of a PR
+// has no files
+func (g *githubProvider) commitFiles(ctx context.Context, repo Repo, sha string) ([]diff, error) {
+	if err := g.throttle(ctx); err != nil {
+		return nil, err
+	}
+
+	c, resp, err := g.client.Repositories.GetCommit(ctx, repo.Owner, repo.Name, sha)
+	g.record(resp)
+	if err != nil {
+		return nil, err
+	}
+
+	diffs := make([]diff, 0, len(c.Files))
+	for _, f := range c.Files {
+		diffs = append(diffs, diff{
+			File:      f.GetFilename(),
+			Status:    f.GetStatus(),
+			Additions: f.GetAdditions(),
+			Deletions: f.GetDeletions(),
+			Changes:   f.GetChanges(),
+			patch:     f.GetPatch(),
+		})
+	}
+
+	return diffs, nil
+}
+
+func (g *githubProvider) headSHA(ctx context.Context, repo Repo, id int) (string, error) {
+	if err := g.throttle(ctx); err != nil {
+		return "", err
+	}
+
+	p, resp, err := g.client.PullRequests.Get(ctx, repo.Owner, repo.Name, id)
+	g.record(resp)
+	if err != nil {
+		return "", err
+	}
+
+	return p.GetHead().GetSHA(), nil
+}
+
+// fileAt reads the file through the contents API, which doesn't return the
+// files over 1 MB; they're taken as not found
+func (g *githubProvider) fileAt(ctx context.Context, repo Repo, sha, file string) ([]byte, error) {
+	if err := g.throttle(ctx); err != nil {
+		return nil, err
+	}
+
+	content, _, resp, err := g.client.Repositories.GetContents(ctx, repo.Owner, repo.Name, file, &github.RepositoryContentGetOptions{Ref: sha})
+	g.record(resp)
+	if resp != nil && resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if content == nil || content.GetEncoding() == "none" {
+		return nil, nil
+	}
+
+	text, err := content.GetContent()
+	if err != nil {
+		return nil, err
+	}
+
+	return []byte(text), nil
+}
+
+func (g *githubProvider) patch(ctx context.Context, repo Repo, id int) (string, error) {
+	if err := g.throttle(ctx); err != nil {
+		return "", err
+	}
+
+	raw, resp, err := g.client.PullRequests.GetRaw(ctx, repo.Owner, repo.Name, id, github.RawOptions{Type: github.Diff})
+	g.record(resp)
+
+	return raw, err
+}
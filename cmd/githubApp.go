@@ -0,0 +1,164 @@
+package cmd
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+	"golang.org/x/oauth2"
+)
+
+const (
+	defaultGitHubAPI = "https://api.github.com"
+	// githubAppJWTLifetime is below the 10 minutes GitHub accepts, with
+	// the clock skew covered by backdating the tokens
+	githubAppJWTLifetime = 9 * time.Minute
+	githubAppClockSkew   = time.Minute
+)
+
+// githubAppTokenSource issues the installation access tokens of the
+// GitHub App of github.app.id, signing its requests with the private key
+// of the app. The tokens last an hour; wrapped into an
+// oauth2.ReuseTokenSource a new one is requested once it expires.
+type githubAppTokenSource struct {
+	appID          string
+	installationID string
+	key            *rsa.PrivateKey
+	api            string
+}
+
+// githubInstallationToken represents the response of the access tokens
+// endpoint of an installation
+type githubInstallationToken struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// newGitHubAppTokenSource reads github.app.id, github.app.installation_id
+// and the PEM private key of github.app.private_key or, if it's not set,
+// of the file of github.app.private_key_file
+func newGitHubAppTokenSource() (oauth2.TokenSource, error) {
+	s := &githubAppTokenSource{
+		appID:          viper.GetString("github.app.id"),
+		installationID: viper.GetString("github.app.installation_id"),
+		api:            githubAPI(),
+	}
+	if s.installationID == "" {
+		return nil, fmt.Errorf("github.app.installation_id is not set")
+	}
+
+	raw := []byte(viper.GetString("github.app.private_key"))
+	if len(raw) == 0 {
+		path := viper.GetString("github.app.private_key_file")
+		if path == "" {
+			return nil, fmt.Errorf("neither github.app.private_key nor github.app.private_key_file is set")
+		}
+		var err error
+		if raw, err = os.ReadFile(path); err != nil {
+			return nil, fmt.Errorf("reading the GitHub App private key failed: %w", err)
+		}
+	}
+
+	key, err := parseRSAKey(raw)
+	if err != nil {
+		return nil, fmt.Errorf("parsing the GitHub App private key failed: %w", err)
+	}
+	s.key = key
+
+	return oauth2.ReuseTokenSource(nil, s), nil
+}
+
+// parseRSAKey parses a PEM RSA private key, in PKCS #1 as GitHub issues
+// them or in PKCS #8
+func parseRSAKey(raw []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(raw)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("not an RSA key")
+	}
+
+	return rsaKey, nil
+}
+
+// jwt returns the JSON Web Token authenticating as the app
+func (s *githubAppTokenSource) jwt(now time.Time) (string, error) {
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"RS256","typ":"JWT"}`))
+	claims, err := json.Marshal(map[string]interface{}{
+		"iat": now.Add(-githubAppClockSkew).Unix(),
+		"exp": now.Add(githubAppJWTLifetime).Unix(),
+		"iss": s.appID,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	unsigned := header + "." + base64.RawURLEncoding.EncodeToString(claims)
+	sum := sha256.Sum256([]byte(unsigned))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, s.key, crypto.SHA256, sum[:])
+	if err != nil {
+		return "", err
+	}
+
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+// Token requests a new installation access token
+func (s *githubAppTokenSource) Token() (*oauth2.Token, error) {
+	jwt, err := s.jwt(time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("signing the GitHub App token failed: %w", err)
+	}
+
+	url := fmt.Sprintf("%s/app/installations/%s/access_tokens", strings.TrimSuffix(s.api, "/"), s.installationID)
+	req, err := http.NewRequest("POST", url, bytes.NewReader(nil))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+jwt)
+	req.Header.Set("Accept", "application/vnd.github.v3+json")
+
+	// The request goes through the client, so the network policy and the
+	// retries apply to it too
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("requesting the GitHub App installation token failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		return nil, fmt.Errorf("requesting the GitHub App installation token failed: GitHub responded with %s", resp.Status)
+	}
+
+	t := githubInstallationToken{}
+	if err := json.NewDecoder(resp.Body).Decode(&t); err != nil {
+		return nil, err
+	}
+
+	// The token is replaced a minute early, so no request is sent with
+	// one expiring on its way
+	return &oauth2.Token{AccessToken: t.Token, Expiry: t.ExpiresAt.Add(-time.Minute)}, nil
+}
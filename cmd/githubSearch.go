@@ -0,0 +1,177 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/github"
+)
+
+var (
+	// githubMergeMessage matches the message of a merge commit of a PR,
+	// capturing its number
+	githubMergeMessage = regexp.MustCompile(`^Merge pull request #([0-9]+) from `)
+	// githubSquashMessage matches the first line of a squashed PR, which
+	// ends with its number
+	githubSquashMessage = regexp.MustCompile(`\(#([0-9]+)\)\s*$`)
+	// githubRepoURL matches the API URL of a repo
+	githubRepoURL = regexp.MustCompile(`/repos/([^/]+)/([^/]+)$`)
+)
+
+// searchPRs searches the issues and, with commits set, the commits
+// mentioning the key. A commit leads to its PR through the number in its
+// merge or squash message, or else through a search of the PRs
+// containing it. The matches of the search are checked for the key, since
+// GitHub splits it into words.
+func (g *githubProvider) searchPRs(ctx context.Context, key string, scope []string, commits bool) ([]jiraPR, error) {
+	mentions := mentionsKey(key)
+	qualifiers := strings.Join(scope, " ")
+	found := make(map[string]jiraPR)
+	add := func(repo Repo, id int) {
+		url := githubPRPage(repo, id)
+		found[url] = jiraPR{ID: fmt.Sprintf("#%d", id), Status: "MERGED", URL: url}
+	}
+
+	issues, err := g.searchIssues(ctx, fmt.Sprintf(`"%s" is:pr is:merged %s`, key, qualifiers))
+	if err != nil {
+		return nil, err
+	}
+	for _, i := range issues {
+		if !mentions.MatchString(i.GetTitle() + "\n" + i.GetBody()) {
+			continue
+		}
+		if m := githubRepoURL.FindStringSubmatch(i.GetRepositoryURL()); m != nil {
+			add(Repo{Owner: m[1], Name: m[2]}, i.GetNumber())
+		}
+	}
+
+	if commits {
+		results, err := g.searchCommits(ctx, fmt.Sprintf(`"%s" %s`, key, qualifiers))
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range results {
+			message := c.GetCommit().GetMessage()
+			if !mentions.MatchString(message) {
+				continue
+			}
+			repo := Repo{Owner: c.GetRepository().GetOwner().GetLogin(), Name: c.GetRepository().GetName()}
+
+			firstLine := strings.SplitN(message, "\n", 2)[0]
+			if m := githubMergeMessage.FindStringSubmatch(firstLine); m != nil {
+				id, _ := strconv.Atoi(m[1])
+				add(repo, id)
+				continue
+			}
+			if m := githubSquashMessage.FindStringSubmatch(firstLine); m != nil {
+				id, _ := strconv.Atoi(m[1])
+				add(repo, id)
+				continue
+			}
+
+			containing, err := g.searchIssues(ctx, fmt.Sprintf("%s is:pr is:merged repo:%s/%s", c.GetSHA(), repo.Owner, repo.Name))
+			if err != nil {
+				return nil, err
+			}
+			for _, i := range containing {
+				add(repo, i.GetNumber())
+			}
+		}
+	}
+
+	prs := make([]jiraPR, 0, len(found))
+	for _, p := range found {
+		prs = append(prs, p)
+	}
+	sort.Slice(prs, func(i, j int) bool { return prs[i].URL < prs[j].URL })
+
+	return prs, nil
+}
+
+func (g *githubProvider) searchIssues(ctx context.Context, query string) ([]github.Issue, error) {
+	issues := make([]github.Issue, 0)
+	opt := &github.SearchOptions{ListOptions: github.ListOptions{PerPage: 100}}
+	for {
+		if err := g.throttleSearch(ctx); err != nil {
+			return nil, err
+		}
+
+		result, resp, err := g.client.Search.Issues(ctx, query, opt)
+		g.recordSearch(resp)
+		if err != nil {
+			return nil, err
+		}
+		issues = append(issues, result.Issues...)
+
+		if resp.NextPage == 0 {
+			return issues, nil
+		}
+		opt.Page = resp.NextPage
+	}
+}
+
+func (g *githubProvider) searchCommits(ctx context.Context, query string) ([]*github.CommitResult, error) {
+	commits := make([]*github.CommitResult, 0)
+	opt := &github.SearchOptions{ListOptions: github.ListOptions{PerPage: 100}}
+	for {
+		if err := g.throttleSearch(ctx); err != nil {
+			return nil, err
+		}
+
+		result, resp, err := g.client.Search.Commits(ctx, query, opt)
+		g.recordSearch(resp)
+		if err != nil {
+			return nil, err
+		}
+		commits = append(commits, result.Commits...)
+
+		if resp.NextPage == 0 {
+			return commits, nil
+		}
+		opt.Page = resp.NextPage
+	}
+}
+
+// recordSearch counts a search request. The search API has a rate limit
+// of its own, so it's kept apart from the one of the other requests.
+func (g *githubProvider) recordSearch(resp *github.Response) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.used.Requests++
+	if resp != nil && resp.Rate.Limit > 0 {
+		g.searchRemaining = resp.Rate.Remaining
+		g.searchReset = resp.Rate.Reset.Time
+	}
+}
+
+// throttleSearch sleeps until the search rate limit resets once it's
+// exhausted
+func (g *githubProvider) throttleSearch(ctx context.Context) error {
+	g.mu.Lock()
+	wait := time.Duration(0)
+	if g.searchRemaining == 0 && !g.searchReset.IsZero() {
+		wait = time.Until(g.searchReset) + time.Second
+	}
+	g.mu.Unlock()
+
+	if wait <= 0 {
+		return nil
+	}
+	slog.Info("GitHub search rate limit exhausted, waiting for the reset", "wait", wait.Round(time.Second))
+	if err := sleep(ctx, wait); err != nil {
+		return err
+	}
+
+	g.mu.Lock()
+	g.searchReset = time.Time{}
+	g.mu.Unlock()
+
+	return nil
+}
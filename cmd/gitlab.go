@@ -0,0 +1,253 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+const defaultGitLabHost = "https://gitlab.com"
+
+// mergeRequestURL matches a GitLab MR URL, capturing the host,
+// the project path and the MR number
+var mergeRequestURL = regexp.MustCompile(`^(https?://[^/]+)/(.+?)(?:/-)?/merge_requests/(\d+)`)
+
+// gitlabProvider fetches MR data from GitLab
+type gitlabProvider struct {
+	host  string
+	token string
+	mu    sync.Mutex
+	used  apiUsage
+}
+
+// gitlabDiff is a representation of a changed file in a GitLab MR
+type gitlabDiff struct {
+	OldPath     string `json:"old_path"`
+	NewPath     string `json:"new_path"`
+	Diff        string `json:"diff"`
+	NewFile     bool   `json:"new_file"`
+	RenamedFile bool   `json:"renamed_file"`
+	DeletedFile bool   `json:"deleted_file"`
+}
+
+// gitlabMergeRequest is a representation of the MR fields in use
+type gitlabMergeRequest struct {
+	Author struct {
+		Username string `json:"username"`
+	} `json:"author"`
+	MergedAt     *time.Time `json:"merged_at"`
+	TargetBranch string     `json:"target_branch"`
+}
+
+func newGitLabProvider() *gitlabProvider {
+	viper.SetDefault("gitlab.host", defaultGitLabHost)
+
+	return &gitlabProvider{
+		host:  strings.TrimSuffix(viper.GetString("gitlab.host"), "/"),
+		token: viper.GetString("gitlab.token"),
+	}
+}
+
+func (g *gitlabProvider) applicationType() string {
+	return "GitLab"
+}
+
+// parsePR splits the project path of a MR URL into a namespace, stored
+// as the repo owner, and a project name
+func (g *gitlabProvider) parsePR(p jiraPR) (Repo, int, error) {
+	m := mergeRequestURL.FindStringSubmatch(p.URL)
+	if m == nil {
+		return Repo{}, 0, fmt.Errorf("not a GitLab MR URL: %s", p.URL)
+	}
+
+	i := strings.LastIndex(m[2], "/")
+	if i < 0 {
+		return Repo{}, 0, fmt.Errorf("not a GitLab MR URL: %s", p.URL)
+	}
+
+	id, err := prNumber(p, m[3])
+	if err != nil {
+		return Repo{}, 0, err
+	}
+
+	return Repo{Owner: m[2][:i], Name: m[2][i+1:]}, id, nil
+}
+
+func (g *gitlabProvider) listFiles(ctx context.Context, repo Repo, id int) ([]diff, error) {
+	project := url.PathEscape(fmt.Sprintf("%s/%s", repo.Owner, repo.Name))
+	endpoint := fmt.Sprintf("%s/api/v4/projects/%s/merge_requests/%d/diffs", g.host, project, id)
+
+	diffs := make([]diff, 0)
+	for page := "1"; page != ""; {
+		req, err := http.NewRequestWithContext(ctx, "GET", endpoint, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Add("PRIVATE-TOKEN", g.token)
+
+		q := req.URL.Query()
+		q.Add("per_page", "100")
+		q.Add("page", page)
+		req.URL.RawQuery = q.Encode()
+
+		resp, err := client.Do(req)
+		g.record(resp)
+		if err != nil {
+			return nil, err
+		}
+
+		files := make([]gitlabDiff, 0)
+		if resp.StatusCode != http.StatusOK {
+			err = responseError("GitLab", resp)
+		} else {
+			err = json.NewDecoder(resp.Body).Decode(&files)
+		}
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, f := range files {
+			diffs = append(diffs, f.toDiff())
+		}
+
+		page = resp.Header.Get("X-Next-Page")
+	}
+
+	return diffs, nil
+}
+
+func (g *gitlabProvider) info(ctx context.Context, repo Repo, id int) (prInfo, error) {
+	project := url.PathEscape(fmt.Sprintf("%s/%s", repo.Owner, repo.Name))
+	endpoint := fmt.Sprintf("%s/api/v4/projects/%s/merge_requests/%d", g.host, project, id)
+
+	req, err := http.NewRequestWithContext(ctx, "GET", endpoint, nil)
+	if err != nil {
+		return prInfo{}, err
+	}
+	req.Header.Add("PRIVATE-TOKEN", g.token)
+
+	resp, err := client.Do(req)
+	g.record(resp)
+	if err != nil {
+		return prInfo{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return prInfo{}, responseError("GitLab", resp)
+	}
+
+	mr := &gitlabMergeRequest{}
+	if err := json.NewDecoder(resp.Body).Decode(mr); err != nil {
+		return prInfo{}, err
+	}
+
+	info := prInfo{Author: mr.Author.Username, BaseBranch: mr.TargetBranch}
+	if mr.MergedAt != nil {
+		info.MergedAt = *mr.MergedAt
+	}
+
+	return info, nil
+}
+
+// ping requests the user of the token
+func (g *gitlabProvider) ping(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", g.host+"/api/v4/user", nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Add("PRIVATE-TOKEN", g.token)
+
+	resp, err := client.Do(req)
+	g.record(resp)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return responseError("GitLab", resp)
+	}
+
+	return nil
+}
+
+func (g *gitlabProvider) codeowners(ctx context.Context, repo Repo) ([]byte, error) {
+	project := url.PathEscape(fmt.Sprintf("%s/%s", repo.Owner, repo.Name))
+	for _, p := range codeownersPaths {
+		endpoint := fmt.Sprintf("%s/api/v4/projects/%s/repository/files/%s/raw?ref=HEAD", g.host, project, url.PathEscape(p))
+		req, err := http.NewRequestWithContext(ctx, "GET", endpoint, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Add("PRIVATE-TOKEN", g.token)
+
+		raw, found, err := readRaw(req, g.record)
+		if err != nil {
+			return nil, fmt.Errorf("GitLab: %w", err)
+		}
+		if found {
+			return raw, nil
+		}
+	}
+
+	return nil, nil
+}
+
+func (g *gitlabProvider) usage() apiUsage {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	return g.used
+}
+
+// record counts a request and keeps the rate limit reported in its headers
+func (g *gitlabProvider) record(resp *http.Response) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.used.Requests++
+	if resp == nil {
+		return
+	}
+	if limit, err := strconv.Atoi(resp.Header.Get("RateLimit-Limit")); err == nil {
+		g.used.Limit = limit
+		g.used.Remaining, _ = strconv.Atoi(resp.Header.Get("RateLimit-Remaining"))
+	}
+}
+
+// toDiff counts the added and deleted lines of the unified diff
+func (f gitlabDiff) toDiff() diff {
+	d := diff{File: f.NewPath, Status: "modified", patch: f.Diff}
+	switch {
+	case f.NewFile:
+		d.Status = "added"
+	case f.DeletedFile:
+		d.File = f.OldPath
+		d.Status = "removed"
+	case f.RenamedFile:
+		d.Status = "renamed"
+	}
+
+	for _, line := range patchBody(f.Diff) {
+		switch {
+		case strings.HasPrefix(line, "+"):
+			d.Additions++
+		case strings.HasPrefix(line, "-"):
+			d.Deletions++
+		}
+	}
+	d.Changes = d.Additions + d.Deletions
+
+	return d
+}
@@ -0,0 +1,177 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"rdlf0/heatmap/render"
+)
+
+// hotDir represents a directory node of the co-change diagrams
+type hotDir struct {
+	name  string
+	score float64
+	bugs  int
+}
+
+// coChange represents an edge of the co-change diagrams: the number of
+// PRs changing both directories
+type coChange struct {
+	a, b  string
+	count int
+}
+
+// dirGraph rolls the files of the view up into directory buckets of
+// --depth segments and counts the PRs changing every pair of them
+func dirGraph(view render.View) ([]hotDir, []coChange, error) {
+	byName := make(map[string]*hotDir)
+	dirs := make([]*hotDir, 0)
+	for _, h := range view.Files {
+		name := h.Group
+		if name == "" {
+			name = dirBucket(Repo(h.Repo), h.File, view.Depth)
+		} else if view.Grouping != "dir" {
+			return nil, nil, fmt.Errorf("the co-change diagrams need --group-by file or dir")
+		}
+
+		d, ok := byName[name]
+		if !ok {
+			d = &hotDir{name: name}
+			byName[name] = d
+			dirs = append(dirs, d)
+		}
+		d.score += h.Score
+		d.bugs += h.Bugs
+	}
+
+	counts := make(map[[2]string]int)
+	for _, p := range view.PRs {
+		touched := make(map[string]bool)
+		for _, file := range p.Files {
+			if name := dirBucket(Repo(p.Repo), file, view.Depth); byName[name] != nil {
+				touched[name] = true
+			}
+		}
+
+		names := make([]string, 0, len(touched))
+		for name := range touched {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for i := range names {
+			for j := i + 1; j < len(names); j++ {
+				counts[[2]string{names[i], names[j]}]++
+			}
+		}
+	}
+
+	edges := make([]coChange, 0, len(counts))
+	for k, n := range counts {
+		edges = append(edges, coChange{a: k[0], b: k[1], count: n})
+	}
+	sort.Slice(edges, func(i, j int) bool {
+		if edges[i].count != edges[j].count {
+			return edges[i].count > edges[j].count
+		}
+		return edges[i].a+edges[i].b < edges[j].a+edges[j].b
+	})
+
+	sort.SliceStable(dirs, func(i, j int) bool { return dirs[i].score > dirs[j].score })
+	result := make([]hotDir, len(dirs))
+	for i, d := range dirs {
+		result[i] = *d
+	}
+
+	return result, edges, nil
+}
+
+// dotRenderer renders the hottest directories and their co-changes as
+// a Graphviz graph
+type dotRenderer struct{}
+
+// mermaidRenderer renders the same graph as a Mermaid flowchart, which
+// the Markdown of most wikis and code hosts displays
+type mermaidRenderer struct{}
+
+func init() {
+	render.Register("dot", dotRenderer{})
+	render.Register("mermaid", mermaidRenderer{})
+}
+
+func (dotRenderer) Extension() string {
+	return "dot"
+}
+
+func (dotRenderer) Render(w io.Writer, view render.View) error {
+	dirs, edges, err := dirGraph(view)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintln(w, "graph heatmap {")
+	fmt.Fprintln(w, `  node [shape=box, style=filled, fontname="sans-serif"];`)
+	for i, d := range dirs {
+		risk := 100 * d.score / dirs[0].score
+		fmt.Fprintf(w, "  d%d [label=%s, fillcolor=%q, tooltip=%q];\n",
+			i, dotString(d.name), heatHex(risk), fmt.Sprintf("score %.2f, bugs %d", d.score, d.bugs))
+	}
+	ids := dirIDs(dirs)
+	for _, e := range edges {
+		fmt.Fprintf(w, "  %s -- %s [label=\"%d\", penwidth=%d];\n", ids[e.a], ids[e.b], e.count, penWidth(e.count))
+	}
+	_, err = fmt.Fprintln(w, "}")
+
+	return err
+}
+
+func (mermaidRenderer) Extension() string {
+	return "mmd"
+}
+
+func (mermaidRenderer) Render(w io.Writer, view render.View) error {
+	dirs, edges, err := dirGraph(view)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintln(w, "graph LR")
+	for i, d := range dirs {
+		fmt.Fprintf(w, "  d%d[\"%s<br/>score %.2f, bugs %d\"]\n", i, mermaidText(d.name), d.score, d.bugs)
+	}
+	ids := dirIDs(dirs)
+	for _, e := range edges {
+		fmt.Fprintf(w, "  %s ---|%d| %s\n", ids[e.a], e.count, ids[e.b])
+	}
+	for i, d := range dirs {
+		risk := 100 * d.score / dirs[0].score
+		fmt.Fprintf(w, "  style d%d fill:%s\n", i, heatHex(risk))
+	}
+
+	return nil
+}
+
+// dirIDs returns the node IDs of the directories
+func dirIDs(dirs []hotDir) map[string]string {
+	ids := make(map[string]string, len(dirs))
+	for i, d := range dirs {
+		ids[d.name] = fmt.Sprintf("d%d", i)
+	}
+
+	return ids
+}
+
+// penWidth grows the width of an edge with its count, up to 8
+func penWidth(count int) int {
+	if count > 8 {
+		return 8
+	}
+
+	return count
+}
+
+// mermaidText escapes the quotes, which end a Mermaid label
+func mermaidText(s string) string {
+	return strings.ReplaceAll(printable(s), `"`, "#quot;")
+}
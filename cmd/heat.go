@@ -0,0 +1,444 @@
+package cmd
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// heatSignals holds the per-file signals which can be combined into
+// the risk index
+var heatSignals = map[string]func(fileHeat) float64{
+	"bugs":  func(h fileHeat) float64 { return float64(h.Bugs) },
+	"churn": func(h fileHeat) float64 { return float64(h.Changes) },
+	"prs":   func(h fileHeat) float64 { return float64(h.PRs) },
+	"sla":   func(h fileHeat) float64 { return float64(h.SLABreaches) },
+}
+
+// defaultRiskWeights are used when no risk.weights are configured
+var defaultRiskWeights = map[string]float64{
+	"bugs":  1,
+	"churn": 0.5,
+	"prs":   0.25,
+}
+
+// fileHeat represents the bug heat of a single file
+type fileHeat struct {
+	Repo      Repo    `json:"repo"`
+	File      string  `json:"file"`
+	Group     string  `json:"group,omitempty"`
+	Bugs      int     `json:"bugs"`
+	PRs       int     `json:"prs"`
+	Additions int     `json:"additions"`
+	Deletions int     `json:"deletions"`
+	Changes   int     `json:"changes"`
+	Score     float64 `json:"score"`
+	Risk      float64 `json:"risk"`
+
+	// SLABreaches is the number of the bugs with a breached service desk SLA
+	SLABreaches int `json:"sla_breaches"`
+
+	// FirstSeen and LastSeen are the times the fixes first and last
+	// touched the file, zero if they're not known
+	FirstSeen time.Time `json:"first_seen,omitempty"`
+	LastSeen  time.Time `json:"last_seen,omitempty"`
+
+	// Historical is set for the files of the archived repos, which get no
+	// fixes anymore
+	Historical bool `json:"historical,omitempty"`
+	// AcceptedRisk is the note of the accepted risk of the file, see
+	// annotate
+	AcceptedRisk string `json:"accepted_risk,omitempty"`
+
+	// bugs holds the last time a fix of every bug touched the file,
+	// zero if it's not known
+	bugs map[string]time.Time
+	prs  map[string]bool
+	// weights holds the weights of the bugs other than 1
+	weights map[string]float64
+	// owners holds the CODEOWNERS owners of the file
+	owners map[string]bool
+	// language is the language stored with the diffs of the file
+	language string
+	// events holds the fixes of the bugs touching the file
+	events []fixEvent
+}
+
+// fixEvent represents a fix of a bug touching a file
+type fixEvent struct {
+	Time     time.Time
+	Issue    string
+	PR       string
+	Lines    int
+	Releases []fixVersion
+}
+
+// bugTouch represents a bug fixed by a PR, its resolution time and its
+// weight by priority
+type bugTouch struct {
+	key      string
+	resolved time.Time
+	weight   float64
+	// issue is the key of the issue, or its project/ID if it isn't known
+	issue    string
+	versions []fixVersion
+}
+
+// weight returns the weight of a bug of the file
+func (h fileHeat) weight(b string) float64 {
+	if w, ok := h.weights[b]; ok {
+		return w
+	}
+
+	return 1
+}
+
+// weightedBugs returns the sum of the weights of the bugs of the file
+func (h fileHeat) weightedBugs() float64 {
+	var bugs float64
+	for b := range h.bugs {
+		bugs += h.weight(b)
+	}
+
+	return bugs
+}
+
+// seen widens the first and last seen times of the file to the given ones
+func (h *fileHeat) seen(first, last time.Time) {
+	if !first.IsZero() && (h.FirstSeen.IsZero() || first.Before(h.FirstSeen)) {
+		h.FirstSeen = first
+	}
+	h.LastSeen = latest(h.LastSeen, last)
+}
+
+// prKey identifies a PR across repos
+func prKey(repo Repo, id int) string {
+	return fmt.Sprintf("%s/%s#%d", repo.Owner, repo.Name, id)
+}
+
+// fileKey identifies a file across repos
+func fileKey(repo Repo, file string) string {
+	return fmt.Sprintf("%s/%s/%s", repo.Owner, repo.Name, file)
+}
+
+// computeHeat joins the mappings with the diffs of their PRs and computes
+// the heat of every changed file, sorted from the hottest one. The score
+// of a file is the number of distinct bugs touching it weighted by its
+// churn: bugs * (1 + ln(1 + changes)), every bug counting with the weight
+// of its priority and of its origin times the factor of its
+// documentation.
+func computeHeat(mappings []mongoMapping, prs []pr) []fileHeat {
+	weights := priorityWeights()
+	origins := originWeights()
+	documentation := documentationConfig()
+	bugsByPR := make(map[string][]bugTouch)
+	breached := make(map[string]bool)
+	for _, m := range mappings {
+		k := prKey(m.Repo, m.PRID)
+		b := m.bugKey()
+		issue := m.IssueKey
+		if issue == "" {
+			issue = b
+		}
+		bugsByPR[k] = append(bugsByPR[k], bugTouch{
+			key:      b,
+			resolved: m.ResolvedAt,
+			weight:   priorityWeight(weights, m.Priority) * priorityWeight(origins, m.Origin) * documentation.factor(m),
+			issue:    issue,
+			versions: m.FixVersions,
+		})
+		if m.SLABreached {
+			breached[b] = true
+		}
+	}
+
+	files := make(map[string]*fileHeat)
+	for _, p := range prs {
+		bugs, ok := bugsByPR[prKey(p.Repo, p.PRID)]
+		if !ok {
+			continue
+		}
+
+		for _, d := range p.Diff {
+			k := fileKey(p.Repo, d.File)
+			h, ok := files[k]
+			if !ok {
+				h = &fileHeat{Repo: p.Repo, File: d.File, bugs: make(map[string]time.Time), prs: make(map[string]bool), weights: make(map[string]float64)}
+				files[k] = h
+			}
+
+			h.PRs++
+			h.prs[prKey(p.Repo, p.PRID)] = true
+			h.Additions += d.Additions
+			h.Deletions += d.Deletions
+			h.Changes += d.Changes
+			if d.Language != "" {
+				h.language = d.Language
+			}
+			for _, o := range d.Owners {
+				if h.owners == nil {
+					h.owners = make(map[string]bool)
+				}
+				h.owners[o] = true
+			}
+			for _, b := range bugs {
+				touched := p.MergedAt
+				if touched.IsZero() {
+					touched = b.resolved
+				}
+				h.bugs[b.key] = latest(h.bugs[b.key], touched)
+				h.seen(touched, touched)
+				if b.weight != 1 {
+					h.weights[b.key] = b.weight
+				}
+				h.events = append(h.events, fixEvent{Time: touched, Issue: b.issue, PR: prKey(p.Repo, p.PRID), Lines: d.Changes, Releases: b.versions})
+			}
+		}
+	}
+
+	result := make([]fileHeat, 0, len(files))
+	for _, h := range files {
+		h.Bugs = len(h.bugs)
+		for b := range h.bugs {
+			if breached[b] {
+				h.SLABreaches++
+			}
+		}
+		h.Score = heatScore(h.weightedBugs(), h.Changes)
+		result = append(result, *h)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Score != result[j].Score {
+			return result[i].Score > result[j].Score
+		}
+		return fileKey(result[i].Repo, result[i].File) < fileKey(result[j].Repo, result[j].File)
+	})
+
+	return result
+}
+
+// heatScore weights the number of bugs by the churn
+func heatScore(bugs float64, changes int) float64 {
+	return bugs * (1 + math.Log1p(float64(changes)))
+}
+
+// decayHeat rescores the files counting every bug with the weight
+// 0.5^(age / halfLife) of its weight, the age being the time since its
+// fix last touched the file. The bugs of an unknown age keep the full
+// weight.
+func decayHeat(heat []fileHeat, halfLife time.Duration, now time.Time) {
+	for i := range heat {
+		var bugs float64
+		for b, t := range heat[i].bugs {
+			if t.IsZero() {
+				bugs += heat[i].weight(b)
+				continue
+			}
+			bugs += heat[i].weight(b) * math.Pow(0.5, math.Max(0, now.Sub(t).Hours())/halfLife.Hours())
+		}
+		heat[i].Score = heatScore(bugs, heat[i].Changes)
+	}
+
+	sort.SliceStable(heat, func(i, j int) bool { return heat[i].Score > heat[j].Score })
+}
+
+func latest(a, b time.Time) time.Time {
+	if b.After(a) {
+		return b
+	}
+
+	return a
+}
+
+// groupHeat merges the heat of the files by the groups returned by key.
+// A file can belong to several groups. The bugs and PRs of a group are
+// counted once even if they touch several of its files.
+func groupHeat(heat []fileHeat, key func(fileHeat) []string) []fileHeat {
+	groups := make(map[string]*fileHeat)
+	for _, h := range heat {
+		for _, name := range key(h) {
+			g, ok := groups[name]
+			if !ok {
+				g = &fileHeat{Group: name, bugs: make(map[string]time.Time), prs: make(map[string]bool), weights: make(map[string]float64)}
+				groups[name] = g
+			}
+
+			g.Additions += h.Additions
+			g.Deletions += h.Deletions
+			g.Changes += h.Changes
+			g.SLABreaches += h.SLABreaches
+			g.seen(h.FirstSeen, h.LastSeen)
+			for b, t := range h.bugs {
+				g.bugs[b] = latest(g.bugs[b], t)
+			}
+			for b, w := range h.weights {
+				g.weights[b] = w
+			}
+			for p := range h.prs {
+				g.prs[p] = true
+			}
+			g.events = append(g.events, h.events...)
+		}
+	}
+
+	result := make([]fileHeat, 0, len(groups))
+	for _, g := range groups {
+		g.Bugs = len(g.bugs)
+		g.PRs = len(g.prs)
+		g.Score = heatScore(g.weightedBugs(), g.Changes)
+		result = append(result, *g)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Score != result[j].Score {
+			return result[i].Score > result[j].Score
+		}
+		return result[i].Group < result[j].Group
+	})
+
+	return result
+}
+
+// groupHeatByPR computes the heat of the PRs of every group returned by
+// key separately and merges each group into a single entry
+func groupHeatByPR(mappings []mongoMapping, prs []pr, key func(pr) string) []fileHeat {
+	byGroup := make(map[string][]pr)
+	for _, p := range prs {
+		k := key(p)
+		byGroup[k] = append(byGroup[k], p)
+	}
+
+	result := make([]fileHeat, 0, len(byGroup))
+	for name, group := range byGroup {
+		result = append(result, groupHeat(computeHeat(mappings, group), func(fileHeat) []string { return []string{name} })...)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Score != result[j].Score {
+			return result[i].Score > result[j].Score
+		}
+		return result[i].Group < result[j].Group
+	})
+
+	return result
+}
+
+// groupHeatByMapping computes the heat of the bugs of every group returned
+// by key separately and merges each group into a single entry
+func groupHeatByMapping(mappings []mongoMapping, prs []pr, key func(mongoMapping) string) []fileHeat {
+	byGroup := make(map[string][]mongoMapping)
+	for _, m := range mappings {
+		k := key(m)
+		byGroup[k] = append(byGroup[k], m)
+	}
+
+	result := make([]fileHeat, 0, len(byGroup))
+	for name, group := range byGroup {
+		result = append(result, groupHeat(computeHeat(group, prs), func(fileHeat) []string { return []string{name} })...)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Score != result[j].Score {
+			return result[i].Score > result[j].Score
+		}
+		return result[i].Group < result[j].Group
+	})
+
+	return result
+}
+
+// dedupeCherryPicks counts the PRs of a repo with the same patch ID as a
+// single logical change. The first PR of every patch is kept and the
+// mappings of its cherry-picks are moved to it.
+func dedupeCherryPicks(mappings []mongoMapping, prs []pr) ([]mongoMapping, []pr) {
+	kept := make(map[string]pr)
+	moved := make(map[string]pr)
+	unique := make([]pr, 0, len(prs))
+	for _, p := range prs {
+		if p.PatchID == "" {
+			unique = append(unique, p)
+			continue
+		}
+
+		k := p.Repo.Owner + "/" + p.Repo.Name + "@" + p.PatchID
+		if first, ok := kept[k]; ok {
+			moved[prKey(p.Repo, p.PRID)] = first
+			continue
+		}
+		kept[k] = p
+		unique = append(unique, p)
+	}
+
+	if len(moved) == 0 {
+		return mappings, prs
+	}
+
+	remapped := make([]mongoMapping, len(mappings))
+	for i, m := range mappings {
+		if to, ok := moved[prKey(m.Repo, m.PRID)]; ok {
+			m.Repo, m.PRID = to.Repo, to.PRID
+		}
+		remapped[i] = m
+	}
+
+	return remapped, unique
+}
+
+// computeRisk sets the risk index of every file. Each weighted signal is
+// normalized by its maximum over all files, so the index of the riskiest
+// possible file is 100.
+func computeRisk(heat []fileHeat, weights map[string]float64) error {
+	var total float64
+	max := make(map[string]float64)
+	for name, w := range weights {
+		signal, ok := heatSignals[name]
+		if !ok {
+			return fmt.Errorf("unknown risk signal %q", name)
+		}
+		if w < 0 {
+			return fmt.Errorf("negative weight of risk signal %q", name)
+		}
+
+		total += w
+		for _, h := range heat {
+			max[name] = math.Max(max[name], signal(h))
+		}
+	}
+
+	if total == 0 {
+		return nil
+	}
+
+	for i := range heat {
+		var risk float64
+		for name, w := range weights {
+			if max[name] > 0 {
+				risk += w * heatSignals[name](heat[i]) / max[name]
+			}
+		}
+		heat[i].Risk = 100 * risk / total
+	}
+
+	return nil
+}
+
+// sortHeat orders the files from the hottest one by the given metric
+func sortHeat(heat []fileHeat, metric string) error {
+	var value func(fileHeat) float64
+	switch metric {
+	case "score":
+		value = func(h fileHeat) float64 { return h.Score }
+	case "risk":
+		value = func(h fileHeat) float64 { return h.Risk }
+	default:
+		return fmt.Errorf("unknown metric %q", metric)
+	}
+
+	sort.SliceStable(heat, func(i, j int) bool {
+		return value(heat[i]) > value(heat[j])
+	})
+
+	return nil
+}
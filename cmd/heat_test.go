@@ -0,0 +1,160 @@
+package cmd
+
+import (
+	"flag"
+	"fmt"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+// The benchmarks of the aggregation time the stages run by report on
+// synthetic datasets of 10k, 100k and 1M diff rows, the last one being
+// skipped with -short:
+//
+//	heat    computeHeat, joining the mappings with the diffs
+//	group   groupHeat by directory
+//	decay   decayHeat with a half-life of 90 days
+//	risk    computeRisk with the default weights
+//	sort    sortHeat by score
+//
+// Every stage has a budget per million diff rows, scaled linearly to the
+// size of the dataset, but never below 10ms, which absorbs the noise of
+// the small datasets. The budgets are about twice the times measured on a
+// single core, so a stage only exceeds its budget, failing the benchmark,
+// after a change which regresses the report latency. -budget-scale scales
+// them for the slower runners of a CI, e.g.
+//
+//	go test ./cmd -run '^$' -bench Aggregation -short -budget-scale 2
+
+var budgetScale = flag.Float64("budget-scale", 1, "factor of the budgets of the aggregation benchmarks")
+
+// aggregationBudgets holds the budgets of the stages per million diff
+// rows, in the order they run
+var aggregationBudgets = []struct {
+	stage  string
+	budget time.Duration
+}{
+	{"heat", 12 * time.Second},
+	{"group", 8 * time.Second},
+	{"decay", 3 * time.Second},
+	{"risk", 500 * time.Millisecond},
+	{"sort", 500 * time.Millisecond},
+}
+
+// minAggregationBudget is the least budget of a stage on any dataset
+const minAggregationBudget = 10 * time.Millisecond
+
+// aggregationDataset generates the mappings and the PRs of about rows diff
+// rows: a PR changes 5 files on average out of a pool of a file per 20
+// rows, over 10 repos, and is mapped to one or two bugs
+func aggregationDataset(rows int, seed int64) ([]mongoMapping, []pr) {
+	rnd := rand.New(rand.NewSource(seed))
+	repos := make([]Repo, 10)
+	for i := range repos {
+		repos[i] = Repo{Owner: "bench", Name: fmt.Sprintf("repo%d", i)}
+	}
+	files := rows/20 + 1
+	priorities := []string{"Highest", "High", "Medium", "Low"}
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	prs := make([]pr, 0, rows/5+1)
+	mappings := make([]mongoMapping, 0, rows/4+1)
+	for n, id := 0, 1; n < rows; id++ {
+		repo := repos[rnd.Intn(len(repos))]
+		p := pr{Repo: repo, PRID: id, MergedAt: start.Add(time.Duration(rnd.Int63n(int64(4 * 365 * 24 * time.Hour))))}
+		for k := 1 + rnd.Intn(9); k > 0 && n < rows; k-- {
+			f := rnd.Intn(files)
+			additions, deletions := rnd.Intn(80), rnd.Intn(40)
+			p.Diff = append(p.Diff, diff{
+				File:      fmt.Sprintf("pkg/m%d/d%d/file%d.go", f%50, f%7, f),
+				Status:    "modified",
+				Additions: additions,
+				Deletions: deletions,
+				Changes:   additions + deletions,
+			})
+			n++
+		}
+		prs = append(prs, p)
+
+		for b := 1 + rnd.Intn(2); b > 0; b-- {
+			issue := rnd.Intn(rows/10 + 1)
+			mappings = append(mappings, mongoMapping{
+				Project:    "BENCH",
+				IssueID:    issue,
+				IssueKey:   fmt.Sprintf("BENCH-%d", issue),
+				Repo:       repo,
+				PRID:       id,
+				Priority:   priorities[rnd.Intn(len(priorities))],
+				ResolvedAt: p.MergedAt,
+			})
+		}
+	}
+
+	return mappings, prs
+}
+
+func BenchmarkAggregation(b *testing.B) {
+	for _, size := range []struct {
+		name string
+		rows int
+	}{{"10k", 10000}, {"100k", 100000}, {"1M", 1000000}} {
+		b.Run(size.name, func(b *testing.B) {
+			if size.rows > 100000 && testing.Short() {
+				b.Skip("skipping the largest dataset in short mode")
+			}
+			mappings, prs := aggregationDataset(size.rows, 1)
+
+			stages := map[string]func(heat []fileHeat) []fileHeat{
+				"heat": func([]fileHeat) []fileHeat {
+					return computeHeat(mappings, prs)
+				},
+				"group": func(heat []fileHeat) []fileHeat {
+					return groupHeat(heat, func(h fileHeat) []string { return []string{dirBucket(h.Repo, h.File, 2)} })
+				},
+				"decay": func(heat []fileHeat) []fileHeat {
+					decayHeat(heat, 90*24*time.Hour, time.Now())
+					return heat
+				},
+				"risk": func(heat []fileHeat) []fileHeat {
+					if err := computeRisk(heat, defaultRiskWeights); err != nil {
+						b.Fatal(err)
+					}
+					return heat
+				},
+				"sort": func(heat []fileHeat) []fileHeat {
+					if err := sortHeat(heat, "score"); err != nil {
+						b.Fatal(err)
+					}
+					return heat
+				},
+			}
+
+			for _, s := range aggregationBudgets {
+				b.Run(s.stage, func(b *testing.B) {
+					budget := time.Duration(float64(s.budget) * float64(size.rows) / 1e6)
+					if budget < minAggregationBudget {
+						budget = minAggregationBudget
+					}
+					budget = time.Duration(float64(budget) * *budgetScale)
+
+					for i := 0; i < b.N; i++ {
+						// Every stage runs on the heat of a fresh report,
+						// which is computed off the clock
+						b.StopTimer()
+						var heat []fileHeat
+						if s.stage != "heat" {
+							heat = computeHeat(mappings, prs)
+						}
+						b.StartTimer()
+						stages[s.stage](heat)
+					}
+
+					if elapsed := b.Elapsed() / time.Duration(b.N); elapsed > budget {
+						b.Errorf("%s of %s rows took %s, over the budget of %s", s.stage, size.name, elapsed, budget)
+					}
+				})
+			}
+		})
+	}
+}
@@ -0,0 +1,144 @@
+package cmd
+
+import (
+	"context"
+	"log/slog"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// hunkHeader matches the header of a hunk of a unified diff, capturing the
+// start and the number of the lines of the hunk in the new file
+var hunkHeader = regexp.MustCompile(`^@@ -[0-9]+(?:,[0-9]+)? \+([0-9]+)(?:,([0-9]+))? @@`)
+
+// lineRange represents the lines of a hunk in the file after the change.
+// A hunk only removing lines has no lines and starts at the line before
+// the removed ones.
+type lineRange struct {
+	Start int `bson:"start" json:"start"`
+	Lines int `bson:"lines" json:"lines"`
+}
+
+// hunksEnabled tells whether collectDiffs records the hunks of the files
+func hunksEnabled() bool {
+	return viper.GetBool("diffs.hunks")
+}
+
+// parseHunks returns the line ranges of the hunks of the patch of a file
+func parseHunks(patch string) []lineRange {
+	hunks := make([]lineRange, 0)
+	for _, line := range strings.Split(patch, "\n") {
+		m := hunkHeader.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+
+		r := lineRange{Lines: 1}
+		r.Start, _ = strconv.Atoi(m[1])
+		if m[2] != "" {
+			r.Lines, _ = strconv.Atoi(m[2])
+		}
+		hunks = append(hunks, r)
+	}
+
+	return hunks
+}
+
+// patchBody returns the lines of the hunks of the patch of a file, without
+// the file header lines before the first hunk, e.g. --- a/file. A changed
+// line can start with --- or +++ itself, e.g. a removed SQL comment.
+func patchBody(patch string) []string {
+	lines := strings.Split(patch, "\n")
+	for i, line := range lines {
+		if strings.HasPrefix(line, "@@") {
+			return lines[i:]
+		}
+	}
+
+	return nil
+}
+
+// splitUnifiedDiff splits the unified diff of a PR into the patches of
+// its files by their cleaned paths, the old paths of the removed files
+func splitUnifiedDiff(raw string) map[string]string {
+	patches := make(map[string]string)
+	var file, oldFile string
+	header := false
+	var patch strings.Builder
+	flush := func() {
+		if file != "" {
+			patches[file] = patch.String()
+		}
+		file = ""
+		patch.Reset()
+	}
+
+	for _, line := range strings.Split(raw, "\n") {
+		switch {
+		case strings.HasPrefix(line, "diff --git "):
+			flush()
+			oldFile, header = "", true
+		case header && strings.HasPrefix(line, "--- "):
+			oldFile = strings.TrimPrefix(strings.TrimPrefix(line, "--- "), "a/")
+		case header && strings.HasPrefix(line, "+++ "):
+			file = strings.TrimPrefix(strings.TrimPrefix(line, "+++ "), "b/")
+			if file == "/dev/null" {
+				file = oldFile
+			}
+			file = cleanPath(strings.TrimRight(file, "\t\r"))
+			header = false
+		case file != "":
+			patch.WriteString(line)
+			patch.WriteString("\n")
+		}
+	}
+	flush()
+
+	return patches
+}
+
+// parsePatches sets the hunks of the files from their patches and tells
+// whether any file has no patch
+func parsePatches(diffs []diff) bool {
+	missing := false
+	for i := range diffs {
+		if diffs[i].patch == "" {
+			missing = true
+			continue
+		}
+		diffs[i].Hunks = parseHunks(diffs[i].patch)
+	}
+
+	return missing
+}
+
+// setHunks sets the hunks of the files from their patches. The patches of
+// the files listed without them, e.g. by Bitbucket or for the large files
+// by GitHub, are read from the diff of the whole PR if the provider
+// returns it. The providers refuse the diffs of the huge PRs, whose
+// files are then left without hunks.
+func setHunks(ctx context.Context, provider vcsProvider, repo Repo, id int, diffs []diff) error {
+	fetcher, ok := provider.(patchFetcher)
+	if missing := parsePatches(diffs); !missing || !ok {
+		return nil
+	}
+	raw, err := fetcher.patch(ctx, repo, id)
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if err != nil {
+		slog.Warn("fetching the patch failed, the files without one have no hunks", "pr", prKey(repo, id), "err", err)
+		return nil
+	}
+	patches := splitUnifiedDiff(raw)
+	for i := range diffs {
+		if p, ok := patches[diffs[i].File]; ok && diffs[i].patch == "" {
+			diffs[i].Hunks = parseHunks(p)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,197 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"unicode"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+const unknownAuthor = "unknown"
+
+// identitiesCmd represents the identities command
+var identitiesCmd = &cobra.Command{
+	Use:   "identities",
+	Short: "Lists the authors of the PRs and the people they resolve to",
+	Long: `Lists the logins of the authors of the collected PRs with the
+person each one resolves to, so the metrics by author, e.g. report
+--group-by author and analyze reviewers, count the work of an
+engineer with several logins and emails once.
+
+The people are configured with their aliases, e.g.
+  "identities": {"people": [{"name": "Jane Doe",
+    "aliases": ["jdoe", "jane.doe@acme.com", "jane-d"]}]}
+A login matches an alias regardless of the case; with
+identities.heuristics, the default, it also matches an alias, the
+local part of an email alias or the name of the person once the
+case, the punctuation and a [bot] suffix are dropped from both, e.g.
+Jane.Doe, jane_doe and JaneDoe all match Jane Doe. A heuristic
+matching more than one person resolves to none.
+
+The MATCH column tells how a login resolved: alias, heuristic, or
+- for the logins left as they are, the candidates for new aliases.`,
+	Args: cobra.NoArgs,
+	RunE: listIdentities,
+}
+
+func init() {
+	rootCmd.AddCommand(identitiesCmd)
+}
+
+// identityResolver maps the logins and the emails of the providers to the
+// canonical names of the people of identities.people
+type identityResolver struct {
+	aliases map[string]string
+	// squashed maps the squashed aliases to their people, or to "" if
+	// they're ambiguous
+	squashed   map[string]string
+	heuristics bool
+}
+
+// identityPerson represents a person of identities.people. They're a list
+// rather than a map by name, since viper lowercases the keys of the maps.
+type identityPerson struct {
+	Name    string   `mapstructure:"name"`
+	Aliases []string `mapstructure:"aliases"`
+}
+
+func identityPeople() []identityPerson {
+	people := make([]identityPerson, 0)
+	viper.UnmarshalKey("identities.people", &people)
+
+	return people
+}
+
+func newIdentityResolver() *identityResolver {
+	viper.SetDefault("identities.heuristics", true)
+
+	r := &identityResolver{
+		aliases:    make(map[string]string),
+		squashed:   make(map[string]string),
+		heuristics: viper.GetBool("identities.heuristics"),
+	}
+	privacy := newAuthorPrivacy()
+	for _, p := range identityPeople() {
+		person := p.Name
+		keys := []string{person}
+		for _, a := range p.Aliases {
+			r.aliases[strings.ToLower(strings.TrimSpace(a))] = person
+			if privacy.mode == privacyHash {
+				r.aliases[privacy.person(a)] = person
+			}
+			keys = append(keys, a)
+		}
+		for _, k := range keys {
+			for _, s := range squashIdentity(k) {
+				if other, ok := r.squashed[s]; ok && other != person {
+					r.squashed[s] = ""
+					continue
+				}
+				r.squashed[s] = person
+			}
+		}
+	}
+
+	return r
+}
+
+// squashIdentity returns the forms of a login, an email or a name compared
+// by the heuristics: the letters and the digits of the whole of it and of
+// the local part of an email, lowercased
+func squashIdentity(s string) []string {
+	s = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(s)), "[bot]")
+	forms := []string{s}
+	if at := strings.Index(s, "@"); at > 0 {
+		local := s[:at]
+		if plus := strings.Index(local, "+"); plus > 0 {
+			local = local[:plus]
+		}
+		forms = append(forms, local)
+	}
+
+	squashed := make([]string, 0, len(forms))
+	for _, f := range forms {
+		f = strings.Map(func(r rune) rune {
+			if unicode.IsLetter(r) || unicode.IsDigit(r) {
+				return r
+			}
+			return -1
+		}, f)
+		if f != "" {
+			squashed = append(squashed, f)
+		}
+	}
+
+	return squashed
+}
+
+// resolve returns the person of the login and how it matched, or the
+// login itself and "" if it matches none
+func (r *identityResolver) resolve(login string) (string, string) {
+	if person, ok := r.aliases[strings.ToLower(strings.TrimSpace(login))]; ok {
+		return person, "alias"
+	}
+	if r.heuristics {
+		for _, s := range squashIdentity(login) {
+			if person := r.squashed[s]; person != "" {
+				return person, "heuristic"
+			}
+		}
+	}
+
+	return login, ""
+}
+
+// person returns the canonical name of the author of a PR
+func (r *identityResolver) person(login string) string {
+	if login == "" {
+		return unknownAuthor
+	}
+	person, _ := r.resolve(login)
+
+	return person
+}
+
+func listIdentities(cmd *cobra.Command, args []string) error {
+	ctx, cancel, st, err := openReadStore()
+	if err != nil {
+		return err
+	}
+	defer cancel()
+	defer closeStore(ctx, st)
+
+	prs, err := st.PRs(ctx)
+	if err != nil {
+		return storageError(fmt.Errorf("reading diffs failed: %w", err))
+	}
+
+	counts := make(map[string]int)
+	for _, p := range prs {
+		if p.Author != "" {
+			counts[p.Author]++
+		}
+	}
+	logins := make([]string, 0, len(counts))
+	for l := range counts {
+		logins = append(logins, l)
+	}
+	sort.Strings(logins)
+
+	r := newIdentityResolver()
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "LOGIN\tPERSON\tMATCH\tPRS")
+	for _, l := range logins {
+		person, match := r.resolve(l)
+		if match == "" {
+			person, match = "-", "-"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\n", l, person, match, counts[l])
+	}
+
+	return tw.Flush()
+}
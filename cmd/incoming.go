@@ -0,0 +1,149 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"sort"
+	"time"
+)
+
+const (
+	// incomingAgeScale is the age which doubles the weight of an open bug
+	incomingAgeScale = 30 * 24 * time.Hour
+)
+
+// incomingGroupings are the groupings of report --incoming, which only
+// knows the changed files of the open PRs: their owners, base branches
+// and authors aren't fetched, nor are they mapped in the store
+var incomingGroupings = map[string]bool{"file": true, "dir": true, "team": true, "language": true, "topic": true}
+
+// incomingWriters write the historical heat next to the incoming one
+var incomingWriters = map[string]func(w io.Writer, historical, incoming []fileHeat) error{
+	"table": writeIncomingTable,
+	"json":  writeIncomingJSON,
+}
+
+// computeIncomingHeat forecasts the heat of the files touched by the open
+// PRs of the open bugs, fetched live from Jira and the provider. Every bug
+// weighs 1 plus its age in units of incomingAgeScale, so the bugs lingering
+// the longest contribute the most.
+func computeIncomingHeat(ctx context.Context, provider vcsProvider, auth string, projects []string) ([]fileHeat, error) {
+	files := make(map[string]*fileHeat)
+	now := time.Now()
+
+	for _, project := range projects {
+		bugs, err := collectOpenBugs(auth, project)
+		if err != nil {
+			return nil, jiraError(fmt.Errorf("project %s: collecting open bugs failed: %w", project, err))
+		}
+
+		prog := newProgress(len(bugs))
+		for _, b := range bugs {
+			weight := 1.0
+			if created, err := b.created(); err == nil {
+				weight += now.Sub(created).Hours() / incomingAgeScale.Hours()
+			}
+
+			prs, err := findDevStatus(b, auth, provider)
+			if errors.Is(err, errNoDevStatus) {
+				prog.step(provider.usage())
+				continue
+			}
+			if err != nil {
+				prog.finish()
+				return nil, jiraError(fmt.Errorf("bug %s: %w", b.Key, err))
+			}
+
+			for _, p := range *prs {
+				if p.Status != "OPEN" {
+					continue
+				}
+
+				repo, id, err := provider.parsePR(p)
+				if err != nil {
+					slog.Warn("skipping PR", "issue", b.Key, "err", err)
+					continue
+				}
+
+				diffs, err := provider.listFiles(ctx, repo, id)
+				if err != nil {
+					prog.finish()
+					return nil, vcsError(fmt.Errorf("PR %s: listing files failed: %w", prKey(repo, id), err))
+				}
+
+				for _, d := range diffs {
+					k := fileKey(repo, d.File)
+					h, ok := files[k]
+					if !ok {
+						h = &fileHeat{Repo: repo, File: d.File, bugs: make(map[string]time.Time), prs: make(map[string]bool), weights: make(map[string]float64)}
+						files[k] = h
+					}
+					if d.Language != "" {
+						h.language = d.Language
+					}
+
+					h.Additions += d.Additions
+					h.Deletions += d.Deletions
+					h.Changes += d.Changes
+					h.prs[prKey(repo, id)] = true
+					bk := fmt.Sprintf("%s/%d", project, b.ID)
+					if _, ok := h.bugs[bk]; !ok {
+						h.bugs[bk] = time.Time{}
+						h.weights[bk] = weight
+					}
+				}
+			}
+			prog.step(provider.usage())
+		}
+		prog.finish()
+	}
+
+	result := make([]fileHeat, 0, len(files))
+	for _, h := range files {
+		h.Bugs = len(h.bugs)
+		h.PRs = len(h.prs)
+		h.Score = heatScore(h.weightedBugs(), h.Changes)
+		result = append(result, *h)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Score != result[j].Score {
+			return result[i].Score > result[j].Score
+		}
+		return fileKey(result[i].Repo, result[i].File) < fileKey(result[j].Repo, result[j].File)
+	})
+
+	return result, nil
+}
+
+// collectOpenBugs searches the not yet done bugs of the project
+func collectOpenBugs(auth, project string) ([]bug, error) {
+	jql := fmt.Sprintf("project = %q and (%s) and statusCategory != Done", project, jqlFilter())
+
+	return searchIssues(auth, jql, "id,key,created")
+}
+
+func writeIncomingTable(w io.Writer, historical, incoming []fileHeat) error {
+	fmt.Fprintln(w, "HISTORICAL HEAT")
+	if err := writeReportTable(w, historical); err != nil {
+		return err
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "INCOMING HEAT")
+
+	return writeReportTable(w, incoming)
+}
+
+func writeIncomingJSON(w io.Writer, historical, incoming []fileHeat) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+
+	return enc.Encode(struct {
+		Historical []fileHeat `json:"historical"`
+		Incoming   []fileHeat `json:"incoming"`
+	}{historical, incoming})
+}
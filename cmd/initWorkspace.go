@@ -0,0 +1,161 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+)
+
+// initWorkspaceCmd represents the init-workspace command
+var initWorkspaceCmd = &cobra.Command{
+	Use:   "init-workspace",
+	Short: "Writes the starter files of a new workspace",
+	Long: `Writes the starter files of a team adopting heatmap into --dir:
+  .heatmap.json                  a config with a SQLite store and the
+                                 keys to fill in
+  templates/reportHTML.*         the template, the styles and the
+                                 scripts of report --format html, set
+                                 as assets.dir to be customized
+  .gitignore                     the journals, the manifests and the
+                                 database of the workspace
+  deploy/crontab                 a crontab line of a nightly sync
+  deploy/cronjob.yaml            a Kubernetes CronJob of the same, the
+                                 credentials coming from a Secret
+
+The existing files are kept unless --force is set. The credentials
+are left out of the config, pass them in the environment, e.g.
+HEATMAP_JIRA_AUTH_TOKEN and HEATMAP_GITHUB_TOKEN.`,
+	Annotations: map[string]string{optionalConfig: ""},
+	Args:        cobra.NoArgs,
+	RunE:        initWorkspace,
+}
+
+var (
+	workspaceDir   string
+	workspaceForce bool
+)
+
+func init() {
+	rootCmd.AddCommand(initWorkspaceCmd)
+	initWorkspaceCmd.Flags().StringVar(&workspaceDir, "dir", ".", "directory of the workspace")
+	initWorkspaceCmd.Flags().BoolVar(&workspaceForce, "force", false, "overwrite the existing files")
+}
+
+const workspaceConfig = `{
+  "storage": {"driver": "sqlite"},
+  "sqlite": {"path": "heatmap.db"},
+  "manifest": {"dir": "."},
+
+  "jira": {
+    "host": "https://example.atlassian.net",
+    "projects": ["PROJ"],
+    "auth": {"type": "basic", "email": "bot@example.com"}
+  },
+  "vcs": {"provider": "github"},
+
+  "diffs": {
+    "exclude": ["**/vendor/**", "**/node_modules/**", "**/*.lock", "**/*.min.js"]
+  },
+  "teams": {
+    "platform": ["infra/**"]
+  },
+  "assets": {"dir": "templates"}
+}
+`
+
+const workspaceGitignore = `# The state of the runs
+.heatmap-*.json
+.heatmap-*.json.tmp
+.heatmap-daemon.lock
+
+# The SQLite store
+heatmap.db
+heatmap.db-*
+
+# The reports and the exports
+*.html
+*.ndjson.gz
+*.sig
+`
+
+const workspaceCrontab = `# A nightly sync at 02:30, the credentials coming from the environment
+30 2 * * * cd /srv/heatmap && HEATMAP_JIRA_AUTH_TOKEN=... HEATMAP_GITHUB_TOKEN=... heatmap sync --config .heatmap.json --log-format json >> sync.log 2>&1
+`
+
+const workspaceCronJob = `apiVersion: batch/v1
+kind: CronJob
+metadata:
+  name: heatmap-sync
+spec:
+  schedule: "30 2 * * *"
+  concurrencyPolicy: Forbid
+  jobTemplate:
+    spec:
+      backoffLimit: 1
+      template:
+        spec:
+          restartPolicy: Never
+          containers:
+            - name: heatmap
+              image: heatmap:latest
+              args: ["sync", "--config", "/workspace/.heatmap.json", "--log-format", "json"]
+              workingDir: /workspace
+              envFrom:
+                # HEATMAP_JIRA_AUTH_TOKEN, HEATMAP_GITHUB_TOKEN, ...
+                - secretRef:
+                    name: heatmap-credentials
+              volumeMounts:
+                - name: workspace
+                  mountPath: /workspace
+          volumes:
+            - name: workspace
+              persistentVolumeClaim:
+                claimName: heatmap-workspace
+`
+
+// workspaceFile represents a starter file of a workspace
+type workspaceFile struct {
+	path    string
+	content string
+}
+
+func workspaceFiles() []workspaceFile {
+	files := []workspaceFile{
+		{".heatmap.json", workspaceConfig},
+	}
+	for _, name := range assetNames {
+		files = append(files, workspaceFile{filepath.Join("templates", name), embeddedAsset(name)})
+	}
+
+	return append(files, []workspaceFile{
+		{".gitignore", workspaceGitignore},
+		{filepath.Join("deploy", "crontab"), workspaceCrontab},
+		{filepath.Join("deploy", "cronjob.yaml"), workspaceCronJob},
+	}...)
+}
+
+func initWorkspace(cmd *cobra.Command, args []string) error {
+	for _, f := range workspaceFiles() {
+		path := filepath.Join(workspaceDir, f.path)
+		if _, err := os.Stat(path); err == nil && !workspaceForce {
+			slog.Info("file exists, kept", "path", path)
+			continue
+		} else if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return fmt.Errorf("creating %s failed: %w", filepath.Dir(path), err)
+		}
+		if err := os.WriteFile(path, []byte(f.content), 0o644); err != nil {
+			return fmt.Errorf("writing %s failed: %w", path, err)
+		}
+		slog.Info("file written", "path", path)
+	}
+
+	return nil
+}
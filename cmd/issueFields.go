@@ -0,0 +1,269 @@
+package cmd
+
+import (
+	"encoding/json"
+	"math"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/spf13/viper"
+)
+
+// issueFields are the fields of the bugs kept in their mappings
+var issueFields = []string{"summary", "priority", "components", "labels", "fixVersions", "created", "resolutiondate", "attachment", "description"}
+
+const (
+	defaultMaxAttachments = 5
+	defaultMaxDescription = 5000
+)
+
+// jiraNamed represents a field value of Jira with a name, like the
+// priority or a component
+type jiraNamed struct {
+	Name string `json:"name"`
+}
+
+// summary returns the summary of the bug if it was requested
+func (b bug) summary() string {
+	var s string
+	json.Unmarshal(b.Fields["summary"], &s)
+
+	return s
+}
+
+// priority returns the name of the priority of the bug if it has one
+func (b bug) priority() string {
+	p := jiraNamed{}
+	json.Unmarshal(b.Fields["priority"], &p)
+
+	return p.Name
+}
+
+// components returns the names of the components of the bug
+func (b bug) components() []string {
+	components := make([]jiraNamed, 0)
+	if err := json.Unmarshal(b.Fields["components"], &components); err != nil {
+		return nil
+	}
+
+	names := make([]string, 0, len(components))
+	for _, c := range components {
+		names = append(names, c.Name)
+	}
+
+	return names
+}
+
+// labels returns the labels of the bug
+func (b bug) labels() []string {
+	var labels []string
+	json.Unmarshal(b.Fields["labels"], &labels)
+
+	return labels
+}
+
+// fixVersion represents a release of Jira the bug was fixed in
+type fixVersion struct {
+	Name     string    `bson:"name" json:"name"`
+	Released time.Time `bson:"released,omitempty" json:"released,omitempty"`
+}
+
+// fixVersions returns the releases of the bug with their release dates,
+// which are left out of the versions not released yet
+func (b bug) fixVersions() []fixVersion {
+	versions := make([]struct {
+		Name        string `json:"name"`
+		ReleaseDate string `json:"releaseDate"`
+	}, 0)
+	if err := json.Unmarshal(b.Fields["fixVersions"], &versions); err != nil {
+		return nil
+	}
+
+	result := make([]fixVersion, 0, len(versions))
+	for _, v := range versions {
+		released, _ := time.Parse("2006-01-02", v.ReleaseDate)
+		result = append(result, fixVersion{Name: v.Name, Released: released})
+	}
+
+	return result
+}
+
+// attachments returns the number of the files attached to the bug
+func (b bug) attachments() int {
+	files := make([]json.RawMessage, 0)
+	json.Unmarshal(b.Fields["attachment"], &files)
+
+	return len(files)
+}
+
+// descriptionLength returns the number of the characters of the text of
+// the description, which is a string in the API v2 and a document of the
+// Atlassian Document Format in v3
+func (b bug) descriptionLength() int {
+	raw := b.Fields["description"]
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return utf8.RuneCountInString(s)
+	}
+
+	var doc interface{}
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		return 0
+	}
+
+	return adfTextLength(doc)
+}
+
+// adfTextLength sums the lengths of the text nodes of an ADF document
+func adfTextLength(node interface{}) int {
+	n := 0
+	switch v := node.(type) {
+	case map[string]interface{}:
+		if text, ok := v["text"].(string); ok && v["type"] == "text" {
+			n += utf8.RuneCountInString(text)
+		}
+		n += adfTextLength(v["content"])
+	case []interface{}:
+		for _, c := range v {
+			n += adfTextLength(c)
+		}
+	}
+
+	return n
+}
+
+// setIssueMetadata copies the key, the summary, the priority, the
+// components, the labels, the fix versions, the creation and resolution
+// times, the number of the attachments, the length of the description
+// and the origin of the bugs into their mappings
+func setIssueMetadata(mappings []mongoMapping, bugs map[string]bug) {
+	for i := range mappings {
+		b, ok := bugs[mappings[i].issueRef()]
+		if !ok {
+			continue
+		}
+
+		mappings[i].IssueKey = b.Key
+		mappings[i].Summary = b.summary()
+		mappings[i].Priority = b.priority()
+		mappings[i].Components = b.components()
+		mappings[i].Labels = b.labels()
+		mappings[i].FixVersions = b.fixVersions()
+		mappings[i].CreatedAt, _ = b.created()
+		mappings[i].ResolvedAt, _ = b.timeField("resolutiondate")
+		mappings[i].Attachments = b.attachments()
+		mappings[i].DescriptionLength = b.descriptionLength()
+		mappings[i].Origin = b.origin()
+	}
+}
+
+// issueFilter selects the mappings by the metadata of their bugs. A bug
+// passes if it matches one of the values of every non-empty dimension.
+type issueFilter struct {
+	priorities []string
+	components []string
+	labels     []string
+	origins    []string
+}
+
+func (f issueFilter) empty() bool {
+	return len(f.priorities) == 0 && len(f.components) == 0 && len(f.labels) == 0 && len(f.origins) == 0
+}
+
+func (f issueFilter) match(m mongoMapping) bool {
+	return matchAny(f.priorities, []string{m.Priority}) &&
+		matchAny(f.components, m.Components) &&
+		matchAny(f.labels, m.Labels) &&
+		matchAny(f.origins, []string{mappingOrigin(m)})
+}
+
+// matchAny tells whether one of the values is wanted, any value being
+// wanted if nothing is. The names are compared case-insensitively.
+func matchAny(wanted, values []string) bool {
+	if len(wanted) == 0 {
+		return true
+	}
+	for _, w := range wanted {
+		for _, v := range values {
+			if strings.EqualFold(w, v) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// filterMappings keeps the mappings of the bugs passing the filter
+func filterMappings(mappings []mongoMapping, f issueFilter) []mongoMapping {
+	if f.empty() {
+		return mappings
+	}
+
+	filtered := make([]mongoMapping, 0, len(mappings))
+	for _, m := range mappings {
+		if f.match(m) {
+			filtered = append(filtered, m)
+		}
+	}
+
+	return filtered
+}
+
+// priorityWeights returns the weights of the bugs by their priority from
+// heat.priority_weights, e.g. {"Blocker": 5, "Trivial": 0.5}. The bugs
+// of other priorities weigh 1.
+func priorityWeights() map[string]float64 {
+	weights := make(map[string]float64)
+	for name := range viper.GetStringMap("heat.priority_weights") {
+		weights[name] = viper.GetFloat64("heat.priority_weights." + name)
+	}
+
+	return weights
+}
+
+// documentationWeights represents the weights of how well documented the
+// bugs are, read from heat.documentation. The detailed reports with
+// screenshots and repro steps tend to come from the users, so they can
+// count for more.
+type documentationWeights struct {
+	attachment     float64
+	maxAttachments int
+	description    float64
+	maxDescription int
+}
+
+// documentationConfig reads heat.documentation.attachment_weight, added to
+// the weight of a bug per attachment up to max_attachments (default 5),
+// and description_weight, added per 1000 characters of the description up
+// to max_description (default 5000). Both weights are 0 by default.
+func documentationConfig() documentationWeights {
+	viper.SetDefault("heat.documentation.max_attachments", defaultMaxAttachments)
+	viper.SetDefault("heat.documentation.max_description", defaultMaxDescription)
+
+	return documentationWeights{
+		attachment:     viper.GetFloat64("heat.documentation.attachment_weight"),
+		maxAttachments: viper.GetInt("heat.documentation.max_attachments"),
+		description:    viper.GetFloat64("heat.documentation.description_weight"),
+		maxDescription: viper.GetInt("heat.documentation.max_description"),
+	}
+}
+
+// factor returns the multiplier of the weight of the bug of the mapping
+func (d documentationWeights) factor(m mongoMapping) float64 {
+	attachments := math.Min(float64(m.Attachments), float64(d.maxAttachments))
+	description := math.Min(float64(m.DescriptionLength), float64(d.maxDescription))
+
+	return 1 + d.attachment*attachments + d.description*description/1000
+}
+
+// priorityWeight returns the weight of a priority, the names of the
+// priorities being compared case-insensitively like viper stores them
+func priorityWeight(weights map[string]float64, priority string) float64 {
+	if w, ok := weights[strings.ToLower(priority)]; ok {
+		return w
+	}
+
+	return 1
+}
@@ -0,0 +1,426 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+const (
+	defaultJiraAPIVersion = "auto"
+	defaultJiraAuthType   = "basic"
+	jiraPageSize          = 100
+)
+
+// issuePage represents the paging fields of a page of issues of both
+// search endpoints and the number of its issues
+type issuePage struct {
+	Total         int
+	NextPageToken string
+	IsLast        bool
+	Issues        int
+}
+
+var (
+	// jiraVersion caches the version found by the probe of the auto
+	// mode, empty until a probe gets a definite answer
+	jiraVersionMu sync.Mutex
+	jiraVersion   string
+)
+
+// jiraTracker finds the bugs with JQL and their PRs in the dev-status
+// of the issues
+type jiraTracker struct {
+	auth     string
+	provider vcsProvider
+}
+
+func (t *jiraTracker) searchBugs(project string, since time.Time) (*[]bug, error) {
+	return collectBugs(t.auth, project, since)
+}
+
+func (t *jiraTracker) linkedPRs(b bug) (*[]jiraPR, error) {
+	return findDevStatus(b, t.auth, t.provider)
+}
+
+func (t *jiraTracker) findBug(project, key string) (*bug, error) {
+	bugs, err := searchIssues(t.auth, fmt.Sprintf("%s and key = %q", projectJQL(project), key), bugFields())
+	if err != nil || len(bugs) == 0 {
+		return nil, err
+	}
+
+	return &bugs[0], nil
+}
+
+func (t *jiraTracker) mentions(text string) []string {
+	return issueKeyPattern.FindAllString(text, -1)
+}
+
+// ping requests the user of the credentials
+func (t *jiraTracker) ping(ctx context.Context) error {
+	_, err := jiraGet(t.auth, "/rest/api/2/myself", nil, &struct{}{})
+
+	return err
+}
+
+// jiraAuth sets the Jira host and returns the Authorization header of the
+// requests for jira.auth.type: basic, sending jira.auth.email and the API
+// token of jira.auth.token, pat, sending jira.auth.token as a personal
+// access token of Jira Data Center, or oauth2. The OAuth 2.0 access token
+// is set per request, so it's refreshed when it expires.
+func jiraAuth() (string, error) {
+	jiraHost = viper.GetString("jira.host")
+	viper.SetDefault("jira.auth.type", defaultJiraAuthType)
+
+	switch t := viper.GetString("jira.auth.type"); t {
+	case "basic":
+		jiraEmail := viper.GetString("jira.auth.email")
+		jiraToken := viper.GetString("jira.auth.token")
+		return "Basic " + base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%s:%s", jiraEmail, jiraToken))), nil
+	case "pat":
+		return "Bearer " + viper.GetString("jira.auth.token"), nil
+	case "oauth2":
+		ts, err := newJiraTokenSource()
+		if err != nil {
+			return "", err
+		}
+		jiraTokens = ts
+		return "", nil
+	default:
+		return "", fmt.Errorf("unknown Jira auth type %q", t)
+	}
+}
+
+// jiraGet sends a GET request to the Jira API and decodes the response into v.
+// It returns the status of the response.
+func jiraGet(auth, path string, q url.Values, v interface{}) (int, error) {
+	return jiraGetStream(auth, path, q, func(r io.Reader) error {
+		return json.NewDecoder(r).Decode(v)
+	})
+}
+
+// jiraGetStream sends a GET request to the Jira API and passes the body of
+// the response to decode. It returns the status of the response.
+func jiraGetStream(auth, path string, q url.Values, decode func(io.Reader) error) (int, error) {
+	return jiraDo(auth, "GET", path, q, nil, decode)
+}
+
+// jiraPut sends a PUT request with v encoded as JSON to the Jira API.
+// It returns the status of the response.
+func jiraPut(auth, path string, v interface{}) (int, error) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return 0, err
+	}
+
+	return jiraDo(auth, "PUT", path, nil, bytes.NewReader(body), func(io.Reader) error { return nil })
+}
+
+// jiraDo sends a request to the Jira API and passes the body of a
+// successful response to decode. It returns the status of the response.
+func jiraDo(auth, method, path string, q url.Values, body io.Reader, decode func(io.Reader) error) (int, error) {
+	req, err := http.NewRequest(method, fmt.Sprintf("%s%s", jiraHost, path), body)
+	if err != nil {
+		return 0, err
+	}
+	if auth == "" && jiraTokens != nil {
+		t, err := jiraTokens.Token()
+		if err != nil {
+			return 0, fmt.Errorf("refreshing the Jira access token failed: %w", err)
+		}
+		auth = "Bearer " + t.AccessToken
+	}
+	req.Header.Add("Authorization", auth)
+	req.Header.Add("Content-Type", "application/json")
+	req.URL.RawQuery = q.Encode()
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+		return resp.StatusCode, jiraResponseError(resp)
+	}
+
+	return resp.StatusCode, decode(resp.Body)
+}
+
+// jiraResponseError returns the error of a failed response, with the
+// error messages of its body, e.g. the errors of a rejected JQL
+func jiraResponseError(resp *http.Response) error {
+	body := struct {
+		ErrorMessages []string `json:"errorMessages"`
+	}{}
+	if json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&body) != nil || len(body.ErrorMessages) == 0 {
+		return fmt.Errorf("Jira responded with %s", resp.Status)
+	}
+
+	return fmt.Errorf("Jira responded with %s: %s", resp.Status, strings.Join(body.ErrorMessages, "; "))
+}
+
+// jiraSearchRequest represents the parameters of a request of a page of
+// a search endpoint
+type jiraSearchRequest struct {
+	JQL           string   `json:"jql"`
+	Fields        []string `json:"fields"`
+	StartAt       int      `json:"startAt,omitempty"`
+	MaxResults    int      `json:"maxResults"`
+	NextPageToken string   `json:"nextPageToken,omitempty"`
+}
+
+// query returns the parameters as the query string of a GET
+func (r jiraSearchRequest) query() url.Values {
+	q := url.Values{}
+	q.Add("jql", r.JQL)
+	q.Add("fields", strings.Join(r.Fields, ","))
+	if r.StartAt > 0 {
+		q.Add("startAt", strconv.Itoa(r.StartAt))
+	}
+	q.Add("maxResults", strconv.Itoa(r.MaxResults))
+	if r.NextPageToken != "" {
+		q.Add("nextPageToken", r.NextPageToken)
+	}
+
+	return q
+}
+
+// jiraSearchPage requests a page of a search endpoint, passing its issues
+// to fn one at a time as they are decoded. The request is sent as a GET,
+// or as a POST if its query string would be too long for a URL.
+func jiraSearchPage(auth, path string, r jiraSearchRequest, fn func(bug)) (int, issuePage, error) {
+	page := issuePage{}
+	decode := func(r io.Reader) error {
+		var err error
+		page, err = decodeIssuePage(r, fn)
+		return err
+	}
+
+	q := r.query()
+	if len(q.Encode()) <= jiraMaxQueryLength {
+		status, err := jiraGetStream(auth, path, q, decode)
+		return status, page, err
+	}
+
+	body, err := json.Marshal(r)
+	if err != nil {
+		return 0, page, err
+	}
+	status, err := jiraDo(auth, "POST", path, nil, bytes.NewReader(body), decode)
+
+	return status, page, err
+}
+
+// decodeIssuePage decodes a page of issues token by token, so only a
+// single issue of the page is held in memory at a time
+func decodeIssuePage(r io.Reader, fn func(bug)) (issuePage, error) {
+	page := issuePage{}
+	dec := json.NewDecoder(r)
+	if err := expectDelim(dec, '{'); err != nil {
+		return page, err
+	}
+
+	for dec.More() {
+		t, err := dec.Token()
+		if err != nil {
+			return page, err
+		}
+
+		var field interface{}
+		switch t {
+		case "issues":
+			if err := expectDelim(dec, '['); err != nil {
+				return page, err
+			}
+			for dec.More() {
+				b := bug{}
+				if err := dec.Decode(&b); err != nil {
+					return page, err
+				}
+				fn(b)
+				page.Issues++
+			}
+			if err := expectDelim(dec, ']'); err != nil {
+				return page, err
+			}
+			continue
+		case "total":
+			field = &page.Total
+		case "nextPageToken":
+			field = &page.NextPageToken
+		case "isLast":
+			field = &page.IsLast
+		default:
+			field = &json.RawMessage{}
+		}
+		if err := dec.Decode(field); err != nil {
+			return page, err
+		}
+	}
+
+	return page, expectDelim(dec, '}')
+}
+
+// expectDelim reads the next token, failing if it isn't the delimiter
+func expectDelim(dec *json.Decoder, delim json.Delim) error {
+	t, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if t != delim {
+		return fmt.Errorf("unexpected %v in the response, %v expected", t, delim)
+	}
+
+	return nil
+}
+
+// jiraAPIVersion returns the version of the search API selected by the
+// jira.api_version config key. In auto mode the v3 JQL search endpoint
+// is probed until it answers, and the old search endpoint is used if
+// it's missing.
+func jiraAPIVersion(auth string) (string, error) {
+	viper.SetDefault("jira.api_version", defaultJiraAPIVersion)
+
+	switch v := viper.GetString("jira.api_version"); v {
+	case "2", "latest":
+		return "2", nil
+	case "3":
+		return "3", nil
+	case "auto":
+	default:
+		return "", configError(fmt.Errorf("unknown Jira API version %q", v))
+	}
+
+	jiraVersionMu.Lock()
+	defer jiraVersionMu.Unlock()
+	if jiraVersion != "" {
+		return jiraVersion, nil
+	}
+
+	// Only a definite answer is cached, a failed probe, e.g. a timeout,
+	// is retried by the next search
+	r := jiraSearchRequest{JQL: "order by created", Fields: []string{"id"}, MaxResults: 1}
+	status, _, err := jiraSearchPage(auth, "/rest/api/3/search/jql", r, func(bug) {})
+	switch {
+	case err == nil:
+		jiraVersion = "3"
+	case status == http.StatusNotFound:
+		jiraVersion = "2"
+	default:
+		return "", fmt.Errorf("probing Jira API version failed: %w", err)
+	}
+
+	return jiraVersion, nil
+}
+
+// searchIssues returns all issues matching the JQL, requesting the given
+// fields. A JQL longer than jira.max_jql_length is searched in chunks of
+// its longest IN list, and a chunk rejected by Jira as a bad request is
+// halved until it's accepted, so the issue lists beyond the limits of
+// Jira are searched transparently. The issues of the chunks are merged
+// by their IDs.
+func searchIssues(auth, jql, fields string) ([]bug, error) {
+	version, err := jiraAPIVersion(auth)
+	if err != nil {
+		return nil, err
+	}
+
+	chunks := splitJQL(jql, maxJQLLength())
+	if len(chunks) > 1 {
+		slog.Debug("searching JQL in chunks", "length", len(jql), "chunks", len(chunks))
+	}
+
+	issues := make([]bug, 0)
+	seen := make(map[int]bool)
+	for _, chunk := range chunks {
+		bugs, err := searchIssuesChunk(auth, version, chunk, strings.Split(fields, ","))
+		if err != nil {
+			return nil, err
+		}
+		for _, b := range bugs {
+			if !seen[b.ID] {
+				seen[b.ID] = true
+				issues = append(issues, b)
+			}
+		}
+	}
+
+	return issues, nil
+}
+
+// searchIssuesChunk searches the issues of a chunk of a JQL, halving it
+// if it's rejected as a bad request
+func searchIssuesChunk(auth, version, jql string, fields []string) ([]bug, error) {
+	search := searchIssuesByOffset
+	if version == "3" {
+		search = searchIssuesByToken
+	}
+
+	status, bugs, err := search(auth, jql, fields)
+	if err == nil || status != http.StatusBadRequest {
+		return bugs, err
+	}
+	halves, ok := halveJQL(jql)
+	if !ok {
+		return nil, err
+	}
+	slog.Debug("JQL rejected, halving it", "length", len(jql), "err", err)
+
+	issues := make([]bug, 0)
+	for _, half := range halves {
+		bugs, err := searchIssuesChunk(auth, version, half, fields)
+		if err != nil {
+			return nil, err
+		}
+		issues = append(issues, bugs...)
+	}
+
+	return issues, nil
+}
+
+// searchIssuesByOffset pages through the old search endpoint by startAt
+func searchIssuesByOffset(auth, jql string, fields []string) (int, []bug, error) {
+	issues := make([]bug, 0)
+	for {
+		r := jiraSearchRequest{JQL: jql, Fields: fields, StartAt: len(issues), MaxResults: jiraPageSize}
+		status, page, err := jiraSearchPage(auth, "/rest/api/latest/search", r, func(b bug) { issues = append(issues, b) })
+		if err != nil {
+			return status, nil, err
+		}
+
+		if page.Issues == 0 || len(issues) >= page.Total {
+			return status, issues, nil
+		}
+	}
+}
+
+// searchIssuesByToken pages through the v3 JQL search endpoint by nextPageToken
+func searchIssuesByToken(auth, jql string, fields []string) (int, []bug, error) {
+	issues := make([]bug, 0)
+	token := ""
+	for {
+		r := jiraSearchRequest{JQL: jql, Fields: fields, MaxResults: jiraPageSize, NextPageToken: token}
+		status, page, err := jiraSearchPage(auth, "/rest/api/3/search/jql", r, func(b bug) { issues = append(issues, b) })
+		if err != nil {
+			return status, nil, err
+		}
+
+		if page.IsLast || page.NextPageToken == "" {
+			return status, issues, nil
+		}
+		token = page.NextPageToken
+	}
+}
@@ -0,0 +1,94 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/spf13/viper"
+	"golang.org/x/oauth2"
+)
+
+const defaultJiraTokenURL = "https://auth.atlassian.com/oauth/token"
+
+// jiraTokens issues the access tokens of the oauth2 Jira auth type
+var jiraTokens oauth2.TokenSource
+
+// newJiraTokenSource refreshes the access tokens with the refresh token
+// of the OAuth 2.0 (3LO) app of jira.auth.client_id and
+// jira.auth.client_secret. The refresh token is read from
+// jira.auth.token_file if it exists, since Atlassian rotates it on every
+// refresh, and jira.auth.refresh_token otherwise.
+func newJiraTokenSource() (oauth2.TokenSource, error) {
+	viper.SetDefault("jira.auth.token_url", defaultJiraTokenURL)
+
+	refresh := viper.GetString("jira.auth.refresh_token")
+	path := viper.GetString("jira.auth.token_file")
+	if path != "" {
+		raw, err := os.ReadFile(path)
+		if err == nil {
+			refresh = strings.TrimSpace(string(raw))
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("reading the Jira refresh token failed: %w", err)
+		}
+	}
+	if refresh == "" {
+		return nil, fmt.Errorf("jira.auth.refresh_token is not set")
+	}
+
+	conf := &oauth2.Config{
+		ClientID:     viper.GetString("jira.auth.client_id"),
+		ClientSecret: viper.GetString("jira.auth.client_secret"),
+		Endpoint: oauth2.Endpoint{
+			TokenURL:  viper.GetString("jira.auth.token_url"),
+			AuthStyle: oauth2.AuthStyleInParams,
+		},
+	}
+
+	// The refreshes go through the client, so the network policy and the
+	// retries apply to them too
+	ctx := context.WithValue(context.Background(), oauth2.HTTPClient, client)
+
+	return &rotatingTokenSource{
+		src:     conf.TokenSource(ctx, &oauth2.Token{RefreshToken: refresh}),
+		path:    path,
+		refresh: refresh,
+	}, nil
+}
+
+// rotatingTokenSource saves the refresh token to the token file whenever
+// the authorization server rotates it
+type rotatingTokenSource struct {
+	mu      sync.Mutex
+	src     oauth2.TokenSource
+	path    string
+	refresh string
+}
+
+func (s *rotatingTokenSource) Token() (*oauth2.Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, err := s.src.Token()
+	if err != nil {
+		return nil, err
+	}
+	if t.RefreshToken == "" || t.RefreshToken == s.refresh {
+		return t, nil
+	}
+
+	s.refresh = t.RefreshToken
+	if s.path == "" {
+		slog.Warn("the Jira refresh token was rotated, set jira.auth.token_file to keep it across runs")
+		return t, nil
+	}
+	if err := os.WriteFile(s.path, []byte(t.RefreshToken), 0o600); err != nil {
+		slog.Warn("saving the Jira refresh token failed", "path", s.path, "err", err)
+	}
+
+	return t, nil
+}
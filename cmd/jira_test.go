@@ -0,0 +1,106 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// searchPageJSON returns a page of a search endpoint with the issues, every
+// one with a description of about 4KB as the fields
+func searchPageJSON(issues int) []byte {
+	description := strings.Repeat("lorem ipsum ", 350)
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, `{"expand":"names","startAt":0,"maxResults":%d,"total":%d,"issues":[`, issues, issues)
+	for i := 0; i < issues; i++ {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, `{"id":"%d","key":"BENCH-%d","fields":{"priority":{"name":"High"},"resolutiondate":"2024-01-02T03:04:05.000+0000","description":%q}}`,
+			10000+i, i, description)
+	}
+	b.WriteString(`],"isLast":true}`)
+
+	return b.Bytes()
+}
+
+// liveHeap returns the bytes of the heap objects left after a collection
+func liveHeap() uint64 {
+	runtime.GC()
+
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+
+	return m.HeapAlloc
+}
+
+// BenchmarkDecodeIssuePage compares decodeIssuePage, which passes the
+// issues on one at a time, with the decoding of the whole page it
+// replaced. live-B is the most heap the decoding of a page keeps alive
+// above the heap before it: sampled every 100 issues when streaming, and
+// once the page is decoded otherwise, which is the least the decoding of
+// the whole page needs.
+func BenchmarkDecodeIssuePage(b *testing.B) {
+	for _, issues := range []int{100, 1000, 5000} {
+		page := searchPageJSON(issues)
+
+		b.Run(fmt.Sprintf("stream/%d", issues), func(b *testing.B) {
+			base, live := liveHeap(), uint64(0)
+			n := 0
+			p, err := decodeIssuePage(bytes.NewReader(page), func(bug) {
+				if n++; n%100 == 0 {
+					if h := liveHeap(); h > base && h-base > live {
+						live = h - base
+					}
+				}
+			})
+			if err != nil {
+				b.Fatal(err)
+			}
+			if p.Issues != issues {
+				b.Fatalf("%d of %d issues decoded", p.Issues, issues)
+			}
+
+			b.SetBytes(int64(len(page)))
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := decodeIssuePage(bytes.NewReader(page), func(bug) {}); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(live), "live-B")
+		})
+
+		b.Run(fmt.Sprintf("whole/%d", issues), func(b *testing.B) {
+			type wholePage struct {
+				Total  int   `json:"total"`
+				IsLast bool  `json:"isLast"`
+				Issues []bug `json:"issues"`
+			}
+
+			base := liveHeap()
+			p := wholePage{}
+			if err := json.NewDecoder(bytes.NewReader(page)).Decode(&p); err != nil {
+				b.Fatal(err)
+			}
+			live := liveHeap() - base
+			runtime.KeepAlive(p)
+
+			b.SetBytes(int64(len(page)))
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				p := wholePage{}
+				if err := json.NewDecoder(bytes.NewReader(page)).Decode(&p); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(live), "live-B")
+		})
+	}
+}
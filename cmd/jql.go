@@ -0,0 +1,205 @@
+package cmd
+
+import (
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+const (
+	// defaultJiraMaxJQLLength is the length of the longest JQL searched in
+	// a single request by default, less than the 2,000 characters of the
+	// URLs of the proxies in front of Jira
+	defaultJiraMaxJQLLength = 1800
+	// jiraMaxQueryLength is the length of the longest query string of a
+	// search sent as a GET, a longer one is sent as a POST
+	jiraMaxQueryLength = 4000
+)
+
+// jqlToken represents a word, a quoted string or a punctuation character
+// of a JQL query and its offsets in the query
+type jqlToken struct {
+	text       string
+	start, end int
+	quoted     bool
+}
+
+// jqlList represents the items of an IN list of a JQL query, between the
+// offsets of its parentheses
+type jqlList struct {
+	start, end int
+	items      []string
+}
+
+func maxJQLLength() int {
+	viper.SetDefault("jira.max_jql_length", defaultJiraMaxJQLLength)
+
+	return viper.GetInt("jira.max_jql_length")
+}
+
+// tokenizeJQL splits the JQL into tokens, keeping the quoted strings with
+// their escaped quotes whole
+func tokenizeJQL(jql string) []jqlToken {
+	tokens := make([]jqlToken, 0)
+	for i := 0; i < len(jql); {
+		c := jql[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '"' || c == '\'':
+			j := i + 1
+			for j < len(jql) && jql[j] != c {
+				if jql[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j < len(jql) {
+				j++
+			}
+			if j > len(jql) {
+				j = len(jql)
+			}
+			tokens = append(tokens, jqlToken{text: jql[i:j], start: i, end: j, quoted: true})
+			i = j
+		case strings.IndexByte("(),=!~<>", c) >= 0:
+			tokens = append(tokens, jqlToken{text: jql[i : i+1], start: i, end: i + 1})
+			i++
+		default:
+			j := i
+			for j < len(jql) && strings.IndexByte(" \t\n\r\"'(),=!~<>", jql[j]) < 0 {
+				j++
+			}
+			tokens = append(tokens, jqlToken{text: jql[i:j], start: i, end: j})
+			i = j
+		}
+	}
+
+	return tokens
+}
+
+// isJQLWord reports if the token is the unquoted keyword
+func isJQLWord(tokens []jqlToken, i int, word string) bool {
+	return i >= 0 && i < len(tokens) && !tokens[i].quoted && strings.EqualFold(tokens[i].text, word)
+}
+
+// longestJQLList returns the longest IN list of the JQL which can be
+// searched in chunks, the union of their results being the results of
+// the whole list. That's only so without a NOT operator, which could
+// negate the list, and for the lists of plain values, not of functions.
+func longestJQLList(jql string) (jqlList, bool) {
+	tokens := tokenizeJQL(jql)
+	for i := range tokens {
+		// NOT IN and IS NOT are operators of a single clause
+		if isJQLWord(tokens, i, "not") && !isJQLWord(tokens, i-1, "is") && !isJQLWord(tokens, i+1, "in") {
+			return jqlList{}, false
+		}
+	}
+
+	var longest jqlList
+	found := false
+	for i := range tokens {
+		if !isJQLWord(tokens, i, "in") || isJQLWord(tokens, i-1, "not") || i+1 >= len(tokens) || tokens[i+1].text != "(" {
+			continue
+		}
+
+		list := jqlList{start: tokens[i+1].end}
+		item := -1
+		nested := false
+		j := i + 2
+		for ; j < len(tokens); j++ {
+			t := tokens[j]
+			if !t.quoted && t.text == "(" {
+				nested = true
+				break
+			}
+			if !t.quoted && t.text == ")" {
+				break
+			}
+			if !t.quoted && t.text == "," {
+				if item >= 0 {
+					list.items = append(list.items, jql[item:tokens[j-1].end])
+				}
+				item = -1
+				continue
+			}
+			if item < 0 {
+				item = t.start
+			}
+		}
+		if nested || j == len(tokens) {
+			continue
+		}
+		if item >= 0 {
+			list.items = append(list.items, jql[item:tokens[j-1].end])
+		}
+		list.end = tokens[j].start
+
+		if len(list.items) > 1 && (!found || list.end-list.start > longest.end-longest.start) {
+			longest, found = list, true
+		}
+	}
+
+	return longest, found
+}
+
+// chunkJQLList returns the JQL with its list replaced by the chunks of
+// the items, each chunk joined into at most max characters unless a
+// single item is longer
+func chunkJQLList(jql string, list jqlList, max int) []string {
+	chunks := make([]string, 0)
+	chunk := make([]string, 0)
+	length := 0
+	flush := func() {
+		chunks = append(chunks, jql[:list.start]+strings.Join(chunk, ", ")+jql[list.end:])
+		chunk, length = chunk[:0], 0
+	}
+	for _, item := range list.items {
+		if len(chunk) > 0 && length+2+len(item) > max {
+			flush()
+		}
+		if len(chunk) > 0 {
+			length += 2
+		}
+		chunk = append(chunk, item)
+		length += len(item)
+	}
+	flush()
+
+	return chunks
+}
+
+// splitJQL splits a JQL longer than max characters into JQL queries of at
+// most max characters by its longest IN list. A JQL which can't be split
+// is returned as is.
+func splitJQL(jql string, max int) []string {
+	if len(jql) <= max {
+		return []string{jql}
+	}
+	list, ok := longestJQLList(jql)
+	if !ok {
+		return []string{jql}
+	}
+
+	budget := max - (len(jql) - (list.end - list.start))
+	if budget < 1 {
+		budget = 1
+	}
+
+	return chunkJQLList(jql, list, budget)
+}
+
+// halveJQL splits the longest IN list of the JQL into two halves
+func halveJQL(jql string) ([]string, bool) {
+	list, ok := longestJQLList(jql)
+	if !ok {
+		return nil, false
+	}
+
+	half := len(list.items) / 2
+	join := func(items []string) string {
+		return jql[:list.start] + strings.Join(items, ", ") + jql[list.end:]
+	}
+
+	return []string{join(list.items[:half]), join(list.items[half:])}, true
+}
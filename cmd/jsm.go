@@ -0,0 +1,121 @@
+package cmd
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+const (
+	jiraTimeLayout          = "2006-01-02T15:04:05.000-0700"
+	defaultJSMRequestType   = "customfield_10010"
+	defaultJSMTimeToResolve = "customfield_10030"
+)
+
+// jsmRequestType represents the value of the Customer Request Type field
+type jsmRequestType struct {
+	RequestType struct {
+		Name string `json:"name"`
+	} `json:"requestType"`
+}
+
+// jsmSLA represents the value of an SLA field of a service desk issue
+type jsmSLA struct {
+	OngoingCycle *struct {
+		Breached bool `json:"breached"`
+	} `json:"ongoingCycle"`
+	CompletedCycles []struct {
+		Breached bool `json:"breached"`
+	} `json:"completedCycles"`
+}
+
+// jsmFields returns the Jira Service Management fields requested with
+// the bugs: jira.jsm.request_type_field and jira.jsm.sla_fields. Their
+// IDs differ between the instances, so they are configurable.
+func jsmFields() []string {
+	jsmDefaults()
+
+	fields := make([]string, 0)
+	if f := viper.GetString("jira.jsm.request_type_field"); f != "" {
+		fields = append(fields, f)
+	}
+
+	return append(fields, viper.GetStringSlice("jira.jsm.sla_fields")...)
+}
+
+func jsmDefaults() {
+	viper.SetDefault("jira.jsm.request_type_field", defaultJSMRequestType)
+	viper.SetDefault("jira.jsm.sla_fields", []string{defaultJSMTimeToResolve})
+}
+
+// created returns the creation time of the bug if it was requested
+func (b bug) created() (time.Time, error) {
+	return b.timeField("created")
+}
+
+// timeField parses a date-time field of the bug if it was requested
+func (b bug) timeField(name string) (time.Time, error) {
+	var value string
+	if err := json.Unmarshal(b.Fields[name], &value); err != nil {
+		return time.Time{}, err
+	}
+
+	return time.Parse(jiraTimeLayout, value)
+}
+
+// requestType returns the name of the portal request type of a bug
+// reported through a service desk, or an empty string for other bugs
+func (b bug) requestType() string {
+	raw, ok := b.Fields[viper.GetString("jira.jsm.request_type_field")]
+	if !ok {
+		return ""
+	}
+
+	rt := jsmRequestType{}
+	if err := json.Unmarshal(raw, &rt); err != nil {
+		return ""
+	}
+
+	return rt.RequestType.Name
+}
+
+// slaBreached tells whether any SLA of the bug is or was breached
+func (b bug) slaBreached() bool {
+	for _, f := range viper.GetStringSlice("jira.jsm.sla_fields") {
+		raw, ok := b.Fields[f]
+		if !ok {
+			continue
+		}
+
+		sla := jsmSLA{}
+		if err := json.Unmarshal(raw, &sla); err != nil {
+			continue
+		}
+		if sla.OngoingCycle != nil && sla.OngoingCycle.Breached {
+			return true
+		}
+		for _, c := range sla.CompletedCycles {
+			if c.Breached {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// setServiceDeskContext copies the request type and the SLA state of the
+// bugs into their mappings
+func setServiceDeskContext(mappings []mongoMapping, bugs map[string]bug) {
+	jsmDefaults()
+	for i := range mappings {
+		b, ok := bugs[mappings[i].issueRef()]
+		if !ok {
+			continue
+		}
+
+		mappings[i].RequestType = b.requestType()
+		mappings[i].SLABreached = b.slaBreached()
+	}
+}
@@ -0,0 +1,86 @@
+package cmd
+
+import (
+	"path"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+const unknownLanguage = "unknown"
+
+// defaultLanguages maps the extensions, and the names of the files without
+// one, to their languages
+var defaultLanguages = map[string]string{
+	".go": "go", ".php": "php", ".phtml": "php", ".py": "python", ".rb": "ruby",
+	".java": "java", ".kt": "kotlin", ".kts": "kotlin", ".scala": "scala", ".groovy": "groovy",
+	".js": "javascript", ".jsx": "javascript", ".mjs": "javascript", ".cjs": "javascript",
+	".ts": "typescript", ".tsx": "typescript", ".vue": "vue", ".svelte": "svelte",
+	".c": "c", ".h": "c", ".cc": "c++", ".cpp": "c++", ".cxx": "c++", ".hpp": "c++",
+	".cs": "c#", ".fs": "f#", ".vb": "visual basic", ".swift": "swift", ".m": "objective-c",
+	".rs": "rust", ".dart": "dart", ".ex": "elixir", ".exs": "elixir", ".erl": "erlang",
+	".clj": "clojure", ".hs": "haskell", ".lua": "lua", ".pl": "perl", ".r": "r",
+	".sh": "shell", ".bash": "shell", ".ps1": "powershell", ".sql": "sql",
+	".html": "html", ".css": "css", ".scss": "css", ".less": "css",
+	".tf": "terraform", ".yaml": "yaml", ".yml": "yaml", ".json": "json", ".xml": "xml",
+	".md": "markdown", ".proto": "protobuf", ".graphql": "graphql",
+	"dockerfile": "dockerfile", "makefile": "make", "jenkinsfile": "groovy",
+}
+
+// languageExtensions returns the default mapping with the languages key
+// applied over it. The key maps a language to its extensions or file
+// names, e.g. "languages": {"php": [".inc"], "starlark": ["BUILD", ".bzl"]}
+func languageExtensions() map[string]string {
+	languages := make(map[string]string, len(defaultLanguages))
+	for ext, l := range defaultLanguages {
+		languages[ext] = l
+	}
+	for l := range viper.GetStringMap("languages") {
+		for _, ext := range viper.GetStringSlice("languages." + l) {
+			languages[strings.ToLower(ext)] = l
+		}
+	}
+
+	return languages
+}
+
+// languageOf detects the language of a file by its extension, or by its
+// name if it has none, e.g. Dockerfile
+func languageOf(languages map[string]string, file string) string {
+	name := strings.ToLower(path.Base(file))
+	if l, ok := languages[path.Ext(name)]; ok {
+		return l
+	}
+	if l, ok := languages[name]; ok {
+		return l
+	}
+
+	return unknownLanguage
+}
+
+// setLanguages sets the language of every file changed by the PRs
+func setLanguages(languages map[string]string, prs []pr) {
+	for i := range prs {
+		diffs := [][]diff{prs[i].Diff}
+		for _, commit := range prs[i].Commits {
+			diffs = append(diffs, commit.Diff)
+		}
+		for _, ds := range diffs {
+			for j := range ds {
+				ds[j].Language = languageOf(languages, ds[j].File)
+			}
+		}
+	}
+}
+
+// fileLanguage returns the language stored with the diffs of the file.
+// The files collected before the languages were stored are detected by
+// their names.
+func fileLanguage(languages map[string]string) func(fileHeat) []string {
+	return func(h fileHeat) []string {
+		if h.language != "" {
+			return []string{h.language}
+		}
+		return []string{languageOf(languages, h.File)}
+	}
+}
@@ -0,0 +1,264 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+const (
+	defaultLinearAPI   = "https://api.linear.app/graphql"
+	defaultLinearLabel = "Bug"
+	linearPageSize     = 100
+)
+
+// linearPullRequestURL matches the URL of a PR attached to a Linear issue,
+// capturing its number
+var linearPullRequestURL = regexp.MustCompile(`/pull/(\d+)/?$`)
+
+// linearIssuesQuery selects a page of the issues of a team with a label,
+// updated since a time
+const linearIssuesQuery = `query Issues($filter: IssueFilter, $first: Int, $after: String) {
+  issues(filter: $filter, first: $first, after: $after) {
+    nodes { id identifier }
+    pageInfo { hasNextPage endCursor }
+  }
+}`
+
+// linearAttachmentsQuery selects the attachments of an issue
+const linearAttachmentsQuery = `query Attachments($id: String!) {
+  issue(id: $id) {
+    attachments { nodes { url sourceType metadata } }
+  }
+}`
+
+// linearTracker finds the bugs in the teams of Linear by their label and
+// their PRs in the attachments of the issues
+type linearTracker struct {
+	api    string
+	apiKey string
+	label  string
+}
+
+// linearIssues represents a page of the issues of a team
+type linearIssues struct {
+	Issues struct {
+		Nodes []struct {
+			ID         string `json:"id"`
+			Identifier string `json:"identifier"`
+		} `json:"nodes"`
+		PageInfo struct {
+			HasNextPage bool   `json:"hasNextPage"`
+			EndCursor   string `json:"endCursor"`
+		} `json:"pageInfo"`
+	} `json:"issues"`
+}
+
+// linearAttachments represents the attachments of an issue
+type linearAttachments struct {
+	Issue struct {
+		Attachments struct {
+			Nodes []struct {
+				URL        string `json:"url"`
+				SourceType string `json:"sourceType"`
+				Metadata   struct {
+					Status string `json:"status"`
+				} `json:"metadata"`
+			} `json:"nodes"`
+		} `json:"attachments"`
+	} `json:"issue"`
+}
+
+// linearResponse represents the envelope of a GraphQL response
+type linearResponse struct {
+	Data   json.RawMessage `json:"data"`
+	Errors []struct {
+		Message string `json:"message"`
+	} `json:"errors"`
+}
+
+func newLinearTracker() (*linearTracker, error) {
+	viper.SetDefault("linear.api", defaultLinearAPI)
+	viper.SetDefault("linear.label", defaultLinearLabel)
+
+	apiKey := viper.GetString("linear.api_key")
+	if apiKey == "" {
+		return nil, fmt.Errorf("linear.api_key is not set")
+	}
+
+	return &linearTracker{
+		api:    viper.GetString("linear.api"),
+		apiKey: apiKey,
+		label:  viper.GetString("linear.label"),
+	}, nil
+}
+
+// linearBug returns the bug of a Linear issue. The numbers of the issues
+// are only unique within their teams and change when an issue moves to
+// another team, so the bug is identified by the UUID of the issue.
+func linearBug(uuid, identifier string) bug {
+	return bug{Key: identifier, Tracker: "linear", UID: uuid}
+}
+
+// searchBugs pages through the issues of the team whose key is the
+// project, e.g. ENG, with the label of linear.label
+func (t *linearTracker) searchBugs(project string, since time.Time) (*[]bug, error) {
+	filter := t.filter(project)
+	if !since.IsZero() {
+		filter["updatedAt"] = map[string]string{"gte": since.UTC().Add(-watermarkOverlap).Format(time.RFC3339)}
+	}
+
+	bugs := make([]bug, 0)
+	after := ""
+	for {
+		vars := map[string]interface{}{"filter": filter, "first": linearPageSize}
+		if after != "" {
+			vars["after"] = after
+		}
+
+		page := &linearIssues{}
+		if err := t.query(context.Background(), linearIssuesQuery, vars, page); err != nil {
+			return nil, err
+		}
+		for _, issue := range page.Issues.Nodes {
+			bugs = append(bugs, linearBug(issue.ID, issue.Identifier))
+		}
+
+		if !page.Issues.PageInfo.HasNextPage {
+			break
+		}
+		after = page.Issues.PageInfo.EndCursor
+	}
+
+	slog.Info("bugs found", "project", project, "count", len(bugs))
+
+	return &bugs, nil
+}
+
+// findBug returns the issue of an identifier, e.g. ENG-123, if it's an
+// issue of the team of the project with the label of linear.label
+func (t *linearTracker) findBug(project, key string) (*bug, error) {
+	i := strings.LastIndex(key, "-")
+	if i < 0 || key[:i] != project {
+		return nil, nil
+	}
+	number, err := strconv.Atoi(key[i+1:])
+	if err != nil {
+		return nil, nil
+	}
+
+	filter := t.filter(project)
+	filter["number"] = map[string]int{"eq": number}
+	page := &linearIssues{}
+	if err := t.query(context.Background(), linearIssuesQuery, map[string]interface{}{"filter": filter, "first": 1}, page); err != nil {
+		return nil, err
+	}
+	if len(page.Issues.Nodes) == 0 {
+		return nil, nil
+	}
+	issue := page.Issues.Nodes[0]
+
+	b := linearBug(issue.ID, issue.Identifier)
+
+	return &b, nil
+}
+
+// mentions returns the identifiers of the issues mentioned in the text
+// of a PR, which are shaped like the keys of Jira
+func (t *linearTracker) mentions(text string) []string {
+	return issueKeyPattern.FindAllString(text, -1)
+}
+
+// filter returns the filter of the issues of the team of the project with
+// the label of linear.label
+func (t *linearTracker) filter(project string) map[string]interface{} {
+	return map[string]interface{}{
+		"team":   map[string]interface{}{"key": map[string]string{"eq": project}},
+		"labels": map[string]interface{}{"some": map[string]interface{}{"name": map[string]string{"eq": t.label}}},
+	}
+}
+
+// linkedPRs returns the PRs attached to the issue, as attached by the
+// GitHub integration of Linear or by hand. A PR whose state isn't in the
+// metadata of its attachment is taken as merged.
+func (t *linearTracker) linkedPRs(b bug) (*[]jiraPR, error) {
+	result := &linearAttachments{}
+	id := b.UID
+	if id == "" {
+		id = b.Key
+	}
+	if err := t.query(context.Background(), linearAttachmentsQuery, map[string]interface{}{"id": id}, result); err != nil {
+		return nil, err
+	}
+
+	prs := make([]jiraPR, 0)
+	for _, a := range result.Issue.Attachments.Nodes {
+		m := linearPullRequestURL.FindStringSubmatch(a.URL)
+		if m == nil {
+			continue
+		}
+
+		status := strings.ToUpper(a.Metadata.Status)
+		if status == "" {
+			status = "MERGED"
+		}
+		prs = append(prs, jiraPR{ID: "#" + m[1], Status: status, URL: strings.TrimSuffix(a.URL, "/")})
+	}
+
+	if len(prs) == 0 {
+		return nil, errNoDevStatus
+	}
+
+	return &prs, nil
+}
+
+// ping requests the user of the API key
+func (t *linearTracker) ping(ctx context.Context) error {
+	return t.query(ctx, `query { viewer { id } }`, nil, &struct{}{})
+}
+
+// query sends a GraphQL query to the Linear API and decodes the data of
+// the response into v
+func (t *linearTracker) query(ctx context.Context, query string, vars map[string]interface{}, v interface{}) error {
+	body, err := json.Marshal(map[string]interface{}{"query": query, "variables": vars})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", t.api, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	// A personal API key is sent as is, without a scheme
+	req.Header.Add("Authorization", t.apiKey)
+	req.Header.Add("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return responseError("Linear", resp)
+	}
+
+	result := &linearResponse{}
+	if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+		return err
+	}
+	if len(result.Errors) > 0 {
+		return fmt.Errorf("Linear: %s", result.Errors[0].Message)
+	}
+
+	return json.Unmarshal(result.Data, v)
+}
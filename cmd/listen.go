@@ -0,0 +1,351 @@
+package cmd
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"regexp"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// listenCmd represents the listen command
+var listenCmd = &cobra.Command{
+	Use:   "listen",
+	Short: "Updates the store from the Jira and GitHub webhooks",
+	Long: `Runs an HTTP server receiving the webhooks of Jira on
+/webhooks/jira and of GitHub on /webhooks/github.
+
+A created or updated bug of the projects is mapped right away and
+the diffs of its merged PRs are collected. A merged PR of GitHub
+maps the bugs whose keys appear in its title, branch or body, the
+keys of Jira and Linear or the AB#123 mentions of Azure Boards. The
+bugs are looked up in the tracker of tracker.type, and the ones
+which are mapped already are skipped like in backfill.
+
+The payloads are verified with the HMAC secrets listen.jira_secret
+and listen.github_secret, which are required unless --insecure is
+given, e.g. behind a gateway verifying them.
+
+A verified webhook is acknowledged with 202 Accepted right away and
+queued; the events are applied one at a time in the order they came
+in. A webhook arriving while listen.queue_size (default 100) events
+are waiting is refused with 503, for the sender to retry it. On
+SIGTERM or SIGINT the current event is finished and the queued ones
+are dropped; the next backfill maps their bugs.`,
+	RunE: listen,
+}
+
+const (
+	defaultListenAddr      = ":8080"
+	defaultListenQueueSize = 100
+	listenEventTimeout     = 5 * time.Minute
+	maxWebhookSize         = 5 << 20
+)
+
+var (
+	listenAddr     string
+	listenInsecure bool
+)
+
+// issueKeyPattern matches the keys of the Jira issues
+var issueKeyPattern = regexp.MustCompile(`\b[A-Z][A-Z0-9_]+-[0-9]+\b`)
+
+func init() {
+	rootCmd.AddCommand(listenCmd)
+	listenCmd.Flags().StringVar(&listenAddr, "addr", defaultListenAddr, "address to listen on")
+	listenCmd.Flags().BoolVar(&listenInsecure, "insecure", false, "accept the webhooks without verifying their signatures if the secrets aren't set")
+	listenCmd.Flags().StringVarP(&jiraProject, "project", "p", "Memberships", "Jira project name or a comma separated list of names")
+	listenCmd.Flags().StringVar(&jiraJQL, "jql", "", fmt.Sprintf("JQL selecting the issues of a project (default is jira.jql or %q)", defaultJiraJQL))
+}
+
+// listener applies the webhook events of its queue to the store one at
+// a time
+type listener struct {
+	st       store
+	tracker  issueTracker
+	provider vcsProvider
+	projects []string
+	queue    chan webhookEvent
+}
+
+// webhookEvent represents a verified webhook waiting in the queue
+type webhookEvent struct {
+	path   string
+	header http.Header
+	body   []byte
+	handle func(context.Context, http.Header, []byte) error
+}
+
+// jiraWebhook represents the payload of the issue events of Jira
+type jiraWebhook struct {
+	WebhookEvent string `json:"webhookEvent"`
+	Issue        bug    `json:"issue"`
+}
+
+// pullRequestWebhook represents the payload of the pull_request events of GitHub
+type pullRequestWebhook struct {
+	Action      string `json:"action"`
+	PullRequest struct {
+		Merged bool   `json:"merged"`
+		Title  string `json:"title"`
+		Body   string `json:"body"`
+		Head   struct {
+			Ref string `json:"ref"`
+		} `json:"head"`
+	} `json:"pull_request"`
+}
+
+func listen(cmd *cobra.Command, args []string) error {
+	if !listenInsecure {
+		for _, key := range []string{"listen.jira_secret", "listen.github_secret"} {
+			if viper.GetString(key) == "" {
+				return configError(fmt.Errorf("%s is not set, set it or accept unsigned webhooks with --insecure", key))
+			}
+		}
+	}
+	viper.SetDefault("listen.queue_size", defaultListenQueueSize)
+	size := viper.GetInt("listen.queue_size")
+	if size <= 0 {
+		return configError(fmt.Errorf("invalid listen.queue_size %d", size))
+	}
+
+	ctx, cancel, st, err := openStore()
+	if err != nil {
+		return err
+	}
+	defer cancel()
+	defer closeStore(context.Background(), st)
+
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	provider, err := newVCSProvider(ctx)
+	if err != nil {
+		return configError(err)
+	}
+	tracker, err := newIssueTracker(provider)
+	if err != nil {
+		return configError(err)
+	}
+
+	l := &listener{st: st, tracker: tracker, provider: provider, projects: backfillProjects(cmd), queue: make(chan webhookEvent, size)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/webhooks/jira", l.webhook("listen.jira_secret", "X-Hub-Signature", l.jiraEvent))
+	mux.HandleFunc("/webhooks/github", l.webhook("listen.github_secret", "X-Hub-Signature-256", l.githubEvent))
+
+	srv := &http.Server{Addr: listenAddr, Handler: mux}
+	go func() {
+		<-ctx.Done()
+		shutdown, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdown)
+	}()
+
+	done := make(chan struct{})
+	go func() {
+		l.work(ctx)
+		close(done)
+	}()
+
+	slog.Info("listening", "addr", listenAddr, "projects", strings.Join(l.projects, ","))
+	err = srv.ListenAndServe()
+	stop()
+	<-done
+	if dropped := len(l.queue); dropped > 0 {
+		slog.Warn("queued webhooks dropped", "events", dropped)
+	}
+	if !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	return nil
+}
+
+// work applies the queued events until ctx is done
+func (l *listener) work(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case e := <-l.queue:
+			start := time.Now()
+			eventCtx, cancel := context.WithTimeout(context.Background(), listenEventTimeout)
+			err := e.handle(eventCtx, e.header, e.body)
+			cancel()
+			if err != nil {
+				slog.Error("webhook failed", "path", e.path, "err", err)
+				continue
+			}
+			slog.Debug("webhook applied", "path", e.path, "elapsed", time.Since(start).Round(time.Millisecond))
+		}
+	}
+}
+
+// webhook verifies the signature of the payload in the given header with
+// the secret of the config key and queues the payload for handle
+func (l *listener) webhook(secretKey, header string, handle func(context.Context, http.Header, []byte) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxWebhookSize))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if secret := viper.GetString(secretKey); secret != "" && !validSignature(secret, r.Header.Get(header), body) {
+			slog.Warn("webhook rejected: invalid signature", "path", r.URL.Path)
+			http.Error(w, "invalid signature", http.StatusUnauthorized)
+			return
+		}
+
+		select {
+		case l.queue <- webhookEvent{path: r.URL.Path, header: r.Header.Clone(), body: body, handle: handle}:
+			w.WriteHeader(http.StatusAccepted)
+		default:
+			slog.Warn("webhook refused: queue full", "path", r.URL.Path, "queued", len(l.queue))
+			w.Header().Set("Retry-After", "60")
+			http.Error(w, "queue full", http.StatusServiceUnavailable)
+		}
+	}
+}
+
+// validSignature checks a sha256=<hex HMAC> signature of the payload
+func validSignature(secret, signature string, body []byte) bool {
+	sum, err := hex.DecodeString(strings.TrimPrefix(signature, "sha256="))
+	if err != nil {
+		return false
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+
+	return hmac.Equal(sum, mac.Sum(nil))
+}
+
+func (l *listener) jiraEvent(ctx context.Context, header http.Header, body []byte) error {
+	e := jiraWebhook{}
+	if err := json.Unmarshal(body, &e); err != nil {
+		return err
+	}
+	if e.WebhookEvent != "jira:issue_created" && e.WebhookEvent != "jira:issue_updated" {
+		return nil
+	}
+
+	return l.update(ctx, []string{e.Issue.Key})
+}
+
+func (l *listener) githubEvent(ctx context.Context, header http.Header, body []byte) error {
+	if header.Get("X-GitHub-Event") != "pull_request" {
+		return nil
+	}
+
+	e := pullRequestWebhook{}
+	if err := json.Unmarshal(body, &e); err != nil {
+		return err
+	}
+	if e.Action != "closed" || !e.PullRequest.Merged {
+		return nil
+	}
+
+	text := strings.Join([]string{e.PullRequest.Title, e.PullRequest.Head.Ref, e.PullRequest.Body}, "\n")
+
+	return l.update(ctx, l.tracker.mentions(text))
+}
+
+// update maps the bugs of the keys and collects the diffs of their PRs
+func (l *listener) update(ctx context.Context, keys []string) error {
+	mapped, err := l.st.MappedIssues(ctx)
+	if err != nil {
+		return storageError(fmt.Errorf("reading mapped issues failed: %w", err))
+	}
+
+	total := 0
+	seen := make(map[string]bool)
+	for _, key := range keys {
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		n, err := l.mapIssue(ctx, key, mapped)
+		if err != nil {
+			return fmt.Errorf("issue %s: %w", key, err)
+		}
+		total += n
+	}
+	if total == 0 {
+		return nil
+	}
+
+	_, err = runCollectDiffs(ctx, l.st)
+
+	return err
+}
+
+// mapIssue maps the bug of the key if it's one of the bugs of the
+// projects and it isn't mapped yet. It returns the number of the new
+// mappings.
+func (l *listener) mapIssue(ctx context.Context, key string, mapped map[string]bool) (int, error) {
+	for _, project := range l.projects {
+		found, err := l.tracker.findBug(project, key)
+		if err != nil {
+			return 0, jiraError(err)
+		}
+		if found == nil {
+			continue
+		}
+
+		b := *found
+		if _, ok := mapped[b.ref()]; ok {
+			slog.Debug("issue already mapped", "key", key)
+			return 0, nil
+		}
+
+		// A new bug usually has no PRs yet, it's mapped by the webhook
+		// of the PR which mentions it
+		prs, err := l.tracker.linkedPRs(b)
+		if errors.Is(err, errNoDevStatus) {
+			slog.Debug("issue has no PRs yet", "key", key)
+			return 0, nil
+		}
+		if err != nil {
+			return 0, jiraError(err)
+		}
+
+		byRef := map[string]bug{b.ref(): b}
+		mappings := convertJiraMappingsToMongoMappings(map[string]*[]jiraPR{b.ref(): prs}, byRef, l.provider, project)
+		setServiceDeskContext(*mappings, byRef)
+		setIssueMetadata(*mappings, byRef)
+		if len(*mappings) == 0 {
+			return 0, nil
+		}
+
+		if err := l.st.InsertMappings(ctx, *mappings); err != nil {
+			return 0, storageError(fmt.Errorf("writing mappings failed: %w", err))
+		}
+		savePayloads(ctx, l.st)
+		mapped[b.ref()] = false
+		slog.Info("issue mapped", "key", key, "project", project, "mappings", len(*mappings))
+
+		return len(*mappings), nil
+	}
+
+	return 0, nil
+}
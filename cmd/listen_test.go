@@ -0,0 +1,88 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// stubTracker serves the bugs of a single project from memory
+type stubTracker struct {
+	bugs   map[string]bug
+	linked map[string]error
+}
+
+func (t stubTracker) searchBugs(project string, since time.Time) (*[]bug, error) {
+	bugs := make([]bug, 0, len(t.bugs))
+	for _, b := range t.bugs {
+		bugs = append(bugs, b)
+	}
+
+	return &bugs, nil
+}
+
+func (t stubTracker) linkedPRs(b bug) (*[]jiraPR, error) {
+	if err := t.linked[b.Key]; err != nil {
+		return nil, err
+	}
+
+	return &[]jiraPR{}, nil
+}
+
+func (t stubTracker) findBug(project, key string) (*bug, error) {
+	if b, ok := t.bugs[key]; ok {
+		return &b, nil
+	}
+
+	return nil, nil
+}
+
+func (t stubTracker) mentions(text string) []string {
+	return nil
+}
+
+func TestListenerMapIssue(t *testing.T) {
+	errDown := errors.New("dev status unavailable")
+	tracker := stubTracker{
+		bugs: map[string]bug{
+			"PAY-1": {ID: 1, Key: "PAY-1"},
+			"PAY-2": {ID: 2, Key: "PAY-2"},
+		},
+		linked: map[string]error{
+			"PAY-1": errNoDevStatus,
+			"PAY-2": errDown,
+		},
+	}
+
+	for _, tt := range []struct {
+		name string
+		key  string
+		err  error
+	}{
+		{"bug without PRs", "PAY-1", nil},
+		{"failed dev status", "PAY-2", errDown},
+		{"not a bug of the project", "OPS-1", nil},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			st := newMemoryStore()
+			l := &listener{st: st, tracker: tracker, projects: []string{"PAY"}}
+
+			n, err := l.mapIssue(context.Background(), tt.key, map[string]bool{})
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("mapIssue(%s) = %v, want %v", tt.key, err, tt.err)
+			}
+			if n != 0 {
+				t.Errorf("mapIssue(%s) mapped %d mappings, want none", tt.key, n)
+			}
+
+			mappings, err := st.Mappings(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(mappings) != 0 {
+				t.Errorf("%d mappings stored, want none", len(mappings))
+			}
+		})
+	}
+}
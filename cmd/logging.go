@@ -0,0 +1,52 @@
+package cmd
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+)
+
+var (
+	verbose   bool
+	quiet     bool
+	logFormat string
+)
+
+// setupLogging installs the default logger of the --verbose, --quiet and
+// --log-format flags. The logs go to stderr, so stdout only carries the
+// output of the commands.
+func setupLogging() error {
+	level := slog.LevelInfo
+	switch {
+	case verbose && quiet:
+		return configError(fmt.Errorf("--verbose and --quiet can't be used together"))
+	case verbose:
+		level = slog.LevelDebug
+	case quiet:
+		level = slog.LevelWarn
+	}
+
+	opts := &slog.HandlerOptions{Level: level}
+	switch logFormat {
+	case "text":
+		slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, opts)))
+	case "json":
+		slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stderr, opts)))
+	default:
+		return configError(fmt.Errorf("unknown log format %q", logFormat))
+	}
+
+	return nil
+}
+
+// interactive tells whether the progress lines can be drawn: they are
+// meant for a person watching a terminal, not for a log collector
+func interactive() bool {
+	if quiet || logFormat == "json" {
+		return false
+	}
+
+	fi, err := os.Stderr.Stat()
+
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
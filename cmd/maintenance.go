@@ -0,0 +1,144 @@
+package cmd
+
+import (
+	"fmt"
+	"log/slog"
+
+	"github.com/spf13/cobra"
+)
+
+// purgeCmd represents the purge command
+var purgeCmd = &cobra.Command{
+	Use:   "purge",
+	Short: "Removes the data of a project",
+	Long: `Removes the mappings and the watermark of the project, the
+diffs of the PRs which no other project maps to and the raw payloads
+of its issues and of those PRs. The next backfill of the project
+starts from scratch.`,
+	RunE: purge,
+}
+
+// resetCmd represents the reset command
+var resetCmd = &cobra.Command{
+	Use:   "reset",
+	Short: "Empties a collection of the store",
+	Long: `Drops a collection of the store and recreates it empty, with
+its indexes. The collections are mappings (or jira), prs (or
+github), sync, reports, archive, repos, annotations and payloads.`,
+	RunE: reset,
+}
+
+// refilterCmd represents the refilter command
+var refilterCmd = &cobra.Command{
+	Use:   "refilter",
+	Short: "Applies the diff filter to the stored diffs",
+	Long: `Drops the files excluded by diffs.include and diffs.exclude from
+the stored diffs of the PRs, so the data collected before the
+patterns were set or changed is filtered too. The totals of the
+PRs are kept. The dropped files can only be restored by resetting
+the prs collection and collecting the diffs again.`,
+	RunE: refilter,
+}
+
+var (
+	purgeProject    string
+	resetCollection string
+	confirmed       bool
+)
+
+func init() {
+	rootCmd.AddCommand(purgeCmd)
+	purgeCmd.Flags().StringVarP(&purgeProject, "project", "p", "", "Jira project to remove")
+	purgeCmd.Flags().BoolVar(&confirmed, "yes", false, "confirm the removal")
+	purgeCmd.MarkFlagRequired("project")
+
+	rootCmd.AddCommand(resetCmd)
+	resetCmd.Flags().StringVar(&resetCollection, "collection", "", "collection to empty")
+	resetCmd.Flags().BoolVar(&confirmed, "yes", false, "confirm the removal")
+	resetCmd.MarkFlagRequired("collection")
+
+	rootCmd.AddCommand(refilterCmd)
+	refilterCmd.Flags().BoolVar(&confirmed, "yes", false, "confirm the removal")
+}
+
+func purge(cmd *cobra.Command, args []string) error {
+	if !confirmed {
+		return configError(fmt.Errorf("purging project %s removes its data for good, confirm with --yes", purgeProject))
+	}
+
+	ctx, cancel, st, err := openStore()
+	if err != nil {
+		return err
+	}
+	defer cancel()
+	defer closeStore(ctx, st)
+
+	mappings, prs, err := st.PurgeProject(ctx, purgeProject)
+	if err != nil {
+		return storageError(fmt.Errorf("purging project %s failed: %w", purgeProject, err))
+	}
+	slog.Info("project purged", "project", purgeProject, "mappings", mappings, "prs", prs)
+
+	return nil
+}
+
+func reset(cmd *cobra.Command, args []string) error {
+	collection, err := storeCollection(resetCollection)
+	if err != nil {
+		return configError(err)
+	}
+	if !confirmed {
+		return configError(fmt.Errorf("resetting %s removes all of its data, confirm with --yes", collection))
+	}
+
+	ctx, cancel, st, err := openStore()
+	if err != nil {
+		return err
+	}
+	defer cancel()
+	defer closeStore(ctx, st)
+
+	if err := st.Reset(ctx, collection); err != nil {
+		return storageError(fmt.Errorf("resetting %s failed: %w", collection, err))
+	}
+	slog.Info("collection reset", "collection", collection)
+
+	return nil
+}
+
+func refilter(cmd *cobra.Command, args []string) error {
+	filter := diffFilter()
+	if filter.empty() {
+		return configError(fmt.Errorf("neither diffs.include nor diffs.exclude is set"))
+	}
+	if !confirmed {
+		return configError(fmt.Errorf("refiltering removes the excluded files from the stored diffs for good, confirm with --yes"))
+	}
+
+	ctx, cancel, st, err := openStore()
+	if err != nil {
+		return err
+	}
+	defer cancel()
+	defer closeStore(ctx, st)
+
+	prs, err := st.PRs(ctx)
+	if err != nil {
+		return storageError(fmt.Errorf("reading diffs failed: %w", err))
+	}
+
+	changed := make([]pr, 0)
+	for _, p := range prs {
+		if filter.apply(&p) {
+			changed = append(changed, p)
+		}
+	}
+	if len(changed) > 0 {
+		if err := st.InsertPRs(ctx, changed); err != nil {
+			return storageError(fmt.Errorf("writing diffs failed: %w", err))
+		}
+	}
+	slog.Info("diffs refiltered", "prs", len(prs), "changed", len(changed))
+
+	return nil
+}
@@ -0,0 +1,228 @@
+package cmd
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// manifest represents the planned work items of a run. Its items are
+// written before the run starts and every completed item is appended to
+// a journal, so an interrupted run can be resumed without redoing the
+// completed items or skipping the remaining ones.
+type manifest struct {
+	Command string          `json:"command"`
+	Scope   string          `json:"scope"`
+	Created time.Time       `json:"created"`
+	Items   []*manifestItem `json:"items"`
+
+	mu      sync.Mutex
+	byKey   map[string]*manifestItem
+	journal *os.File
+}
+
+// manifestItem represents a single work item, e.g. an issue to check
+type manifestItem struct {
+	Key    string          `json:"key"`
+	Data   json.RawMessage `json:"data"`
+	Done   bool            `json:"done"`
+	Result json.RawMessage `json:"result,omitempty"`
+}
+
+// journalEntry represents a completed work item in the journal
+type journalEntry struct {
+	Key    string          `json:"key"`
+	Result json.RawMessage `json:"result,omitempty"`
+}
+
+// errNoManifest is returned when there is no run to resume
+var errNoManifest = errors.New("no manifest to resume")
+
+// unsafeFileChars matches the characters of a scope which are replaced
+// in the name of the manifest file
+var unsafeFileChars = regexp.MustCompile(`[^A-Za-z0-9_-]+`)
+
+func manifestPath(command, scope string) string {
+	viper.SetDefault("manifest.dir", ".")
+	name := command
+	if scope != "" {
+		name += "-" + unsafeFileChars.ReplaceAllString(scope, "_")
+	}
+
+	return filepath.Join(viper.GetString("manifest.dir"), fmt.Sprintf(".heatmap-%s.manifest.json", name))
+}
+
+func journalPath(command, scope string) string {
+	return manifestPath(command, scope) + ".journal"
+}
+
+// newManifest creates the manifest of a new run
+func newManifest(command, scope string) *manifest {
+	return &manifest{
+		Command: command,
+		Scope:   scope,
+		Created: time.Now(),
+		Items:   make([]*manifestItem, 0),
+		byKey:   make(map[string]*manifestItem),
+	}
+}
+
+// add plans a work item, unless an item with the same key is already planned
+func (m *manifest) add(key string, data interface{}) error {
+	if _, ok := m.byKey[key]; ok {
+		return nil
+	}
+
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	item := &manifestItem{Key: key, Data: raw}
+	m.Items = append(m.Items, item)
+	m.byKey[key] = item
+
+	return nil
+}
+
+// start writes the manifest, replacing any previous one, and opens a new journal
+func (m *manifest) start() error {
+	raw, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	path := manifestPath(m.Command, m.Scope)
+	if err := os.WriteFile(path+".tmp", raw, 0o600); err != nil {
+		return err
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return err
+	}
+
+	m.journal, err = os.Create(journalPath(m.Command, m.Scope))
+
+	return err
+}
+
+// loadManifest reads the manifest of an interrupted run together with
+// its journal and reopens the journal for appending
+func loadManifest(command, scope string) (*manifest, error) {
+	raw, err := os.ReadFile(manifestPath(command, scope))
+	if os.IsNotExist(err) {
+		return nil, errNoManifest
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	m := &manifest{byKey: make(map[string]*manifestItem)}
+	if err := json.Unmarshal(raw, m); err != nil {
+		return nil, fmt.Errorf("corrupted manifest %s: %w", manifestPath(command, scope), err)
+	}
+	for _, item := range m.Items {
+		m.byKey[item.Key] = item
+	}
+
+	m.journal, err = os.OpenFile(journalPath(command, scope), os.O_CREATE|os.O_RDWR|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, err
+	}
+
+	// Stop at the first line cut by the interruption and drop it, so the
+	// new entries are appended after the last completed item
+	var offset int64
+	reader := bufio.NewReader(m.journal)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err != nil {
+			break
+		}
+
+		e := &journalEntry{}
+		if err := json.Unmarshal(line, e); err != nil {
+			break
+		}
+		if item, ok := m.byKey[e.Key]; ok {
+			item.Done = true
+			item.Result = e.Result
+		}
+		offset += int64(len(line))
+	}
+
+	if err := m.journal.Truncate(offset); err != nil {
+		m.journal.Close()
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// pending returns the items which are not done yet
+func (m *manifest) pending() []*manifestItem {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	items := make([]*manifestItem, 0)
+	for _, item := range m.Items {
+		if !item.Done {
+			items = append(items, item)
+		}
+	}
+
+	return items
+}
+
+// markDone records the result of a completed item in the journal
+func (m *manifest) markDone(key string, result interface{}) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	item, ok := m.byKey[key]
+	if !ok {
+		return fmt.Errorf("unknown manifest item %s", key)
+	}
+
+	raw, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+
+	line, err := json.Marshal(journalEntry{Key: key, Result: raw})
+	if err != nil {
+		return err
+	}
+	if _, err := m.journal.Write(append(line, '\n')); err != nil {
+		return err
+	}
+
+	item.Done = true
+	item.Result = raw
+
+	return nil
+}
+
+// remove deletes the manifest of a completed run
+func (m *manifest) remove() error {
+	m.journal.Close()
+	if err := os.Remove(journalPath(m.Command, m.Scope)); err != nil {
+		return err
+	}
+
+	return os.Remove(manifestPath(m.Command, m.Scope))
+}
+
+// close releases the journal of an interrupted run, keeping the manifest
+func (m *manifest) close() {
+	if m.journal != nil {
+		m.journal.Close()
+	}
+}
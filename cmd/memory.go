@@ -0,0 +1,492 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var ephemeral bool
+
+// memoryStore keeps the data in the memory of the process, so nothing
+// outlives the run. It serves the --ephemeral runs, which export their
+// results directly.
+type memoryStore struct {
+	mu   sync.Mutex
+	data memoryData
+}
+
+// memoryData holds the collections of the memory store
+type memoryData struct {
+	mappings    []mongoMapping
+	prs         []pr
+	watermarks  map[string]time.Time
+	reports     []heatReport
+	archive     []archivedDoc
+	repos       []repoMeta
+	annotations []annotation
+	payloads    []rawPayload
+}
+
+func openMemoryStore() (context.Context, context.CancelFunc, store, error) {
+	ctx, cancel := commandContext()
+
+	return ctx, cancel, newMemoryStore(), nil
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{data: memoryData{watermarks: make(map[string]time.Time)}}
+}
+
+// snapshot returns a copy of the data which the later writes don't change
+func (s *memoryStore) snapshot() memoryData {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.data.copy()
+}
+
+// restore replaces the data with a snapshot
+func (s *memoryStore) restore(d memoryData) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.data = d.copy()
+}
+
+func (d memoryData) copy() memoryData {
+	c := memoryData{
+		mappings:    append([]mongoMapping(nil), d.mappings...),
+		prs:         append([]pr(nil), d.prs...),
+		watermarks:  make(map[string]time.Time, len(d.watermarks)),
+		reports:     append([]heatReport(nil), d.reports...),
+		archive:     append([]archivedDoc(nil), d.archive...),
+		repos:       append([]repoMeta(nil), d.repos...),
+		annotations: append([]annotation(nil), d.annotations...),
+		payloads:    append([]rawPayload(nil), d.payloads...),
+	}
+	for k, v := range d.watermarks {
+		c.watermarks[k] = v
+	}
+
+	return c
+}
+
+func (s *memoryStore) Close(ctx context.Context) error {
+	return nil
+}
+
+func (s *memoryStore) MappedIssues(ctx context.Context) (map[string]bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	refs := make(map[string]bool)
+	for _, m := range s.data.mappings {
+		refs[m.issueRef()] = false
+	}
+
+	return refs, nil
+}
+
+func (s *memoryStore) InsertMappings(ctx context.Context, mappings []mongoMapping) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// The mappings are upserted like by the other backends
+	index := make(map[string]int, len(s.data.mappings))
+	for i, m := range s.data.mappings {
+		index[mappingKey(m)] = i
+	}
+	for _, m := range mappings {
+		if i, ok := index[mappingKey(m)]; ok {
+			s.data.mappings[i] = m
+			continue
+		}
+		index[mappingKey(m)] = len(s.data.mappings)
+		s.data.mappings = append(s.data.mappings, m)
+	}
+
+	return nil
+}
+
+// mappingKey identifies a mapping of a bug of a project and a PR
+func mappingKey(m mongoMapping) string {
+	return fmt.Sprintf("%s/%s/%s", m.Project, m.issueRef(), prKey(m.Repo, m.PRID))
+}
+
+func (s *memoryStore) Mappings(ctx context.Context) ([]mongoMapping, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append(make([]mongoMapping, 0, len(s.data.mappings)), s.data.mappings...), nil
+}
+
+func (s *memoryStore) Watermark(ctx context.Context, project string) (time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.data.watermarks[project], nil
+}
+
+func (s *memoryStore) SetWatermark(ctx context.Context, project string, t time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.data.watermarks[project] = t
+
+	return nil
+}
+
+func (s *memoryStore) NotAnalyzedPRs(ctx context.Context, fn func(p pr) error) error {
+	s.mu.Lock()
+	analyzed := make(map[string]bool)
+	for _, p := range s.data.prs {
+		analyzed[prKey(p.Repo, p.PRID)] = true
+	}
+
+	prs := make([]pr, 0)
+	for _, m := range s.data.mappings {
+		k := prKey(m.Repo, m.PRID)
+		if analyzed[k] {
+			continue
+		}
+		analyzed[k] = true
+		prs = append(prs, pr{Repo: m.Repo, PRID: m.PRID})
+	}
+	// fn is called unlocked, it may use the store
+	s.mu.Unlock()
+
+	for _, p := range prs {
+		if err := fn(p); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *memoryStore) InsertPRs(ctx context.Context, prs []pr) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	index := make(map[string]int, len(s.data.prs))
+	for i, p := range s.data.prs {
+		index[prKey(p.Repo, p.PRID)] = i
+	}
+	for _, p := range prs {
+		if i, ok := index[prKey(p.Repo, p.PRID)]; ok {
+			s.data.prs[i] = p
+			continue
+		}
+		index[prKey(p.Repo, p.PRID)] = len(s.data.prs)
+		s.data.prs = append(s.data.prs, p)
+	}
+
+	return nil
+}
+
+func (s *memoryStore) PRs(ctx context.Context) ([]pr, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append(make([]pr, 0, len(s.data.prs)), s.data.prs...), nil
+}
+
+func (s *memoryStore) SaveRepos(ctx context.Context, repos []repoMeta) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	index := make(map[Repo]int, len(s.data.repos))
+	for i, r := range s.data.repos {
+		index[r.Repo] = i
+	}
+	for _, r := range repos {
+		if i, ok := index[r.Repo]; ok {
+			s.data.repos[i] = r
+			continue
+		}
+		index[r.Repo] = len(s.data.repos)
+		s.data.repos = append(s.data.repos, r)
+	}
+
+	return nil
+}
+
+func (s *memoryStore) Repos(ctx context.Context) ([]repoMeta, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append(make([]repoMeta, 0, len(s.data.repos)), s.data.repos...), nil
+}
+
+func (s *memoryStore) ReplaceAnnotations(ctx context.Context, annotations []annotation) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.data.annotations = append([]annotation(nil), annotations...)
+
+	return nil
+}
+
+func (s *memoryStore) Annotations(ctx context.Context) ([]annotation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append(make([]annotation, 0, len(s.data.annotations)), s.data.annotations...), nil
+}
+
+func (s *memoryStore) SavePayloads(ctx context.Context, payloads []rawPayload) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.data.payloads = append(unexpiredPayloads(s.data.payloads, time.Now()), payloads...)
+
+	return nil
+}
+
+func (s *memoryStore) Payloads(ctx context.Context, key string) ([]rawPayload, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	payloads := make([]rawPayload, 0)
+	for _, p := range unexpiredPayloads(s.data.payloads, time.Now()) {
+		if p.Key == key {
+			payloads = append(payloads, p)
+		}
+	}
+
+	return payloads, nil
+}
+
+func (s *memoryStore) SaveReport(ctx context.Context, r heatReport) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.data.reports = append(s.data.reports, r)
+	sort.SliceStable(s.data.reports, func(i, j int) bool {
+		return s.data.reports[i].Created.Before(s.data.reports[j].Created)
+	})
+
+	return nil
+}
+
+func (s *memoryStore) LatestReport(ctx context.Context) (*heatReport, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.data.reports) == 0 {
+		return nil, nil
+	}
+	r := s.data.reports[len(s.data.reports)-1]
+
+	return &r, nil
+}
+
+func (s *memoryStore) PurgeProject(ctx context.Context, project string) (int, int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	kept := make([]mongoMapping, 0, len(s.data.mappings))
+	purged := make(map[string]bool)
+	left := make(map[string]bool)
+	payloadKeys := make(map[string]bool)
+	for _, m := range s.data.mappings {
+		if m.Project == project {
+			purged[prKey(m.Repo, m.PRID)] = true
+			if m.Tracker == "" {
+				payloadKeys[strconv.Itoa(m.IssueID)] = true
+			}
+			continue
+		}
+		left[prKey(m.Repo, m.PRID)] = true
+		kept = append(kept, m)
+	}
+	mappings := len(s.data.mappings) - len(kept)
+	s.data.mappings = kept
+
+	prs := make([]pr, 0, len(s.data.prs))
+	for _, p := range s.data.prs {
+		if k := prKey(p.Repo, p.PRID); purged[k] && !left[k] {
+			payloadKeys[k] = true
+			continue
+		}
+		prs = append(prs, p)
+	}
+	removed := len(s.data.prs) - len(prs)
+	s.data.prs = prs
+
+	payloads := make([]rawPayload, 0, len(s.data.payloads))
+	for _, p := range s.data.payloads {
+		if !payloadKeys[p.Key] {
+			payloads = append(payloads, p)
+		}
+	}
+	s.data.payloads = payloads
+
+	delete(s.data.watermarks, project)
+
+	return mappings, removed, nil
+}
+
+func (s *memoryStore) Prune(ctx context.Context, mappings []mongoMapping, prs []pr, archive bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if archive {
+		s.data.archive = append(s.data.archive, archivedDocs(mappings, prs, time.Now().UTC())...)
+	}
+
+	pruned := make(map[string]bool, len(mappings))
+	for _, m := range mappings {
+		pruned[mappingKey(m)] = true
+	}
+	kept := make([]mongoMapping, 0, len(s.data.mappings))
+	for _, m := range s.data.mappings {
+		if !pruned[mappingKey(m)] {
+			kept = append(kept, m)
+		}
+	}
+	s.data.mappings = kept
+
+	prunedPRs := make(map[string]bool, len(prs))
+	for _, p := range prs {
+		prunedPRs[prKey(p.Repo, p.PRID)] = true
+	}
+	keptPRs := make([]pr, 0, len(s.data.prs))
+	for _, p := range s.data.prs {
+		if !prunedPRs[prKey(p.Repo, p.PRID)] {
+			keptPRs = append(keptPRs, p)
+		}
+	}
+	s.data.prs = keptPRs
+
+	return nil
+}
+
+func (s *memoryStore) ScrubArchive(ctx context.Context, fn func(p *pr) bool) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := 0
+	for _, a := range s.data.archive {
+		if a.PR != nil && fn(a.PR) {
+			n++
+		}
+	}
+
+	return n, nil
+}
+
+// Export resumes after the index of the token
+func (s *memoryStore) Export(ctx context.Context, collection, after string, fn func(doc []byte, token string) error) error {
+	from := 0
+	if after != "" {
+		var err error
+		if from, err = strconv.Atoi(after); err != nil {
+			return fmt.Errorf("invalid resume token %q", after)
+		}
+	}
+
+	data := s.snapshot()
+	docs := make([]interface{}, 0)
+	switch collection {
+	case "mappings":
+		for _, m := range data.mappings {
+			docs = append(docs, m)
+		}
+	case "prs":
+		for _, p := range data.prs {
+			docs = append(docs, p)
+		}
+	case "sync":
+		projects := make([]string, 0, len(data.watermarks))
+		for p := range data.watermarks {
+			projects = append(projects, p)
+		}
+		sort.Strings(projects)
+		for _, p := range projects {
+			docs = append(docs, syncState{Project: p, LastSync: data.watermarks[p]})
+		}
+	case "reports":
+		for _, r := range data.reports {
+			docs = append(docs, r)
+		}
+	case "archive":
+		for _, a := range data.archive {
+			docs = append(docs, a)
+		}
+	case "repos":
+		for _, r := range data.repos {
+			docs = append(docs, r)
+		}
+	case "annotations":
+		for _, a := range data.annotations {
+			docs = append(docs, a)
+		}
+	case "payloads":
+		for _, p := range data.payloads {
+			docs = append(docs, p)
+		}
+	}
+
+	for i := from; i < len(docs); i++ {
+		doc, err := json.Marshal(docs[i])
+		if err != nil {
+			return err
+		}
+		if err := fn(doc, strconv.Itoa(i+1)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Stats counts the sizes of the documents as JSON
+func (s *memoryStore) Stats(ctx context.Context) ([]collectionStats, error) {
+	stats := make([]collectionStats, 0, len(storeCollections))
+	for _, c := range storeCollections {
+		cs := collectionStats{Collection: c}
+		err := s.Export(ctx, c, "", func(doc []byte, token string) error {
+			cs.Docs++
+			cs.Bytes += int64(len(doc))
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		stats = append(stats, cs)
+	}
+
+	return stats, nil
+}
+
+func (s *memoryStore) Reset(ctx context.Context, collection string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch collection {
+	case "mappings":
+		s.data.mappings = nil
+	case "prs":
+		s.data.prs = nil
+	case "sync":
+		s.data.watermarks = make(map[string]time.Time)
+	case "reports":
+		s.data.reports = nil
+	case "archive":
+		s.data.archive = nil
+	case "repos":
+		s.data.repos = nil
+	case "annotations":
+		s.data.annotations = nil
+	case "payloads":
+		s.data.payloads = nil
+	}
+
+	return nil
+}
@@ -0,0 +1,198 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// metricsCmd represents the metrics command
+var metricsCmd = &cobra.Command{
+	Use:   "metrics",
+	Short: "Exposes the heat as Prometheus metrics",
+	Long: `Runs an HTTP server exposing the heat of the hottest files and
+the last syncs of the projects on /metrics in the Prometheus text
+format, so they can be plotted and alerted on in Grafana.
+
+The heat is computed from the store on every scrape:
+
+  heatmap_file_bug_count{repo,path}   distinct bugs touching the file
+  heatmap_file_score{repo,path}       heat score of the file
+  heatmap_file_risk{repo,path}        risk index of the file
+  heatmap_last_sync_timestamp{project} start of the last backfill
+
+The storage is exposed too, the growth being estimated from the
+stats recorded by sync like by status:
+
+  heatmap_store_documents{collection}       documents of the collection
+  heatmap_store_bytes{collection}           size of the collection
+  heatmap_store_growth_documents_per_day{collection}
+  heatmap_store_growth_bytes_per_day{collection}`,
+	RunE: metrics,
+}
+
+const (
+	defaultMetricsAddr   = ":9090"
+	metricsScrapeTimeout = time.Minute
+)
+
+var (
+	metricsAddr string
+	metricsTop  int
+)
+
+func init() {
+	rootCmd.AddCommand(metricsCmd)
+	metricsCmd.Flags().StringVar(&metricsAddr, "addr", defaultMetricsAddr, "address to listen on")
+	metricsCmd.Flags().IntVar(&metricsTop, "top", defaultReportTop, "number of files to expose (0 exposes all)")
+	metricsCmd.Flags().StringVarP(&jiraProject, "project", "p", "Memberships", "Jira project name or a comma separated list of names of the sync timestamps")
+	issueFilterFlags(metricsCmd)
+}
+
+// heatGauge represents a gauge of the heat of a file
+type heatGauge struct {
+	name  string
+	help  string
+	value func(fileHeat) float64
+}
+
+// heatGauges are the exposed gauges of every file
+var heatGauges = []heatGauge{
+	{"heatmap_file_bug_count", "Number of distinct bugs whose fixes touched the file.", func(h fileHeat) float64 { return float64(h.Bugs) }},
+	{"heatmap_file_score", "Heat score of the file.", func(h fileHeat) float64 { return h.Score }},
+	{"heatmap_file_risk", "Risk index of the file, 0 to 100.", func(h fileHeat) float64 { return h.Risk }},
+}
+
+// storageGauge represents a gauge of a collection of the store, which
+// may be unknown
+type storageGauge struct {
+	name  string
+	help  string
+	value func(collectionGrowth) (float64, bool)
+}
+
+// storageGauges are the exposed gauges of every collection
+var storageGauges = []storageGauge{
+	{"heatmap_store_documents", "Number of documents of the collection.", func(c collectionGrowth) (float64, bool) { return float64(c.Docs), true }},
+	{"heatmap_store_bytes", "Size of the collection in bytes.", func(c collectionGrowth) (float64, bool) { return float64(c.Bytes), true }},
+	{"heatmap_store_growth_documents_per_day", "Growth of the number of documents of the collection per day.", func(c collectionGrowth) (float64, bool) {
+		if c.DocsPerDay == nil {
+			return 0, false
+		}
+		return *c.DocsPerDay, true
+	}},
+	{"heatmap_store_growth_bytes_per_day", "Growth of the size of the collection in bytes per day.", func(c collectionGrowth) (float64, bool) {
+		if c.BytesPerDay == nil {
+			return 0, false
+		}
+		return *c.BytesPerDay, true
+	}},
+}
+
+func metrics(cmd *cobra.Command, args []string) error {
+	ctx, cancel, st, err := openReadStore()
+	if err != nil {
+		return err
+	}
+	defer cancel()
+	defer closeStore(context.Background(), st)
+
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	projects := backfillProjects(cmd)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), metricsScrapeTimeout)
+		defer cancel()
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		if err := writeMetrics(ctx, st, projects, w); err != nil {
+			slog.Error("scrape failed", "err", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+
+	srv := &http.Server{Addr: metricsAddr, Handler: mux}
+	go func() {
+		<-ctx.Done()
+		shutdown, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdown)
+	}()
+
+	slog.Info("serving metrics", "addr", metricsAddr)
+	if err := srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	return nil
+}
+
+// writeMetrics computes the heat and the sync timestamps of the projects
+// and writes them to w. Nothing is written if reading the store fails.
+func writeMetrics(ctx context.Context, st store, projects []string, w io.Writer) error {
+	heat, _, err := loadHeat(ctx, st)
+	if err != nil {
+		return err
+	}
+	if metricsTop > 0 && len(heat) > metricsTop {
+		heat = heat[:metricsTop]
+	}
+
+	synced := make(map[string]time.Time, len(projects))
+	for _, p := range projects {
+		if synced[p], err = st.Watermark(ctx, p); err != nil {
+			return storageError(fmt.Errorf("reading watermark failed: %w", err))
+		}
+	}
+
+	storage, err := currentStorageGrowth(ctx, st)
+	if err != nil {
+		return err
+	}
+
+	bw := bufio.NewWriter(w)
+	for _, g := range heatGauges {
+		fmt.Fprintf(bw, "# HELP %s %s\n# TYPE %s gauge\n", g.name, g.help, g.name)
+		for _, h := range heat {
+			repo, file := heatName(h)
+			fmt.Fprintf(bw, "%s{repo=%s,path=%s} %g\n", g.name, metricLabel(repo), metricLabel(file), g.value(h))
+		}
+	}
+
+	fmt.Fprintln(bw, "# HELP heatmap_last_sync_timestamp Start of the last completed backfill of the project in seconds since the epoch.")
+	fmt.Fprintln(bw, "# TYPE heatmap_last_sync_timestamp gauge")
+	for _, p := range projects {
+		if t := synced[p]; !t.IsZero() {
+			fmt.Fprintf(bw, "heatmap_last_sync_timestamp{project=%s} %d\n", metricLabel(p), t.Unix())
+		}
+	}
+
+	for _, g := range storageGauges {
+		fmt.Fprintf(bw, "# HELP %s %s\n# TYPE %s gauge\n", g.name, g.help, g.name)
+		for _, c := range storage.Collections {
+			if v, ok := g.value(c); ok {
+				fmt.Fprintf(bw, "%s{collection=%s} %g\n", g.name, metricLabel(c.Collection), v)
+			}
+		}
+	}
+
+	return bw.Flush()
+}
+
+// metricLabel quotes a label value of the Prometheus text format
+func metricLabel(v string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v) + `"`
+}
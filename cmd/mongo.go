@@ -0,0 +1,919 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+const (
+	defaultSyncCollName        = "sync"
+	defaultReportsCollName     = "reports"
+	defaultArchiveCollName     = "archive"
+	defaultReposCollName       = "repos"
+	defaultAnnotationsCollName = "annotations"
+	defaultPayloadsCollName    = "payloads"
+	defaultMongoBatchSize      = 1000
+
+	defaultMongoReadPreference = "secondaryPreferred"
+	defaultMongoConnectTimeout = 30 * time.Second
+)
+
+// mongoClients holds the clients connected by the run, keyed by their URI
+// and read preference, so that the stores opened by a command share
+// their connection pools
+var (
+	mongoClientsMu sync.Mutex
+	mongoClients   = make(map[string]*sharedMongoClient)
+)
+
+// sharedMongoClient represents a client and the number of the stores using it
+type sharedMongoClient struct {
+	client *mongo.Client
+	refs   int
+}
+
+// mongoStore keeps the data in MongoDB collections. The heat is read
+// through the read collections, which are the primary ones unless the
+// store was opened for reading with a mongo.read connection.
+type mongoStore struct {
+	client      *mongo.Client
+	jira        *mongo.Collection
+	github      *mongo.Collection
+	sync        *mongo.Collection
+	reports     *mongo.Collection
+	archive     *mongo.Collection
+	repos       *mongo.Collection
+	annotations *mongo.Collection
+	payloads    *mongo.Collection
+
+	readClient  *mongo.Client
+	readJira    *mongo.Collection
+	readGithub  *mongo.Collection
+	readReports *mongo.Collection
+
+	// lookup and batchSize are the mongo.lookup and mongo.batch_size of
+	// the not analyzed PRs
+	lookup    bool
+	batchSize int32
+}
+
+// syncState represents the watermark of the last completed backfill of a project
+type syncState struct {
+	Project  string    `bson:"_id" json:"project"`
+	LastSync time.Time `bson:"last_sync" json:"last_sync"`
+}
+
+func openMongoStore(read bool) (context.Context, context.CancelFunc, store, error) {
+	client, err := connectToMongo()
+	if err != nil {
+		return nil, nil, nil, storageError(err)
+	}
+	ctx, cancel := commandContext()
+
+	viper.SetDefault("mongo.collections.sync", defaultSyncCollName)
+	viper.SetDefault("mongo.collections.reports", defaultReportsCollName)
+	viper.SetDefault("mongo.collections.archive", defaultArchiveCollName)
+	viper.SetDefault("mongo.collections.repos", defaultReposCollName)
+	viper.SetDefault("mongo.collections.annotations", defaultAnnotationsCollName)
+	viper.SetDefault("mongo.collections.payloads", defaultPayloadsCollName)
+	viper.SetDefault("mongo.lookup", true)
+	viper.SetDefault("mongo.batch_size", defaultMongoBatchSize)
+	db := client.Database(dbname)
+
+	s := &mongoStore{
+		client:      client,
+		jira:        db.Collection(viper.GetString("mongo.collections.jira")),
+		github:      db.Collection(viper.GetString("mongo.collections.github")),
+		sync:        db.Collection(viper.GetString("mongo.collections.sync")),
+		reports:     db.Collection(viper.GetString("mongo.collections.reports")),
+		archive:     db.Collection(viper.GetString("mongo.collections.archive")),
+		repos:       db.Collection(viper.GetString("mongo.collections.repos")),
+		annotations: db.Collection(viper.GetString("mongo.collections.annotations")),
+		payloads:    db.Collection(viper.GetString("mongo.collections.payloads")),
+		lookup:      viper.GetBool("mongo.lookup"),
+		batchSize:   viper.GetInt32("mongo.batch_size"),
+	}
+	s.readJira, s.readGithub, s.readReports = s.jira, s.github, s.reports
+	if !read {
+		s.ensureMongoIndexes(ctx)
+	}
+
+	if read && (viper.IsSet("mongo.read.srv") || viper.IsSet("mongo.read.preference")) {
+		readClient, rdb, err := connectToMongoReplica()
+		if err != nil {
+			releaseMongoClient(ctx, client)
+			cancel()
+			return nil, nil, nil, err
+		}
+
+		s.readClient = readClient
+		s.readJira = rdb.Collection(viper.GetString("mongo.collections.jira"))
+		s.readGithub = rdb.Collection(viper.GetString("mongo.collections.github"))
+		s.readReports = rdb.Collection(viper.GetString("mongo.collections.reports"))
+	}
+
+	return ctx, cancel, s, nil
+}
+
+func (s *mongoStore) Close(ctx context.Context) error {
+	if s.readClient != nil {
+		if err := releaseMongoClient(ctx, s.readClient); err != nil {
+			return err
+		}
+	}
+
+	return releaseMongoClient(ctx, s.client)
+}
+
+func (s *mongoStore) MappedIssues(ctx context.Context) (map[string]bool, error) {
+	return getAlreadyMappedIssues(ctx, s.jira)
+}
+
+func (s *mongoStore) InsertMappings(ctx context.Context, mappings []mongoMapping) error {
+	docs := make([]interface{}, len(mappings))
+	keys := make([]bson.M, len(mappings))
+	for i, v := range mappings {
+		docs[i] = v
+		keys[i] = mappingFilter(v)
+	}
+
+	return writeItemsToMongo(ctx, s.jira, docs, keys)
+}
+
+// mappingFilter selects the mapping by its unique key. The mappings of
+// Jira are stored without a tracker and a uid, like the ones stored
+// before the trackers were recorded.
+func mappingFilter(m mongoMapping) bson.M {
+	var tracker, uid interface{}
+	if m.Tracker != "" {
+		tracker = m.Tracker
+	}
+	if m.IssueUID != "" {
+		uid = m.IssueUID
+	}
+
+	return bson.M{"project": m.Project, "tracker": tracker, "issue_id": m.IssueID, "issue_uid": uid, "repo.owner": m.Repo.Owner, "repo.name": m.Repo.Name, "pr_id": m.PRID}
+}
+
+func (s *mongoStore) Mappings(ctx context.Context) ([]mongoMapping, error) {
+	return getMappings(ctx, s.readJira)
+}
+
+func (s *mongoStore) Watermark(ctx context.Context, project string) (time.Time, error) {
+	return getWatermark(ctx, s.sync, project)
+}
+
+func (s *mongoStore) SetWatermark(ctx context.Context, project string, t time.Time) error {
+	return setWatermark(ctx, s.sync, project, t)
+}
+
+// NotAnalyzedPRs uses the $lookup aggregation unless mongo.lookup is false
+func (s *mongoStore) NotAnalyzedPRs(ctx context.Context, fn func(p pr) error) error {
+	if s.lookup {
+		return getNotAnalyzedPRs(ctx, s.jira, s.github.Name(), s.batchSize, fn)
+	}
+
+	return getNotAnalyzedPRsWithoutLookup(ctx, s.jira, s.github, s.batchSize, fn)
+}
+
+func (s *mongoStore) InsertPRs(ctx context.Context, prs []pr) error {
+	docs := make([]interface{}, len(prs))
+	keys := make([]bson.M, len(prs))
+	for i, v := range prs {
+		docs[i] = v
+		keys[i] = bson.M{"repo.owner": v.Repo.Owner, "repo.name": v.Repo.Name, "pr_id": v.PRID}
+	}
+
+	return writeItemsToMongo(ctx, s.github, docs, keys)
+}
+
+func (s *mongoStore) PRs(ctx context.Context) ([]pr, error) {
+	return getPRs(ctx, s.readGithub)
+}
+
+func (s *mongoStore) SaveRepos(ctx context.Context, repos []repoMeta) error {
+	docs := make([]interface{}, len(repos))
+	keys := make([]bson.M, len(repos))
+	for i, v := range repos {
+		docs[i] = v
+		keys[i] = bson.M{"repo.owner": v.Repo.Owner, "repo.name": v.Repo.Name}
+	}
+
+	return writeItemsToMongo(ctx, s.repos, docs, keys)
+}
+
+func (s *mongoStore) Repos(ctx context.Context) ([]repoMeta, error) {
+	cur, err := s.repos.Find(ctx, bson.D{})
+	if err != nil {
+		return nil, err
+	}
+
+	repos := make([]repoMeta, 0)
+	if err := cur.All(ctx, &repos); err != nil {
+		return nil, err
+	}
+
+	return repos, nil
+}
+
+// ReplaceAnnotations deletes the annotations before inserting the new
+// ones; they aren't replaced atomically
+func (s *mongoStore) ReplaceAnnotations(ctx context.Context, annotations []annotation) error {
+	if _, err := s.annotations.DeleteMany(ctx, bson.D{}); err != nil {
+		return err
+	}
+	if len(annotations) == 0 {
+		return nil
+	}
+
+	docs := make([]interface{}, len(annotations))
+	for i, a := range annotations {
+		docs[i] = a
+	}
+	_, err := s.annotations.InsertMany(ctx, docs)
+
+	return err
+}
+
+func (s *mongoStore) Annotations(ctx context.Context) ([]annotation, error) {
+	cur, err := s.annotations.Find(ctx, bson.D{})
+	if err != nil {
+		return nil, err
+	}
+
+	annotations := make([]annotation, 0)
+	if err := cur.All(ctx, &annotations); err != nil {
+		return nil, err
+	}
+
+	return annotations, nil
+}
+
+// SavePayloads leaves the expired payloads to the TTL index of expires_at
+func (s *mongoStore) SavePayloads(ctx context.Context, payloads []rawPayload) error {
+	docs := make([]interface{}, len(payloads))
+	for i, p := range payloads {
+		docs[i] = p
+	}
+	_, err := s.payloads.InsertMany(ctx, docs)
+
+	return err
+}
+
+// Payloads filters out the expired payloads the TTL monitor hasn't
+// removed yet
+func (s *mongoStore) Payloads(ctx context.Context, key string) ([]rawPayload, error) {
+	filter := bson.M{"key": key, "expires_at": bson.M{"$gt": time.Now().UTC()}}
+	cur, err := s.payloads.Find(ctx, filter, options.Find().SetSort(bson.M{"_id": 1}))
+	if err != nil {
+		return nil, err
+	}
+
+	payloads := make([]rawPayload, 0)
+	if err := cur.All(ctx, &payloads); err != nil {
+		return nil, err
+	}
+
+	return payloads, nil
+}
+
+func (s *mongoStore) SaveReport(ctx context.Context, r heatReport) error {
+	_, err := s.reports.InsertOne(ctx, r)
+	return err
+}
+
+func (s *mongoStore) LatestReport(ctx context.Context) (*heatReport, error) {
+	r := &heatReport{}
+	err := s.readReports.FindOne(ctx, bson.D{}, options.FindOne().SetSort(bson.M{"created": -1})).Decode(r)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+func (s *mongoStore) PurgeProject(ctx context.Context, project string) (int, int, error) {
+	filter := bson.M{"project": project}
+	cur, err := s.jira.Find(ctx, filter, options.Find().SetProjection(bson.M{"tracker": 1, "issue_id": 1, "repo": 1, "pr_id": 1}))
+	if err != nil {
+		return 0, 0, err
+	}
+	mappings := make([]mongoMapping, 0)
+	if err := cur.All(ctx, &mappings); err != nil {
+		return 0, 0, err
+	}
+
+	res, err := s.jira.DeleteMany(ctx, filter)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	prs := 0
+	seen := make(map[string]bool)
+	payloadKeys := make([]string, 0)
+	for _, m := range mappings {
+		if m.Tracker == "" {
+			payloadKeys = append(payloadKeys, strconv.Itoa(m.IssueID))
+		}
+		k := prKey(m.Repo, m.PRID)
+		if seen[k] {
+			continue
+		}
+		seen[k] = true
+
+		pr := bson.M{"repo.owner": m.Repo.Owner, "repo.name": m.Repo.Name, "pr_id": m.PRID}
+		left, err := s.jira.CountDocuments(ctx, pr)
+		if err != nil {
+			return int(res.DeletedCount), prs, err
+		}
+		if left > 0 {
+			continue
+		}
+
+		deleted, err := s.github.DeleteMany(ctx, pr)
+		if err != nil {
+			return int(res.DeletedCount), prs, err
+		}
+		prs += int(deleted.DeletedCount)
+		payloadKeys = append(payloadKeys, k)
+	}
+
+	if len(payloadKeys) > 0 {
+		if _, err := s.payloads.DeleteMany(ctx, bson.M{"key": bson.M{"$in": payloadKeys}}); err != nil {
+			return int(res.DeletedCount), prs, err
+		}
+	}
+
+	if _, err := s.sync.DeleteOne(ctx, bson.M{"_id": project}); err != nil {
+		return int(res.DeletedCount), prs, err
+	}
+
+	return int(res.DeletedCount), prs, nil
+}
+
+func (s *mongoStore) Prune(ctx context.Context, mappings []mongoMapping, prs []pr, archive bool) error {
+	if archive {
+		docs := make([]interface{}, 0, len(mappings)+len(prs))
+		for _, a := range archivedDocs(mappings, prs, time.Now().UTC()) {
+			docs = append(docs, a)
+		}
+		if len(docs) > 0 {
+			if _, err := s.archive.InsertMany(ctx, docs); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, m := range mappings {
+		if _, err := s.jira.DeleteMany(ctx, mappingFilter(m)); err != nil {
+			return err
+		}
+	}
+	for _, p := range prs {
+		if _, err := s.github.DeleteMany(ctx, bson.M{"repo.owner": p.Repo.Owner, "repo.name": p.Repo.Name, "pr_id": p.PRID}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *mongoStore) ScrubArchive(ctx context.Context, fn func(p *pr) bool) (int, error) {
+	cur, err := s.archive.Find(ctx, bson.M{"kind": "pr"})
+	if err != nil {
+		return 0, err
+	}
+	defer cur.Close(ctx)
+
+	n := 0
+	for cur.Next(ctx) {
+		a := struct {
+			ID          interface{} `bson:"_id"`
+			archivedDoc `bson:",inline"`
+		}{}
+		if err := cur.Decode(&a); err != nil {
+			return n, err
+		}
+		if a.PR == nil || !fn(a.PR) {
+			continue
+		}
+		if _, err := s.archive.UpdateOne(ctx, bson.M{"_id": a.ID}, bson.M{"$set": bson.M{"pr": a.PR}}); err != nil {
+			return n, err
+		}
+		n++
+	}
+
+	return n, cur.Err()
+}
+
+func (s *mongoStore) Reset(ctx context.Context, collection string) error {
+	colls := map[string]*mongo.Collection{
+		"mappings":    s.jira,
+		"prs":         s.github,
+		"sync":        s.sync,
+		"reports":     s.reports,
+		"archive":     s.archive,
+		"repos":       s.repos,
+		"annotations": s.annotations,
+		"payloads":    s.payloads,
+	}
+
+	coll := colls[collection]
+	if err := coll.Drop(ctx); err != nil {
+		return err
+	}
+
+	if indexes := mongoIndexes[collection]; len(indexes) > 0 {
+		if _, err := coll.Indexes().CreateMany(ctx, indexes); err != nil {
+			return fmt.Errorf("creating indexes failed: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// Stats runs collStats, the size being the storage allocated to the
+// documents and the indexes of the collection
+func (s *mongoStore) Stats(ctx context.Context) ([]collectionStats, error) {
+	colls := map[string]*mongo.Collection{
+		"mappings":    s.jira,
+		"prs":         s.github,
+		"sync":        s.sync,
+		"reports":     s.reports,
+		"archive":     s.archive,
+		"repos":       s.repos,
+		"annotations": s.annotations,
+		"payloads":    s.payloads,
+	}
+
+	stats := make([]collectionStats, 0, len(storeCollections))
+	for _, c := range storeCollections {
+		coll := colls[c]
+		res := struct {
+			Count          int64 `bson:"count"`
+			StorageSize    int64 `bson:"storageSize"`
+			TotalIndexSize int64 `bson:"totalIndexSize"`
+		}{}
+		if err := coll.Database().RunCommand(ctx, bson.D{{Key: "collStats", Value: coll.Name()}}).Decode(&res); err != nil {
+			return nil, fmt.Errorf("collStats of %s failed: %w", coll.Name(), err)
+		}
+		stats = append(stats, collectionStats{Collection: c, Docs: res.Count, Bytes: res.StorageSize + res.TotalIndexSize})
+	}
+
+	return stats, nil
+}
+
+// mongoExportDocs holds the constructors of the documents of the
+// collections, which are exported as the JSON of the same types as
+// by the other backends
+var mongoExportDocs = map[string]func() interface{}{
+	"mappings":    func() interface{} { return &mongoMapping{} },
+	"prs":         func() interface{} { return &pr{} },
+	"sync":        func() interface{} { return &syncState{} },
+	"reports":     func() interface{} { return &heatReport{} },
+	"archive":     func() interface{} { return &archivedDoc{} },
+	"repos":       func() interface{} { return &repoMeta{} },
+	"annotations": func() interface{} { return &annotation{} },
+	"payloads":    func() interface{} { return &rawPayload{} },
+}
+
+// Export resumes after the _id of the token, the extended JSON of a
+// document holding only the _id
+func (s *mongoStore) Export(ctx context.Context, collection, after string, fn func(doc []byte, token string) error) error {
+	colls := map[string]*mongo.Collection{
+		"mappings":    s.readJira,
+		"prs":         s.readGithub,
+		"sync":        s.sync,
+		"reports":     s.readReports,
+		"archive":     s.archive,
+		"repos":       s.repos,
+		"annotations": s.annotations,
+		"payloads":    s.payloads,
+	}
+
+	filter := bson.M{}
+	if after != "" {
+		from := bson.M{}
+		if err := bson.UnmarshalExtJSON([]byte(after), true, &from); err != nil {
+			return fmt.Errorf("invalid resume token %q: %w", after, err)
+		}
+		filter["_id"] = bson.M{"$gt": from["_id"]}
+	}
+
+	cur, err := colls[collection].Find(ctx, filter, options.Find().SetSort(bson.M{"_id": 1}))
+	if err != nil {
+		return err
+	}
+	defer cur.Close(ctx)
+
+	for cur.Next(ctx) {
+		v := mongoExportDocs[collection]()
+		if err := cur.Decode(v); err != nil {
+			return err
+		}
+		doc, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+
+		token, err := bson.MarshalExtJSON(bson.D{{Key: "_id", Value: cur.Current.Lookup("_id")}}, true, false)
+		if err != nil {
+			return err
+		}
+		if err := fn(doc, string(token)); err != nil {
+			return err
+		}
+	}
+
+	return cur.Err()
+}
+
+// mongoIndexes holds the indexes of the collections
+var mongoIndexes = map[string][]mongo.IndexModel{
+	"mappings": {
+		{
+			Keys: bson.D{
+				{Key: "project", Value: 1}, {Key: "tracker", Value: 1}, {Key: "issue_id", Value: 1}, {Key: "issue_uid", Value: 1},
+				{Key: "repo.owner", Value: 1}, {Key: "repo.name", Value: 1}, {Key: "pr_id", Value: 1},
+			},
+			Options: options.Index().SetUnique(true),
+		},
+		{Keys: bson.D{{Key: "repo.owner", Value: 1}, {Key: "repo.name", Value: 1}, {Key: "pr_id", Value: 1}}},
+		{Keys: bson.D{{Key: "issue_id", Value: 1}}},
+	},
+	"prs": {
+		{
+			Keys:    bson.D{{Key: "repo.owner", Value: 1}, {Key: "repo.name", Value: 1}, {Key: "pr_id", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+	},
+	"reports": {
+		{Keys: bson.D{{Key: "created", Value: -1}}},
+	},
+	"repos": {
+		{
+			Keys:    bson.D{{Key: "repo.owner", Value: 1}, {Key: "repo.name", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+	},
+	"payloads": {
+		{Keys: bson.D{{Key: "key", Value: 1}}},
+		{
+			Keys:    bson.D{{Key: "expires_at", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		},
+	},
+}
+
+func connectToMongo() (*mongo.Client, error) {
+	srv := viper.GetString("mongo.srv")
+	user := viper.GetString("mongo.user")
+	pass := viper.GetString("mongo.password")
+	dbname = viper.GetString("mongo.dbname")
+
+	client, err := acquireMongoClient(fmt.Sprintf(srv, user, pass, dbname), nil)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to MongoDB failed: %w", err)
+	}
+
+	return client, nil
+}
+
+// acquireMongoClient returns the client of the URI and the read
+// preference, nil for the one of the URI. A client connected by an earlier
+// store of the run is reused, else a new one connects and pings the
+// server within mongo.connect_timeout, which doesn't limit the commands'
+// operations.
+func acquireMongoClient(uri string, pref *readpref.ReadPref) (*mongo.Client, error) {
+	id := uri
+	if pref != nil {
+		id += "#" + pref.Mode().String()
+	}
+
+	mongoClientsMu.Lock()
+	defer mongoClientsMu.Unlock()
+
+	if shared, ok := mongoClients[id]; ok {
+		shared.refs++
+		return shared.client, nil
+	}
+
+	viper.SetDefault("mongo.connect_timeout", defaultMongoConnectTimeout)
+	timeout := viper.GetDuration("mongo.connect_timeout")
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	opts := options.Client().ApplyURI(uri).SetConnectTimeout(timeout)
+	if pref != nil {
+		opts.SetReadPreference(pref)
+	}
+	client, err := mongo.Connect(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := client.Ping(ctx, pref); err != nil {
+		client.Disconnect(context.Background())
+		return nil, err
+	}
+
+	mongoClients[id] = &sharedMongoClient{client: client, refs: 1}
+
+	return client, nil
+}
+
+// releaseMongoClient disconnects the client once no store of the run uses it
+func releaseMongoClient(ctx context.Context, client *mongo.Client) error {
+	mongoClientsMu.Lock()
+	for id, shared := range mongoClients {
+		if shared.client != client {
+			continue
+		}
+		if shared.refs--; shared.refs > 0 {
+			mongoClientsMu.Unlock()
+			return nil
+		}
+		delete(mongoClients, id)
+	}
+	mongoClientsMu.Unlock()
+
+	return client.Disconnect(ctx)
+}
+
+// connectToMongoReplica connects to the read connection of the mongo.read
+// config keys, which default to the ones of the primary connection, with
+// the mongo.read.preference read preference
+func connectToMongoReplica() (*mongo.Client, *mongo.Database, error) {
+	for _, key := range []string{"srv", "user", "password", "dbname"} {
+		viper.SetDefault("mongo.read."+key, viper.Get("mongo."+key))
+	}
+	viper.SetDefault("mongo.read.preference", defaultMongoReadPreference)
+
+	mode, err := readpref.ModeFromString(viper.GetString("mongo.read.preference"))
+	if err != nil {
+		return nil, nil, configError(err)
+	}
+	pref, err := readpref.New(mode)
+	if err != nil {
+		return nil, nil, configError(err)
+	}
+
+	name := viper.GetString("mongo.read.dbname")
+	client, err := acquireMongoClient(fmt.Sprintf(
+		viper.GetString("mongo.read.srv"),
+		viper.GetString("mongo.read.user"),
+		viper.GetString("mongo.read.password"),
+		name,
+	), pref)
+	if err != nil {
+		return nil, nil, storageError(fmt.Errorf("connecting to the MongoDB read connection failed: %w", err))
+	}
+
+	return client, client.Database(name), nil
+}
+
+func getAlreadyMappedIssues(ctx context.Context, collection *mongo.Collection) (map[string]bool, error) {
+	projection := options.Find().SetProjection(bson.M{"_id": 0, "tracker": 1, "issue_id": 1, "issue_uid": 1})
+
+	cur, err := collection.Find(ctx, bson.D{}, projection)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	mappings := make(map[string]bool, 0)
+	for cur.Next(ctx) {
+		result := &mongoMapping{}
+		err := cur.Decode(&result)
+		if err != nil {
+			return nil, err
+		}
+
+		mappings[result.issueRef()] = false
+	}
+
+	if err := cur.Err(); err != nil {
+		return nil, err
+	}
+
+	return mappings, nil
+}
+
+// getWatermark returns the start of the last completed backfill of the
+// project or zero time if there's none
+func getWatermark(ctx context.Context, collection *mongo.Collection, project string) (time.Time, error) {
+	state := &syncState{}
+	err := collection.FindOne(ctx, bson.M{"_id": project}).Decode(state)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return time.Time{}, nil
+	}
+
+	return state.LastSync, err
+}
+
+func setWatermark(ctx context.Context, collection *mongo.Collection, project string, t time.Time) error {
+	_, err := collection.ReplaceOne(ctx,
+		bson.M{"_id": project},
+		syncState{Project: project, LastSync: t},
+		options.Replace().SetUpsert(true),
+	)
+
+	return err
+}
+
+// writeItemsToMongo upserts the documents by their keys, so writing the
+// documents of an interrupted run again doesn't duplicate them
+func writeItemsToMongo(ctx context.Context, coll *mongo.Collection, docs []interface{}, keys []bson.M) error {
+	models := make([]mongo.WriteModel, len(docs))
+	for i, doc := range docs {
+		models[i] = mongo.NewReplaceOneModel().SetFilter(keys[i]).SetReplacement(doc).SetUpsert(true)
+	}
+
+	res, err := coll.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false))
+	if err != nil {
+		return err
+	}
+
+	slog.Debug("documents written", "collection", coll.Name(), "inserted", res.UpsertedCount, "replaced", res.ModifiedCount)
+
+	return nil
+}
+
+// ensureMongoIndexes creates the missing indexes of the collections. A
+// unique index can't be created while the collection holds duplicates,
+// which is reported without failing the run.
+func (s *mongoStore) ensureMongoIndexes(ctx context.Context) {
+	colls := map[string]*mongo.Collection{
+		"mappings": s.jira,
+		"prs":      s.github,
+		"reports":  s.reports,
+		"repos":    s.repos,
+	}
+	for name, coll := range colls {
+		if _, err := coll.Indexes().CreateMany(ctx, mongoIndexes[name]); err != nil {
+			slog.Warn("creating indexes failed, the collection may hold duplicates", "collection", coll.Name(), "err", err)
+		}
+	}
+}
+
+// getNotAnalyzedPRs groups the mappings by their PRs and joins them with
+// the diffs by a $lookup which only fetches whether a diff exists, so the
+// joined documents stay small. The PRs are matched by their repos and
+// numbers, as the numbers of the PRs are only unique in a repo. The
+// aggregation may spill to disk and fn is called with its results as the
+// cursor fetches them in batches of batchSize.
+func getNotAnalyzedPRs(ctx context.Context, collection *mongo.Collection, githubCollName string, batchSize int32, fn func(p pr) error) error {
+	group := bson.D{{
+		Key: "$group",
+		Value: bson.M{
+			"_id": bson.M{"owner": "$repo.owner", "name": "$repo.name", "pr_id": "$pr_id"},
+		},
+	}}
+
+	lookup := bson.D{{
+		Key: "$lookup",
+		Value: bson.M{
+			"from": githubCollName,
+			"let":  bson.M{"owner": "$_id.owner", "name": "$_id.name", "pr_id": "$_id.pr_id"},
+			"pipeline": bson.A{
+				bson.M{"$match": bson.M{"$expr": bson.M{"$and": bson.A{
+					bson.M{"$eq": bson.A{"$repo.owner", "$$owner"}},
+					bson.M{"$eq": bson.A{"$repo.name", "$$name"}},
+					bson.M{"$eq": bson.A{"$pr_id", "$$pr_id"}},
+				}}}},
+				bson.M{"$project": bson.M{"_id": 1}},
+				bson.M{"$limit": 1},
+			},
+			"as": "pr",
+		},
+	}}
+
+	match := bson.D{{
+		Key: "$match",
+		Value: bson.M{
+			"pr": bson.M{
+				"$size": 0,
+			},
+		},
+	}}
+
+	project := bson.D{{
+		Key: "$project",
+		Value: bson.M{
+			"_id":   0,
+			"repo":  bson.M{"owner": "$_id.owner", "name": "$_id.name"},
+			"pr_id": "$_id.pr_id",
+		},
+	}}
+
+	opts := options.Aggregate().SetAllowDiskUse(true).SetBatchSize(batchSize)
+	cur, err := collection.Aggregate(ctx, mongo.Pipeline{group, lookup, match, project}, opts)
+	if err != nil {
+		return err
+	}
+	defer cur.Close(ctx)
+
+	for cur.Next(ctx) {
+		p := pr{}
+		if err := cur.Decode(&p); err != nil {
+			return err
+		}
+		if err := fn(p); err != nil {
+			return err
+		}
+	}
+
+	return cur.Err()
+}
+
+// getNotAnalyzedPRsWithoutLookup is the alternative of getNotAnalyzedPRs
+// for the stores without $lookup support. It reads the IDs of the analyzed
+// PRs first and then streams the mappings, calling fn with the PRs which
+// aren't analyzed nor passed on yet.
+func getNotAnalyzedPRsWithoutLookup(ctx context.Context, jiraColl, githubColl *mongo.Collection, batchSize int32, fn func(p pr) error) error {
+	opts := options.Find().SetProjection(bson.M{"_id": 0, "repo": 1, "pr_id": 1}).SetBatchSize(batchSize)
+	cur, err := githubColl.Find(ctx, bson.D{}, opts)
+	if err != nil {
+		return err
+	}
+
+	seen := make(map[string]bool)
+	for cur.Next(ctx) {
+		p := pr{}
+		if err := cur.Decode(&p); err != nil {
+			cur.Close(ctx)
+			return err
+		}
+		seen[prKey(p.Repo, p.PRID)] = true
+	}
+	err = cur.Err()
+	cur.Close(ctx)
+	if err != nil {
+		return err
+	}
+
+	cur, err = jiraColl.Find(ctx, bson.D{}, opts)
+	if err != nil {
+		return err
+	}
+	defer cur.Close(ctx)
+
+	for cur.Next(ctx) {
+		p := pr{}
+		if err := cur.Decode(&p); err != nil {
+			return err
+		}
+		k := prKey(p.Repo, p.PRID)
+		if seen[k] {
+			continue
+		}
+		seen[k] = true
+		if err := fn(p); err != nil {
+			return err
+		}
+	}
+
+	return cur.Err()
+}
+
+func getMappings(ctx context.Context, collection *mongo.Collection) ([]mongoMapping, error) {
+	cur, err := collection.Find(ctx, bson.D{})
+	if err != nil {
+		return nil, err
+	}
+
+	mappings := make([]mongoMapping, 0)
+	if err := cur.All(ctx, &mappings); err != nil {
+		return nil, err
+	}
+
+	return mappings, nil
+}
+
+func getPRs(ctx context.Context, collection *mongo.Collection) ([]pr, error) {
+	cur, err := collection.Find(ctx, bson.D{})
+	if err != nil {
+		return nil, err
+	}
+
+	prs := make([]pr, 0)
+	if err := cur.All(ctx, &prs); err != nil {
+		return nil, err
+	}
+
+	return prs, nil
+}
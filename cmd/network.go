@@ -0,0 +1,79 @@
+package cmd
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+var offline bool
+
+// policyTransport rejects the requests to the hosts which are not in the
+// allowlist. In offline mode every request is rejected; the store is
+// the only thing the tool talks to.
+type policyTransport struct {
+	next    http.RoundTripper
+	allowed map[string]bool
+	offline bool
+}
+
+// newPolicyTransport allows the hosts of the configured services and the
+// ones listed in network.allow
+func newPolicyTransport(next http.RoundTripper) *policyTransport {
+	viper.SetDefault("gitlab.host", defaultGitLabHost)
+	viper.SetDefault("bitbucket.api", defaultBitbucketAPI)
+	viper.SetDefault("azure.host", defaultAzureHost)
+	viper.SetDefault("linear.api", defaultLinearAPI)
+	viper.SetDefault("jira.auth.token_url", defaultJiraTokenURL)
+
+	t := &policyTransport{next: next, allowed: make(map[string]bool), offline: offline}
+	for _, key := range []string{"jira.host", "gitlab.host", "bitbucket.api", "azure.host", "linear.api", "jira.auth.token_url"} {
+		if u, err := url.Parse(viper.GetString(key)); err == nil && u.Hostname() != "" {
+			t.allowed[strings.ToLower(u.Hostname())] = true
+		}
+	}
+	if base, upload, err := githubEnterprise(); err == nil && base != "" {
+		for _, endpoint := range []string{base, upload} {
+			if u, err := url.Parse(endpoint); err == nil && u.Hostname() != "" {
+				t.allowed[strings.ToLower(u.Hostname())] = true
+			}
+		}
+	} else {
+		t.allowed["api.github.com"] = true
+	}
+	for _, hook := range notifyWebhooks() {
+		if u, err := url.Parse(hook.url); err == nil && u.Hostname() != "" {
+			t.allowed[strings.ToLower(u.Hostname())] = true
+		}
+	}
+	for _, host := range viper.GetStringSlice("network.allow") {
+		t.allowed[strings.ToLower(host)] = true
+	}
+
+	return t
+}
+
+func (t *policyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.check(req.URL.Hostname()); err != nil {
+		return nil, err
+	}
+
+	return t.next.RoundTrip(req)
+}
+
+// check returns an error if the host can't be reached under the policy,
+// for the connections made without an HTTP client too, e.g. SMTP
+func (t *policyTransport) check(host string) error {
+	host = strings.ToLower(host)
+	if t.offline {
+		return configError(fmt.Errorf("offline mode: request to %s rejected", host))
+	}
+	if !t.allowed[host] {
+		return configError(fmt.Errorf("request to %s rejected: host is not in the allowlist", host))
+	}
+
+	return nil
+}
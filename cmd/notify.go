@@ -0,0 +1,268 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+const defaultNotifyTop = 5
+
+// notifyWebhook represents a chat webhook the digest of a sync is posted
+// to, only the part of a team if team is set
+type notifyWebhook struct {
+	kind string
+	url  string
+	team string
+}
+
+// notifyWebhooks returns the webhooks of notify.slack.webhook_url and
+// notify.teams.webhook_url which are set, then the ones of the teams in
+// notify.team_webhooks, e.g.
+//
+//	"notify": {"team_webhooks": {"payments": {"slack": "https://..."}}}
+func notifyWebhooks() []notifyWebhook {
+	hooks := make([]notifyWebhook, 0, 2)
+	for _, kind := range []string{"slack", "teams"} {
+		if u := viper.GetString("notify." + kind + ".webhook_url"); u != "" {
+			hooks = append(hooks, notifyWebhook{kind: kind, url: u})
+		}
+	}
+
+	teams := make([]string, 0)
+	for team := range viper.GetStringMap("notify.team_webhooks") {
+		teams = append(teams, team)
+	}
+	sort.Strings(teams)
+	for _, team := range teams {
+		for _, kind := range []string{"slack", "teams"} {
+			if u := viper.GetString(teamWebhookKey(team, kind)); u != "" {
+				hooks = append(hooks, notifyWebhook{kind: kind, url: u, team: team})
+			}
+		}
+	}
+
+	return hooks
+}
+
+// teamWebhookKey returns the config key of the webhook of the kind of a
+// team
+func teamWebhookKey(team, kind string) string {
+	return "notify.team_webhooks." + team + "." + kind
+}
+
+// teamHeat returns the heat of the files the team owns per the teams
+// config key
+func teamHeat(heat []fileHeat, teams map[string][]string, team string) []fileHeat {
+	owned := make([]fileHeat, 0)
+	for _, h := range heat {
+		for _, t := range teamsOf(teams, h.Repo, h.File) {
+			if t == team {
+				owned = append(owned, h)
+				break
+			}
+		}
+	}
+
+	return owned
+}
+
+// heatChange represents the score of a file against the one of the last
+// run
+type heatChange struct {
+	Repo     Repo
+	File     string
+	Score    float64
+	Previous float64
+}
+
+// heatDigest represents the changes of the heat of a sync run
+type heatDigest struct {
+	RunID       string
+	NewMappings int
+	// Team is the team of the digest, only with the files it owns
+	Team string
+	// Since is the time of the report of the last run, zero if there's none
+	Since     time.Time
+	Hot       []heatChange
+	Threshold float64
+	Crossed   []heatChange
+	// Exceeded are the heat budgets of the teams the run exceeded
+	Exceeded []budgetState
+}
+
+// digestHeat compares the heat with the one of the last report: the
+// files whose scores grew the most, top of them, and the ones reaching
+// the threshold, unless it's 0
+func digestHeat(heat []fileHeat, last *heatReport, top int, threshold float64) heatDigest {
+	d := heatDigest{Threshold: threshold}
+	previous := make(map[string]float64)
+	if last != nil {
+		d.Since = last.Created
+		for _, h := range last.Files {
+			previous[h.Repo.Owner+"/"+h.Repo.Name+"/"+h.File] = h.Score
+		}
+	}
+
+	grown := make([]heatChange, 0)
+	for _, h := range heat {
+		c := heatChange{Repo: h.Repo, File: h.File, Score: h.Score, Previous: previous[h.Repo.Owner+"/"+h.Repo.Name+"/"+h.File]}
+		if c.Score > c.Previous {
+			grown = append(grown, c)
+		}
+		if threshold > 0 && c.Score >= threshold && c.Previous < threshold {
+			d.Crossed = append(d.Crossed, c)
+		}
+	}
+	sort.SliceStable(grown, func(i, j int) bool {
+		return grown[i].Score-grown[i].Previous > grown[j].Score-grown[j].Previous
+	})
+	if top > 0 && len(grown) > top {
+		grown = grown[:top]
+	}
+	d.Hot = grown
+
+	return d
+}
+
+// text renders the digest as Markdown, with the bold markup of the chat
+func (d heatDigest) text(bold string) string {
+	b := &strings.Builder{}
+	fmt.Fprintf(b, "%sheatmap sync %s%s", bold, d.RunID, bold)
+	if d.Team != "" {
+		fmt.Fprintf(b, " for %s", d.Team)
+	}
+	fmt.Fprintf(b, ": %d new bug-PR mappings", d.NewMappings)
+	if !d.Since.IsZero() {
+		fmt.Fprintf(b, " since %s", d.Since.UTC().Format("2006-01-02 15:04 MST"))
+	}
+	b.WriteString("\n")
+
+	line := func(c heatChange) {
+		fmt.Fprintf(b, "- `%s/%s/%s` %.2f (+%.2f)\n", c.Repo.Owner, c.Repo.Name, c.File, c.Score, c.Score-c.Previous)
+	}
+	if len(d.Hot) > 0 {
+		fmt.Fprintf(b, "\n%sNewly hot files%s\n", bold, bold)
+		for _, c := range d.Hot {
+			line(c)
+		}
+	}
+	if len(d.Crossed) > 0 {
+		fmt.Fprintf(b, "\n%sCrossed the score of %.2f%s\n", bold, d.Threshold, bold)
+		for _, c := range d.Crossed {
+			line(c)
+		}
+	}
+	if len(d.Exceeded) > 0 {
+		fmt.Fprintf(b, "\n%sExceeded heat budgets%s\n", bold, bold)
+		for _, s := range d.Exceeded {
+			fmt.Fprintf(b, "- `%s` %.2f of %.2f\n", s.Team, s.Score, s.Budget)
+		}
+	}
+
+	return b.String()
+}
+
+// empty tells whether the digest lists neither files nor budgets
+func (d heatDigest) empty() bool {
+	return len(d.Hot) == 0 && len(d.Crossed) == 0 && len(d.Exceeded) == 0
+}
+
+// payload returns the JSON message of the webhook: a Slack message or a
+// Teams message card
+func (d heatDigest) payload(kind string) ([]byte, error) {
+	if kind == "teams" {
+		return json.Marshal(map[string]string{
+			"@type":    "MessageCard",
+			"@context": "https://schema.org/extensions",
+			"summary":  fmt.Sprintf("heatmap sync %s", d.RunID),
+			"text":     d.text("**"),
+		})
+	}
+
+	return json.Marshal(map[string]string{"text": d.text("*")})
+}
+
+func postDigest(ctx context.Context, hook notifyWebhook, d heatDigest) error {
+	body, err := d.payload(hook.kind)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("posting to %s failed: %s: %s", hook.kind, resp.Status, strings.TrimSpace(string(msg)))
+	}
+
+	return nil
+}
+
+// runNotify posts the digest of the run, with the exceeded budgets, to
+// the webhooks and saves the heat as the report the next run is compared
+// with. The webhook of a team gets the digest of the files the team
+// owns and of its budget, and nothing if it's empty. It returns the
+// number of the webhooks posted to; a failed post is only logged, it
+// mustn't fail the run.
+func runNotify(ctx context.Context, st store, hooks []notifyWebhook, newMappings int, exceeded []budgetState) (int, error) {
+	heat, _, err := loadHeat(ctx, st)
+	if err != nil {
+		return 0, err
+	}
+	last, err := st.LatestReport(ctx)
+	if err != nil {
+		return 0, storageError(fmt.Errorf("reading the last report failed: %w", err))
+	}
+
+	viper.SetDefault("notify.top", defaultNotifyTop)
+	top, threshold := viper.GetInt("notify.top"), viper.GetFloat64("notify.threshold")
+	d := digestHeat(heat, last, top, threshold)
+	d.RunID, d.NewMappings, d.Exceeded = runID(), newMappings, exceeded
+
+	teams := teamPatterns()
+	posted := 0
+	for _, hook := range hooks {
+		d := d
+		if hook.team != "" {
+			d = digestHeat(teamHeat(heat, teams, hook.team), last, top, threshold)
+			d.RunID, d.NewMappings, d.Team = runID(), newMappings, hook.team
+			for _, s := range exceeded {
+				if s.Team == hook.team {
+					d.Exceeded = append(d.Exceeded, s)
+				}
+			}
+			if d.empty() {
+				continue
+			}
+		}
+		if err := postDigest(ctx, hook, d); err != nil {
+			slog.Warn("posting the digest failed", "webhook", hook.kind, "team", hook.team, "err", err)
+			continue
+		}
+		posted++
+	}
+
+	if err := st.SaveReport(ctx, heatReport{Created: time.Now(), Files: heat, RunID: runID()}); err != nil {
+		return posted, storageError(fmt.Errorf("saving report failed: %w", err))
+	}
+
+	return posted, nil
+}
@@ -0,0 +1,101 @@
+package cmd
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// The origins of the bugs
+const (
+	originCustomer = "customer"
+	originInternal = "internal"
+	originUnknown  = "unknown"
+)
+
+var defaultOriginCustomerValues = []string{"Customer"}
+
+// originFields returns the field of jira.origin.field requested with the
+// bugs, if it's set
+func originFields() []string {
+	if f := viper.GetString("jira.origin.field"); f != "" {
+		return []string{f}
+	}
+
+	return nil
+}
+
+// fieldStrings returns the text of a field value: a string, an option or
+// a named value, e.g. of a select list, or a list of them
+func fieldStrings(raw json.RawMessage) []string {
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return []string{s}
+	}
+
+	option := struct {
+		Value string `json:"value"`
+		Name  string `json:"name"`
+	}{}
+	if err := json.Unmarshal(raw, &option); err == nil {
+		if option.Value != "" {
+			return []string{option.Value}
+		}
+		return []string{option.Name}
+	}
+
+	list := make([]json.RawMessage, 0)
+	if err := json.Unmarshal(raw, &list); err != nil {
+		return nil
+	}
+	values := make([]string, 0, len(list))
+	for _, item := range list {
+		values = append(values, fieldStrings(item)...)
+	}
+
+	return values
+}
+
+// origin returns whether the bug was reported by a customer: it came
+// through a service desk portal or jira.origin.field has one of the
+// values of jira.origin.customer_values. The other bugs are found
+// internally if the field is set, else of an unknown origin.
+func (b bug) origin() string {
+	jsmDefaults()
+	viper.SetDefault("jira.origin.customer_values", defaultOriginCustomerValues)
+
+	if b.requestType() != "" {
+		return originCustomer
+	}
+	field := viper.GetString("jira.origin.field")
+	if field == "" {
+		return ""
+	}
+	if values := viper.GetStringSlice("jira.origin.customer_values"); len(values) > 0 && matchAny(values, fieldStrings(b.Fields[field])) {
+		return originCustomer
+	}
+
+	return originInternal
+}
+
+// originWeights returns the weights of the bugs by their origin from
+// heat.origin_weights, e.g. {"customer": 2}. The bugs of other origins
+// weigh 1.
+func originWeights() map[string]float64 {
+	weights := make(map[string]float64)
+	for name := range viper.GetStringMap("heat.origin_weights") {
+		weights[name] = viper.GetFloat64("heat.origin_weights." + name)
+	}
+
+	return weights
+}
+
+// mappingOrigin returns the origin of the bug of the mapping
+func mappingOrigin(m mongoMapping) string {
+	if m.Origin == "" {
+		return originUnknown
+	}
+
+	return strings.ToLower(m.Origin)
+}
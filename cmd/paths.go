@@ -0,0 +1,78 @@
+package cmd
+
+import (
+	"html"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+	"golang.org/x/text/width"
+)
+
+// cleanPath makes a file path returned by a provider safe to store, key
+// and render: the bytes which aren't UTF-8 are replaced and the path is
+// normalized to NFC, so a name committed in the decomposed form, as
+// macOS writes it, keys the same file as the composed one.
+func cleanPath(p string) string {
+	return norm.NFC.String(strings.ToValidUTF8(p, "\uFFFD"))
+}
+
+// cleanDiffs cleans the paths of the files of the diffs
+func cleanDiffs(diffs []diff) []diff {
+	for i := range diffs {
+		diffs[i].File = cleanPath(diffs[i].File)
+	}
+
+	return diffs
+}
+
+// printable replaces the invalid bytes and drops the control characters
+// other than new lines and tabs, which XML and the diagram languages
+// don't allow in their text. The paths stored before they were cleaned
+// can still have them.
+func printable(s string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) && r != '\n' && r != '\t' {
+			return -1
+		}
+		return r
+	}, strings.ToValidUTF8(s, "\uFFFD"))
+}
+
+// svgText escapes the text of an SVG element or attribute
+func svgText(s string) string {
+	return html.EscapeString(printable(s))
+}
+
+// dotString quotes a DOT string. Unlike %q it keeps the non-ASCII
+// characters as they are, since Graphviz reads UTF-8 but not the Go
+// escapes.
+func dotString(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(printable(s)) + `"`
+}
+
+// displayWidth returns the number of the columns of a terminal the text
+// takes: two for the wide characters of the East Asian scripts and most
+// emoji, none for the combining marks, the joiners and the variation
+// selectors, one for the others
+func displayWidth(s string) int {
+	w := 0
+	for _, r := range s {
+		w += runeWidth(r)
+	}
+
+	return w
+}
+
+func runeWidth(r rune) int {
+	switch {
+	case unicode.IsControl(r), unicode.In(r, unicode.Mn, unicode.Me, unicode.Cf), unicode.Is(unicode.Variation_Selector, r):
+		return 0
+	}
+	switch width.LookupRune(r).Kind() {
+	case width.EastAsianWide, width.EastAsianFullwidth:
+		return 2
+	}
+
+	return 1
+}
@@ -0,0 +1,116 @@
+package cmd
+
+import "testing"
+
+func TestCleanPath(t *testing.T) {
+	tests := []struct {
+		name string
+		path string
+		want string
+	}{
+		{"ascii", "src/main.go", "src/main.go"},
+		{"composed", "docs/caf\u00e9.md", "docs/caf\u00e9.md"},
+		{"decomposed", "docs/cafe\u0301.md", "docs/caf\u00e9.md"},
+		{"cjk", "文档/说明.md", "文档/说明.md"},
+		{"emoji", "assets/🔥/fire.svg", "assets/🔥/fire.svg"},
+		{"invalid bytes", "bad\xff\xfe.go", "bad�.go"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := cleanPath(tt.path); got != tt.want {
+				t.Errorf("cleanPath(%q) = %q, want %q", tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPrintable(t *testing.T) {
+	tests := []struct {
+		name string
+		s    string
+		want string
+	}{
+		{"plain", "src/main.go", "src/main.go"},
+		{"controls", "a\x00b\x1bc\x7f", "abc"},
+		{"new lines and tabs", "a\nb\tc", "a\nb\tc"},
+		{"cjk", "源码/文件.go", "源码/文件.go"},
+		{"emoji", "🔥🚀.txt", "🔥🚀.txt"},
+		{"invalid bytes", "a\xffb", "a�b"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := printable(tt.s); got != tt.want {
+				t.Errorf("printable(%q) = %q, want %q", tt.s, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSVGText(t *testing.T) {
+	tests := []struct {
+		name string
+		s    string
+		want string
+	}{
+		{"markup", `<a href="x">&</a>`, "&lt;a href=&#34;x&#34;&gt;&amp;&lt;/a&gt;"},
+		{"cjk", "日本語/ファイル.ts", "日本語/ファイル.ts"},
+		{"emoji", "🔥 hot.go", "🔥 hot.go"},
+		{"controls", "a\x01b", "ab"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := svgText(tt.s); got != tt.want {
+				t.Errorf("svgText(%q) = %q, want %q", tt.s, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDOTString(t *testing.T) {
+	tests := []struct {
+		name string
+		s    string
+		want string
+	}{
+		{"plain", "acme/api/src/", `"acme/api/src/"`},
+		{"quotes and backslashes", `a"b\c`, `"a\"b\\c"`},
+		{"new line", "a\nb", `"a\nb"`},
+		{"cjk", "acme/api/源码/", `"acme/api/源码/"`},
+		{"emoji", "acme/api/🔥/", `"acme/api/🔥/"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := dotString(tt.s); got != tt.want {
+				t.Errorf("dotString(%q) = %s, want %s", tt.s, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDisplayWidth(t *testing.T) {
+	tests := []struct {
+		name string
+		s    string
+		want int
+	}{
+		{"empty", "", 0},
+		{"ascii", "main.go", 7},
+		{"latin", "caf\u00e9", 4},
+		{"combining mark", "cafe\u0301", 4},
+		{"cjk", "说明", 4},
+		{"kana", "ファイル", 8},
+		{"hangul", "한글", 4},
+		{"fullwidth", "ＡＢ", 4},
+		{"halfwidth kana", "ｶﾅ", 2},
+		{"emoji", "🔥", 2},
+		{"emoji with variation selector", "❤️", 1},
+		{"mixed", "src/文档/🔥.md", 14},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := displayWidth(tt.s); got != tt.want {
+				t.Errorf("displayWidth(%q) = %d, want %d", tt.s, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,254 @@
+package cmd
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// payloadsCmd represents the payloads command
+var payloadsCmd = &cobra.Command{
+	Use:   "payloads <key>",
+	Short: "Prints the raw payloads of the providers stored for a bug or a PR",
+	Long: `Prints the raw JSON responses stored for the key, from the
+oldest one, one per line: the dev-status responses of Jira for the ID
+of an issue, e.g. 10234, and the file lists of GitHub for a PR, e.g.
+acme/billing#42, so a parser bug can be diagnosed from the data of
+production without fetching it again.
+
+The payloads are only stored with debug.raw_payloads set, gzipped, in
+the payloads collection of the store; they expire after
+debug.raw_payloads_ttl (default 168h), e.g.
+  "debug": {"raw_payloads": true, "raw_payloads_ttl": "72h"}
+Every page of a paginated response is a payload of its own.
+
+The payloads hold the authors of the PRs as the providers return
+them, so they can't be recorded unless privacy.authors is keep; scrub
+removes the ones stored before and purge the ones of the project.`,
+	Args: cobra.ExactArgs(1),
+	RunE: payloads,
+}
+
+const defaultRawPayloadsTTL = 7 * 24 * time.Hour
+
+// The sources of the raw payloads
+const (
+	payloadDevStatus   = "jira_dev_status"
+	payloadGitHubFiles = "github_files"
+)
+
+var (
+	// devStatusPath matches the path of the dev-status requests of Jira
+	devStatusPath = regexp.MustCompile(`/rest/dev-status/[^/]+/issue/detail$`)
+	// githubFilesPath matches the path of the file lists of the GitHub
+	// PRs, capturing the owner, the name and the number
+	githubFilesPath = regexp.MustCompile(`/repos/([^/]+)/([^/]+)/pulls/(\d+)/files$`)
+)
+
+// rawPayload represents a raw response of a provider, gzipped
+type rawPayload struct {
+	Source    string    `bson:"source" json:"source"`
+	Key       string    `bson:"key" json:"key"`
+	URL       string    `bson:"url" json:"url"`
+	FetchedAt time.Time `bson:"fetched_at" json:"fetched_at"`
+	ExpiresAt time.Time `bson:"expires_at" json:"expires_at"`
+	Data      []byte    `bson:"data" json:"data"`
+}
+
+// payloadRecorder holds the payloads recorded since they were last saved
+var payloadRecorder struct {
+	sync.Mutex
+	payloads []rawPayload
+}
+
+func init() {
+	rootCmd.AddCommand(payloadsCmd)
+}
+
+// payloadTransport records the raw responses of the dev-status and the
+// file list requests, with debug.raw_payloads set
+type payloadTransport struct {
+	next http.RoundTripper
+	ttl  time.Duration
+}
+
+// newPayloadTransport returns next as is unless debug.raw_payloads is
+// set. Nothing is recorded unless privacy.authors is keep, the payloads
+// holding the authors as the providers return them.
+func newPayloadTransport(next http.RoundTripper) http.RoundTripper {
+	if !viper.GetBool("debug.raw_payloads") {
+		return next
+	}
+	if mode := newAuthorPrivacy().mode; mode != privacyKeep {
+		slog.Warn("raw payloads not recorded", "privacy.authors", mode)
+		return next
+	}
+	viper.SetDefault("debug.raw_payloads_ttl", defaultRawPayloadsTTL)
+
+	return &payloadTransport{next: next, ttl: viper.GetDuration("debug.raw_payloads_ttl")}
+}
+
+func (t *payloadTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.next.RoundTrip(req)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		return resp, err
+	}
+
+	source, key := payloadSource(req)
+	if source == "" {
+		return resp, nil
+	}
+
+	raw, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(raw))
+	if err != nil {
+		return resp, nil
+	}
+
+	data, err := gzipPayload(raw)
+	if err != nil {
+		slog.Warn("compressing the payload failed", "url", req.URL.String(), "err", err)
+		return resp, nil
+	}
+	now := time.Now().UTC()
+	payloadRecorder.Lock()
+	payloadRecorder.payloads = append(payloadRecorder.payloads, rawPayload{
+		Source:    source,
+		Key:       key,
+		URL:       req.URL.String(),
+		FetchedAt: now,
+		ExpiresAt: now.Add(t.ttl),
+		Data:      data,
+	})
+	payloadRecorder.Unlock()
+
+	return resp, nil
+}
+
+// payloadSource returns the source and the key of the payload of the
+// request, an empty source if it isn't recorded
+func payloadSource(req *http.Request) (string, string) {
+	if req.Method != "GET" {
+		return "", ""
+	}
+	if devStatusPath.MatchString(req.URL.Path) {
+		return payloadDevStatus, req.URL.Query().Get("issueId")
+	}
+	if m := githubFilesPath.FindStringSubmatch(req.URL.Path); m != nil {
+		return payloadGitHubFiles, fmt.Sprintf("%s/%s#%s", m[1], m[2], m[3])
+	}
+
+	return "", ""
+}
+
+func gzipPayload(raw []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(raw); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func gunzipPayload(data []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	return io.ReadAll(r)
+}
+
+// savePayloads writes the recorded payloads to the store. A failure is
+// logged, the payloads are only kept for debugging.
+func savePayloads(ctx context.Context, st store) {
+	payloadRecorder.Lock()
+	payloads := payloadRecorder.payloads
+	payloadRecorder.payloads = nil
+	payloadRecorder.Unlock()
+
+	if len(payloads) == 0 {
+		return
+	}
+	if err := st.SavePayloads(ctx, payloads); err != nil {
+		slog.Warn("writing the raw payloads failed", "payloads", len(payloads), "err", err)
+		return
+	}
+	slog.Debug("raw payloads written", "payloads", len(payloads))
+}
+
+// unexpiredPayloads drops the payloads which expired before now
+func unexpiredPayloads(payloads []rawPayload, now time.Time) []rawPayload {
+	result := make([]rawPayload, 0, len(payloads))
+	for _, p := range payloads {
+		if p.ExpiresAt.After(now) {
+			result = append(result, p)
+		}
+	}
+
+	return result
+}
+
+func payloads(cmd *cobra.Command, args []string) error {
+	ctx, cancel, st, err := openReadStore()
+	if err != nil {
+		return err
+	}
+	defer cancel()
+	defer closeStore(ctx, st)
+
+	stored, err := st.Payloads(ctx, args[0])
+	if err != nil {
+		return storageError(fmt.Errorf("reading payloads failed: %w", err))
+	}
+	if len(stored) == 0 {
+		return fmt.Errorf("no payloads stored for %s", args[0])
+	}
+
+	return writePayloads(os.Stdout, stored)
+}
+
+// writePayloads writes the payloads as JSON lines holding their sources,
+// URLs, fetch times and decompressed data
+func writePayloads(w io.Writer, stored []rawPayload) error {
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	for _, p := range stored {
+		raw, err := gunzipPayload(p.Data)
+		if err != nil {
+			return fmt.Errorf("decompressing the payload of %s failed: %w", p.URL, err)
+		}
+		line := struct {
+			Source    string          `json:"source"`
+			URL       string          `json:"url"`
+			FetchedAt time.Time       `json:"fetched_at"`
+			Payload   json.RawMessage `json:"payload"`
+		}{p.Source, p.URL, p.FetchedAt, raw}
+		if !json.Valid(raw) {
+			line.Payload, _ = json.Marshal(string(raw))
+		}
+		if err := enc.Encode(line); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,234 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+const (
+	pluginPrefix = "heatmap-"
+	// pluginCommand annotates the commands running a plugin
+	pluginCommand = "plugin"
+)
+
+// pluginsCmd represents the plugins command
+var pluginsCmd = &cobra.Command{
+	Use:   "plugins",
+	Short: "Lists the plugins found on PATH",
+	Long: `Lists the heatmap-<name> executables found on PATH. Each one runs
+as heatmap <name>, e.g. heatmap-slack-digest as heatmap slack-digest,
+all the arguments after the name being passed to it, so teams can
+extend the CLI without forking it. The flags before the name, e.g.
+--config or -v, are the flags of heatmap.
+
+A plugin runs with the environment of heatmap and:
+  HEATMAP_CONFIG_FILE              the config file in use, if any
+  HEATMAP_STORAGE_DRIVER           sqlite or mongo
+  HEATMAP_SQLITE_PATH              the database of sqlite
+  HEATMAP_MONGO_URI                the connection string of mongo
+  HEATMAP_MONGO_DBNAME             the database of mongo
+  HEATMAP_MONGO_COLLECTIONS_<NAME> the collections of mongo
+  HEATMAP_HTTP_CORRELATION_ID      the correlation ID of the run
+so that it reaches the same store, and the heatmap commands it runs
+share the correlation ID. The exit code of heatmap is the one of the
+plugin.
+
+The first executable of a name on PATH wins; the plugins named like
+a command of heatmap are shadowed by the command and listed as such.`,
+	Annotations: map[string]string{optionalConfig: ""},
+	Args:        cobra.NoArgs,
+	RunE:        listPlugins,
+}
+
+func init() {
+	rootCmd.AddCommand(pluginsCmd)
+}
+
+// plugin represents a heatmap-<name> executable
+type plugin struct {
+	name string
+	path string
+}
+
+// findPlugins lists the executables of PATH named heatmap-<name>, the
+// first one of a name only
+func findPlugins() []plugin {
+	plugins := make([]plugin, 0)
+	seen := make(map[string]bool)
+	for _, dir := range filepath.SplitList(os.Getenv("PATH")) {
+		if dir == "" {
+			dir = "."
+		}
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+
+		for _, e := range entries {
+			name := e.Name()
+			if !strings.HasPrefix(name, pluginPrefix) || e.IsDir() {
+				continue
+			}
+			if runtime.GOOS == "windows" {
+				if !strings.EqualFold(filepath.Ext(name), ".exe") {
+					continue
+				}
+				name = strings.TrimSuffix(name, filepath.Ext(name))
+			} else if info, err := e.Info(); err != nil || info.Mode()&0o111 == 0 {
+				continue
+			}
+
+			name = strings.TrimPrefix(name, pluginPrefix)
+			if name == "" || seen[name] {
+				continue
+			}
+			seen[name] = true
+			plugins = append(plugins, plugin{name: name, path: filepath.Join(dir, e.Name())})
+		}
+	}
+
+	sort.Slice(plugins, func(i, j int) bool { return plugins[i].name < plugins[j].name })
+
+	return plugins
+}
+
+// addPluginCommands adds a command running every plugin which isn't
+// shadowed by a command of heatmap. Its flags aren't parsed, they're
+// passed to the plugin. It's called once all the commands are added.
+func addPluginCommands() {
+	for _, p := range findPlugins() {
+		p := p
+		if builtinCommand(p.name) != "" {
+			continue
+		}
+
+		rootCmd.AddCommand(&cobra.Command{
+			Use:                p.name,
+			Short:              "Runs the plugin " + p.path,
+			DisableFlagParsing: true,
+			Annotations:        map[string]string{optionalConfig: "", pluginCommand: p.path},
+			RunE: func(cmd *cobra.Command, args []string) error {
+				_, pluginArgs := splitPluginArgs(cmd, os.Args[1:])
+				return runPlugin(p, pluginArgs)
+			},
+		})
+	}
+}
+
+// builtinCommand returns the command of heatmap the name or an alias of
+// which is the name, if any
+func builtinCommand(name string) string {
+	for _, c := range rootCmd.Commands() {
+		if _, ok := c.Annotations[pluginCommand]; ok {
+			continue
+		}
+		if c.Name() == name || c.HasAlias(name) {
+			return c.Name()
+		}
+	}
+	if name == "help" || name == "completion" {
+		return name
+	}
+
+	return ""
+}
+
+// splitPluginArgs splits the arguments at the name of the command of the
+// plugin into the flags of heatmap and the arguments of the plugin
+func splitPluginArgs(cmd *cobra.Command, args []string) ([]string, []string) {
+	flags := cmd.Root().PersistentFlags()
+	for i := 0; i < len(args); i++ {
+		a := args[i]
+		if a == cmd.Name() {
+			return args[:i], args[i+1:]
+		}
+		if !strings.HasPrefix(a, "-") || strings.Contains(a, "=") {
+			continue
+		}
+
+		// The value of a flag other than a boolean is the next argument
+		f := flags.Lookup(strings.TrimLeft(a, "-"))
+		if len(a) == 2 {
+			f = flags.ShorthandLookup(a[1:])
+		}
+		if f != nil && f.NoOptDefVal == "" {
+			i++
+		}
+	}
+
+	return nil, args
+}
+
+// pluginEnv returns the environment passing the config and the store
+// connection to the plugins
+func pluginEnv() []string {
+	env := []string{
+		"HEATMAP_CONFIG_FILE=" + viper.ConfigFileUsed(),
+		"HEATMAP_HTTP_CORRELATION_ID=" + runID(),
+	}
+	switch driver := storeName(); driver {
+	case "sqlite":
+		env = append(env, "HEATMAP_STORAGE_DRIVER="+driver, "HEATMAP_SQLITE_PATH="+viper.GetString("sqlite.path"))
+	case "mongo":
+		dbname := viper.GetString("mongo.dbname")
+		uri := fmt.Sprintf(viper.GetString("mongo.srv"), viper.GetString("mongo.user"), viper.GetString("mongo.password"), dbname)
+		env = append(env, "HEATMAP_STORAGE_DRIVER="+driver, "HEATMAP_MONGO_URI="+uri, "HEATMAP_MONGO_DBNAME="+dbname)
+		for _, c := range []string{"jira", "github", "sync", "reports", "archive", "repos", "annotations", "payloads"} {
+			env = append(env, fmt.Sprintf("HEATMAP_MONGO_COLLECTIONS_%s=%s", strings.ToUpper(c), viper.GetString("mongo.collections."+c)))
+		}
+	}
+
+	return env
+}
+
+// runPlugin runs the plugin with the standard streams of heatmap. A
+// failed plugin fails heatmap with its exit code.
+func runPlugin(p plugin, args []string) error {
+	slog.Debug("running plugin", "name", p.name, "path", p.path)
+
+	cmd := exec.Command(p.path, args...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.Env = append(os.Environ(), pluginEnv()...)
+
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return &classifiedError{code: exitErr.ExitCode(), err: fmt.Errorf("plugin %s failed: %w", p.name, err)}
+	}
+	if err != nil {
+		return fmt.Errorf("running plugin %s failed: %w", p.name, err)
+	}
+
+	return nil
+}
+
+func listPlugins(cmd *cobra.Command, args []string) error {
+	plugins := findPlugins()
+	if len(plugins) == 0 {
+		fmt.Fprintln(os.Stdout, "No heatmap-<name> executables found on PATH")
+		return nil
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tPATH\tNOTE")
+	for _, p := range plugins {
+		note := ""
+		if c := builtinCommand(p.name); c != "" {
+			note = "shadowed by the command " + c
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", p.name, p.path, note)
+	}
+
+	return tw.Flush()
+}
@@ -0,0 +1,40 @@
+package cmd
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io"
+	"math"
+
+	"rdlf0/heatmap/render"
+)
+
+// pngRenderer renders the treemap of the svg renderer as a PNG image.
+// The image has no labels, it's meant for a quick glance.
+type pngRenderer struct{}
+
+func init() {
+	render.Register("png", pngRenderer{})
+}
+
+func (pngRenderer) Extension() string {
+	return "png"
+}
+
+func (pngRenderer) Render(w io.Writer, view render.View) error {
+	img := image.NewRGBA(image.Rect(0, 0, treemapWidth, treemapHeight))
+	draw.Draw(img, img.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+
+	for i, c := range treemapCells(view.Files) {
+		// Every cell keeps a white border to the next one
+		r := image.Rect(
+			int(math.Round(c.X)), int(math.Round(c.Y)),
+			int(math.Round(c.X+c.W))-1, int(math.Round(c.Y+c.H))-1,
+		)
+		draw.Draw(img, r, image.NewUniform(heatColor(view.Files[i].Risk)), image.Point{}, draw.Src)
+	}
+
+	return png.Encode(w, img)
+}
@@ -0,0 +1,67 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+
+	"github.com/spf13/viper"
+)
+
+// prSearchRule represents the fallback search of the PRs of the bugs of a
+// project which have none linked in Jira
+type prSearchRule struct {
+	Project string   `mapstructure:"project"`
+	Scope   []string `mapstructure:"scope"`
+	// SkipCommits limits the search to the titles and the bodies of the PRs
+	SkipCommits bool `mapstructure:"skip_commits"`
+}
+
+// prSearchRules reads backfill.pr_search by the names of the projects
+func prSearchRules() (map[string]prSearchRule, error) {
+	rules := make([]prSearchRule, 0)
+	if err := viper.UnmarshalKey("backfill.pr_search", &rules); err != nil {
+		return nil, fmt.Errorf("invalid backfill.pr_search: %w", err)
+	}
+
+	byProject := make(map[string]prSearchRule, len(rules))
+	for i, r := range rules {
+		if r.Project == "" {
+			return nil, fmt.Errorf("rule %d of backfill.pr_search has no project", i+1)
+		}
+		// An unscoped search would go through all of the public code
+		if len(r.Scope) == 0 {
+			return nil, fmt.Errorf("the PR search of project %s has no scope", r.Project)
+		}
+		byProject[r.Project] = r
+	}
+
+	return byProject, nil
+}
+
+// mentionsKey returns the matcher of the mentions of an issue key, which
+// are case-insensitive as the branch names are often lower case
+func mentionsKey(key string) *regexp.Regexp {
+	return regexp.MustCompile(`(?i)\b` + regexp.QuoteMeta(key) + `\b`)
+}
+
+// searchLinkedPRs finds the PRs of a bug by its key, as configured by the
+// project's rule. It returns errNoDevStatus if there's no rule for the
+// project or the provider can't search, like findDevStatus when Jira
+// knows no PRs.
+func searchLinkedPRs(ctx context.Context, provider vcsProvider, rule *prSearchRule, b bug) (*[]jiraPR, error) {
+	searcher, ok := provider.(prSearcher)
+	if rule == nil || !ok || b.Key == "" {
+		return nil, errNoDevStatus
+	}
+
+	prs, err := searcher.searchPRs(ctx, b.Key, rule.Scope, !rule.SkipCommits)
+	if err != nil {
+		return nil, err
+	}
+	if len(prs) == 0 {
+		return nil, errNoDevStatus
+	}
+
+	return &prs, nil
+}
@@ -0,0 +1,223 @@
+package cmd
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// The modes of privacy.authors
+const (
+	privacyKeep = "keep"
+	privacyHash = "hash"
+	privacyDrop = "drop"
+)
+
+var (
+	// anonymousPattern matches the pseudonyms of the hash mode, which
+	// aren't hashed again
+	anonymousPattern = regexp.MustCompile(`^anon-[0-9a-f]{12}$`)
+	// trailerPattern matches a trailer of a commit message naming a
+	// person, e.g. Signed-off-by: Jane Doe <jane@example.com>, capturing
+	// the trailer, the person and the email
+	trailerPattern = regexp.MustCompile(`(?i)^\s*([a-z]+(?:-[a-z]+)*-by):\s*(.*?)\s*(?:<([^>]*)>)?\s*$`)
+)
+
+// scrubCmd represents the scrub command
+var scrubCmd = &cobra.Command{
+	Use:   "scrub",
+	Short: "Applies the privacy mode to the stored diffs",
+	Long: `Applies privacy.authors to the diffs of the PRs stored before it
+was set, including the archived ones, so no document keeps the
+personal data of the authors.
+
+privacy.authors selects what is stored of the login or the email of
+the author of a PR, of the people among the CODEOWNERS owners of a
+file, the teams being kept, and of the people of the trailers of the
+commit messages, e.g. Signed-off-by or Co-authored-by:
+  keep   the default, as the provider returns them
+  hash   a pseudonym, anon- and 12 hex digits of the HMAC-SHA256 of
+         the lowercased login keyed with privacy.salt, e.g. set as
+         HEATMAP_PRIVACY_SALT; the same person gets the same pseudonym
+         so the counts by author still add up
+  drop   nothing; the counts by author then all go to unknown
+The aliases of identities.people are hashed as well in the hash mode,
+so the pseudonyms still resolve to the configured people.
+
+The raw payloads of debug.raw_payloads hold the authors as the
+providers return them, so they are all removed.
+
+Neither mode can be undone: a hashed or dropped author can only be
+restored by resetting the prs collection and collecting the diffs
+again.`,
+	RunE: scrub,
+}
+
+func init() {
+	rootCmd.AddCommand(scrubCmd)
+	scrubCmd.Flags().BoolVar(&confirmed, "yes", false, "confirm the rewrite")
+}
+
+// authorPrivacy represents the privacy mode of the stored documents
+type authorPrivacy struct {
+	mode string
+	salt []byte
+}
+
+func newAuthorPrivacy() authorPrivacy {
+	viper.SetDefault("privacy.authors", privacyKeep)
+
+	return authorPrivacy{
+		mode: strings.ToLower(viper.GetString("privacy.authors")),
+		salt: []byte(viper.GetString("privacy.salt")),
+	}
+}
+
+// person returns what is stored of a login or an email
+func (a authorPrivacy) person(login string) string {
+	switch {
+	case login == "" || anonymousPattern.MatchString(login):
+		return login
+	case a.mode == privacyHash:
+		mac := hmac.New(sha256.New, a.salt)
+		mac.Write([]byte(strings.ToLower(strings.TrimSpace(login))))
+		return "anon-" + hex.EncodeToString(mac.Sum(nil))[:12]
+	case a.mode == privacyDrop:
+		return ""
+	}
+
+	return login
+}
+
+// owners returns what is stored of the CODEOWNERS owners of a file. The
+// teams, @org/team, are kept.
+func (a authorPrivacy) owners(owners []string) []string {
+	if a.mode == privacyKeep || len(owners) == 0 {
+		return owners
+	}
+
+	kept := make([]string, 0, len(owners))
+	for _, o := range owners {
+		if !strings.Contains(o, "/") {
+			if o = a.person(strings.TrimPrefix(o, "@")); o == "" {
+				continue
+			}
+		}
+		kept = append(kept, o)
+	}
+
+	return kept
+}
+
+// message returns what is stored of a commit message. The people of its
+// trailers are hashed, by their emails if they have any, or the
+// trailers are dropped.
+func (a authorPrivacy) message(msg string) string {
+	if a.mode == privacyKeep {
+		return msg
+	}
+
+	lines := strings.Split(msg, "\n")
+	kept := make([]string, 0, len(lines))
+	for _, l := range lines {
+		m := trailerPattern.FindStringSubmatch(l)
+		if m == nil || (m[3] == "" && anonymousPattern.MatchString(m[2])) {
+			kept = append(kept, l)
+			continue
+		}
+		if a.mode == privacyDrop {
+			continue
+		}
+		person := m[2]
+		if m[3] != "" {
+			person = m[3]
+		}
+		kept = append(kept, m[1]+": "+a.person(person))
+	}
+
+	return strings.Join(kept, "\n")
+}
+
+// apply applies the mode to a PR and tells whether it changed
+func (a authorPrivacy) apply(p *pr) bool {
+	if a.mode == privacyKeep {
+		return false
+	}
+
+	changed := false
+	if author := a.person(p.Author); author != p.Author {
+		p.Author = author
+		changed = true
+	}
+	diffs := [][]diff{p.Diff}
+	for i := range p.Commits {
+		if message := a.message(p.Commits[i].Message); message != p.Commits[i].Message {
+			p.Commits[i].Message = message
+			changed = true
+		}
+		diffs = append(diffs, p.Commits[i].Diff)
+	}
+	for _, ds := range diffs {
+		for i := range ds {
+			owners := a.owners(ds[i].Owners)
+			if strings.Join(owners, " ") != strings.Join(ds[i].Owners, " ") {
+				ds[i].Owners = owners
+				changed = true
+			}
+		}
+	}
+
+	return changed
+}
+
+func scrub(cmd *cobra.Command, args []string) error {
+	privacy := newAuthorPrivacy()
+	if privacy.mode == privacyKeep {
+		return configError(fmt.Errorf("privacy.authors is %s, set it to hash or drop", privacyKeep))
+	}
+	if !confirmed {
+		return configError(fmt.Errorf("scrubbing rewrites the authors of the stored diffs for good, confirm with --yes"))
+	}
+
+	ctx, cancel, st, err := openStore()
+	if err != nil {
+		return err
+	}
+	defer cancel()
+	defer closeStore(ctx, st)
+
+	prs, err := st.PRs(ctx)
+	if err != nil {
+		return storageError(fmt.Errorf("reading diffs failed: %w", err))
+	}
+
+	changed := make([]pr, 0)
+	for _, p := range prs {
+		if privacy.apply(&p) {
+			changed = append(changed, p)
+		}
+	}
+	if len(changed) > 0 {
+		if err := st.InsertPRs(ctx, changed); err != nil {
+			return storageError(fmt.Errorf("writing diffs failed: %w", err))
+		}
+	}
+
+	archived, err := st.ScrubArchive(ctx, privacy.apply)
+	if err != nil {
+		return storageError(fmt.Errorf("scrubbing archive failed: %w", err))
+	}
+	if err := st.Reset(ctx, "payloads"); err != nil {
+		return storageError(fmt.Errorf("removing raw payloads failed: %w", err))
+	}
+	slog.Info("diffs scrubbed", "mode", privacy.mode, "prs", len(prs), "changed", len(changed), "archived", archived)
+
+	return nil
+}
@@ -0,0 +1,53 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// progress displays the state of a long running collection in place.
+// It stays silent unless stderr is a terminal.
+type progress struct {
+	out   io.Writer
+	off   bool
+	total int
+	done  int
+	start time.Time
+}
+
+func newProgress(total int) *progress {
+	return &progress{out: os.Stderr, off: !interactive(), total: total, start: time.Now()}
+}
+
+// step marks one more item as done and redraws the progress line
+func (p *progress) step(u apiUsage) {
+	p.done++
+	if p.off {
+		return
+	}
+
+	elapsed := time.Since(p.start)
+	line := fmt.Sprintf("[%d/%d]", p.done, p.total)
+	if u.Limit > 0 {
+		line += fmt.Sprintf(" rate limit %d/%d", u.Remaining, u.Limit)
+	}
+	if secs := elapsed.Seconds(); secs > 0 {
+		line += fmt.Sprintf(" %.1f req/s", float64(u.Requests)/secs)
+	}
+	if p.done < p.total {
+		eta := elapsed / time.Duration(p.done) * time.Duration(p.total-p.done)
+		line += fmt.Sprintf(" ETA %s", eta.Round(time.Second))
+	}
+
+	// \r moves back to the start of the line and \033[K clears what's left of the previous one
+	fmt.Fprintf(p.out, "\r%s\033[K", line)
+}
+
+// finish ends the progress line
+func (p *progress) finish() {
+	if p.done > 0 && !p.off {
+		fmt.Fprintln(p.out)
+	}
+}
@@ -0,0 +1,147 @@
+package cmd
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// pruneCmd represents the prune command
+var pruneCmd = &cobra.Command{
+	Use:   "prune",
+	Short: "Removes the mappings and the diffs older than the retention",
+	Long: `Removes the mappings of the bugs resolved more than --days, or
+retention.days, ago, and the diffs of the PRs which only such
+mappings map to, so the working set and the heat stay focused on
+the recent history. The age of a bug resolved at an unknown time is
+the one of its PR, if it's known; the others are kept.
+
+With --archive, or retention.archive set, the removed documents are
+moved to the archive collection first, from which export reads them
+back. Without it they're removed for good. --dry-run only counts
+them.
+
+A backfill reaching further back than the retention, e.g. with
+--since, maps the pruned bugs again; run prune after it.`,
+	RunE: prune,
+}
+
+var (
+	pruneDays    int
+	pruneArchive bool
+	pruneDryRun  bool
+)
+
+func init() {
+	rootCmd.AddCommand(pruneCmd)
+	pruneCmd.Flags().IntVar(&pruneDays, "days", 0, "retention in days (default retention.days)")
+	pruneCmd.Flags().BoolVar(&pruneArchive, "archive", false, "move the removed documents to the archive collection (default retention.archive)")
+	pruneCmd.Flags().BoolVar(&pruneDryRun, "dry-run", false, "only count the documents to remove")
+	pruneCmd.Flags().BoolVar(&confirmed, "yes", false, "confirm the removal")
+}
+
+// archivedDoc represents a mapping or the diff of a PR moved to the
+// archive collection
+type archivedDoc struct {
+	Kind       string        `bson:"kind" json:"kind"`
+	ArchivedAt time.Time     `bson:"archived_at" json:"archived_at"`
+	Mapping    *mongoMapping `bson:"mapping,omitempty" json:"mapping,omitempty"`
+	PR         *pr           `bson:"pr,omitempty" json:"pr,omitempty"`
+}
+
+// archivedDocs wraps the mappings and the PRs into the documents of the
+// archive collection
+func archivedDocs(mappings []mongoMapping, prs []pr, now time.Time) []archivedDoc {
+	docs := make([]archivedDoc, 0, len(mappings)+len(prs))
+	for i := range mappings {
+		docs = append(docs, archivedDoc{Kind: "mapping", ArchivedAt: now, Mapping: &mappings[i]})
+	}
+	for i := range prs {
+		docs = append(docs, archivedDoc{Kind: "pr", ArchivedAt: now, PR: &prs[i]})
+	}
+
+	return docs
+}
+
+// staleData returns the mappings older than the cutoff and the PRs
+// which only they map to
+func staleData(mappings []mongoMapping, prs []pr, cutoff time.Time) ([]mongoMapping, []pr) {
+	merged := make(map[string]time.Time, len(prs))
+	for _, p := range prs {
+		merged[prKey(p.Repo, p.PRID)] = p.MergedAt
+	}
+
+	staleMappings := make([]mongoMapping, 0)
+	fresh := make(map[string]bool)
+	stale := make(map[string]bool)
+	for _, m := range mappings {
+		k := prKey(m.Repo, m.PRID)
+		age := m.ResolvedAt
+		if age.IsZero() {
+			age = merged[k]
+		}
+		if age.IsZero() || !age.Before(cutoff) {
+			fresh[k] = true
+			continue
+		}
+		stale[k] = true
+		staleMappings = append(staleMappings, m)
+	}
+
+	stalePRs := make([]pr, 0)
+	for _, p := range prs {
+		if k := prKey(p.Repo, p.PRID); stale[k] && !fresh[k] {
+			stalePRs = append(stalePRs, p)
+		}
+	}
+
+	return staleMappings, stalePRs
+}
+
+func prune(cmd *cobra.Command, args []string) error {
+	if !cmd.Flags().Changed("days") {
+		pruneDays = viper.GetInt("retention.days")
+	}
+	if !cmd.Flags().Changed("archive") {
+		pruneArchive = viper.GetBool("retention.archive")
+	}
+	if pruneDays <= 0 {
+		return configError(fmt.Errorf("no retention, set --days or retention.days"))
+	}
+	if !confirmed && !pruneDryRun {
+		return configError(fmt.Errorf("pruning removes the data older than %d days, confirm with --yes", pruneDays))
+	}
+
+	ctx, cancel, st, err := openStore()
+	if err != nil {
+		return err
+	}
+	defer cancel()
+	defer closeStore(ctx, st)
+
+	mappings, err := st.Mappings(ctx)
+	if err != nil {
+		return storageError(fmt.Errorf("reading mappings failed: %w", err))
+	}
+	prs, err := st.PRs(ctx)
+	if err != nil {
+		return storageError(fmt.Errorf("reading diffs failed: %w", err))
+	}
+
+	cutoff := time.Now().UTC().AddDate(0, 0, -pruneDays)
+	staleMappings, stalePRs := staleData(mappings, prs, cutoff)
+	if pruneDryRun || len(staleMappings)+len(stalePRs) == 0 {
+		slog.Info("stale data found", "before", cutoff.Format("2006-01-02"), "mappings", len(staleMappings), "prs", len(stalePRs))
+		return nil
+	}
+
+	if err := st.Prune(ctx, staleMappings, stalePRs, pruneArchive); err != nil {
+		return storageError(fmt.Errorf("pruning failed: %w", err))
+	}
+	slog.Info("stale data pruned", "before", cutoff.Format("2006-01-02"), "mappings", len(staleMappings), "prs", len(stalePRs), "archived", pruneArchive)
+
+	return nil
+}
@@ -0,0 +1,179 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// recurrenceCmd represents the analyze recurrence command
+var recurrenceCmd = &cobra.Command{
+	Use:   "recurrence",
+	Short: "Flags the files where bugs recur shortly after a fix",
+	Long: `Orders the bug fixes of every file by their merge time and
+flags the files where a fix of another bug is merged within the
+given number of days of a prior fix. These are the places where
+the fixes repeatedly fail to stick.
+
+The PRs collected without a merge time are not considered.`,
+	RunE: recurrence,
+}
+
+var (
+	recurrenceWithin int
+	recurrenceFormat string
+)
+
+const defaultRecurrenceWithin = 30
+
+// fileRecurrence represents the recurring bugs of a single file
+type fileRecurrence struct {
+	Repo        Repo      `json:"repo"`
+	File        string    `json:"file"`
+	Fixes       int       `json:"fixes"`
+	Recurrences int       `json:"recurrences"`
+	MinInterval float64   `json:"min_interval_days"`
+	LastFix     time.Time `json:"last_fix"`
+}
+
+// fileFix represents a fix of a bug merged into a file
+type fileFix struct {
+	bug      string
+	mergedAt time.Time
+}
+
+func init() {
+	analyzeCmd.AddCommand(recurrenceCmd)
+	recurrenceCmd.Flags().IntVar(&recurrenceWithin, "within", defaultRecurrenceWithin, "days after a fix in which another fix counts as a recurrence")
+	recurrenceCmd.Flags().StringVar(&recurrenceFormat, "format", "table", "output format: table or json")
+}
+
+func recurrence(cmd *cobra.Command, args []string) error {
+	write, ok := recurrenceWriters[recurrenceFormat]
+	if !ok {
+		return configError(fmt.Errorf("unknown report format %q", recurrenceFormat))
+	}
+	if recurrenceWithin <= 0 {
+		return configError(fmt.Errorf("--within must be positive"))
+	}
+
+	ctx, cancel, st, err := openReadStore()
+	if err != nil {
+		return err
+	}
+	defer cancel()
+	defer closeStore(ctx, st)
+
+	mappings, err := st.Mappings(ctx)
+	if err != nil {
+		return storageError(fmt.Errorf("reading mappings failed: %w", err))
+	}
+
+	prs, err := st.PRs(ctx)
+	if err != nil {
+		return storageError(fmt.Errorf("reading diffs failed: %w", err))
+	}
+
+	within := time.Duration(recurrenceWithin) * 24 * time.Hour
+
+	return write(os.Stdout, computeRecurrence(mappings, prs, within))
+}
+
+// computeRecurrence returns the files with at least one recurrence, sorted
+// by the number of recurrences. A recurrence is a fix of another bug merged
+// within the window after the previous fix of the file.
+func computeRecurrence(mappings []mongoMapping, prs []pr, within time.Duration) []fileRecurrence {
+	bugsByPR := make(map[string][]string)
+	for _, m := range mappings {
+		k := prKey(m.Repo, m.PRID)
+		bugsByPR[k] = append(bugsByPR[k], m.bugKey())
+	}
+
+	fixes := make(map[string][]fileFix)
+	repos := make(map[string]Repo)
+	names := make(map[string]string)
+	for _, p := range prs {
+		bugs, ok := bugsByPR[prKey(p.Repo, p.PRID)]
+		if !ok || p.MergedAt.IsZero() {
+			continue
+		}
+
+		for _, d := range p.Diff {
+			k := fileKey(p.Repo, d.File)
+			repos[k], names[k] = p.Repo, d.File
+			for _, b := range bugs {
+				fixes[k] = append(fixes[k], fileFix{bug: b, mergedAt: p.MergedAt})
+			}
+		}
+	}
+
+	result := make([]fileRecurrence, 0)
+	for k, ff := range fixes {
+		sort.Slice(ff, func(i, j int) bool { return ff[i].mergedAt.Before(ff[j].mergedAt) })
+
+		r := fileRecurrence{Repo: repos[k], File: names[k], LastFix: ff[len(ff)-1].mergedAt}
+		fixed := make(map[string]bool)
+		var prev *fileFix
+		for i := range ff {
+			f := &ff[i]
+			if fixed[f.bug] {
+				continue
+			}
+			fixed[f.bug] = true
+
+			if prev != nil {
+				if interval := f.mergedAt.Sub(prev.mergedAt); interval <= within {
+					days := interval.Hours() / 24
+					if r.Recurrences == 0 || days < r.MinInterval {
+						r.MinInterval = days
+					}
+					r.Recurrences++
+				}
+			}
+			prev = f
+		}
+		r.Fixes = len(fixed)
+
+		if r.Recurrences > 0 {
+			result = append(result, r)
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Recurrences != result[j].Recurrences {
+			return result[i].Recurrences > result[j].Recurrences
+		}
+		return fileKey(result[i].Repo, result[i].File) < fileKey(result[j].Repo, result[j].File)
+	})
+
+	return result
+}
+
+// recurrenceWriters holds the writers of the supported recurrence formats
+var recurrenceWriters = map[string]func(io.Writer, []fileRecurrence) error{
+	"table": writeRecurrenceTable,
+	"json":  writeRecurrenceJSON,
+}
+
+func writeRecurrenceTable(w io.Writer, recurrences []fileRecurrence) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "RECURRENCES\tFIXES\tMIN DAYS\tLAST FIX\tREPO\tFILE")
+	for _, r := range recurrences {
+		fmt.Fprintf(tw, "%d\t%d\t%.1f\t%s\t%s/%s\t%s\n", r.Recurrences, r.Fixes, r.MinInterval, r.LastFix.Format("2006-01-02"), r.Repo.Owner, r.Repo.Name, r.File)
+	}
+
+	return tw.Flush()
+}
+
+func writeRecurrenceJSON(w io.Writer, recurrences []fileRecurrence) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+
+	return enc.Encode(recurrences)
+}
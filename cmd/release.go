@@ -0,0 +1,72 @@
+package cmd
+
+import (
+	"sort"
+)
+
+// fixVersionNames returns the names of the releases
+func fixVersionNames(versions []fixVersion) []string {
+	names := make([]string, 0, len(versions))
+	for _, v := range versions {
+		names = append(names, v.Name)
+	}
+
+	return names
+}
+
+// heatByRelease counts the bugs of every file by the fix versions of the
+// bugs, a bug fixed in several releases counting in each of them. The
+// releases are ordered by their release dates, the ones not released yet
+// last, and the bugs without a fix version are left out.
+func heatByRelease(heat []fileHeat, mappings []mongoMapping) []trendPoint {
+	releases := make(map[string][]fixVersion)
+	released := make(map[string]fixVersion)
+	for _, m := range mappings {
+		b := m.bugKey()
+		if _, ok := releases[b]; ok {
+			continue
+		}
+		releases[b] = m.FixVersions
+		for _, v := range m.FixVersions {
+			if r, ok := released[v.Name]; !ok || r.Released.IsZero() {
+				released[v.Name] = v
+			}
+		}
+	}
+
+	points := make([]trendPoint, 0)
+	for _, h := range heat {
+		byRelease := make(map[string]int)
+		for b := range h.bugs {
+			for _, v := range releases[b] {
+				byRelease[v.Name]++
+			}
+		}
+
+		for name, n := range byRelease {
+			points = append(points, trendPoint{Period: name, Start: released[name].Released, Repo: h.Repo, File: h.File, Group: h.Group, Bugs: n})
+		}
+	}
+
+	// The files keep the order of the report within a release
+	order := make(map[string]int, len(heat))
+	for i, h := range heat {
+		order[fileKey(h.Repo, h.File)+"#"+h.Group] = i
+	}
+	sort.SliceStable(points, func(i, j int) bool {
+		a, b := points[i], points[j]
+		if a.Period != b.Period {
+			switch {
+			case a.Start.IsZero() != b.Start.IsZero():
+				return b.Start.IsZero()
+			case !a.Start.Equal(b.Start):
+				return a.Start.Before(b.Start)
+			default:
+				return a.Period < b.Period
+			}
+		}
+		return order[fileKey(a.Repo, a.File)+"#"+a.Group] < order[fileKey(b.Repo, b.File)+"#"+b.Group]
+	})
+
+	return points
+}
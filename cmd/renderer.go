@@ -0,0 +1,65 @@
+package cmd
+
+import (
+	"rdlf0/heatmap/render"
+)
+
+// newHeatView builds the view of the renderers from the heat and the PRs
+// it was computed from, with the create URLs of report.tech_debt if it's
+// set
+func newHeatView(heat []fileHeat, prs []pr) render.View {
+	techDebt, ok := techDebtConfig()
+	files := make([]render.Heat, len(heat))
+	for i, h := range heat {
+		files[i] = renderHeat(h)
+		if ok {
+			files[i].CreateURL = techDebt.createURL(h)
+		}
+	}
+
+	changed := make([]render.PR, len(prs))
+	for i, p := range prs {
+		changed[i] = render.PR{Repo: render.Repo(p.Repo), Files: make([]string, len(p.Diff))}
+		for j, d := range p.Diff {
+			changed[i].Files[j] = d.File
+		}
+	}
+
+	return render.NewView(files, changed, reportGroup, reportDepth)
+}
+
+// renderHeat returns the heat of a file as the renderers take it
+func renderHeat(h fileHeat) render.Heat {
+	events := make([]render.Event, len(h.events))
+	for i, e := range h.events {
+		events[i] = render.Event{Time: e.Time, Issue: e.Issue, PR: e.PR, Lines: e.Lines}
+		for _, v := range e.Releases {
+			events[i].Releases = append(events[i].Releases, render.Release(v))
+		}
+	}
+
+	return render.Heat{
+		Repo:         render.Repo(h.Repo),
+		File:         h.File,
+		Group:        h.Group,
+		Bugs:         h.Bugs,
+		PRs:          h.PRs,
+		Additions:    h.Additions,
+		Deletions:    h.Deletions,
+		Changes:      h.Changes,
+		Score:        h.Score,
+		Risk:         h.Risk,
+		SLABreaches:  h.SLABreaches,
+		FirstSeen:    h.FirstSeen,
+		LastSeen:     h.LastSeen,
+		Historical:   h.Historical,
+		AcceptedRisk: h.AcceptedRisk,
+		Events:       events,
+	}
+}
+
+// viewName returns the printed name of a file or a group of the view,
+// see heatName
+func viewName(h render.Heat) (string, string) {
+	return heatName(fileHeat{Repo: Repo(h.Repo), File: h.File, Group: h.Group})
+}
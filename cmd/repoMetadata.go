@@ -0,0 +1,129 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// untopicalRepo is the group of the files of the repos without topics
+const untopicalRepo = "untopical"
+
+// repoMeta represents the metadata of a repo: its topics, the bytes of
+// code of each of its languages and whether it's archived
+type repoMeta struct {
+	Repo      Repo           `bson:"repo" json:"repo"`
+	Topics    []string       `bson:"topics" json:"topics"`
+	Languages map[string]int `bson:"languages" json:"languages"`
+	Archived  bool           `bson:"archived" json:"archived"`
+	FetchedAt time.Time      `bson:"fetched_at" json:"fetched_at"`
+}
+
+// repoIndex holds the metadata of the repos by repo, loaded by loadHeat
+// for the report options using it
+var repoIndex map[Repo]repoMeta
+
+// indexRepos returns the metadata of the repos by repo
+func indexRepos(repos []repoMeta) map[Repo]repoMeta {
+	index := make(map[Repo]repoMeta, len(repos))
+	for _, r := range repos {
+		index[r.Repo] = r
+	}
+
+	return index
+}
+
+// excludeArchived drops the PRs of the archived repos. The repos without
+// metadata are kept.
+func excludeArchived(prs []pr, index map[Repo]repoMeta) []pr {
+	kept := make([]pr, 0, len(prs))
+	for _, p := range prs {
+		if !index[p.Repo].Archived {
+			kept = append(kept, p)
+		}
+	}
+
+	return kept
+}
+
+// includeArchived tells whether the archived repos are collected and
+// reported like the active ones, as set by repos.include_archived
+func includeArchived() bool {
+	return viper.GetBool("repos.include_archived")
+}
+
+// archivedRepos returns the repos archived as of the last enrich, none if
+// repos.include_archived is set
+func archivedRepos(ctx context.Context, st repoStore) (map[Repo]bool, error) {
+	archived := make(map[Repo]bool)
+	if includeArchived() {
+		return archived, nil
+	}
+
+	repos, err := st.Repos(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range repos {
+		if r.Archived {
+			archived[r.Repo] = true
+		}
+	}
+
+	return archived, nil
+}
+
+// markHistorical marks the heat of the files of the archived repos as
+// historical, unless repos.include_archived is set
+func markHistorical(heat []fileHeat, index map[Repo]repoMeta) {
+	if includeArchived() {
+		return
+	}
+	for i := range heat {
+		if heat[i].Group == "" && index[heat[i].Repo].Archived {
+			heat[i].Historical = true
+		}
+	}
+}
+
+// fileTopics returns the topics of the repo of the file, or untopical
+func fileTopics(h fileHeat) []string {
+	if topics := repoIndex[h.Repo].Topics; len(topics) > 0 {
+		return topics
+	}
+
+	return []string{untopicalRepo}
+}
+
+// describeRepos fetches the metadata of the repos of the PRs
+func describeRepos(ctx context.Context, describer repoDescriber, prs []pr) ([]repoMeta, error) {
+	seen := make(map[Repo]bool)
+	repos := make([]Repo, 0)
+	for _, p := range prs {
+		if !seen[p.Repo] {
+			seen[p.Repo] = true
+			repos = append(repos, p.Repo)
+		}
+	}
+	sort.Slice(repos, func(i, j int) bool {
+		return repos[i].Owner+"/"+repos[i].Name < repos[j].Owner+"/"+repos[j].Name
+	})
+
+	metas := make([]repoMeta, 0, len(repos))
+	for _, repo := range repos {
+		meta, err := describer.describeRepo(ctx, repo)
+		if err != nil {
+			return nil, fmt.Errorf("reading the metadata of %s/%s failed: %w", repo.Owner, repo.Name, err)
+		}
+		meta.Repo, meta.FetchedAt = repo, time.Now().UTC()
+		sort.Strings(meta.Topics)
+		metas = append(metas, meta)
+		slog.Debug("repo described", "repo", repo.Owner+"/"+repo.Name, "topics", len(meta.Topics), "archived", meta.Archived)
+	}
+
+	return metas, nil
+}
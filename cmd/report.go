@@ -0,0 +1,504 @@
+package cmd
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// reportCmd represents the report command
+var reportCmd = &cobra.Command{
+	Use:   "report",
+	Short: "Prints the files with the highest bug heat",
+	Long: `Joins the mappings of the Jira issues with the diffs of
+their PRs and computes a bug heat score for every changed file.
+The score is the number of distinct bugs touching the file
+weighted by its churn; the risk index combines it with the other
+signals of the file, weighted by risk.weights.
+
+The files can be grouped with --group-by, counted by period with
+--trend or by fix version with --by-release, and forecast from the
+open bugs with --incoming. --format html writes a self-contained
+page, e.g. for a retrospective. See the README for the
+configuration.`,
+	RunE: report,
+}
+
+var (
+	reportTop      int
+	reportFormat   string
+	reportSort     string
+	reportSave     bool
+	reportGroup    string
+	reportBranch   []string
+	reportDepth    int
+	reportIncoming bool
+	reportHalfLife string
+	reportIssues   issueFilter
+	reportOut      string
+	reportRelease  bool
+	reportArchived bool
+	reportGrain    string
+)
+
+const (
+	defaultReportTop   = 20
+	defaultReportDepth = 1
+)
+
+func init() {
+	rootCmd.AddCommand(reportCmd)
+	reportCmd.Flags().IntVar(&reportTop, "top", defaultReportTop, "number of files to print (0 prints all)")
+	reportCmd.Flags().StringVar(&reportFormat, "format", "table", "output format: table, json, csv or html")
+	reportCmd.Flags().StringVar(&reportOut, "out", "", "file to write the report to (default is stdout)")
+	reportCmd.Flags().StringVar(&reportSort, "sort", "score", "metric to sort by: score or risk")
+	reportCmd.Flags().BoolVar(&reportSave, "save", false, "save the complete report to the store")
+	reportCmd.Flags().StringVar(&reportGroup, "group-by", "file", "unit of the report: file, dir, team, owner, language, topic, branch, author or origin")
+	reportCmd.Flags().IntVar(&reportDepth, "depth", defaultReportDepth, "number of leading path segments of the directory buckets of --group-by dir")
+	reportCmd.Flags().StringVar(&reportHalfLife, "half-life", "", "decay the weight of a bug by half every period since its fix, e.g. 90d")
+	reportCmd.Flags().BoolVar(&reportIncoming, "incoming", false, "forecast the heat of the open bugs and their open PRs, fetched from Jira, next to the historical heat")
+	reportCmd.Flags().StringVarP(&jiraProject, "project", "p", "Memberships", "Jira project name or a comma separated list of names of --incoming")
+	reportCmd.Flags().StringVar(&jiraJQL, "jql", "", fmt.Sprintf("JQL selecting the issues of --incoming (default is jira.jql or %q)", defaultJiraJQL))
+	reportCmd.Flags().StringVar(&reportTrend, "trend", "", "count the bugs of the files by period: week or month")
+	reportCmd.Flags().StringVar(&reportTZ, "tz", "", "time zone of the periods of --trend, e.g. Europe/Sofia (default is report.tz or UTC)")
+	reportCmd.Flags().StringVar(&reportWeekStart, "week-start", "", "first day of the weeks of --trend (default is report.week_start or monday)")
+	reportCmd.Flags().BoolVar(&reportRelease, "by-release", false, "count the bugs of the files by their fix versions")
+	reportCmd.Flags().StringSliceVar(&reportBranch, "branch", nil, "only count the PRs merged into the base branches matching these patterns, e.g. release/*")
+	reportCmd.Flags().StringVar(&reportGrain, "granularity", "file", "unit of the heat: file or function")
+	reportCmd.Flags().BoolVar(&reportArchived, "exclude-archived", false, "leave out the PRs of the repos archived as of the last enrich")
+	issueFilterFlags(reportCmd)
+}
+
+// issueFilterFlags adds the flags selecting the bugs by their metadata
+func issueFilterFlags(cmd *cobra.Command) {
+	cmd.Flags().StringSliceVar(&reportIssues.priorities, "priority", nil, "only count the bugs of these priorities")
+	cmd.Flags().StringSliceVar(&reportIssues.components, "component", nil, "only count the bugs of these components")
+	cmd.Flags().StringSliceVar(&reportIssues.labels, "label", nil, "only count the bugs with one of these labels")
+	cmd.Flags().StringSliceVar(&reportIssues.origins, "origin", nil, "only count the bugs of these origins: customer, internal or unknown")
+}
+
+func report(cmd *cobra.Command, args []string) error {
+	if err := checkSigner(); err != nil {
+		return err
+	}
+
+	ctx, cancel, st, err := openReadStore()
+	if err != nil {
+		return err
+	}
+	defer cancel()
+	defer closeStore(ctx, st)
+
+	w := io.Writer(os.Stdout)
+	if reportOut != "" {
+		f, err := os.Create(reportOut)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	if reportIncoming {
+		err = runIncomingReport(ctx, cmd, st, w)
+	} else {
+		err = runReport(ctx, st, w)
+	}
+	if err != nil {
+		return err
+	}
+	if f, ok := w.(*os.File); ok && f != os.Stdout {
+		if err := f.Close(); err != nil {
+			return err
+		}
+		return signFiles(reportOut)
+	}
+
+	return nil
+}
+
+// runIncomingReport writes the heat of the store next to the forecast of
+// the heat of the open bugs to w
+func runIncomingReport(ctx context.Context, cmd *cobra.Command, st store, w io.Writer) error {
+	write, ok := incomingWriters[reportFormat]
+	if !ok {
+		return configError(fmt.Errorf("the incoming heat can't be written as %s", reportFormat))
+	}
+	if reportTrend != "" || reportRelease {
+		return configError(fmt.Errorf("--incoming can't be combined with --trend or --by-release"))
+	}
+	if len(reportBranch) > 0 {
+		return configError(fmt.Errorf("--incoming can't be combined with --branch, the open PRs aren't merged yet"))
+	}
+	if !incomingGroupings[reportGroup] {
+		return configError(fmt.Errorf("the incoming heat can't be grouped by %s", reportGroup))
+	}
+	if reportGrain != "file" {
+		return configError(fmt.Errorf("the incoming heat can't be computed by %s", reportGrain))
+	}
+
+	provider, err := newVCSProvider(ctx)
+	if err != nil {
+		return configError(err)
+	}
+
+	auth, err := jiraAuth()
+	if err != nil {
+		return configError(err)
+	}
+
+	historical, _, err := loadHeat(ctx, st)
+	if err != nil {
+		return err
+	}
+	if reportSave {
+		if err := st.SaveReport(ctx, heatReport{Created: time.Now(), Files: historical, RunID: runID()}); err != nil {
+			return storageError(fmt.Errorf("saving report failed: %w", err))
+		}
+	}
+
+	incoming, err := computeIncomingHeat(ctx, provider, auth, backfillProjects(cmd))
+	if err != nil {
+		return err
+	}
+	if reportGroup != "file" {
+		incoming = groupHeat(incoming, heatGroupKeys[reportGroup]())
+	}
+	if err := computeRisk(incoming, riskWeights()); err != nil {
+		return configError(err)
+	}
+	if err := sortHeat(incoming, reportSort); err != nil {
+		return configError(err)
+	}
+
+	return write(w, topHeat(historical, reportTop), topHeat(incoming, reportTop))
+}
+
+// runReport computes the heat from the store and writes the report to w
+func runReport(ctx context.Context, st store, w io.Writer) error {
+	write, ok := reportWriters[reportFormat]
+	if !ok {
+		return configError(fmt.Errorf("unknown report format %q", reportFormat))
+	}
+	var cal trendCalendar
+	if reportTrend != "" && reportRelease {
+		return configError(fmt.Errorf("--trend and --by-release can't be combined"))
+	}
+	if reportRelease {
+		if _, ok := trendWriters[reportFormat]; !ok {
+			return configError(fmt.Errorf("the releases can't be written as %s", reportFormat))
+		}
+	}
+	if reportTrend != "" {
+		if _, ok := trendWriters[reportFormat]; !ok {
+			return configError(fmt.Errorf("the trend can't be written as %s", reportFormat))
+		}
+		var err error
+		if cal, err = reportCalendar(); err != nil {
+			return configError(err)
+		}
+		if _, err := cal.periodStart(time.Now(), reportTrend); err != nil {
+			return configError(err)
+		}
+	}
+
+	heat, _, err := loadHeat(ctx, st)
+	if err != nil {
+		return err
+	}
+	if reportSave {
+		if err := st.SaveReport(ctx, heatReport{Created: time.Now(), Files: heat, RunID: runID()}); err != nil {
+			return storageError(fmt.Errorf("saving report failed: %w", err))
+		}
+	}
+	if reportTop > 0 && len(heat) > reportTop {
+		heat = heat[:reportTop]
+	}
+	if reportTrend != "" {
+		points, err := heatTrend(heat, reportTrend, cal)
+		if err != nil {
+			return configError(err)
+		}
+		return trendWriters[reportFormat](w, "period", points)
+	}
+	if reportRelease {
+		mappings, err := st.Mappings(ctx)
+		if err != nil {
+			return storageError(fmt.Errorf("reading mappings failed: %w", err))
+		}
+		return trendWriters[reportFormat](w, "release", heatByRelease(heat, mappings))
+	}
+
+	return write(w, heat)
+}
+
+// loadHeat computes the heat from the store, filtered, grouped and sorted
+// as set by the report flags. It returns the counted PRs too.
+func loadHeat(ctx context.Context, st store) ([]fileHeat, []pr, error) {
+	mappings, err := st.Mappings(ctx)
+	if err != nil {
+		return nil, nil, storageError(fmt.Errorf("reading mappings failed: %w", err))
+	}
+
+	prs, err := st.PRs(ctx)
+	if err != nil {
+		return nil, nil, storageError(fmt.Errorf("reading diffs failed: %w", err))
+	}
+
+	mappings = filterMappings(mappings, reportIssues)
+
+	// The branches are filtered first, so the cherry-picks onto the
+	// matching branches aren't moved to PRs which are filtered out
+	if len(reportBranch) > 0 {
+		if prs, err = filterPRsByBranch(prs, reportBranch); err != nil {
+			return nil, nil, configError(err)
+		}
+	}
+
+	viper.SetDefault("heat.dedupe_cherry_picks", true)
+	if viper.GetBool("heat.dedupe_cherry_picks") {
+		mappings, prs = dedupeCherryPicks(mappings, prs)
+	}
+
+	repos, err := st.Repos(ctx)
+	if err != nil {
+		return nil, nil, storageError(fmt.Errorf("reading repos failed: %w", err))
+	}
+	repoIndex = indexRepos(repos)
+	if reportArchived {
+		prs = excludeArchived(prs, repoIndex)
+	}
+
+	annotations, err := st.Annotations(ctx)
+	if err != nil {
+		return nil, nil, storageError(fmt.Errorf("reading annotations failed: %w", err))
+	}
+	heatAnnotations = activeAnnotations(annotations, time.Now())
+	prs = ignoreAnnotated(prs, heatAnnotations)
+
+	switch reportGrain {
+	case "file":
+	case "function":
+		prs = functionDiffs(prs)
+	default:
+		return nil, nil, configError(fmt.Errorf("unknown granularity %q", reportGrain))
+	}
+
+	var heat []fileHeat
+	if key, ok := prGroupKeys[reportGroup]; ok {
+		heat = groupHeatByPR(mappings, prs, key())
+	} else if key, ok := mappingGroupKeys[reportGroup]; ok {
+		heat = groupHeatByMapping(mappings, prs, key)
+	} else {
+		heat = computeHeat(mappings, prs)
+		if reportGroup != "file" {
+			key, ok := heatGroupKeys[reportGroup]
+			if !ok {
+				return nil, nil, configError(fmt.Errorf("unknown report grouping %q", reportGroup))
+			}
+			heat = groupHeat(heat, key())
+		}
+	}
+	markHistorical(heat, repoIndex)
+	markAccepted(heat, heatAnnotations)
+	if reportHalfLife != "" {
+		halfLife, err := parseDays(reportHalfLife)
+		if err != nil || halfLife <= 0 {
+			return nil, nil, configError(fmt.Errorf("invalid half-life %q", reportHalfLife))
+		}
+		decayHeat(heat, halfLife, time.Now())
+	}
+	if err := computeRisk(heat, riskWeights()); err != nil {
+		return nil, nil, configError(err)
+	}
+	if err := sortHeat(heat, reportSort); err != nil {
+		return nil, nil, configError(err)
+	}
+
+	return heat, prs, nil
+}
+
+// parseDays parses a duration which, besides the units of time.ParseDuration,
+// can be given in days, e.g. 90d
+func parseDays(s string) (time.Duration, error) {
+	if days := strings.TrimSuffix(s, "d"); days != s {
+		n, err := strconv.ParseFloat(days, 64)
+		if err != nil {
+			return 0, err
+		}
+		return time.Duration(n * 24 * float64(time.Hour)), nil
+	}
+
+	return time.ParseDuration(s)
+}
+
+// riskWeights returns the configured weights of the risk signals
+func riskWeights() map[string]float64 {
+	if !viper.IsSet("risk.weights") {
+		return defaultRiskWeights
+	}
+
+	weights := make(map[string]float64)
+	for name := range viper.GetStringMap("risk.weights") {
+		weights[name] = viper.GetFloat64("risk.weights." + name)
+	}
+
+	return weights
+}
+
+// heatGroupKeys holds the supported groupings of the report. Each one
+// builds the function returning the groups of a file.
+var heatGroupKeys = map[string]func() func(fileHeat) []string{
+	"dir": func() func(fileHeat) []string {
+		return func(h fileHeat) []string { return []string{dirBucket(h.Repo, h.File, reportDepth)} }
+	},
+	"team": func() func(fileHeat) []string {
+		teams := annotatedTeams(teamPatterns(), heatAnnotations)
+		return func(h fileHeat) []string { return teamsOf(teams, h.Repo, h.File) }
+	},
+	"owner": func() func(fileHeat) []string {
+		return fileOwners
+	},
+	"language": func() func(fileHeat) []string {
+		return fileLanguage(languageExtensions())
+	},
+	"topic": func() func(fileHeat) []string {
+		return fileTopics
+	},
+}
+
+// dirBucket returns the directory of the file cut to the given number of
+// path segments. The files in the root of the repo form their own bucket.
+func dirBucket(repo Repo, file string, depth int) string {
+	segments := strings.Split(path.Dir(file), "/")
+	if segments[0] == "." {
+		segments = nil
+	}
+	if depth > 0 && len(segments) > depth {
+		segments = segments[:depth]
+	}
+
+	return strings.Join(append([]string{repo.Owner, repo.Name}, segments...), "/") + "/"
+}
+
+// prGroupKeys holds the groupings by a property of the PRs rather than
+// of the files
+var prGroupKeys = map[string]func() func(pr) string{
+	"branch": func() func(pr) string {
+		return func(p pr) string {
+			if p.Branch == "" {
+				return "unknown"
+			}
+			return p.Branch
+		}
+	},
+	"author": func() func(pr) string {
+		identities := newIdentityResolver()
+		return func(p pr) string { return identities.person(p.Author) }
+	},
+}
+
+// mappingGroupKeys holds the groupings by a property of the bugs
+var mappingGroupKeys = map[string]func(mongoMapping) string{
+	"origin": mappingOrigin,
+}
+
+// filterPRsByBranch keeps the PRs merged into a base branch matching one
+// of the patterns
+func filterPRsByBranch(prs []pr, patterns []string) ([]pr, error) {
+	filtered := make([]pr, 0, len(prs))
+	for _, p := range prs {
+		for _, pattern := range patterns {
+			ok, err := path.Match(pattern, p.Branch)
+			if err != nil {
+				return nil, fmt.Errorf("invalid branch pattern %q: %w", pattern, err)
+			}
+			if ok {
+				filtered = append(filtered, p)
+				break
+			}
+		}
+	}
+
+	return filtered, nil
+}
+
+// heatName returns the printed name of a file or a group
+func heatName(h fileHeat) (string, string) {
+	if h.Group != "" {
+		return "", h.Group
+	}
+
+	return h.Repo.Owner + "/" + h.Repo.Name, h.File
+}
+
+// reportWriters holds the writers of the supported report formats
+var reportWriters = map[string]func(io.Writer, []fileHeat) error{
+	"table": writeReportTable,
+	"json":  writeReportJSON,
+	"csv":   writeReportCSV,
+	"html":  writeReportHTML,
+}
+
+func writeReportTable(w io.Writer, heat []fileHeat) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "SCORE\tRISK\tBUGS\tPRS\tCHANGES\tREPO\tFILE")
+	for _, h := range heat {
+		repo, file := heatName(h)
+		if h.Historical {
+			file += " (historical)"
+		}
+		if h.AcceptedRisk != "" {
+			file += " (accepted risk)"
+		}
+		fmt.Fprintf(tw, "%.2f\t%.1f\t%d\t%d\t%d\t%s\t%s\n", h.Score, h.Risk, h.Bugs, h.PRs, h.Changes, repo, file)
+	}
+
+	return tw.Flush()
+}
+
+func writeReportJSON(w io.Writer, heat []fileHeat) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+
+	return enc.Encode(heat)
+}
+
+func writeReportCSV(w io.Writer, heat []fileHeat) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"score", "risk", "bugs", "prs", "additions", "deletions", "changes", "sla_breaches", "owner", "repo", "file", "group", "first_seen", "last_seen", "historical", "accepted_risk"})
+	for _, h := range heat {
+		cw.Write([]string{
+			strconv.FormatFloat(h.Score, 'f', 2, 64),
+			strconv.FormatFloat(h.Risk, 'f', 1, 64),
+			strconv.Itoa(h.Bugs),
+			strconv.Itoa(h.PRs),
+			strconv.Itoa(h.Additions),
+			strconv.Itoa(h.Deletions),
+			strconv.Itoa(h.Changes),
+			strconv.Itoa(h.SLABreaches),
+			h.Repo.Owner,
+			h.Repo.Name,
+			h.File,
+			h.Group,
+			csvTime(h.FirstSeen),
+			csvTime(h.LastSeen),
+			strconv.FormatBool(h.Historical),
+			h.AcceptedRisk,
+		})
+	}
+	cw.Flush()
+
+	return cw.Error()
+}
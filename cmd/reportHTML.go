@@ -0,0 +1,183 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/spf13/viper"
+
+	"rdlf0/heatmap/render"
+)
+
+// htmlTemplate returns the template of report.html_template if it's set,
+// or reportHTML.tmpl of the assets, see readAsset
+func htmlTemplate() (*template.Template, error) {
+	if path := viper.GetString("report.html_template"); path != "" {
+		t, err := template.ParseFiles(path)
+		if err != nil {
+			return nil, configError(fmt.Errorf("reading report.html_template failed: %w", err))
+		}
+		return t, nil
+	}
+
+	source, err := readAsset("reportHTML.tmpl")
+	if err != nil {
+		return nil, err
+	}
+	t, err := template.New("report").Parse(string(source))
+	if err != nil {
+		return nil, configError(fmt.Errorf("parsing reportHTML.tmpl failed: %w", err))
+	}
+
+	return t, nil
+}
+
+// htmlReport represents the data of the HTML report
+type htmlReport struct {
+	Created time.Time
+	Files   []render.Heat
+	Treemap template.HTML
+	Rows    []htmlReportRow
+	// TechDebt tells whether the rows link to the create page of Jira
+	TechDebt bool
+	// Timelines holds the fixes of every row, from the oldest one
+	Timelines [][]htmlEvent
+	// Releases holds the released fix versions of the bugs of the rows
+	Releases []htmlRelease
+	// Style and Script are the reportHTML.css and reportHTML.js assets,
+	// inlined into the page
+	Style  template.CSS
+	Script template.JS
+}
+
+// htmlEvent represents a fix on the timeline of a row, at the Unix time in
+// milliseconds, as taken by the scripts of the page
+type htmlEvent struct {
+	T     int64  `json:"t"`
+	Issue string `json:"issue"`
+	PR    string `json:"pr"`
+	Lines int    `json:"lines"`
+}
+
+// htmlRelease represents a release marked on the timelines
+type htmlRelease struct {
+	T    int64  `json:"t"`
+	Name string `json:"name"`
+}
+
+// htmlReportRow represents a row of the table of the HTML report
+type htmlReportRow struct {
+	render.Heat
+	Repo  string
+	File  string
+	First string
+	Last  string
+	Color template.CSS
+}
+
+// htmlRenderer renders a self-contained HTML page with the treemap of the
+// files and a sortable table of their metrics. It needs no network
+// access to be viewed, so it can be attached as it is. With
+// report.tech_debt set, every row links to a pre-filled tech debt ticket.
+// assets.dir overrides its template, styles and scripts. A click on a row
+// shows the timeline of its fixes.
+type htmlRenderer struct{}
+
+func init() {
+	render.Register("html", htmlRenderer{})
+}
+
+func (htmlRenderer) Extension() string {
+	return "html"
+}
+
+func (htmlRenderer) Render(w io.Writer, view render.View) error {
+	heat := view.Files
+
+	// The treemap lays out the cells from the highest score
+	byScore := make([]render.Heat, len(heat))
+	copy(byScore, heat)
+	sort.SliceStable(byScore, func(i, j int) bool { return byScore[i].Score > byScore[j].Score })
+
+	var svg bytes.Buffer
+	if err := (svgRenderer{}).Render(&svg, render.View{Files: byScore}); err != nil {
+		return err
+	}
+
+	_, techDebt := techDebtConfig()
+	r := htmlReport{Created: time.Now(), Files: heat, Treemap: template.HTML(svg.String()), TechDebt: techDebt}
+	released := make(map[string]time.Time)
+	for _, h := range heat {
+		r.Timelines = append(r.Timelines, htmlTimeline(h.Events, released))
+		repo, file := viewName(h)
+		r.Rows = append(r.Rows, htmlReportRow{
+			Heat:  h,
+			Repo:  repo,
+			File:  file,
+			First: htmlDate(h.FirstSeen),
+			Last:  htmlDate(h.LastSeen),
+			Color: template.CSS(heatHex(h.Risk)),
+		})
+	}
+
+	for name, t := range released {
+		r.Releases = append(r.Releases, htmlRelease{T: t.UnixMilli(), Name: name})
+	}
+	sort.Slice(r.Releases, func(i, j int) bool { return r.Releases[i].T < r.Releases[j].T })
+
+	style, err := readAsset("reportHTML.css")
+	if err != nil {
+		return err
+	}
+	script, err := readAsset("reportHTML.js")
+	if err != nil {
+		return err
+	}
+	r.Style, r.Script = template.CSS(style), template.JS(script)
+
+	t, err := htmlTemplate()
+	if err != nil {
+		return err
+	}
+
+	return t.Execute(w, r)
+}
+
+// writeReportHTML writes the heat with the renderer of the html format
+func writeReportHTML(w io.Writer, heat []fileHeat) error {
+	r, _ := render.Lookup("html")
+
+	return r.Render(w, newHeatView(heat, nil))
+}
+
+// htmlTimeline returns the fixes of a row with known times, from the
+// oldest one, and adds their released fix versions to released
+func htmlTimeline(events []render.Event, released map[string]time.Time) []htmlEvent {
+	timeline := make([]htmlEvent, 0, len(events))
+	for _, e := range events {
+		if e.Time.IsZero() {
+			continue
+		}
+		timeline = append(timeline, htmlEvent{T: e.Time.UnixMilli(), Issue: e.Issue, PR: e.PR, Lines: e.Lines})
+		for _, v := range e.Releases {
+			if !v.Released.IsZero() {
+				released[v.Name] = v.Released
+			}
+		}
+	}
+	sort.SliceStable(timeline, func(i, j int) bool { return timeline[i].T < timeline[j].T })
+
+	return timeline
+}
+
+func htmlDate(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+
+	return t.Format("2006-01-02")
+}
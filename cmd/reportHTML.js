@@ -0,0 +1,162 @@
+document.querySelectorAll("#heat th").forEach(function (th, col) {
+  if (th.dataset.type === "none") return;
+  th.addEventListener("click", function () {
+    var body = document.querySelector("#heat tbody");
+    var desc = !th.classList.contains("desc");
+    var num = th.dataset.type === "num";
+    var rows = Array.prototype.slice.call(body.rows);
+    rows.sort(function (a, b) {
+      var x = a.cells[col].textContent, y = b.cells[col].textContent;
+      var c = num ? parseFloat(x) - parseFloat(y) : x.localeCompare(y);
+      return desc ? -c : c;
+    });
+    rows.forEach(function (r) { body.appendChild(r); });
+    document.querySelectorAll("#heat th").forEach(function (h) { h.classList.remove("asc", "desc"); });
+    th.classList.add(desc ? "desc" : "asc");
+  });
+});
+
+(function () {
+  var panel = document.getElementById("timeline");
+  var chart = panel.querySelector(".chart"), brush = panel.querySelector(".brush");
+  var W = 900, H = 220, BH = 40, PAD = 30, DAY = 864e5;
+  var NS = "http://www.w3.org/2000/svg";
+  var events = [], full = [0, 0], view = [0, 0], drag = null;
+
+  function el(parent, name, attrs, text) {
+    var e = document.createElementNS(NS, name);
+    for (var k in attrs) e.setAttribute(k, attrs[k]);
+    if (text !== undefined) e.textContent = text;
+    parent.appendChild(e);
+    return e;
+  }
+  function day(t) { return new Date(t).toISOString().slice(0, 10); }
+  function scale(d, t) { return PAD + (t - d[0]) / (d[1] - d[0]) * (W - 2 * PAD); }
+  function unscale(d, x) { return d[0] + (x - PAD) / (W - 2 * PAD) * (d[1] - d[0]); }
+  function pointer(svg, ev) {
+    var r = svg.getBoundingClientRect();
+    return (ev.clientX - r.left) / r.width * W;
+  }
+  function clamp(d) {
+    var span = Math.max(d[1] - d[0], DAY);
+    span = Math.min(span, full[1] - full[0]);
+    var lo = Math.min(Math.max(d[0], full[0]), full[1] - span);
+    return [lo, lo + span];
+  }
+
+  function draw() {
+    chart.textContent = "";
+    var maxLines = 1;
+    events.forEach(function (e) { maxLines = Math.max(maxLines, e.lines); });
+    var base = H - 25, top = 15;
+
+    var axis = el(chart, "g", { "class": "axis" });
+    el(axis, "line", { x1: PAD, x2: W - PAD, y1: base, y2: base, stroke: "#999" });
+    for (var i = 0; i <= 5; i++) {
+      var t = view[0] + (view[1] - view[0]) * i / 5, x = scale(view, t);
+      el(axis, "line", { x1: x, x2: x, y1: base, y2: base + 4, stroke: "#999" });
+      el(axis, "text", { x: x, y: base + 16, "text-anchor": "middle" }, day(t));
+    }
+
+    releases.forEach(function (r) {
+      if (r.t < view[0] || r.t > view[1]) return;
+      var g = el(chart, "g", { "class": "release" }), x = scale(view, r.t);
+      el(g, "line", { x1: x, x2: x, y1: top, y2: base });
+      el(g, "text", { x: x + 3, y: top + 8 }, r.name);
+      el(g, "title", {}, r.name + " released " + day(r.t));
+    });
+
+    var rows = panel.querySelector(".events tbody");
+    rows.textContent = "";
+    events.forEach(function (e) {
+      if (e.t < view[0] || e.t > view[1]) return;
+      var x = scale(view, e.t), y = base - Math.sqrt(e.lines / maxLines) * (base - top - 10);
+      var g = el(chart, "g", { "class": "fix" });
+      el(g, "line", { x1: x, x2: x, y1: base, y2: y });
+      el(g, "circle", { cx: x, cy: y, r: 3 + Math.min(e.lines, 400) / 80 });
+      el(g, "title", {}, e.issue + " \u00b7 " + e.pr + " \u00b7 " + e.lines + " lines \u00b7 " + day(e.t));
+
+      var tr = rows.insertRow();
+      [day(e.t), e.issue, e.pr, e.lines].forEach(function (v) { tr.insertCell().textContent = v; });
+    });
+
+    drawBrush();
+  }
+
+  function drawBrush() {
+    brush.textContent = "";
+    el(brush, "rect", { x: PAD, y: 0, width: W - 2 * PAD, height: BH, fill: "#f6f6f6" });
+    events.forEach(function (e) {
+      var x = scale(full, e.t);
+      el(brush, "line", { x1: x, x2: x, y1: 8, y2: BH - 8, stroke: "#d9534f" });
+    });
+    if (view[0] > full[0] || view[1] < full[1]) {
+      var x0 = scale(full, view[0]), x1 = scale(full, view[1]);
+      el(brush, "rect", { x: x0, y: 0, width: Math.max(x1 - x0, 1), height: BH, fill: "#6a8fd0", "fill-opacity": .25, stroke: "#6a8fd0" });
+    }
+    if (drag) {
+      var a = Math.min(drag.from, drag.to), b = Math.max(drag.from, drag.to);
+      el(brush, "rect", { x: a, y: 0, width: b - a, height: BH, fill: "#222", "fill-opacity": .15 });
+    }
+  }
+
+  function show(index, name) {
+    events = timelines[index] || [];
+    panel.hidden = false;
+    panel.querySelector("h2").textContent = name + " \u2014 " + events.length + " fixes";
+    if (events.length === 0) {
+      chart.textContent = "";
+      brush.textContent = "";
+      panel.querySelector(".events tbody").textContent = "";
+      return;
+    }
+    var margin = Math.max((events[events.length - 1].t - events[0].t) * .03, 15 * DAY);
+    full = [events[0].t - margin, events[events.length - 1].t + margin];
+    view = full.slice();
+    draw();
+  }
+
+  document.querySelectorAll("#heat tbody tr").forEach(function (tr) {
+    tr.addEventListener("click", function (ev) {
+      if (ev.target.closest("a")) return;
+      document.querySelectorAll("#heat tbody tr.selected").forEach(function (s) { s.classList.remove("selected"); });
+      tr.classList.add("selected");
+      var cells = tr.cells;
+      show(parseInt(tr.dataset.index, 10), cells[8].textContent + "/" + cells[9].textContent);
+      panel.scrollIntoView({ behavior: "smooth", block: "nearest" });
+    });
+  });
+
+  chart.addEventListener("wheel", function (ev) {
+    if (events.length === 0) return;
+    ev.preventDefault();
+    var at = unscale(view, pointer(chart, ev)), k = ev.deltaY < 0 ? 1 / 1.25 : 1.25;
+    view = clamp([at - (at - view[0]) * k, at + (view[1] - at) * k]);
+    draw();
+  }, { passive: false });
+
+  brush.addEventListener("mousedown", function (ev) {
+    if (events.length === 0) return;
+    var x = pointer(brush, ev);
+    drag = { from: x, to: x };
+    ev.preventDefault();
+  });
+  window.addEventListener("mousemove", function (ev) {
+    if (!drag) return;
+    drag.to = Math.min(Math.max(pointer(brush, ev), PAD), W - PAD);
+    drawBrush();
+  });
+  window.addEventListener("mouseup", function () {
+    if (!drag) return;
+    var a = Math.min(drag.from, drag.to), b = Math.max(drag.from, drag.to);
+    drag = null;
+    if (b - a > 3) view = clamp([unscale(full, a), unscale(full, b)]);
+    draw();
+  });
+  [chart, brush].forEach(function (svg) {
+    svg.addEventListener("dblclick", function () {
+      view = full.slice();
+      draw();
+    });
+  });
+})();
@@ -0,0 +1,59 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// repoScope is the repo of --repo, the only one a run collects
+var repoScope string
+
+// repoFilter represents the repos the PRs are collected from: the ones
+// of repos.allow, all if it's empty, but the ones of repos.deny. The
+// patterns are owner/name, where * matches any name, e.g. acme/*, and a
+// pattern of an owner alone matches all of its repos.
+type repoFilter struct {
+	allow []string
+	deny  []string
+	only  string
+}
+
+func newRepoFilter() repoFilter {
+	f := repoFilter{only: strings.ToLower(repoScope)}
+	for _, p := range viper.GetStringSlice("repos.allow") {
+		f.allow = append(f.allow, strings.ToLower(p))
+	}
+	for _, p := range viper.GetStringSlice("repos.deny") {
+		f.deny = append(f.deny, strings.ToLower(p))
+	}
+
+	return f
+}
+
+// checkRepoScope checks the repo of --repo
+func checkRepoScope() error {
+	if repoScope == "" {
+		return nil
+	}
+	if parts := strings.Split(repoScope, "/"); len(parts) < 2 || parts[0] == "" || parts[len(parts)-1] == "" {
+		return configError(fmt.Errorf("invalid repo %q, expected owner/name", repoScope))
+	}
+
+	return nil
+}
+
+// allows tells whether the PRs of the repo are collected. The repo names
+// are compared case-insensitively, like by the providers.
+func (f repoFilter) allows(repo Repo) bool {
+	name := strings.ToLower(repo.Owner + "/" + repo.Name)
+	if f.only != "" && name != f.only {
+		return false
+	}
+	if matchesAny(f.deny, []string{name}) {
+		return false
+	}
+
+	return len(f.allow) == 0 || matchesAny(f.allow, []string{name})
+}
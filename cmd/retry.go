@@ -0,0 +1,189 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+const (
+	defaultHTTPMaxAttempts = 5
+	defaultHTTPBackoff     = 500 * time.Millisecond
+	defaultHTTPMaxBackoff  = 30 * time.Second
+)
+
+// httpAttempts counts the requests sent, every retry included, and the
+// ones failing with a transient error, for the anomalies of sync
+var httpAttempts struct {
+	sent, failed int64
+}
+
+// retryTransport retries the requests failing with a transient error:
+// a network error, 429, 5xx or a 403 with Retry-After, which is how
+// GitHub reports its secondary rate limits. The delay grows exponentially
+// with full jitter, unless the response tells how long to wait in
+// Retry-After. A Retry-After pauses all the requests to the host, so the
+// parallel requests don't keep hitting the limit.
+type retryTransport struct {
+	next        http.RoundTripper
+	maxAttempts int
+	backoff     time.Duration
+	maxBackoff  time.Duration
+
+	mu     sync.Mutex
+	paused map[string]time.Time
+}
+
+// newRetryTransport reads the http.max_attempts, http.backoff and
+// http.max_backoff config keys
+func newRetryTransport(next http.RoundTripper) *retryTransport {
+	viper.SetDefault("http.max_attempts", defaultHTTPMaxAttempts)
+	viper.SetDefault("http.backoff", defaultHTTPBackoff)
+	viper.SetDefault("http.max_backoff", defaultHTTPMaxBackoff)
+
+	t := &retryTransport{
+		next:        next,
+		maxAttempts: viper.GetInt("http.max_attempts"),
+		backoff:     viper.GetDuration("http.backoff"),
+		maxBackoff:  viper.GetDuration("http.max_backoff"),
+		paused:      make(map[string]time.Time),
+	}
+	if t.maxAttempts < 1 {
+		t.maxAttempts = 1
+	}
+
+	return t
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	for attempt := 1; ; attempt++ {
+		if err := sleep(req.Context(), t.pauseOf(req.URL.Host)); err != nil {
+			return nil, err
+		}
+
+		resp, err := t.next.RoundTrip(req)
+		atomic.AddInt64(&httpAttempts.sent, 1)
+		if retryable(resp, err) {
+			atomic.AddInt64(&httpAttempts.failed, 1)
+		}
+		if attempt >= t.maxAttempts || !retryable(resp, err) {
+			return resp, err
+		}
+
+		// The body of a request can be sent again only if it can be recreated
+		if req.Body != nil && req.GetBody == nil {
+			return resp, err
+		}
+		if req.GetBody != nil {
+			body, bodyErr := req.GetBody()
+			if bodyErr != nil {
+				return resp, err
+			}
+			req.Body = body
+		}
+
+		wait := t.delay(attempt, resp)
+		if resp != nil {
+			if _, ok := retryAfter(resp.Header.Get("Retry-After")); ok {
+				t.pause(req.URL.Host, wait)
+			}
+			resp.Body.Close()
+		}
+
+		if err := sleep(req.Context(), wait); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// pause holds the requests to the host for the given time
+func (t *retryTransport) pause(host string, wait time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.paused[host] = latest(t.paused[host], time.Now().Add(wait))
+}
+
+// pauseOf returns the time left of the pause of the host
+func (t *retryTransport) pauseOf(host string) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return time.Until(t.paused[host])
+}
+
+// sleep waits for the given time unless the context is done first
+func sleep(ctx context.Context, wait time.Duration) error {
+	if wait <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// delay returns the wait before the next attempt
+func (t *retryTransport) delay(attempt int, resp *http.Response) time.Duration {
+	if resp != nil {
+		if wait, ok := retryAfter(resp.Header.Get("Retry-After")); ok {
+			if wait > t.maxBackoff {
+				return t.maxBackoff
+			}
+			return wait
+		}
+	}
+
+	ceiling := t.backoff << uint(attempt-1)
+	if ceiling <= 0 || ceiling > t.maxBackoff {
+		ceiling = t.maxBackoff
+	}
+	if ceiling <= 0 {
+		return 0
+	}
+
+	return time.Duration(rand.Int63n(int64(ceiling)))
+}
+
+// retryable tells whether the failure is transient
+func retryable(resp *http.Response, err error) bool {
+	if err != nil {
+		return !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+	}
+
+	if resp.StatusCode == http.StatusForbidden && resp.Header.Get("Retry-After") != "" {
+		return true
+	}
+
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= http.StatusInternalServerError
+}
+
+// retryAfter parses the Retry-After header, given either in seconds or as a date
+func retryAfter(value string) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		if wait := time.Until(at); wait > 0 {
+			return wait, true
+		}
+		return 0, true
+	}
+
+	return 0, false
+}
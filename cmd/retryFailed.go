@@ -0,0 +1,143 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/spf13/cobra"
+)
+
+// retryFailedCmd represents the retry-failed command
+var retryFailedCmd = &cobra.Command{
+	Use:   "retry-failed",
+	Short: "Collects the diffs of the PRs which couldn't be read again",
+	Long: `Collects the diffs of the PRs which collectDiffs couldn't read,
+their repos being private to the credentials (forbidden) or deleted
+(not_found), e.g. after the token was granted access to the repos.
+--status only retries the PRs of one status and --repo the PRs of
+one repo.
+
+The PRs which are read are stored with their diffs like by
+collectDiffs, with the same --granularity; the others keep their
+status with the new error. An interrupted run is continued with
+--resume.`,
+	RunE: retryFailed,
+}
+
+var retryStatus string
+
+func init() {
+	rootCmd.AddCommand(retryFailedCmd)
+	retryFailedCmd.Flags().BoolVar(&resume, "resume", false, "resume the interrupted run from its manifest")
+	retryFailedCmd.Flags().IntVar(&concurrency, "concurrency", defaultConcurrency, "number of PRs fetched in parallel")
+	retryFailedCmd.Flags().StringVar(&repoScope, "repo", "", "only retry the PRs of this owner/name repo")
+	retryFailedCmd.Flags().StringVar(&retryStatus, "status", "", "only retry the PRs of this fetch status: forbidden or not_found")
+	retryFailedCmd.Flags().StringVar(&diffGranularity, "granularity", "pr", "unit of the collected diffs: pr or commit")
+}
+
+// failedPRs returns the PRs which couldn't be read
+func failedPRs(prs []pr) []pr {
+	failed := make([]pr, 0)
+	for _, p := range prs {
+		if p.FetchStatus != "" {
+			failed = append(failed, p)
+		}
+	}
+
+	return failed
+}
+
+// planRetryFailed writes the manifest of the failed PRs of --status and
+// --repo
+func planRetryFailed(ctx context.Context, st store) (*manifest, error) {
+	prs, err := st.PRs(ctx)
+	if err != nil {
+		return nil, storageError(fmt.Errorf("reading diffs failed: %w", err))
+	}
+
+	keys := make(map[string][]string)
+	if diffGranularity == "commit" {
+		if keys, err = prIssueKeys(ctx, st); err != nil {
+			return nil, storageError(fmt.Errorf("reading mappings failed: %w", err))
+		}
+	}
+
+	m := newManifest("retry-failed", "")
+	repos := newRepoFilter()
+	for _, p := range failedPRs(prs) {
+		if !repos.allows(p.Repo) || (retryStatus != "" && p.FetchStatus != retryStatus) {
+			continue
+		}
+		k := prKey(p.Repo, p.PRID)
+		if err := m.add(k, diffTask{pr: p, Keys: keys[k]}); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := m.start(); err != nil {
+		return nil, fmt.Errorf("writing manifest failed: %w", err)
+	}
+
+	return m, nil
+}
+
+func retryFailed(cmd *cobra.Command, args []string) error {
+	if err := checkRepoScope(); err != nil {
+		return err
+	}
+	switch retryStatus {
+	case "", fetchForbidden, fetchNotFound:
+	default:
+		return configError(fmt.Errorf("unknown fetch status %q", retryStatus))
+	}
+	switch diffGranularity {
+	case "pr", "commit":
+	default:
+		return configError(fmt.Errorf("unknown granularity %q", diffGranularity))
+	}
+
+	ctx, cancel, st, err := openStore()
+	if err != nil {
+		return err
+	}
+	defer cancel()
+	defer closeStore(ctx, st)
+
+	var m *manifest
+	if resume {
+		if m, err = loadManifest("retry-failed", ""); err != nil {
+			return err
+		}
+	} else if m, err = planRetryFailed(ctx, st); err != nil {
+		return err
+	}
+	defer m.close()
+
+	slog.Info("failed PRs found", "count", len(m.Items))
+	if len(m.Items) == 0 {
+		return m.remove()
+	}
+
+	provider, err := newVCSProvider(ctx)
+	if err != nil {
+		return configError(err)
+	}
+	if diffGranularity == "commit" {
+		if _, ok := provider.(commitLister); !ok {
+			return configError(fmt.Errorf("%s doesn't list the commits of the PRs", provider.applicationType()))
+		}
+	}
+	if err := setPRsDiffs(ctx, provider, m); err != nil {
+		return vcsError(err)
+	}
+
+	prs, err := storeCollectedPRs(ctx, st, provider, m)
+	if err != nil {
+		return err
+	}
+	failed := len(failedPRs(prs))
+	slog.Info("failed PRs retried", "collected", len(prs)-failed, "failed", failed)
+
+	return m.remove()
+}
@@ -0,0 +1,192 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/spf13/cobra"
+)
+
+// reviewersCmd represents the analyze reviewers command
+var reviewersCmd = &cobra.Command{
+	Use:   "reviewers",
+	Short: "Suggests reviewers for a PR",
+	Long: `Fetches the files changed by the PR and suggests as reviewers
+the authors of the past bug fixes of these files. Every fixed file
+counts with its heat score, so the experience with the hottest files
+weighs the most. The PR author is never suggested. The logins of a
+person in identities.people count as the person; see identities.
+
+The suggestions are printed as JSON.`,
+	RunE: reviewers,
+}
+
+var (
+	reviewersPR    string
+	reviewersLimit int
+)
+
+const defaultReviewersLimit = 3
+
+// reviewerSuggestion represents a suggested reviewer and the hot files
+// they have fixed bugs in
+type reviewerSuggestion struct {
+	Login string   `json:"login"`
+	Score float64  `json:"score"`
+	Fixes int      `json:"fixes"`
+	Files []string `json:"files"`
+}
+
+// reviewersResult represents the output of the command
+type reviewersResult struct {
+	PR        string               `json:"pr"`
+	Author    string               `json:"author"`
+	Files     int                  `json:"files"`
+	Reviewers []reviewerSuggestion `json:"reviewers"`
+}
+
+func init() {
+	analyzeCmd.AddCommand(reviewersCmd)
+	reviewersCmd.Flags().StringVar(&reviewersPR, "pr", "", "PR to suggest reviewers for, as owner/name#id")
+	reviewersCmd.Flags().IntVar(&reviewersLimit, "limit", defaultReviewersLimit, "number of reviewers to suggest (0 suggests all)")
+	reviewersCmd.MarkFlagRequired("pr")
+}
+
+func reviewers(cmd *cobra.Command, args []string) error {
+	repo, id, err := parsePRKey(reviewersPR)
+	if err != nil {
+		return configError(err)
+	}
+
+	ctx, cancel, st, err := openReadStore()
+	if err != nil {
+		return err
+	}
+	defer cancel()
+	defer closeStore(ctx, st)
+
+	mappings, err := st.Mappings(ctx)
+	if err != nil {
+		return storageError(fmt.Errorf("reading mappings failed: %w", err))
+	}
+
+	prs, err := st.PRs(ctx)
+	if err != nil {
+		return storageError(fmt.Errorf("reading diffs failed: %w", err))
+	}
+
+	provider, err := newVCSProvider(ctx)
+	if err != nil {
+		return configError(err)
+	}
+
+	target, err := fetchPR(ctx, provider, repo, id)
+	if err != nil {
+		return vcsError(err)
+	}
+
+	result := reviewersResult{
+		PR:        prKey(repo, id),
+		Author:    target.Author,
+		Files:     len(target.Diff),
+		Reviewers: suggestReviewers(target, computeHeat(mappings, prs), prs),
+	}
+	if reviewersLimit > 0 && len(result.Reviewers) > reviewersLimit {
+		result.Reviewers = result.Reviewers[:reviewersLimit]
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+
+	return enc.Encode(result)
+}
+
+// fetchPR gets the files and the details of a PR from the provider
+func fetchPR(ctx context.Context, provider vcsProvider, repo Repo, id int) (pr, error) {
+	p := pr{Repo: repo, PRID: id}
+
+	diffs, err := provider.listFiles(ctx, repo, id)
+	if err != nil {
+		return p, fmt.Errorf("PR %s: listing files failed: %w", prKey(repo, id), err)
+	}
+	p.Diff = cleanDiffs(diffs)
+	p.Stats = summarizeDiffs(diffs)
+	p.PatchID = patchID(diffs)
+
+	info, err := provider.info(ctx, repo, id)
+	if err != nil {
+		return p, fmt.Errorf("PR %s: fetching details failed: %w", prKey(repo, id), err)
+	}
+	p.Author = info.Author
+	p.MergedAt = info.MergedAt
+	p.Branch = info.BaseBranch
+
+	return p, nil
+}
+
+// suggestReviewers ranks the authors of the bug fixes of the files changed
+// by the target PR. Every fixed file adds its heat score to the author.
+func suggestReviewers(target pr, heat []fileHeat, prs []pr) []reviewerSuggestion {
+	scores := make(map[string]float64, len(heat))
+	for _, h := range heat {
+		scores[fileKey(h.Repo, h.File)] = h.Score
+	}
+
+	touched := make(map[string]bool, len(target.Diff))
+	for _, d := range target.Diff {
+		touched[fileKey(target.Repo, d.File)] = true
+	}
+
+	// The logins of a person count as one, the reviewer being the person
+	identities := newIdentityResolver()
+	author := identities.person(target.Author)
+	byLogin := make(map[string]*reviewerSuggestion)
+	seen := make(map[string]bool)
+	for _, p := range prs {
+		login := identities.person(p.Author)
+		if p.Author == "" || login == author || prKey(p.Repo, p.PRID) == prKey(target.Repo, target.PRID) {
+			continue
+		}
+
+		fixed := false
+		for _, d := range p.Diff {
+			k := fileKey(p.Repo, d.File)
+			if !touched[k] {
+				continue
+			}
+
+			s, ok := byLogin[login]
+			if !ok {
+				s = &reviewerSuggestion{Login: login, Files: make([]string, 0)}
+				byLogin[login] = s
+			}
+			s.Score += scores[k]
+			fixed = true
+
+			if !seen[login+"\x00"+k] {
+				seen[login+"\x00"+k] = true
+				s.Files = append(s.Files, d.File)
+			}
+		}
+		if fixed {
+			byLogin[login].Fixes++
+		}
+	}
+
+	suggestions := make([]reviewerSuggestion, 0, len(byLogin))
+	for _, s := range byLogin {
+		sort.Strings(s.Files)
+		suggestions = append(suggestions, *s)
+	}
+	sort.Slice(suggestions, func(i, j int) bool {
+		if suggestions[i].Score != suggestions[j].Score {
+			return suggestions[i].Score > suggestions[j].Score
+		}
+		return suggestions[i].Login < suggestions[j].Login
+	})
+
+	return suggestions
+}
@@ -1,9 +1,13 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
+	"log/slog"
+	"net/http"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/spf13/cobra"
 
@@ -14,9 +18,16 @@ import (
 const (
 	defaultConfigName = ".heatmap"
 	defaultConfigType = "json"
+	// optionalConfig annotates the commands which run without a config
+	// file, e.g. on the machines of the consumers of the exports
+	optionalConfig = "optional_config"
 )
 
-var cfgFile string
+var (
+	cfgFile string
+	// commandTimeout is the time limit of the whole command, 0 if it's unlimited
+	commandTimeout time.Duration
+)
 
 // rootCmd represents the base command when called without any subcommands
 var rootCmd = &cobra.Command{
@@ -26,30 +37,75 @@ var rootCmd = &cobra.Command{
 and finds the related GitHub PRs, from which extracts information
 about the changes related to the bugs. A vusalization shows the
 most problematic parts of the code.`,
+	SilenceErrors: true,
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		// The arguments are parsed at this point, so any further error is
+		// not a usage error
+		cmd.SilenceUsage = true
+		if _, ok := cmd.Annotations[pluginCommand]; ok {
+			// The flags of heatmap, before the name of the plugin
+			flags, _ := splitPluginArgs(cmd, os.Args[1:])
+			if err := cmd.Root().PersistentFlags().Parse(flags); err != nil {
+				return configError(err)
+			}
+		}
+		if err := setupLogging(); err != nil {
+			return err
+		}
+		_, optional := cmd.Annotations[optionalConfig]
+		if err := initConfig(optional); err != nil {
+			return err
+		}
+		if !optional {
+			if problems := validateConfig(false); len(problems) > 0 {
+				return configProblemsError(problems)
+			}
+		}
+
+		client.Transport = newPolicyTransport(newTagTransport(newPayloadTransport(newRetryTransport(http.DefaultTransport))))
+		slog.Debug("run started", "run_id", startRun())
+
+		return nil
+	},
+	PersistentPostRun: func(cmd *cobra.Command, args []string) {
+		warnExpiringCredentials()
+	},
 }
 
-// Repo represents a pair of a GitHub's repo owner and name
+// Repo represents a pair of a repo owner (or GitLab namespace) and name
 type Repo struct {
-	Owner string `bson:"owner"`
-	Name  string `bson:"name"`
+	Owner string `bson:"owner" json:"owner"`
+	Name  string `bson:"name" json:"name"`
 }
 
 // Execute adds all child commands to the root command and sets flags appropriately.
 // This is called by main.main(). It only needs to happen once to the rootCmd.
+// The exit code of the process depends on the class of the returned error.
 func Execute() {
+	addPluginCommands()
 	if err := rootCmd.Execute(); err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+		if logFormat == "json" {
+			slog.Error("command failed", "err", err, "exit_code", exitCode(err), "run_id", runID())
+		} else {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+		}
+		os.Exit(exitCode(err))
 	}
 }
 
 func init() {
-	cobra.OnInitialize(initConfig)
 	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", fmt.Sprintf("config file (default is $HOME/%s.%s)", defaultConfigName, defaultConfigType))
+	rootCmd.PersistentFlags().BoolVar(&offline, "offline", false, "reject every outbound request, only the store is reachable")
+	rootCmd.PersistentFlags().BoolVar(&ephemeral, "ephemeral", false, "keep the data in memory only, e.g. for a one-shot sync --report")
+	rootCmd.PersistentFlags().BoolVarP(&verbose, "verbose", "v", false, "log the debug messages too")
+	rootCmd.PersistentFlags().BoolVarP(&quiet, "quiet", "q", false, "log only the warnings and errors")
+	rootCmd.PersistentFlags().DurationVar(&commandTimeout, "timeout", 0, "time limit of the whole command (0 means no limit)")
+	rootCmd.PersistentFlags().StringVar(&logFormat, "log-format", "text", "format of the logs: text or json")
 }
 
-// initConfig reads in config file and ENV variables if set.
-func initConfig() {
+// initConfig reads in config file and ENV variables if set. Unless it's
+// optional, a missing config file is an error.
+func initConfig(optional bool) error {
 	if cfgFile != "" {
 		// Use config file from the flag.
 		viper.SetConfigFile(cfgFile)
@@ -57,8 +113,7 @@ func initConfig() {
 		// Find home directory.
 		home, err := homedir.Dir()
 		if err != nil {
-			fmt.Println(err)
-			os.Exit(1)
+			return configError(err)
 		}
 
 		// Search config in home directory with name ".heatmap" (without extension).
@@ -73,9 +128,17 @@ func initConfig() {
 	viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
 
 	// If a config file is found, read it in.
-	if err := viper.ReadInConfig(); err == nil {
-		fmt.Println("Using config file:", viper.ConfigFileUsed())
-	} else {
-		panic("Config not found")
+	if err := viper.ReadInConfig(); err != nil {
+		var notFound viper.ConfigFileNotFoundError
+		if optional && errors.As(err, &notFound) {
+			return nil
+		}
+		if errors.As(err, &notFound) {
+			return configError(fmt.Errorf("no config file %s.%s found in $HOME or the working directory, pass one with --config", defaultConfigName, defaultConfigType))
+		}
+		return configError(fmt.Errorf("reading config failed: %w", err))
 	}
+	slog.Debug("using config file", "path", viper.ConfigFileUsed())
+
+	return nil
 }
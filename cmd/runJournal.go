@@ -0,0 +1,87 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// stageRun represents the outcome of the last runs of a stage, e.g.
+// backfill or collectDiffs
+type stageRun struct {
+	LastSuccess    *time.Time `json:"last_success,omitempty"`
+	LastSuccessRun string     `json:"last_success_run,omitempty"`
+	LastCount      int        `json:"last_count"`
+	LastFailure    *time.Time `json:"last_failure,omitempty"`
+	LastFailureRun string     `json:"last_failure_run,omitempty"`
+	LastError      string     `json:"last_error,omitempty"`
+}
+
+func runJournalPath() string {
+	viper.SetDefault("manifest.dir", ".")
+
+	return filepath.Join(viper.GetString("manifest.dir"), ".heatmap-runs.json")
+}
+
+func loadRuns() (map[string]stageRun, error) {
+	path := runJournalPath()
+	raw, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return map[string]stageRun{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	runs := make(map[string]stageRun)
+	if err := json.Unmarshal(raw, &runs); err != nil {
+		return nil, fmt.Errorf("reading %s failed: %w", path, err)
+	}
+
+	return runs, nil
+}
+
+func saveRuns(runs map[string]stageRun) error {
+	path := runJournalPath()
+	raw, err := json.MarshalIndent(runs, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path+".tmp", raw, 0o600); err != nil {
+		return err
+	}
+
+	return os.Rename(path+".tmp", path)
+}
+
+// recordRun notes the end of a run of the stage in .heatmap-runs.json of
+// manifest.dir, so status tells when it last succeeded. Nothing is noted
+// for the ephemeral runs. A failure to write it is only logged, it mustn't
+// fail the run.
+func recordRun(stage string, n int, runErr error) {
+	if ephemeral {
+		return
+	}
+
+	runs, err := loadRuns()
+	if err == nil {
+		now := time.Now().UTC()
+		r := runs[stage]
+		if runErr != nil {
+			r.LastFailure, r.LastFailureRun, r.LastError = &now, runID(), runErr.Error()
+		} else {
+			r.LastSuccess, r.LastSuccessRun, r.LastCount = &now, runID(), n
+		}
+		runs[stage] = r
+		err = saveRuns(runs)
+	}
+	if err != nil {
+		slog.Warn("recording the run failed", "stage", stage, "err", err)
+	}
+}
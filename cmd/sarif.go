@@ -0,0 +1,213 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+const (
+	sarifSchema  = "https://json.schemastore.org/sarif-2.1.0.json"
+	sarifVersion = "2.1.0"
+	// sarifRuleID is the ID of the rule of the results of the hot files
+	sarifRuleID = "bug-heat"
+)
+
+// sarifLog represents a SARIF log with the single run of heatmap
+type sarifLog struct {
+	Schema  string     `json:"$schema"`
+	Version string     `json:"version"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool    sarifTool     `json:"tool"`
+	Results []sarifResult `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name  string      `json:"name"`
+	Rules []sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	ID                   string          `json:"id"`
+	Name                 string          `json:"name"`
+	ShortDescription     sarifMessage    `json:"shortDescription"`
+	FullDescription      sarifMessage    `json:"fullDescription"`
+	DefaultConfiguration sarifRuleConfig `json:"defaultConfiguration"`
+}
+
+type sarifRuleConfig struct {
+	Level string `json:"level"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+// sarifResult represents a hot file. The heat of the file is kept in its
+// properties too, for the tools reading them.
+type sarifResult struct {
+	RuleID              string            `json:"ruleId"`
+	Level               string            `json:"level"`
+	Message             sarifMessage      `json:"message"`
+	Locations           []sarifLocation   `json:"locations"`
+	PartialFingerprints map[string]string `json:"partialFingerprints"`
+	Properties          sarifProperties   `json:"properties"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation sarifPhysicalLocation `json:"physicalLocation"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+	Region           sarifRegion           `json:"region"`
+}
+
+type sarifArtifactLocation struct {
+	URI string `json:"uri"`
+}
+
+type sarifRegion struct {
+	StartLine int `json:"startLine"`
+}
+
+type sarifProperties struct {
+	Repo         string  `json:"repo"`
+	Rank         int     `json:"rank"`
+	Bugs         int     `json:"bugs"`
+	PRs          int     `json:"prs"`
+	Score        float64 `json:"score"`
+	Risk         float64 `json:"risk"`
+	AcceptedRisk string  `json:"accepted_risk,omitempty"`
+}
+
+// exportSARIF writes the hottest files of the store as the results of a
+// SARIF log to the file of --out or the standard output
+func exportSARIF() error {
+	if err := checkRepoScope(); err != nil {
+		return err
+	}
+	if err := checkSigner(); err != nil {
+		return err
+	}
+
+	ctx, cancel, st, err := openReadStore()
+	if err != nil {
+		return err
+	}
+	defer cancel()
+	defer closeStore(ctx, st)
+
+	reportGroup, reportGrain = "file", "file"
+	heat, _, err := loadHeat(ctx, st)
+	if err != nil {
+		return err
+	}
+	heat = topRepoFiles(heat, repoScope, reportTop)
+
+	out := os.Stdout
+	if exportOut != "" && exportOut != "-" {
+		if out, err = os.Create(exportOut); err != nil {
+			return err
+		}
+		defer out.Close()
+	}
+	if err := writeSARIF(out, heat); err != nil {
+		return err
+	}
+
+	if out != os.Stdout {
+		if err := out.Close(); err != nil {
+			return err
+		}
+		return signFiles(exportOut)
+	}
+
+	return nil
+}
+
+// topRepoFiles returns the top files of the sorted heat, only the ones
+// of the owner/name repo if it's set. A top of 0 returns all of them.
+func topRepoFiles(heat []fileHeat, repo string, top int) []fileHeat {
+	files := make([]fileHeat, 0, len(heat))
+	for _, h := range heat {
+		if repo != "" && !strings.EqualFold(h.Repo.Owner+"/"+h.Repo.Name, repo) {
+			continue
+		}
+		if top > 0 && len(files) == top {
+			break
+		}
+		files = append(files, h)
+	}
+
+	return files
+}
+
+// writeSARIF writes the files as the results of a SARIF log, in the order
+// of their heat. The files with an accepted risk are notes, the others
+// warnings.
+func writeSARIF(w io.Writer, heat []fileHeat) error {
+	results := make([]sarifResult, 0, len(heat))
+	for i, h := range heat {
+		repo := h.Repo.Owner + "/" + h.Repo.Name
+		level := "warning"
+		if h.AcceptedRisk != "" {
+			level = "note"
+		}
+
+		results = append(results, sarifResult{
+			RuleID: sarifRuleID,
+			Level:  level,
+			Message: sarifMessage{Text: fmt.Sprintf(
+				"Hot file #%d: touched by the fixes of %d bugs in %d PRs (score %.2f, risk %.2f). Review its changes with extra care.",
+				i+1, h.Bugs, h.PRs, h.Score, h.Risk)},
+			Locations: []sarifLocation{{PhysicalLocation: sarifPhysicalLocation{
+				ArtifactLocation: sarifArtifactLocation{URI: h.File},
+				Region:           sarifRegion{StartLine: 1},
+			}}},
+			// the fingerprint keeps a file the same alert while its heat changes
+			PartialFingerprints: map[string]string{"heatmapFile/v1": repo + "/" + h.File},
+			Properties: sarifProperties{
+				Repo:         repo,
+				Rank:         i + 1,
+				Bugs:         h.Bugs,
+				PRs:          h.PRs,
+				Score:        h.Score,
+				Risk:         h.Risk,
+				AcceptedRisk: h.AcceptedRisk,
+			},
+		})
+	}
+
+	log := sarifLog{
+		Schema:  sarifSchema,
+		Version: sarifVersion,
+		Runs: []sarifRun{{
+			Tool: sarifTool{Driver: sarifDriver{
+				Name: "heatmap",
+				Rules: []sarifRule{{
+					ID:                   sarifRuleID,
+					Name:                 "HotFile",
+					ShortDescription:     sarifMessage{Text: "File with a high bug heat"},
+					FullDescription:      sarifMessage{Text: "The file is among the ones touched by the fixes of the most bugs, weighted by their churn."},
+					DefaultConfiguration: sarifRuleConfig{Level: "warning"},
+				}},
+			}},
+			Results: results,
+		}},
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+
+	return enc.Encode(log)
+}
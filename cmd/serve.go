@@ -0,0 +1,443 @@
+package cmd
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// serveCmd represents the serve command
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Serves the heat as a REST API",
+	Long: `Runs an HTTP server exposing the heat as JSON for the other
+tools, e.g. the bots reviewing the PRs or the plugins of the IDEs:
+
+  GET /api/v1/files?top=50
+      the hottest files, like report --format json; top defaults to
+      50, 0 returns all files
+  GET /api/v1/files/{owner}/{name}/{file}/bugs
+      the bugs whose fixes touched the file, from the latest fix, with
+      their PRs, e.g. /api/v1/files/acme/billing/src/invoice.go/bugs
+  GET /api/v1/repos/{owner}/{name}/heat?top=10
+      the totals of the repo and its hottest files
+
+The heat is computed from the store when the server starts, with the
+issue filters of the flags, like by report, and kept until a sync
+changes the store: every serve.refresh (default 1m) the server compares
+the stats of the collections the heat is read from and recomputes it if
+they moved. A failed recompute is logged and the last heat kept.
+
+The server listens on 127.0.0.1:8080 unless --addr is set. If
+serve.token (HEATMAP_SERVE_TOKEN) is set, the requests must send it as
+"Authorization: Bearer <token>"; it's required to listen on an address
+other than a loopback one.
+
+The errors are returned as {"error": "..."} with the status of the
+error: 400 for an invalid parameter, 401 for a missing or wrong token,
+404 for a file or a repo without heat and 500 for a failed read of the
+store.`,
+	RunE: serve,
+}
+
+const (
+	defaultServeAddr    = "127.0.0.1:8080"
+	defaultServeTop     = 50
+	defaultServeRefresh = time.Minute
+	serveRequestTimeout = time.Minute
+)
+
+var serveAddr string
+
+// heatCollections are the collections the heat is computed from, whose
+// stats tell the cache of serve that a sync changed them
+var heatCollections = map[string]bool{"mappings": true, "prs": true, "repos": true, "annotations": true}
+
+// heatCache holds the heat served by the API with the stats of the store
+// it was computed from
+type heatCache struct {
+	mu    sync.RWMutex
+	heat  []fileHeat
+	stats string
+}
+
+// apiBug represents a bug whose fixes touched a file
+type apiBug struct {
+	Issue    string   `json:"issue"`
+	Project  string   `json:"project,omitempty"`
+	IssueID  int      `json:"issue_id,omitempty"`
+	Summary  string   `json:"summary,omitempty"`
+	Priority string   `json:"priority,omitempty"`
+	Fixes    []apiFix `json:"fixes"`
+}
+
+// apiFix represents a PR of a bug touching a file
+type apiFix struct {
+	PR       string    `json:"pr"`
+	MergedAt time.Time `json:"merged_at,omitempty"`
+	Lines    int       `json:"lines"`
+}
+
+// apiRepoHeat represents the heat of a repo
+type apiRepoHeat struct {
+	Repo    Repo       `json:"repo"`
+	Files   int        `json:"files"`
+	Bugs    int        `json:"bugs"`
+	PRs     int        `json:"prs"`
+	Changes int        `json:"changes"`
+	Score   float64    `json:"score"`
+	Top     []fileHeat `json:"top"`
+}
+
+// apiError represents an error of a request with its status
+type apiError struct {
+	status int
+	err    error
+}
+
+func (e *apiError) Error() string {
+	return e.err.Error()
+}
+
+func init() {
+	rootCmd.AddCommand(serveCmd)
+	serveCmd.Flags().StringVar(&serveAddr, "addr", defaultServeAddr, "address to listen on")
+	issueFilterFlags(serveCmd)
+}
+
+func serve(cmd *cobra.Command, args []string) error {
+	ctx, cancel, st, err := openReadStore()
+	if err != nil {
+		return err
+	}
+	defer cancel()
+	defer closeStore(context.Background(), st)
+
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	token := viper.GetString("serve.token")
+	if token == "" && !loopbackAddr(serveAddr) {
+		return configError(fmt.Errorf("serve.token is not set, set it to listen on %s", serveAddr))
+	}
+	viper.SetDefault("serve.refresh", defaultServeRefresh)
+	refresh := viper.GetDuration("serve.refresh")
+	if refresh <= 0 {
+		return configError(fmt.Errorf("invalid serve.refresh %s", refresh))
+	}
+
+	cache := &heatCache{}
+	if _, err := cache.refresh(ctx, st); err != nil {
+		return err
+	}
+	go cache.poll(ctx, st, refresh)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/files", apiHandler(st, cache, token, apiFiles))
+	mux.HandleFunc("/api/v1/files/", apiHandler(st, cache, token, apiFileBugs))
+	mux.HandleFunc("/api/v1/repos/", apiHandler(st, cache, token, apiRepo))
+
+	srv := &http.Server{Addr: serveAddr, Handler: mux}
+	go func() {
+		<-ctx.Done()
+		shutdown, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdown)
+	}()
+
+	slog.Info("serving the API", "addr", serveAddr, "auth", token != "")
+	if err := srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	return nil
+}
+
+// loopbackAddr tells if the address only listens on a loopback
+// interface, the empty host listening on all of them
+func loopbackAddr(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil || host == "" {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+
+	return ip != nil && ip.IsLoopback()
+}
+
+// refresh recomputes the heat if the stats of its collections changed
+// since it was last computed, telling if it did. Only one goroutine
+// refreshes the cache, as loadHeat sets package state.
+func (c *heatCache) refresh(ctx context.Context, st store) (bool, error) {
+	stats, err := st.Stats(ctx)
+	if err != nil {
+		return false, storageError(fmt.Errorf("reading the storage stats failed: %w", err))
+	}
+	var b strings.Builder
+	for _, s := range stats {
+		if heatCollections[s.Collection] {
+			fmt.Fprintf(&b, "%s:%d:%d ", s.Collection, s.Docs, s.Bytes)
+		}
+	}
+
+	c.mu.RLock()
+	fresh := c.heat != nil && c.stats == b.String()
+	c.mu.RUnlock()
+	if fresh {
+		return false, nil
+	}
+
+	heat, _, err := loadHeat(ctx, st)
+	if err != nil {
+		return false, err
+	}
+
+	c.mu.Lock()
+	c.heat, c.stats = heat, b.String()
+	c.mu.Unlock()
+
+	return true, nil
+}
+
+// poll refreshes the cache every interval until the context is done
+func (c *heatCache) poll(ctx context.Context, st store, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			changed, err := c.refresh(ctx, st)
+			if err != nil {
+				slog.Error("recomputing the heat failed, serving the last one", "err", err)
+			} else if changed {
+				slog.Info("heat recomputed")
+			}
+		}
+	}
+}
+
+// get returns the cached heat, which the handlers must not change
+func (c *heatCache) get() []fileHeat {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.heat
+}
+
+// authorized tells if the request sends the token, any request being
+// if there's none
+func authorized(r *http.Request, token string) bool {
+	if token == "" {
+		return true
+	}
+	got := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+
+	return subtle.ConstantTimeCompare([]byte(got), []byte(token)) == 1
+}
+
+// apiHandler serves the JSON returned by fn for the cached heat
+func apiHandler(st store, cache *heatCache, token string, fn func(r *http.Request, heat []fileHeat, st store) (interface{}, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if !authorized(r, token) {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeAPIError(w, &apiError{http.StatusUnauthorized, errors.New("missing or invalid token")})
+			return
+		}
+		if r.Method != "GET" {
+			writeAPIError(w, &apiError{http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method)})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), serveRequestTimeout)
+		defer cancel()
+
+		v, err := fn(r.WithContext(ctx), cache.get(), st)
+		if err != nil {
+			writeAPIError(w, err)
+			return
+		}
+		if err := json.NewEncoder(w).Encode(v); err != nil {
+			slog.Warn("writing the response failed", "path", r.URL.Path, "err", err)
+		}
+	}
+}
+
+func writeAPIError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	var ae *apiError
+	if errors.As(err, &ae) {
+		status = ae.status
+	}
+
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+}
+
+// apiTop parses the top parameter of the request, def if it's missing
+func apiTop(r *http.Request, def int) (int, error) {
+	s := r.URL.Query().Get("top")
+	if s == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		return 0, &apiError{http.StatusBadRequest, fmt.Errorf("invalid top %q", s)}
+	}
+
+	return n, nil
+}
+
+func topHeat(heat []fileHeat, top int) []fileHeat {
+	if top > 0 && len(heat) > top {
+		return heat[:top]
+	}
+
+	return heat
+}
+
+func apiFiles(r *http.Request, heat []fileHeat, st store) (interface{}, error) {
+	top, err := apiTop(r, defaultServeTop)
+	if err != nil {
+		return nil, err
+	}
+
+	return topHeat(heat, top), nil
+}
+
+// apiFileBugs finds the file by its owner/name/file path, which is matched
+// as a whole, so the owners with slashes, e.g. the GitLab subgroups, are
+// found too
+func apiFileBugs(r *http.Request, heat []fileHeat, st store) (interface{}, error) {
+	p := strings.TrimPrefix(r.URL.Path, "/api/v1/files/")
+	if !strings.HasSuffix(p, "/bugs") {
+		return nil, &apiError{http.StatusNotFound, fmt.Errorf("unknown endpoint %s", r.URL.Path)}
+	}
+	p = strings.TrimSuffix(p, "/bugs")
+
+	var file *fileHeat
+	for i := range heat {
+		if strings.Join([]string{heat[i].Repo.Owner, heat[i].Repo.Name, heat[i].File}, "/") == p {
+			file = &heat[i]
+			break
+		}
+	}
+	if file == nil {
+		return nil, &apiError{http.StatusNotFound, fmt.Errorf("no heat of %s", p)}
+	}
+
+	mappings, err := st.Mappings(r.Context())
+	if err != nil {
+		return nil, storageError(fmt.Errorf("reading mappings failed: %w", err))
+	}
+	issues := make(map[string]mongoMapping, len(mappings))
+	for _, m := range mappings {
+		k := m.IssueKey
+		if k == "" {
+			k = m.bugKey()
+		}
+		issues[k] = m
+	}
+
+	// A PR touching the file is a fix of a bug once, even if it's mapped
+	// to the bug twice
+	bugs := make([]apiBug, 0)
+	index := make(map[string]int)
+	fixed := make(map[string]bool)
+	for _, e := range file.events {
+		if fixed[e.Issue+" "+e.PR] {
+			continue
+		}
+		fixed[e.Issue+" "+e.PR] = true
+
+		i, ok := index[e.Issue]
+		if !ok {
+			m := issues[e.Issue]
+			i = len(bugs)
+			index[e.Issue] = i
+			bugs = append(bugs, apiBug{Issue: e.Issue, Project: m.Project, IssueID: m.IssueID, Summary: m.Summary, Priority: m.Priority})
+		}
+		bugs[i].Fixes = append(bugs[i].Fixes, apiFix{PR: e.PR, MergedAt: e.Time, Lines: e.Lines})
+	}
+
+	latestFix := func(b apiBug) time.Time {
+		var t time.Time
+		for _, f := range b.Fixes {
+			t = latest(t, f.MergedAt)
+		}
+		return t
+	}
+	for i := range bugs {
+		sort.SliceStable(bugs[i].Fixes, func(a, b int) bool { return bugs[i].Fixes[a].MergedAt.After(bugs[i].Fixes[b].MergedAt) })
+	}
+	sort.SliceStable(bugs, func(i, j int) bool { return latestFix(bugs[i]).After(latestFix(bugs[j])) })
+
+	return struct {
+		Repo Repo     `json:"repo"`
+		File string   `json:"file"`
+		Bugs []apiBug `json:"bugs"`
+	}{file.Repo, file.File, bugs}, nil
+}
+
+// apiRepo sums the heat of the files of the repo, counting its bugs and
+// PRs once
+func apiRepo(r *http.Request, heat []fileHeat, st store) (interface{}, error) {
+	p := strings.TrimPrefix(r.URL.Path, "/api/v1/repos/")
+	if !strings.HasSuffix(p, "/heat") {
+		return nil, &apiError{http.StatusNotFound, fmt.Errorf("unknown endpoint %s", r.URL.Path)}
+	}
+	p = strings.TrimSuffix(p, "/heat")
+	i := strings.LastIndex(p, "/")
+	if i <= 0 || i == len(p)-1 {
+		return nil, &apiError{http.StatusBadRequest, fmt.Errorf("repo %q is not in the owner/name format", p)}
+	}
+	repo := Repo{Owner: p[:i], Name: p[i+1:]}
+
+	top, err := apiTop(r, defaultReportTop)
+	if err != nil {
+		return nil, err
+	}
+
+	files := make([]fileHeat, 0)
+	for _, h := range heat {
+		if h.Repo == repo {
+			files = append(files, h)
+		}
+	}
+	if len(files) == 0 {
+		return nil, &apiError{http.StatusNotFound, fmt.Errorf("no heat of %s", p)}
+	}
+
+	total := groupHeat(files, func(fileHeat) []string { return []string{p} })[0]
+
+	return apiRepoHeat{
+		Repo:    repo,
+		Files:   len(files),
+		Bugs:    total.Bugs,
+		PRs:     total.PRs,
+		Changes: total.Changes,
+		Score:   total.Score,
+		Top:     topHeat(files, top),
+	}, nil
+}
@@ -0,0 +1,291 @@
+package cmd
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// verifySignatureCmd represents the verify-signature command
+var verifySignatureCmd = &cobra.Command{
+	Use:   "verify-signature FILE...",
+	Short: "Verifies the signatures of exported files",
+	Long: `Checks the files written by report --out and export against
+their detached signatures in <file>.sig and fails with the exit
+code of a failed gate if a file was changed after it was signed
+or was signed with another key.
+
+The files are signed when signing.private_key_file, or the PEM of
+signing.private_key, holds an Ed25519 private key, e.g. created
+with
+  openssl genpkey -algorithm ed25519 -out heatmap.key
+  openssl pkey -in heatmap.key -pubout -out heatmap.pub
+The public key of --key, or signing.public_key_file or the PEM of
+signing.public_key, verifies them; it's derived from the private
+key if only that is set.`,
+	Args:        cobra.MinimumNArgs(1),
+	Annotations: map[string]string{optionalConfig: ""},
+	RunE:        verifySignatures,
+}
+
+const signatureVersion = "heatmap-signature-v1"
+
+var verifyKeyFile string
+
+func init() {
+	rootCmd.AddCommand(verifySignatureCmd)
+	verifySignatureCmd.Flags().StringVar(&verifyKeyFile, "key", "", "PEM file of the public key (default is signing.public_key_file)")
+}
+
+// fileSignature represents the detached signature of a file. The key
+// signs the statement of the SHA-256 of the file and the signing time.
+type fileSignature struct {
+	Version   string    `json:"version"`
+	Algorithm string    `json:"algorithm"`
+	KeyID     string    `json:"key_id"`
+	SHA256    string    `json:"sha256"`
+	SignedAt  time.Time `json:"signed_at"`
+	Signature string    `json:"signature"`
+}
+
+func (s fileSignature) statement() []byte {
+	return []byte(fmt.Sprintf("%s\nsha256:%s\nsigned_at:%s\n", s.Version, s.SHA256, s.SignedAt.UTC().Format(time.RFC3339Nano)))
+}
+
+// fileSigner signs the written files
+type fileSigner struct {
+	key ed25519.PrivateKey
+}
+
+// loadSigner reads the key of signing.private_key or
+// signing.private_key_file. It returns nil if neither is set.
+func loadSigner() (*fileSigner, error) {
+	raw, err := configPEM("signing.private_key", "signing.private_key_file")
+	if err != nil || raw == nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(raw)
+	if block == nil {
+		return nil, errors.New("no PEM block found in the signing key")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parsing the signing key failed: %w", err)
+	}
+	edKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, errors.New("the signing key isn't an Ed25519 key")
+	}
+
+	return &fileSigner{key: edKey}, nil
+}
+
+// configPEM returns the PEM of the config key or of the file of the file
+// key, or nil if neither is set
+func configPEM(key, fileKey string) ([]byte, error) {
+	if value := viper.GetString(key); value != "" {
+		return []byte(value), nil
+	}
+	path := viper.GetString(fileKey)
+	if path == "" {
+		return nil, nil
+	}
+
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s failed: %w", fileKey, err)
+	}
+
+	return raw, nil
+}
+
+// keyID identifies a public key by the start of its hash
+func keyID(pub ed25519.PublicKey) string {
+	sum := sha256.Sum256(pub)
+
+	return hex.EncodeToString(sum[:8])
+}
+
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// sign writes the signature of the file into <path>.sig
+func (s *fileSigner) sign(path string) error {
+	sum, err := fileSHA256(path)
+	if err != nil {
+		return err
+	}
+
+	sig := fileSignature{
+		Version:   signatureVersion,
+		Algorithm: "ed25519",
+		KeyID:     keyID(s.key.Public().(ed25519.PublicKey)),
+		SHA256:    sum,
+		SignedAt:  time.Now().UTC(),
+	}
+	sig.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(s.key, sig.statement()))
+
+	raw, err := json.MarshalIndent(sig, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path+".sig", raw, 0o644); err != nil {
+		return err
+	}
+	slog.Debug("file signed", "file", path, "key_id", sig.KeyID)
+
+	return nil
+}
+
+// checkSigner fails on an invalid signing key before any file is written
+func checkSigner() error {
+	if _, err := loadSigner(); err != nil {
+		return configError(err)
+	}
+
+	return nil
+}
+
+// signFiles signs the files if a signing key is configured
+func signFiles(paths ...string) error {
+	signer, err := loadSigner()
+	if err != nil {
+		return configError(err)
+	}
+	if signer == nil {
+		return nil
+	}
+
+	for _, p := range paths {
+		if err := signer.sign(p); err != nil {
+			return fmt.Errorf("signing %s failed: %w", p, err)
+		}
+	}
+
+	return nil
+}
+
+// verifyKey reads the public key of --key or signing.public_key_file, or
+// derives it from the signing key
+func verifyKey() (ed25519.PublicKey, error) {
+	var (
+		raw []byte
+		err error
+	)
+	if verifyKeyFile != "" {
+		raw, err = os.ReadFile(verifyKeyFile)
+	} else {
+		raw, err = configPEM("signing.public_key", "signing.public_key_file")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if raw == nil {
+		signer, err := loadSigner()
+		if err != nil {
+			return nil, err
+		}
+		if signer == nil {
+			return nil, errors.New("neither --key nor signing.public_key_file is set")
+		}
+		return signer.key.Public().(ed25519.PublicKey), nil
+	}
+
+	block, _ := pem.Decode(raw)
+	if block == nil {
+		return nil, errors.New("no PEM block found in the public key")
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parsing the public key failed: %w", err)
+	}
+	edKey, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, errors.New("the public key isn't an Ed25519 key")
+	}
+
+	return edKey, nil
+}
+
+// verifyFile checks the file against its signature
+func verifyFile(pub ed25519.PublicKey, path string) (fileSignature, error) {
+	sig := fileSignature{}
+	raw, err := os.ReadFile(path + ".sig")
+	if err != nil {
+		return sig, err
+	}
+	if err := json.Unmarshal(raw, &sig); err != nil {
+		return sig, fmt.Errorf("invalid signature file: %w", err)
+	}
+	if sig.Version != signatureVersion || sig.Algorithm != "ed25519" {
+		return sig, fmt.Errorf("unsupported signature %s %s", sig.Version, sig.Algorithm)
+	}
+	if id := keyID(pub); sig.KeyID != id {
+		return sig, fmt.Errorf("signed with key %s, not %s", sig.KeyID, id)
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(sig.Signature)
+	if err != nil || !ed25519.Verify(pub, sig.statement(), signature) {
+		return sig, errors.New("the signature is invalid")
+	}
+
+	sum, err := fileSHA256(path)
+	if err != nil {
+		return sig, err
+	}
+	if sum != sig.SHA256 {
+		return sig, errors.New("the file was changed after it was signed")
+	}
+
+	return sig, nil
+}
+
+func verifySignatures(cmd *cobra.Command, args []string) error {
+	pub, err := verifyKey()
+	if err != nil {
+		return configError(err)
+	}
+
+	failed := 0
+	for _, path := range args {
+		sig, err := verifyFile(pub, path)
+		if err != nil {
+			slog.Error("verification failed", "file", path, "err", err)
+			failed++
+			continue
+		}
+		slog.Info("signature verified", "file", path, "key_id", sig.KeyID, "signed_at", sig.SignedAt.Format(time.RFC3339))
+	}
+	if failed > 0 {
+		return gateError(fmt.Errorf("%d of %d files failed the verification", failed, len(args)))
+	}
+
+	return nil
+}
@@ -0,0 +1,652 @@
+package cmd
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	// Registers the sqlite3 driver
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/spf13/viper"
+)
+
+const defaultSQLitePath = "heatmap.db"
+
+// sqliteSchema creates the tables of the store. Every row keeps the whole
+// document as JSON next to the columns needed to query it.
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS mappings (
+	id        INTEGER PRIMARY KEY,
+	project   TEXT    NOT NULL,
+	tracker   TEXT    NOT NULL DEFAULT '',
+	issue_id  INTEGER NOT NULL,
+	issue_uid TEXT    NOT NULL DEFAULT '',
+	owner     TEXT    NOT NULL,
+	name      TEXT    NOT NULL,
+	pr_id     INTEGER NOT NULL,
+	doc       TEXT    NOT NULL
+);
+CREATE INDEX IF NOT EXISTS mappings_pr ON mappings (owner, name, pr_id);
+CREATE UNIQUE INDEX IF NOT EXISTS mappings_unique ON mappings (project, tracker, issue_id, issue_uid, owner, name, pr_id);
+
+CREATE TABLE IF NOT EXISTS prs (
+	owner TEXT    NOT NULL,
+	name  TEXT    NOT NULL,
+	pr_id INTEGER NOT NULL,
+	doc   TEXT    NOT NULL,
+	PRIMARY KEY (owner, name, pr_id)
+);
+
+CREATE TABLE IF NOT EXISTS sync (
+	project   TEXT PRIMARY KEY,
+	last_sync TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS reports (
+	id      INTEGER PRIMARY KEY,
+	created TEXT NOT NULL,
+	doc     TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS archive (
+	id       INTEGER PRIMARY KEY,
+	kind     TEXT NOT NULL,
+	archived TEXT NOT NULL,
+	doc      TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS repos (
+	owner TEXT NOT NULL,
+	name  TEXT NOT NULL,
+	doc   TEXT NOT NULL,
+	PRIMARY KEY (owner, name)
+);
+
+CREATE TABLE IF NOT EXISTS annotations (
+	id  INTEGER PRIMARY KEY,
+	doc TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS payloads (
+	id      INTEGER PRIMARY KEY,
+	key     TEXT    NOT NULL,
+	expires INTEGER NOT NULL,
+	doc     TEXT    NOT NULL
+);
+CREATE INDEX IF NOT EXISTS payloads_key ON payloads (key);
+`
+
+// sqliteStore keeps the data in a local SQLite database
+type sqliteStore struct {
+	db *sql.DB
+}
+
+func openSQLiteStore() (context.Context, context.CancelFunc, store, error) {
+	viper.SetDefault("sqlite.path", defaultSQLitePath)
+
+	db, err := sql.Open("sqlite3", viper.GetString("sqlite.path"))
+	if err != nil {
+		return nil, nil, nil, storageError(fmt.Errorf("opening SQLite database failed: %w", err))
+	}
+
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		db.Close()
+		return nil, nil, nil, storageError(fmt.Errorf("creating SQLite schema failed: %w", err))
+	}
+
+	ctx, cancel := commandContext()
+
+	return ctx, cancel, &sqliteStore{db: db}, nil
+}
+
+func (s *sqliteStore) Close(ctx context.Context) error {
+	return s.db.Close()
+}
+
+func (s *sqliteStore) MappedIssues(ctx context.Context) (map[string]bool, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT DISTINCT tracker, issue_id, issue_uid FROM mappings")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	mappings := make(map[string]bool)
+	for rows.Next() {
+		var (
+			tracker, uid string
+			id           int
+		)
+		if err := rows.Scan(&tracker, &id, &uid); err != nil {
+			return nil, err
+		}
+		mappings[issueRef(tracker, id, uid)] = false
+	}
+
+	return mappings, rows.Err()
+}
+
+func (s *sqliteStore) InsertMappings(ctx context.Context, mappings []mongoMapping) error {
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		for _, m := range mappings {
+			doc, err := json.Marshal(m)
+			if err != nil {
+				return err
+			}
+
+			_, err = tx.ExecContext(ctx,
+				`INSERT INTO mappings (project, tracker, issue_id, issue_uid, owner, name, pr_id, doc) VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+				ON CONFLICT (project, tracker, issue_id, issue_uid, owner, name, pr_id) DO UPDATE SET doc = excluded.doc`,
+				m.Project, m.Tracker, m.IssueID, m.IssueUID, m.Repo.Owner, m.Repo.Name, m.PRID, string(doc),
+			)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+func (s *sqliteStore) Mappings(ctx context.Context) ([]mongoMapping, error) {
+	mappings := make([]mongoMapping, 0)
+	err := s.eachDoc(ctx, "SELECT doc FROM mappings ORDER BY id", func(doc []byte) error {
+		m := mongoMapping{}
+		if err := json.Unmarshal(doc, &m); err != nil {
+			return err
+		}
+		mappings = append(mappings, m)
+
+		return nil
+	})
+
+	return mappings, err
+}
+
+func (s *sqliteStore) Watermark(ctx context.Context, project string) (time.Time, error) {
+	var value string
+	err := s.db.QueryRowContext(ctx, "SELECT last_sync FROM sync WHERE project = ?", project).Scan(&value)
+	if err == sql.ErrNoRows {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return time.Parse(time.RFC3339Nano, value)
+}
+
+func (s *sqliteStore) SetWatermark(ctx context.Context, project string, t time.Time) error {
+	_, err := s.db.ExecContext(ctx,
+		"INSERT OR REPLACE INTO sync (project, last_sync) VALUES (?, ?)",
+		project, t.UTC().Format(time.RFC3339Nano),
+	)
+
+	return err
+}
+
+func (s *sqliteStore) NotAnalyzedPRs(ctx context.Context, fn func(p pr) error) error {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT DISTINCT m.owner, m.name, m.pr_id
+		FROM mappings m LEFT JOIN prs p USING (owner, name, pr_id)
+		WHERE p.pr_id IS NULL`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		p := pr{}
+		if err := rows.Scan(&p.Repo.Owner, &p.Repo.Name, &p.PRID); err != nil {
+			return err
+		}
+		if err := fn(p); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+func (s *sqliteStore) InsertPRs(ctx context.Context, prs []pr) error {
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		for _, p := range prs {
+			doc, err := json.Marshal(p)
+			if err != nil {
+				return err
+			}
+
+			_, err = tx.ExecContext(ctx,
+				`INSERT INTO prs (owner, name, pr_id, doc) VALUES (?, ?, ?, ?)
+				ON CONFLICT (owner, name, pr_id) DO UPDATE SET doc = excluded.doc`,
+				p.Repo.Owner, p.Repo.Name, p.PRID, string(doc),
+			)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+func (s *sqliteStore) PRs(ctx context.Context) ([]pr, error) {
+	prs := make([]pr, 0)
+	err := s.eachDoc(ctx, "SELECT doc FROM prs", func(doc []byte) error {
+		p := pr{}
+		if err := json.Unmarshal(doc, &p); err != nil {
+			return err
+		}
+		prs = append(prs, p)
+
+		return nil
+	})
+
+	return prs, err
+}
+
+func (s *sqliteStore) SaveRepos(ctx context.Context, repos []repoMeta) error {
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		for _, r := range repos {
+			doc, err := json.Marshal(r)
+			if err != nil {
+				return err
+			}
+
+			_, err = tx.ExecContext(ctx,
+				`INSERT INTO repos (owner, name, doc) VALUES (?, ?, ?)
+				ON CONFLICT (owner, name) DO UPDATE SET doc = excluded.doc`,
+				r.Repo.Owner, r.Repo.Name, string(doc),
+			)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+func (s *sqliteStore) Repos(ctx context.Context) ([]repoMeta, error) {
+	repos := make([]repoMeta, 0)
+	err := s.eachDoc(ctx, "SELECT doc FROM repos", func(doc []byte) error {
+		r := repoMeta{}
+		if err := json.Unmarshal(doc, &r); err != nil {
+			return err
+		}
+		repos = append(repos, r)
+
+		return nil
+	})
+
+	return repos, err
+}
+
+func (s *sqliteStore) ReplaceAnnotations(ctx context.Context, annotations []annotation) error {
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM annotations"); err != nil {
+			return err
+		}
+		for _, a := range annotations {
+			doc, err := json.Marshal(a)
+			if err != nil {
+				return err
+			}
+			if _, err := tx.ExecContext(ctx, "INSERT INTO annotations (doc) VALUES (?)", string(doc)); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+func (s *sqliteStore) Annotations(ctx context.Context) ([]annotation, error) {
+	annotations := make([]annotation, 0)
+	err := s.eachDoc(ctx, "SELECT doc FROM annotations ORDER BY id", func(doc []byte) error {
+		a := annotation{}
+		if err := json.Unmarshal(doc, &a); err != nil {
+			return err
+		}
+		annotations = append(annotations, a)
+
+		return nil
+	})
+
+	return annotations, err
+}
+
+// SavePayloads keeps the expiry as Unix seconds, so the expired payloads
+// are removed by comparing numbers
+func (s *sqliteStore) SavePayloads(ctx context.Context, payloads []rawPayload) error {
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM payloads WHERE expires <= ?", time.Now().Unix()); err != nil {
+			return err
+		}
+		for _, p := range payloads {
+			doc, err := json.Marshal(p)
+			if err != nil {
+				return err
+			}
+			_, err = tx.ExecContext(ctx, "INSERT INTO payloads (key, expires, doc) VALUES (?, ?, ?)", p.Key, p.ExpiresAt.Unix(), string(doc))
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+func (s *sqliteStore) Payloads(ctx context.Context, key string) ([]rawPayload, error) {
+	payloads := make([]rawPayload, 0)
+	err := s.eachDoc(ctx, "SELECT doc FROM payloads WHERE key = ? AND expires > ? ORDER BY id", func(doc []byte) error {
+		p := rawPayload{}
+		if err := json.Unmarshal(doc, &p); err != nil {
+			return err
+		}
+		payloads = append(payloads, p)
+
+		return nil
+	}, key, time.Now().Unix())
+
+	return payloads, err
+}
+
+func (s *sqliteStore) SaveReport(ctx context.Context, r heatReport) error {
+	doc, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.ExecContext(ctx,
+		"INSERT INTO reports (created, doc) VALUES (?, ?)",
+		r.Created.UTC().Format(time.RFC3339Nano), string(doc),
+	)
+
+	return err
+}
+
+func (s *sqliteStore) LatestReport(ctx context.Context) (*heatReport, error) {
+	var doc string
+	err := s.db.QueryRowContext(ctx, "SELECT doc FROM reports ORDER BY created DESC LIMIT 1").Scan(&doc)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	r := &heatReport{}
+	if err := json.Unmarshal([]byte(doc), r); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+func (s *sqliteStore) PurgeProject(ctx context.Context, project string) (int, int, error) {
+	var mappings, prs int64
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		// The payloads and the PRs go first, while the mappings still
+		// tell which ones belong only to the project
+		_, err := tx.ExecContext(ctx, `
+			DELETE FROM payloads
+			WHERE key IN (SELECT CAST(issue_id AS TEXT) FROM mappings WHERE project = ? AND tracker = '')
+			OR key IN (SELECT p.owner || '/' || p.name || '#' || p.pr_id FROM prs p
+				WHERE EXISTS (SELECT 1 FROM mappings m
+					WHERE m.project = ? AND m.owner = p.owner AND m.name = p.name AND m.pr_id = p.pr_id)
+				AND NOT EXISTS (SELECT 1 FROM mappings m
+					WHERE m.project != ? AND m.owner = p.owner AND m.name = p.name AND m.pr_id = p.pr_id))`,
+			project, project, project,
+		)
+		if err != nil {
+			return err
+		}
+
+		res, err := tx.ExecContext(ctx, `
+			DELETE FROM prs
+			WHERE EXISTS (SELECT 1 FROM mappings m
+				WHERE m.project = ? AND m.owner = prs.owner AND m.name = prs.name AND m.pr_id = prs.pr_id)
+			AND NOT EXISTS (SELECT 1 FROM mappings m
+				WHERE m.project != ? AND m.owner = prs.owner AND m.name = prs.name AND m.pr_id = prs.pr_id)`,
+			project, project,
+		)
+		if err != nil {
+			return err
+		}
+		if prs, err = res.RowsAffected(); err != nil {
+			return err
+		}
+
+		if res, err = tx.ExecContext(ctx, "DELETE FROM mappings WHERE project = ?", project); err != nil {
+			return err
+		}
+		if mappings, err = res.RowsAffected(); err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, "DELETE FROM sync WHERE project = ?", project)
+
+		return err
+	})
+
+	return int(mappings), int(prs), err
+}
+
+func (s *sqliteStore) Prune(ctx context.Context, mappings []mongoMapping, prs []pr, archive bool) error {
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		if archive {
+			for _, a := range archivedDocs(mappings, prs, time.Now().UTC()) {
+				doc, err := json.Marshal(a)
+				if err != nil {
+					return err
+				}
+				_, err = tx.ExecContext(ctx, "INSERT INTO archive (kind, archived, doc) VALUES (?, ?, ?)",
+					a.Kind, a.ArchivedAt.Format(time.RFC3339Nano), string(doc),
+				)
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		for _, m := range mappings {
+			_, err := tx.ExecContext(ctx,
+				"DELETE FROM mappings WHERE project = ? AND tracker = ? AND issue_id = ? AND issue_uid = ? AND owner = ? AND name = ? AND pr_id = ?",
+				m.Project, m.Tracker, m.IssueID, m.IssueUID, m.Repo.Owner, m.Repo.Name, m.PRID,
+			)
+			if err != nil {
+				return err
+			}
+		}
+		for _, p := range prs {
+			_, err := tx.ExecContext(ctx, "DELETE FROM prs WHERE owner = ? AND name = ? AND pr_id = ?", p.Repo.Owner, p.Repo.Name, p.PRID)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// Reset drops the table and runs the schema, which recreates it
+func (s *sqliteStore) Reset(ctx context.Context, collection string) error {
+	if _, err := s.db.ExecContext(ctx, fmt.Sprintf("DROP TABLE IF EXISTS %s", collection)); err != nil {
+		return err
+	}
+
+	_, err := s.db.ExecContext(ctx, sqliteSchema)
+
+	return err
+}
+
+// sqliteExports holds the queries of the documents of the tables, ordered
+// by their row IDs. The sync table has no documents, its rows are turned
+// into ones.
+var sqliteExports = map[string]string{
+	"mappings":    "SELECT rowid, doc FROM mappings WHERE rowid > ? ORDER BY rowid",
+	"prs":         "SELECT rowid, doc FROM prs WHERE rowid > ? ORDER BY rowid",
+	"sync":        "SELECT rowid, project, last_sync FROM sync WHERE rowid > ? ORDER BY rowid",
+	"reports":     "SELECT rowid, doc FROM reports WHERE rowid > ? ORDER BY rowid",
+	"archive":     "SELECT rowid, doc FROM archive WHERE rowid > ? ORDER BY rowid",
+	"repos":       "SELECT rowid, doc FROM repos WHERE rowid > ? ORDER BY rowid",
+	"annotations": "SELECT rowid, doc FROM annotations WHERE rowid > ? ORDER BY rowid",
+	"payloads":    "SELECT rowid, doc FROM payloads WHERE rowid > ? ORDER BY rowid",
+}
+
+// Export resumes after the row ID of the token
+func (s *sqliteStore) Export(ctx context.Context, collection, after string, fn func(doc []byte, token string) error) error {
+	var from int64
+	if after != "" {
+		var err error
+		if from, err = strconv.ParseInt(after, 10, 64); err != nil {
+			return fmt.Errorf("invalid resume token %q", after)
+		}
+	}
+
+	rows, err := s.db.QueryContext(ctx, sqliteExports[collection], from)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			id  int64
+			doc []byte
+		)
+		if collection == "sync" {
+			var (
+				state syncState
+				last  string
+			)
+			if err := rows.Scan(&id, &state.Project, &last); err != nil {
+				return err
+			}
+			if state.LastSync, err = time.Parse(time.RFC3339Nano, last); err != nil {
+				return err
+			}
+			if doc, err = json.Marshal(state); err != nil {
+				return err
+			}
+		} else if err := rows.Scan(&id, &doc); err != nil {
+			return err
+		}
+		if err := fn(doc, strconv.FormatInt(id, 10)); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// sqliteStats holds the queries of the numbers of the rows of the tables
+// and of the sizes of their documents. The indexes aren't counted.
+var sqliteStats = map[string]string{
+	"mappings":    "SELECT COUNT(*), COALESCE(SUM(LENGTH(doc)), 0) FROM mappings",
+	"prs":         "SELECT COUNT(*), COALESCE(SUM(LENGTH(doc)), 0) FROM prs",
+	"sync":        "SELECT COUNT(*), COALESCE(SUM(LENGTH(project) + LENGTH(last_sync)), 0) FROM sync",
+	"reports":     "SELECT COUNT(*), COALESCE(SUM(LENGTH(doc)), 0) FROM reports",
+	"archive":     "SELECT COUNT(*), COALESCE(SUM(LENGTH(doc)), 0) FROM archive",
+	"repos":       "SELECT COUNT(*), COALESCE(SUM(LENGTH(doc)), 0) FROM repos",
+	"annotations": "SELECT COUNT(*), COALESCE(SUM(LENGTH(doc)), 0) FROM annotations",
+	"payloads":    "SELECT COUNT(*), COALESCE(SUM(LENGTH(doc)), 0) FROM payloads",
+}
+
+func (s *sqliteStore) Stats(ctx context.Context) ([]collectionStats, error) {
+	stats := make([]collectionStats, 0, len(storeCollections))
+	for _, c := range storeCollections {
+		cs := collectionStats{Collection: c}
+		if err := s.db.QueryRowContext(ctx, sqliteStats[c]).Scan(&cs.Docs, &cs.Bytes); err != nil {
+			return nil, err
+		}
+		stats = append(stats, cs)
+	}
+
+	return stats, nil
+}
+
+// ScrubArchive decodes the archived PRs one at a time and writes back the
+// ones fn changed in a single transaction
+func (s *sqliteStore) ScrubArchive(ctx context.Context, fn func(p *pr) bool) (int, error) {
+	changed := make(map[int64]string)
+	rows, err := s.db.QueryContext(ctx, "SELECT rowid, doc FROM archive WHERE kind = 'pr'")
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int64
+		var doc string
+		if err := rows.Scan(&id, &doc); err != nil {
+			return 0, err
+		}
+		a := archivedDoc{}
+		if err := json.Unmarshal([]byte(doc), &a); err != nil {
+			return 0, err
+		}
+		if a.PR == nil || !fn(a.PR) {
+			continue
+		}
+		scrubbed, err := json.Marshal(a)
+		if err != nil {
+			return 0, err
+		}
+		changed[id] = string(scrubbed)
+	}
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	err = s.inTx(ctx, func(tx *sql.Tx) error {
+		for id, doc := range changed {
+			if _, err := tx.ExecContext(ctx, "UPDATE archive SET doc = ? WHERE rowid = ?", doc, id); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	return len(changed), err
+}
+
+// inTx runs fn in a transaction, committing it only if fn succeeds
+func (s *sqliteStore) inTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// eachDoc calls fn with the JSON document of every row of the query
+func (s *sqliteStore) eachDoc(ctx context.Context, query string, fn func(doc []byte) error, args ...interface{}) error {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var doc []byte
+		if err := rows.Scan(&doc); err != nil {
+			return err
+		}
+		if err := fn(doc); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
@@ -0,0 +1,379 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// statusCmd represents the status command
+var statusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Prints the state of the store and of the connections",
+	Long: `Prints what's needed to debug a broken cron job: the config file
+and the HEATMAP_ environment variables in use, the documents of the
+store, the mapped PRs without diffs, the last backfills of the
+projects, the last successful and failed runs of backfill and
+collectDiffs, the credentials expiring soon, and checks of the
+connections to the issue tracker, the VCS provider and the store.
+
+Every sync records the stats in .heatmap-storage.json of
+manifest.dir, keeping storage.history of them (default 90 days).
+The growth is estimated against the latest record at least
+--window old, or storage.growth_window, 7 days by default, or
+against the oldest record if none is; it's unknown until a record
+is an hour old. The sizes are those of the documents with their
+indexes in MongoDB and of the documents alone in SQLite.
+
+The runs are recorded in .heatmap-runs.json of manifest.dir.
+The connections are checked with the cheapest authenticated
+request of every API unless --skip-checks or --offline is set; the
+command fails with the exit code of the first failed service.`,
+	RunE: status,
+}
+
+const statusCheckTimeout = 30 * time.Second
+
+var (
+	statusFormat     string
+	statusWindow     string
+	statusSkipChecks bool
+)
+
+func init() {
+	rootCmd.AddCommand(statusCmd)
+	statusCmd.Flags().StringVar(&statusFormat, "format", "table", "output format: table or json")
+	statusCmd.Flags().StringVar(&statusWindow, "window", "", "period the growth is estimated over, e.g. 30d (default is storage.growth_window or 7d)")
+	statusCmd.Flags().BoolVar(&statusSkipChecks, "skip-checks", false, "don't check the connections to the services")
+	statusCmd.Flags().StringVarP(&jiraProject, "project", "p", "Memberships", "Jira project name or a comma separated list of names of the backfills")
+}
+
+// statusReport represents the output of the status command
+type statusReport struct {
+	Time        time.Time            `json:"time"`
+	Config      *configSource        `json:"config,omitempty"`
+	Collections []collectionGrowth   `json:"collections"`
+	PendingPRs  *int                 `json:"pending_prs,omitempty"`
+	Projects    []projectStatus      `json:"projects,omitempty"`
+	Runs        map[string]stageRun  `json:"runs,omitempty"`
+	Checks      []serviceCheck       `json:"checks,omitempty"`
+	Expiring    map[string]time.Time `json:"expiring_credentials,omitempty"`
+}
+
+// configSource represents where the config is read from. Only the names
+// of the environment variables are kept, their values may be secrets.
+type configSource struct {
+	File string   `json:"file,omitempty"`
+	Env  []string `json:"env,omitempty"`
+}
+
+// projectStatus represents the start of the last completed backfill of a
+// project, zero if it was never backfilled
+type projectStatus struct {
+	Project      string     `json:"project"`
+	LastBackfill *time.Time `json:"last_backfill,omitempty"`
+}
+
+// serviceCheck represents the outcome of the check of the connection to a
+// service
+type serviceCheck struct {
+	Service string        `json:"service"`
+	Name    string        `json:"name"`
+	OK      bool          `json:"ok"`
+	Latency time.Duration `json:"latency_ns"`
+	Error   string        `json:"error,omitempty"`
+	// class classifies the error of a failed check for the exit code
+	class func(error) error
+}
+
+// growthWindow returns the window of --window or storage.growth_window
+func growthWindow() (time.Duration, error) {
+	viper.SetDefault("storage.growth_window", defaultGrowthWindow)
+	w := statusWindow
+	if w == "" {
+		w = viper.GetString("storage.growth_window")
+	}
+
+	window, err := parseDays(w)
+	if err != nil || window <= 0 {
+		return 0, fmt.Errorf("invalid growth window %q", w)
+	}
+
+	return window, nil
+}
+
+// currentStorageGrowth reads the stats of the store and estimates their
+// growth from the recorded history
+func currentStorageGrowth(ctx context.Context, st store) (statusReport, error) {
+	window, err := growthWindow()
+	if err != nil {
+		return statusReport{}, configError(err)
+	}
+
+	stats, err := st.Stats(ctx)
+	if err != nil {
+		return statusReport{}, storageError(fmt.Errorf("reading the storage stats failed: %w", err))
+	}
+	history, err := loadStorageHistory()
+	if err != nil {
+		return statusReport{}, err
+	}
+
+	sample := storageSample{Time: time.Now(), Collections: stats}
+
+	return statusReport{Time: sample.Time, Collections: storageGrowth(sample, history, window)}, nil
+}
+
+func status(cmd *cobra.Command, args []string) error {
+	if statusFormat != "table" && statusFormat != "json" {
+		return configError(fmt.Errorf("unknown status format %q", statusFormat))
+	}
+	if _, err := growthWindow(); err != nil {
+		return configError(err)
+	}
+
+	ctx, cancel, st, err := openReadStore()
+	if err != nil {
+		return err
+	}
+	defer cancel()
+	defer closeStore(ctx, st)
+
+	r := statusReport{Time: time.Now(), Config: currentConfigSource(), Collections: []collectionGrowth{}}
+
+	start := time.Now()
+	storage, storeErr := currentStorageGrowth(ctx, st)
+	storeCheck := newServiceCheck("store", storeName(), time.Since(start), storeErr, storageError)
+	if storeErr == nil {
+		r.Collections = storage.Collections
+		if err := storeStatus(ctx, st, backfillProjects(cmd), &r); err != nil {
+			return err
+		}
+	}
+	if r.Runs, err = loadRuns(); err != nil {
+		return err
+	}
+	r.Expiring = expiringCredentials()
+
+	if !statusSkipChecks && !offline {
+		r.Checks = checkServices(ctx)
+	}
+	r.Checks = append(r.Checks, storeCheck)
+
+	if statusFormat == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(r)
+	} else {
+		err = writeStatusTable(os.Stdout, r)
+	}
+	if err != nil {
+		return err
+	}
+
+	return failedCheck(r.Checks)
+}
+
+// currentConfigSource returns the config file in use and the names of
+// the HEATMAP_ environment variables
+func currentConfigSource() *configSource {
+	c := &configSource{File: viper.ConfigFileUsed()}
+	for _, e := range os.Environ() {
+		name := strings.SplitN(e, "=", 2)[0]
+		if strings.HasPrefix(name, "HEATMAP_") {
+			c.Env = append(c.Env, name)
+		}
+	}
+	sort.Strings(c.Env)
+
+	return c
+}
+
+func storeName() string {
+	if ephemeral {
+		return "memory"
+	}
+	viper.SetDefault("storage.driver", defaultStorageDriver)
+
+	return viper.GetString("storage.driver")
+}
+
+// storeStatus reads the pending PRs and the backfills of the projects
+func storeStatus(ctx context.Context, st store, projects []string, r *statusReport) error {
+	pending := 0
+	err := st.NotAnalyzedPRs(ctx, func(pr) error {
+		pending++
+		return nil
+	})
+	if err != nil {
+		return storageError(fmt.Errorf("reading pending PRs failed: %w", err))
+	}
+	r.PendingPRs = &pending
+
+	for _, p := range projects {
+		t, err := st.Watermark(ctx, p)
+		if err != nil {
+			return storageError(fmt.Errorf("reading watermark failed: %w", err))
+		}
+		s := projectStatus{Project: p}
+		if !t.IsZero() {
+			s.LastBackfill = &t
+		}
+		r.Projects = append(r.Projects, s)
+	}
+
+	return nil
+}
+
+func newServiceCheck(service, name string, latency time.Duration, err error, class func(error) error) serviceCheck {
+	c := serviceCheck{Service: service, Name: name, OK: err == nil, Latency: latency, class: class}
+	if err != nil {
+		c.Error = err.Error()
+	}
+
+	return c
+}
+
+// checkServices pings the issue tracker and the VCS provider
+func checkServices(ctx context.Context) []serviceCheck {
+	ping := func(service, name string, p interface{}, setupErr error, class func(error) error) serviceCheck {
+		if setupErr != nil {
+			return newServiceCheck(service, name, 0, setupErr, configError)
+		}
+		pi, ok := p.(pinger)
+		if !ok {
+			return newServiceCheck(service, name, 0, errors.New("checking the connection isn't supported"), class)
+		}
+
+		ctx, cancel := context.WithTimeout(ctx, statusCheckTimeout)
+		defer cancel()
+		start := time.Now()
+		err := pi.ping(ctx)
+
+		return newServiceCheck(service, name, time.Since(start), err, class)
+	}
+
+	viper.SetDefault("vcs.provider", defaultVCSProvider)
+	viper.SetDefault("tracker.type", defaultTrackerType)
+
+	provider, err := newVCSProvider(ctx)
+	vcs := ping("vcs", viper.GetString("vcs.provider"), provider, err, vcsError)
+	tracker, err := newIssueTracker(provider)
+	issues := ping("tracker", viper.GetString("tracker.type"), tracker, err, jiraError)
+
+	return []serviceCheck{issues, vcs}
+}
+
+// failedCheck returns the classified error of the first failed check
+func failedCheck(checks []serviceCheck) error {
+	failed := 0
+	var first *serviceCheck
+	for i := range checks {
+		if !checks[i].OK {
+			failed++
+			if first == nil {
+				first = &checks[i]
+			}
+		}
+	}
+	if first == nil {
+		return nil
+	}
+
+	return first.class(fmt.Errorf("%d of %d checks failed, %s %s: %s", failed, len(checks), first.Service, first.Name, first.Error))
+}
+
+func writeStatusTable(w io.Writer, r statusReport) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	if r.Config != nil {
+		file := r.Config.File
+		if file == "" {
+			file = "-"
+		}
+		env := "-"
+		if len(r.Config.Env) > 0 {
+			env = strings.Join(r.Config.Env, ", ")
+		}
+		fmt.Fprintf(tw, "CONFIG\t%s\nENV\t%s\n\n", file, env)
+	}
+
+	fmt.Fprintln(tw, "COLLECTION\tDOCS\tSIZE\tDOCS/DAY\tSIZE/DAY")
+	for _, c := range r.Collections {
+		docs, bytes := "-", "-"
+		if c.DocsPerDay != nil {
+			docs = fmt.Sprintf("%+.1f", *c.DocsPerDay)
+			bytes = formatBytes(*c.BytesPerDay)
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%s\t%s\n", c.Collection, c.Docs, formatBytes(float64(c.Bytes)), docs, bytes)
+	}
+	if r.PendingPRs != nil {
+		fmt.Fprintf(tw, "\nPRS WITHOUT DIFFS\t%d\n", *r.PendingPRs)
+	}
+
+	if len(r.Projects) > 0 {
+		fmt.Fprintln(tw, "\nPROJECT\tLAST BACKFILL")
+		for _, p := range r.Projects {
+			fmt.Fprintf(tw, "%s\t%s\n", p.Project, statusTime(p.LastBackfill))
+		}
+	}
+
+	fmt.Fprintln(tw, "\nSTAGE\tLAST SUCCESS\tCOUNT\tLAST FAILURE\tERROR")
+	for _, stage := range []string{"backfill", "collectDiffs"} {
+		run := r.Runs[stage]
+		count := "-"
+		if run.LastSuccess != nil {
+			count = strconv.Itoa(run.LastCount)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", stage, statusTime(run.LastSuccess), count, statusTime(run.LastFailure), run.LastError)
+	}
+
+	if len(r.Checks) > 0 {
+		fmt.Fprintln(tw)
+		writeServiceChecks(tw, r.Checks)
+	}
+
+	if len(r.Expiring) > 0 {
+		services := make([]string, 0, len(r.Expiring))
+		for s := range r.Expiring {
+			services = append(services, s)
+		}
+		sort.Strings(services)
+		fmt.Fprintln(tw, "\nCREDENTIALS\tEXPIRE")
+		for _, s := range services {
+			t := r.Expiring[s]
+			fmt.Fprintf(tw, "%s\t%s\n", s, statusTime(&t))
+		}
+	}
+
+	return tw.Flush()
+}
+
+func writeServiceChecks(tw *tabwriter.Writer, checks []serviceCheck) {
+	fmt.Fprintln(tw, "SERVICE\tNAME\tSTATUS\tLATENCY\tERROR")
+	for _, c := range checks {
+		state := "ok"
+		if !c.OK {
+			state = "failed"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", c.Service, c.Name, state, c.Latency.Round(time.Millisecond), c.Error)
+	}
+}
+
+func statusTime(t *time.Time) string {
+	if t == nil || t.IsZero() {
+		return "-"
+	}
+
+	return t.Local().Format("2006-01-02 15:04:05")
+}
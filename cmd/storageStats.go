@@ -0,0 +1,162 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+const (
+	defaultStorageHistory = 90 * 24 * time.Hour
+	defaultGrowthWindow   = "7d"
+	// minGrowthSpan is the shortest time the growth is estimated over
+	minGrowthSpan = time.Hour
+)
+
+// collectionStats represents the number of the documents of a collection
+// and the bytes they take in the backend
+type collectionStats struct {
+	Collection string `json:"collection"`
+	Docs       int64  `json:"docs"`
+	Bytes      int64  `json:"bytes"`
+}
+
+// storageSample represents the stats of the collections at a time
+type storageSample struct {
+	Time        time.Time         `json:"time"`
+	Collections []collectionStats `json:"collections"`
+}
+
+// collectionGrowth represents the stats of a collection with its growth
+// per day, which is unknown until there's a sample old enough
+type collectionGrowth struct {
+	collectionStats
+	DocsPerDay  *float64 `json:"docs_per_day,omitempty"`
+	BytesPerDay *float64 `json:"bytes_per_day,omitempty"`
+}
+
+func storageHistoryPath() string {
+	viper.SetDefault("manifest.dir", ".")
+
+	return filepath.Join(viper.GetString("manifest.dir"), ".heatmap-storage.json")
+}
+
+func loadStorageHistory() ([]storageSample, error) {
+	path := storageHistoryPath()
+	raw, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return []storageSample{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	history := make([]storageSample, 0)
+	if err := json.Unmarshal(raw, &history); err != nil {
+		return nil, fmt.Errorf("reading %s failed: %w", path, err)
+	}
+
+	return history, nil
+}
+
+func saveStorageHistory(history []storageSample) error {
+	path := storageHistoryPath()
+	raw, err := json.MarshalIndent(history, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path+".tmp", raw, 0o600); err != nil {
+		return err
+	}
+
+	return os.Rename(path+".tmp", path)
+}
+
+// recordStorageStats appends the current stats of the store to the
+// history, dropping the samples older than storage.history (default 90
+// days), and returns the sample
+func recordStorageStats(ctx context.Context, st store) (storageSample, error) {
+	stats, err := st.Stats(ctx)
+	if err != nil {
+		return storageSample{}, storageError(fmt.Errorf("reading the storage stats failed: %w", err))
+	}
+	sample := storageSample{Time: time.Now(), Collections: stats}
+
+	history, err := loadStorageHistory()
+	if err != nil {
+		return sample, err
+	}
+
+	viper.SetDefault("storage.history", defaultStorageHistory)
+	since := sample.Time.Add(-viper.GetDuration("storage.history"))
+	kept := make([]storageSample, 0, len(history)+1)
+	for _, s := range history {
+		if s.Time.After(since) {
+			kept = append(kept, s)
+		}
+	}
+
+	return sample, saveStorageHistory(append(kept, sample))
+}
+
+// storageGrowth estimates the growth per day of the collections of the
+// sample against the latest earlier sample at least the window old, or
+// the oldest one if none is
+func storageGrowth(sample storageSample, history []storageSample, window time.Duration) []collectionGrowth {
+	var base *storageSample
+	for i, s := range history {
+		if sample.Time.Sub(s.Time) >= window && (base == nil || s.Time.After(base.Time)) {
+			base = &history[i]
+		}
+	}
+	if base == nil {
+		for i, s := range history {
+			if sample.Time.Sub(s.Time) >= minGrowthSpan && (base == nil || s.Time.Before(base.Time)) {
+				base = &history[i]
+			}
+		}
+	}
+
+	before := make(map[string]collectionStats)
+	days := 0.0
+	if base != nil {
+		days = sample.Time.Sub(base.Time).Hours() / 24
+		for _, c := range base.Collections {
+			before[c.Collection] = c
+		}
+	}
+
+	growth := make([]collectionGrowth, 0, len(sample.Collections))
+	for _, c := range sample.Collections {
+		g := collectionGrowth{collectionStats: c}
+		if b, ok := before[c.Collection]; ok {
+			docs := float64(c.Docs-b.Docs) / days
+			bytes := float64(c.Bytes-b.Bytes) / days
+			g.DocsPerDay, g.BytesPerDay = &docs, &bytes
+		}
+		growth = append(growth, g)
+	}
+
+	return growth
+}
+
+// formatBytes formats a size in the binary units
+func formatBytes(n float64) string {
+	units := []string{"B", "KiB", "MiB", "GiB", "TiB"}
+	i := 0
+	for (n >= 1024 || n <= -1024) && i < len(units)-1 {
+		n /= 1024
+		i++
+	}
+	if i == 0 {
+		return fmt.Sprintf("%.0f %s", n, units[i])
+	}
+
+	return fmt.Sprintf("%.1f %s", n, units[i])
+}
@@ -0,0 +1,198 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+const defaultStorageDriver = "mongo"
+
+// store represents the storage backend of the collected data
+type store interface {
+	mappingStore
+	diffStore
+	repoStore
+	annotationStore
+	payloadStore
+	reportStore
+	maintenanceStore
+	exportStore
+
+	// Close releases the connection to the backend
+	Close(ctx context.Context) error
+}
+
+// mappingStore keeps the mappings of the Jira issues and their PRs
+type mappingStore interface {
+	// MappedIssues returns the refs of the issues with at least one
+	// mapping, see issueRef
+	MappedIssues(ctx context.Context) (map[string]bool, error)
+	// InsertMappings writes new mappings
+	InsertMappings(ctx context.Context, mappings []mongoMapping) error
+	// Mappings returns all mappings
+	Mappings(ctx context.Context) ([]mongoMapping, error)
+	// Watermark returns the start of the last completed backfill of
+	// the project or zero time if there's none
+	Watermark(ctx context.Context, project string) (time.Time, error)
+	// SetWatermark moves the watermark of the project
+	SetWatermark(ctx context.Context, project string, t time.Time) error
+}
+
+// diffStore keeps the diffs of the PRs
+type diffStore interface {
+	// NotAnalyzedPRs calls fn with every mapped PR without diffs, once,
+	// as it's read, stopping at the first error of fn
+	NotAnalyzedPRs(ctx context.Context, fn func(p pr) error) error
+	// InsertPRs writes the diffs of new PRs
+	InsertPRs(ctx context.Context, prs []pr) error
+	// PRs returns all PRs with their diffs
+	PRs(ctx context.Context) ([]pr, error)
+}
+
+// repoStore keeps the metadata of the repos
+type repoStore interface {
+	// SaveRepos writes the metadata of the repos, replacing their
+	// earlier metadata
+	SaveRepos(ctx context.Context, repos []repoMeta) error
+	// Repos returns the metadata of all repos
+	Repos(ctx context.Context) ([]repoMeta, error)
+}
+
+// annotationStore keeps the annotations of the files
+type annotationStore interface {
+	// ReplaceAnnotations replaces all annotations
+	ReplaceAnnotations(ctx context.Context, annotations []annotation) error
+	// Annotations returns all annotations
+	Annotations(ctx context.Context) ([]annotation, error)
+}
+
+// payloadStore keeps the raw payloads of the providers
+type payloadStore interface {
+	// SavePayloads writes the payloads, removing the expired ones
+	SavePayloads(ctx context.Context, payloads []rawPayload) error
+	// Payloads returns the unexpired payloads of the key, from the oldest
+	Payloads(ctx context.Context, key string) ([]rawPayload, error)
+}
+
+// reportStore keeps the computed heat reports
+type reportStore interface {
+	// SaveReport writes a new report
+	SaveReport(ctx context.Context, r heatReport) error
+	// LatestReport returns the most recently saved report or nil if there's none
+	LatestReport(ctx context.Context) (*heatReport, error)
+}
+
+// maintenanceStore removes the data of bad runs
+type maintenanceStore interface {
+	// PurgeProject removes the mappings and the watermark of the project,
+	// the PRs no other project maps to and the raw payloads of both. It
+	// returns the numbers of the removed mappings and PRs.
+	PurgeProject(ctx context.Context, project string) (int, int, error)
+	// Prune removes the mappings and the PRs, moving them to the archive
+	// collection first if archive is set
+	Prune(ctx context.Context, mappings []mongoMapping, prs []pr, archive bool) error
+	// ScrubArchive rewrites the archived PRs which fn changes and returns
+	// their number
+	ScrubArchive(ctx context.Context, fn func(p *pr) bool) (int, error)
+	// Reset drops the collection and recreates it empty, with its indexes
+	Reset(ctx context.Context, collection string) error
+	// Stats returns the number of documents and the size of every
+	// collection, in the order of storeCollections
+	Stats(ctx context.Context) ([]collectionStats, error)
+}
+
+// exportStore streams the documents of the collections
+type exportStore interface {
+	// Export calls fn with every document of the collection as JSON in a
+	// stable order, starting after the document of the resume token
+	// unless it's empty. The token passed with a document resumes the
+	// export after it.
+	Export(ctx context.Context, collection, after string, fn func(doc []byte, token string) error) error
+}
+
+// storeCollections are the names of the collections of every backend
+var storeCollections = []string{"mappings", "prs", "sync", "reports", "archive", "repos", "annotations", "payloads"}
+
+// collectionAliases maps the historical MongoDB collection names to the
+// collections
+var collectionAliases = map[string]string{
+	"jira":   "mappings",
+	"github": "prs",
+	"diffs":  "prs",
+}
+
+// storeCollection resolves the name or the alias of a collection
+func storeCollection(name string) (string, error) {
+	if alias, ok := collectionAliases[name]; ok {
+		name = alias
+	}
+	for _, c := range storeCollections {
+		if c == name {
+			return c, nil
+		}
+	}
+
+	return "", fmt.Errorf("unknown collection %q, use one of %s", name, strings.Join(storeCollections, ", "))
+}
+
+// heatReport represents a saved snapshot of the heat of the files
+type heatReport struct {
+	Created time.Time  `bson:"created" json:"created"`
+	Files   []fileHeat `bson:"files" json:"files"`
+	// RunID is the correlation ID of the run which saved the report
+	RunID string `bson:"run_id,omitempty" json:"run_id,omitempty"`
+}
+
+// openStore connects to the backend selected by the storage.driver config key.
+// The returned context governs the whole command, see commandContext.
+func openStore() (context.Context, context.CancelFunc, store, error) {
+	return openBackend(false)
+}
+
+// openReadStore opens the store of the commands which render the heat.
+// Their queries go to the read connection of the backend if it has one,
+// so that they don't load the primary while it ingests.
+func openReadStore() (context.Context, context.CancelFunc, store, error) {
+	return openBackend(true)
+}
+
+func openBackend(read bool) (context.Context, context.CancelFunc, store, error) {
+	if ephemeral {
+		return openMemoryStore()
+	}
+	viper.SetDefault("storage.driver", defaultStorageDriver)
+
+	switch name := viper.GetString("storage.driver"); name {
+	case "mongo":
+		return openMongoStore(read)
+	case "sqlite":
+		return openSQLiteStore()
+	default:
+		return nil, nil, nil, configError(fmt.Errorf("unknown storage driver %q", name))
+	}
+}
+
+// commandContext returns the context of the operations of the command,
+// cancelled after --timeout if it's set. It's independent of the time
+// the backend takes to connect.
+func commandContext() (context.Context, context.CancelFunc) {
+	if commandTimeout > 0 {
+		return context.WithTimeout(context.Background(), commandTimeout)
+	}
+
+	return context.WithCancel(context.Background())
+}
+
+// closeStore releases the store, reporting but otherwise ignoring a
+// failure, after writing the raw payloads recorded since the last write
+func closeStore(ctx context.Context, st store) {
+	savePayloads(ctx, st)
+	if err := st.Close(ctx); err != nil {
+		slog.Warn("closing the store failed", "err", err)
+	}
+}
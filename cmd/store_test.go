@@ -0,0 +1,252 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// The stores run the same tests, the memory store being the reference
+// the semantics of the other backends are compared with.
+
+func TestMemoryStore(t *testing.T) {
+	testStore(t, func(t *testing.T) store {
+		return newMemoryStore()
+	})
+}
+
+func TestSQLiteStore(t *testing.T) {
+	testStore(t, func(t *testing.T) store {
+		viper.Set("sqlite.path", t.TempDir()+"/heatmap.db")
+		t.Cleanup(viper.Reset)
+
+		_, cancel, st, err := openSQLiteStore()
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() {
+			st.Close(context.Background())
+			cancel()
+		})
+
+		return st
+	})
+}
+
+// TestMongoStore runs against the server of HEATMAP_TEST_MONGO_SRV, e.g.
+// mongodb://localhost:27017/%[3]s, in a database of its own which it
+// drops at the end
+func TestMongoStore(t *testing.T) {
+	srv := os.Getenv("HEATMAP_TEST_MONGO_SRV")
+	if srv == "" {
+		t.Skip("HEATMAP_TEST_MONGO_SRV is not set")
+	}
+
+	testStore(t, func(t *testing.T) store {
+		viper.Set("mongo.srv", srv)
+		viper.Set("mongo.dbname", fmt.Sprintf("heatmap_test_%d", time.Now().UnixNano()))
+		viper.Set("mongo.collections.jira", "jira")
+		viper.Set("mongo.collections.github", "github")
+		t.Cleanup(viper.Reset)
+
+		_, cancel, st, err := openMongoStore(false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() {
+			ctx := context.Background()
+			st.(*mongoStore).jira.Database().Drop(ctx)
+			st.Close(ctx)
+			cancel()
+		})
+
+		return st
+	})
+}
+
+func testStore(t *testing.T, open func(t *testing.T) store) {
+	ctx := context.Background()
+	api := Repo{Owner: "acme", Name: "api"}
+	web := Repo{Owner: "acme", Name: "web"}
+
+	t.Run("upsert mappings", func(t *testing.T) {
+		st := open(t)
+		first := mongoMapping{Project: "PAY", IssueID: 1, IssueKey: "PAY-1", Repo: api, PRID: 10, Priority: "Low"}
+		mustInsertMappings(t, st, first)
+
+		again := first
+		again.Priority = "High"
+		linear := mongoMapping{Project: "PAY", Tracker: "linear", IssueUID: "0b6e3c2a", IssueKey: "PAY-1", Repo: api, PRID: 10}
+		mustInsertMappings(t, st, again, linear)
+
+		mappings, err := st.Mappings(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := make(map[string]string)
+		for _, m := range mappings {
+			got[m.issueRef()] = m.Priority
+		}
+		want := map[string]string{"jira:1": "High", "linear:0b6e3c2a": ""}
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("mappings by issue = %v, want %v", got, want)
+		}
+
+		mapped, err := st.MappedIssues(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(mapped) != 2 {
+			t.Errorf("mapped issues = %v, want jira:1 and linear:0b6e3c2a", mapped)
+		}
+		for ref := range want {
+			if _, ok := mapped[ref]; !ok {
+				t.Errorf("issue %s not mapped", ref)
+			}
+		}
+	})
+
+	t.Run("upsert PRs", func(t *testing.T) {
+		st := open(t)
+		mustInsertPRs(t, st, pr{Repo: api, PRID: 10, Branch: "main"})
+		mustInsertPRs(t, st, pr{Repo: api, PRID: 10, Branch: "release/1.0"}, pr{Repo: web, PRID: 10, Branch: "main"})
+
+		prs, err := st.PRs(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := make([]string, 0, len(prs))
+		for _, p := range prs {
+			got = append(got, prKey(p.Repo, p.PRID)+" "+p.Branch)
+		}
+		sort.Strings(got)
+		want := []string{"acme/api#10 release/1.0", "acme/web#10 main"}
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("PRs = %v, want %v", got, want)
+		}
+	})
+
+	t.Run("not analyzed", func(t *testing.T) {
+		st := open(t)
+		mustInsertMappings(t, st,
+			mongoMapping{Project: "PAY", IssueID: 1, Repo: api, PRID: 10},
+			mongoMapping{Project: "PAY", IssueID: 2, Repo: api, PRID: 11},
+			mongoMapping{Project: "PAY", IssueID: 3, Repo: api, PRID: 11},
+			// the same number in another repo is another PR
+			mongoMapping{Project: "PAY", IssueID: 4, Repo: web, PRID: 10},
+		)
+		mustInsertPRs(t, st, pr{Repo: api, PRID: 10})
+
+		got := make([]string, 0)
+		err := st.NotAnalyzedPRs(ctx, func(p pr) error {
+			got = append(got, prKey(p.Repo, p.PRID))
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		sort.Strings(got)
+		want := []string{"acme/api#11", "acme/web#10"}
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("not analyzed PRs = %v, want %v", got, want)
+		}
+	})
+
+	t.Run("purge", func(t *testing.T) {
+		st := open(t)
+		mustInsertMappings(t, st,
+			mongoMapping{Project: "PAY", IssueID: 1, Repo: api, PRID: 10},
+			mongoMapping{Project: "PAY", IssueID: 2, Repo: api, PRID: 11},
+			mongoMapping{Project: "OPS", IssueID: 3, Repo: api, PRID: 11},
+			mongoMapping{Project: "OPS", IssueID: 4, Repo: web, PRID: 12},
+		)
+		mustInsertPRs(t, st, pr{Repo: api, PRID: 10}, pr{Repo: api, PRID: 11}, pr{Repo: web, PRID: 12})
+		now := time.Now().UTC().Truncate(time.Second)
+		for _, project := range []string{"PAY", "OPS"} {
+			if err := st.SetWatermark(ctx, project, now); err != nil {
+				t.Fatal(err)
+			}
+		}
+		expires := now.Add(time.Hour)
+		err := st.SavePayloads(ctx, []rawPayload{
+			{Source: payloadDevStatus, Key: "1", ExpiresAt: expires},
+			{Source: payloadDevStatus, Key: "3", ExpiresAt: expires},
+			{Source: payloadGitHubFiles, Key: "acme/api#10", ExpiresAt: expires},
+			{Source: payloadGitHubFiles, Key: "acme/api#11", ExpiresAt: expires},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		mappings, prs, err := st.PurgeProject(ctx, "PAY")
+		if err != nil {
+			t.Fatal(err)
+		}
+		// acme/api#11 is still mapped by OPS
+		if mappings != 2 || prs != 1 {
+			t.Errorf("purged %d mappings and %d PRs, want 2 and 1", mappings, prs)
+		}
+
+		left, err := st.Mappings(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, m := range left {
+			if m.Project == "PAY" {
+				t.Errorf("mapping of %s/%d left", m.Project, m.IssueID)
+			}
+		}
+		if len(left) != 2 {
+			t.Errorf("%d mappings left, want 2", len(left))
+		}
+
+		kept, err := st.PRs(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := make([]string, 0, len(kept))
+		for _, p := range kept {
+			got = append(got, prKey(p.Repo, p.PRID))
+		}
+		sort.Strings(got)
+		if want := []string{"acme/api#11", "acme/web#12"}; fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("PRs left = %v, want %v", got, want)
+		}
+
+		if w, err := st.Watermark(ctx, "PAY"); err != nil || !w.IsZero() {
+			t.Errorf("watermark of PAY = %v, %v, want none", w, err)
+		}
+		if w, err := st.Watermark(ctx, "OPS"); err != nil || !w.Equal(now) {
+			t.Errorf("watermark of OPS = %v, %v, want %v", w, err, now)
+		}
+
+		for key, want := range map[string]int{"1": 0, "acme/api#10": 0, "3": 1, "acme/api#11": 1} {
+			payloads, err := st.Payloads(ctx, key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(payloads) != want {
+				t.Errorf("%d payloads of %s left, want %d", len(payloads), key, want)
+			}
+		}
+	})
+}
+
+func mustInsertMappings(t *testing.T, st store, mappings ...mongoMapping) {
+	t.Helper()
+	if err := st.InsertMappings(context.Background(), mappings); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func mustInsertPRs(t *testing.T, st store, prs ...pr) {
+	t.Helper()
+	if err := st.InsertPRs(context.Background(), prs); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,163 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// syncCmd represents the sync command
+var syncCmd = &cobra.Command{
+	Use:   "sync",
+	Short: "Runs the whole pipeline from the Jira bugs to the diffs",
+	Long: `Runs backfill and collectDiffs one after the other on a single
+store connection and, with --report, prints the report at the end.
+The run stops at the first failing stage; every stage keeps its own
+manifest, so an interrupted run can be continued with backfill
+--resume or collectDiffs --resume.
+
+Depending on the configuration the run then labels the bugs of the
+hottest files, commits a snapshot of the heat, checks the budgets
+and posts a digest to the webhooks. Every run is recorded and
+compared with the earlier ones to alert on anomalies. See the
+README for the configuration.`,
+	RunE: syncPipeline,
+}
+
+var (
+	syncReport  bool
+	syncTimeout time.Duration
+)
+
+func init() {
+	rootCmd.AddCommand(syncCmd)
+	syncCmd.Flags().StringVarP(&jiraProject, "project", "p", "Memberships", "Jira project name or a comma separated list of names")
+	syncCmd.Flags().IntVar(&concurrency, "concurrency", defaultConcurrency, "number of parallel dev-status requests and of PRs fetched in parallel")
+	syncCmd.Flags().BoolVar(&full, "full", false, "check all bugs, ignoring the watermark of the last run")
+	syncCmd.Flags().StringVar(&repoScope, "repo", "", "only collect the PRs of this owner/name repo")
+	syncCmd.Flags().StringVar(&jiraJQL, "jql", "", fmt.Sprintf("JQL selecting the issues of a project (default is jira.jql or %q)", defaultJiraJQL))
+	syncCmd.Flags().BoolVar(&syncReport, "report", false, "print the report after collecting the diffs")
+	syncCmd.Flags().IntVar(&maxRequests, "max-requests", 0, "stop collecting the diffs after this many provider requests (0 means no limit)")
+	syncCmd.Flags().BoolVar(&labelDryRun, "label-dry-run", false, "log the labels of the labeling rules without applying them")
+}
+
+func syncPipeline(cmd *cobra.Command, args []string) error {
+	rules, err := labelRules()
+	if err != nil {
+		return configError(err)
+	}
+
+	ctx, cancel, st, err := openStore()
+	if err != nil {
+		return err
+	}
+	defer cancel()
+	defer closeStore(ctx, st)
+
+	return runSync(ctx, st, backfillProjects(cmd), rules)
+}
+
+// runSync runs the stages of the pipeline on the store and watches the
+// run for anomalies
+func runSync(ctx context.Context, st store, projects []string, rules []labelRule) error {
+	sample := startSyncSample()
+	err := runSyncStages(ctx, st, projects, rules, &sample)
+	finishSyncSample(&sample, err)
+	watchSync(sample)
+
+	return err
+}
+
+// runSyncStages runs the stages of the pipeline within the --run-timeout
+// of the daemon, noting their counts in the sample
+func runSyncStages(ctx context.Context, st store, projects []string, rules []labelRule, sample *syncSample) error {
+	if syncTimeout > 0 {
+		var cancelTimeout context.CancelFunc
+		ctx, cancelTimeout = context.WithTimeout(ctx, syncTimeout)
+		defer cancelTimeout()
+	}
+
+	snapshot, snapshotOn, err := gitSnapshotConfig()
+	if err != nil {
+		return configError(err)
+	}
+
+	var mapped map[string]bool
+	if len(rules) > 0 {
+		if mapped, err = st.MappedIssues(ctx); err != nil {
+			return storageError(fmt.Errorf("reading mapped issues failed: %w", err))
+		}
+	}
+
+	backfill := func() (n int, err error) {
+		n, err = runBackfill(ctx, st, projects)
+		sample.NewMappings = n
+		return n, err
+	}
+	if err := syncStage("backfill", backfill, "new_mappings"); err != nil {
+		return err
+	}
+	collect := func() (n int, err error) {
+		n, err = runCollectDiffs(ctx, st)
+		sample.NewPRs = n
+		return n, err
+	}
+	if err := syncStage("collectDiffs", collect, "new_prs"); err != nil {
+		return err
+	}
+	if len(rules) > 0 {
+		if err := syncStage("label", func() (int, error) { return runAutoLabel(ctx, st, rules, mapped) }, "labels"); err != nil {
+			return err
+		}
+	}
+	if snapshotOn {
+		if err := syncStage("snapshot", func() (int, error) { return runGitSnapshot(ctx, st, snapshot) }, "files"); err != nil {
+			return err
+		}
+	}
+	var exceeded []budgetState
+	if budgets := heatBudgets(); len(budgets) > 0 {
+		check := func() (n int, err error) {
+			exceeded, err = exceededBudgets(ctx, st, budgets)
+			return len(exceeded), err
+		}
+		if err := syncStage("budgets", check, "exceeded"); err != nil {
+			return err
+		}
+	}
+	if hooks := notifyWebhooks(); len(hooks) > 0 {
+		if err := syncStage("notify", func() (int, error) { return runNotify(ctx, st, hooks, sample.NewMappings, exceeded) }, "webhooks"); err != nil {
+			return err
+		}
+	}
+	// The stats of the in-memory runs would skew the growth of the store
+	if !ephemeral {
+		if _, err := recordStorageStats(ctx, st); err != nil {
+			slog.Warn("recording the storage stats failed", "err", err)
+		}
+	}
+	if syncReport {
+		return runReport(ctx, st, os.Stdout)
+	}
+
+	return nil
+}
+
+// syncStage runs a stage of the pipeline and prints its summary
+func syncStage(name string, run func() (int, error), unit string) error {
+	slog.Info("stage started", "stage", name)
+	start := time.Now()
+
+	n, err := run()
+	if err != nil {
+		slog.Error("stage failed", "stage", name, "elapsed", time.Since(start).Round(time.Millisecond))
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	slog.Info("stage finished", "stage", name, unit, n, "elapsed", time.Since(start).Round(time.Millisecond))
+
+	return nil
+}
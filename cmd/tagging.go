@@ -0,0 +1,81 @@
+package cmd
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"sync"
+
+	"github.com/spf13/viper"
+)
+
+const (
+	defaultUserAgent         = "heatmap"
+	defaultCorrelationHeader = "X-Correlation-ID"
+)
+
+// currentRun keeps the correlation ID of the running command, or of the
+// current run of the daemon
+var currentRun = struct {
+	sync.Mutex
+	id string
+}{}
+
+// startRun sets the correlation ID of a new run to http.correlation_id,
+// e.g. the ID of the CI job passed in HEATMAP_HTTP_CORRELATION_ID, or to
+// a random one, and returns it
+func startRun() string {
+	id := viper.GetString("http.correlation_id")
+	if id == "" {
+		raw := make([]byte, 8)
+		rand.Read(raw)
+		id = hex.EncodeToString(raw)
+	}
+
+	currentRun.Lock()
+	defer currentRun.Unlock()
+	currentRun.id = id
+
+	return id
+}
+
+func runID() string {
+	currentRun.Lock()
+	defer currentRun.Unlock()
+
+	return currentRun.id
+}
+
+// tagTransport identifies the requests to the proxies: it sets the
+// User-Agent of http.user_agent and sends the correlation ID of the run in
+// the header of http.correlation_header. An empty header name leaves the
+// ID out.
+type tagTransport struct {
+	next      http.RoundTripper
+	userAgent string
+	header    string
+}
+
+func newTagTransport(next http.RoundTripper) *tagTransport {
+	viper.SetDefault("http.user_agent", defaultUserAgent)
+	viper.SetDefault("http.correlation_header", defaultCorrelationHeader)
+
+	return &tagTransport{
+		next:      next,
+		userAgent: viper.GetString("http.user_agent"),
+		header:    viper.GetString("http.correlation_header"),
+	}
+}
+
+func (t *tagTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// A RoundTripper mustn't change the request it's given
+	req = req.Clone(req.Context())
+	if t.userAgent != "" {
+		req.Header.Set("User-Agent", t.userAgent)
+	}
+	if id := runID(); t.header != "" && id != "" {
+		req.Header.Set(t.header, id)
+	}
+
+	return t.next.RoundTrip(req)
+}
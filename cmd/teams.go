@@ -0,0 +1,86 @@
+package cmd
+
+import (
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+const unownedTeam = "unowned"
+
+// teamPatterns maps every team of the teams config key to its patterns.
+// A pattern is matched against both owner/name/file and name/file, where
+// ** matches any number of path segments. A pattern without wildcards
+// matches everything under it, e.g. a whole repo.
+func teamPatterns() map[string][]string {
+	teams := make(map[string][]string)
+	for team := range viper.GetStringMap("teams") {
+		teams[team] = viper.GetStringSlice("teams." + team)
+	}
+
+	return teams
+}
+
+// teamsOf returns the teams owning a file or unowned if there's none
+func teamsOf(teams map[string][]string, repo Repo, file string) []string {
+	paths := []string{
+		strings.Join([]string{repo.Owner, repo.Name, file}, "/"),
+		strings.Join([]string{repo.Name, file}, "/"),
+	}
+
+	owners := make([]string, 0)
+	for team, patterns := range teams {
+		if matchesAny(patterns, paths) {
+			owners = append(owners, team)
+		}
+	}
+	if len(owners) == 0 {
+		return []string{unownedTeam}
+	}
+	sort.Strings(owners)
+
+	return owners
+}
+
+func matchesAny(patterns, paths []string) bool {
+	for _, pattern := range patterns {
+		pattern = strings.Trim(pattern, "/")
+		if !strings.ContainsAny(pattern, "*?[") {
+			pattern += "/**"
+		}
+		for _, p := range paths {
+			if matchGlob(strings.Split(pattern, "/"), strings.Split(p, "/")) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// matchGlob matches the path segments against the pattern segments
+func matchGlob(pattern, segments []string) bool {
+	if len(pattern) == 0 {
+		return len(segments) == 0
+	}
+
+	if pattern[0] == "**" {
+		for i := 0; i <= len(segments); i++ {
+			if matchGlob(pattern[1:], segments[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+
+	if len(segments) == 0 {
+		return false
+	}
+	if ok, err := path.Match(pattern[0], segments[0]); err != nil || !ok {
+		return false
+	}
+
+	return matchGlob(pattern[1:], segments[1:])
+}
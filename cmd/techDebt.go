@@ -0,0 +1,77 @@
+package cmd
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// techDebtIssue represents the Jira issue the quick-create links of the
+// hot files pre-fill
+type techDebtIssue struct {
+	host        string
+	projectID   string
+	issueTypeID string
+	labels      []string
+}
+
+// techDebtConfig reads the Jira project and issue type of the tech debt
+// tickets from report.tech_debt.project_id and
+// report.tech_debt.issue_type_id. The create page of Jira takes their
+// numeric IDs, not the keys or the names. It returns false unless jira.host
+// and the project are set.
+func techDebtConfig() (techDebtIssue, bool) {
+	t := techDebtIssue{
+		host:        strings.TrimSuffix(viper.GetString("jira.host"), "/"),
+		projectID:   viper.GetString("report.tech_debt.project_id"),
+		issueTypeID: viper.GetString("report.tech_debt.issue_type_id"),
+		labels:      viper.GetStringSlice("report.tech_debt.labels"),
+	}
+
+	return t, t.host != "" && t.projectID != ""
+}
+
+// createURL returns the link of the create page of Jira pre-filled with
+// the summary and the description of the tech debt ticket of the file
+func (t techDebtIssue) createURL(h fileHeat) string {
+	q := url.Values{}
+	q.Set("pid", t.projectID)
+	if t.issueTypeID != "" {
+		q.Set("issuetype", t.issueTypeID)
+	}
+	q.Set("summary", techDebtSummary(h))
+	q.Set("description", techDebtDescription(h))
+	for _, l := range t.labels {
+		q.Add("labels", l)
+	}
+
+	return t.host + "/secure/CreateIssueDetails!init.jspa?" + q.Encode()
+}
+
+func techDebtSummary(h fileHeat) string {
+	_, file := heatName(h)
+
+	return "Reduce bug heat in " + file
+}
+
+// techDebtDescription explains the heat of the file in the wiki markup
+// of Jira
+func techDebtDescription(h fileHeat) string {
+	repo, file := heatName(h)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "{{%s}} in %s is one of the hottest files of the bug heat report.\n\n", file, repo)
+	fmt.Fprintf(&b, "* %d bugs were fixed by %d PRs touching it, changing %d lines\n", h.Bugs, h.PRs, h.Changes)
+	fmt.Fprintf(&b, "* heat score %.2f, risk %.1f of 100\n", h.Score, h.Risk)
+	if h.SLABreaches > 0 {
+		fmt.Fprintf(&b, "* %d of the bugs breached their service desk SLA\n", h.SLABreaches)
+	}
+	if !h.FirstSeen.IsZero() {
+		fmt.Fprintf(&b, "* hot since %s, last bug fixed %s\n", h.FirstSeen.Format("2006-01-02"), h.LastSeen.Format("2006-01-02"))
+	}
+	b.WriteString("\nThe score is the number of the distinct bugs, weighted by their priorities, times 1 + ln(1 + changes).")
+
+	return b.String()
+}
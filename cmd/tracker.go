@@ -0,0 +1,87 @@
+package cmd
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+const defaultTrackerType = "jira"
+
+// issueTracker represents the source of the bugs and of the PRs
+// linked to them
+type issueTracker interface {
+	// searchBugs returns the bugs of the project, updated since the
+	// given time unless it's zero
+	searchBugs(project string, since time.Time) (*[]bug, error)
+	// linkedPRs returns the PRs linked to the bug or errNoDevStatus
+	// if there are none
+	linkedPRs(b bug) (*[]jiraPR, error)
+	// findBug returns the bug of the key if it's one of the bugs of the
+	// project, or nil
+	findBug(project, key string) (*bug, error)
+	// mentions returns the keys of the bugs mentioned in the text of a PR
+	mentions(text string) []string
+}
+
+// newIssueTracker creates the tracker selected by the tracker.type config key
+func newIssueTracker(provider vcsProvider) (issueTracker, error) {
+	viper.SetDefault("tracker.type", defaultTrackerType)
+
+	switch name := viper.GetString("tracker.type"); name {
+	case "jira":
+		auth, err := jiraAuth()
+		if err != nil {
+			return nil, err
+		}
+		return &jiraTracker{auth: auth, provider: provider}, nil
+	case "azure":
+		return newAzureTracker(provider)
+	case "linear":
+		return newLinearTracker()
+	default:
+		return nil, fmt.Errorf("unknown issue tracker %q", name)
+	}
+}
+
+// issueRef identifies a bug across the trackers by its tracker and its ID
+// in the tracker, e.g. azure:42, as the IDs of the trackers overlap. The
+// ID is the uid of the trackers whose IDs aren't numbers, e.g. the UUID of
+// a Linear issue. The bugs without a tracker are the ones of Jira.
+func issueRef(tracker string, id int, uid string) string {
+	if tracker == "" {
+		tracker = defaultTrackerType
+	}
+	if uid != "" {
+		return tracker + ":" + uid
+	}
+
+	return tracker + ":" + strconv.Itoa(id)
+}
+
+// ref returns the ref of the bug
+func (b bug) ref() string {
+	return issueRef(b.Tracker, b.ID, b.UID)
+}
+
+// issueRef returns the ref of the bug of the mapping
+func (m mongoMapping) issueRef() string {
+	return issueRef(m.Tracker, m.IssueID, m.IssueUID)
+}
+
+// bugKey identifies the bug of the mapping in the heat, by its project
+// and ID, e.g. PROJ/10042, prefixed with the tracker unless it's Jira
+func (m mongoMapping) bugKey() string {
+	id := strconv.Itoa(m.IssueID)
+	if m.IssueUID != "" {
+		id = m.IssueUID
+	}
+	key := m.Project + "/" + id
+	if m.Tracker != "" && m.Tracker != defaultTrackerType {
+		key = m.Tracker + ":" + key
+	}
+
+	return key
+}
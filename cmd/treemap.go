@@ -0,0 +1,178 @@
+package cmd
+
+import (
+	"fmt"
+	"image/color"
+	"io"
+	"math"
+	"path"
+
+	"rdlf0/heatmap/render"
+)
+
+const (
+	treemapWidth  = 1200
+	treemapHeight = 800
+)
+
+// rect represents a cell of the treemap
+type rect struct {
+	X, Y, W, H float64
+}
+
+// layoutTreemap splits the area into cells proportional to the values,
+// which have to be sorted in descending order. It uses the squarified
+// algorithm, which keeps the cells close to squares.
+func layoutTreemap(values []float64, area rect) []rect {
+	total := 0.0
+	for _, v := range values {
+		total += v
+	}
+
+	cells := make([]rect, len(values))
+	if total <= 0 {
+		return cells
+	}
+
+	// The values are scaled to the area of the cells
+	scale := area.W * area.H / total
+	sizes := make([]float64, len(values))
+	for i, v := range values {
+		sizes[i] = v * scale
+	}
+
+	start := 0
+	for start < len(sizes) {
+		side := math.Min(area.W, area.H)
+
+		// The row grows while it improves its worst aspect ratio
+		end := start + 1
+		for end < len(sizes) && worstRatio(sizes[start:end+1], side) <= worstRatio(sizes[start:end], side) {
+			end++
+		}
+
+		area = placeRow(sizes[start:end], area, cells[start:end])
+		start = end
+	}
+
+	return cells
+}
+
+// worstRatio returns the highest aspect ratio of a row laid along the side
+func worstRatio(row []float64, side float64) float64 {
+	sum, max, min := 0.0, 0.0, math.Inf(1)
+	for _, s := range row {
+		sum += s
+		max = math.Max(max, s)
+		min = math.Min(min, s)
+	}
+	if sum == 0 || min == 0 {
+		return math.Inf(1)
+	}
+
+	return math.Max(side*side*max/(sum*sum), sum*sum/(side*side*min))
+}
+
+// placeRow lays the row along the shorter side of the area and returns
+// the remaining area
+func placeRow(row []float64, area rect, cells []rect) rect {
+	sum := 0.0
+	for _, s := range row {
+		sum += s
+	}
+
+	if area.W >= area.H {
+		w := sum / area.H
+		y := area.Y
+		for i, s := range row {
+			cells[i] = rect{X: area.X, Y: y, W: w, H: s / w}
+			y += s / w
+		}
+		return rect{X: area.X + w, Y: area.Y, W: area.W - w, H: area.H}
+	}
+
+	h := sum / area.W
+	x := area.X
+	for i, s := range row {
+		cells[i] = rect{X: x, Y: area.Y, W: s / h, H: h}
+		x += s / h
+	}
+	return rect{X: area.X, Y: area.Y + h, W: area.W, H: area.H - h}
+}
+
+// treemapCells lays out the files in the default treemap area
+func treemapCells(heat []render.Heat) []rect {
+	values := make([]float64, 0, len(heat))
+	for _, h := range heat {
+		values = append(values, h.Score)
+	}
+
+	return layoutTreemap(values, rect{W: treemapWidth, H: treemapHeight})
+}
+
+// svgRenderer renders the heat as a standalone SVG treemap. The area of
+// a cell is proportional to the score and its color goes from yellow to
+// red with the risk index.
+type svgRenderer struct{}
+
+func init() {
+	render.Register("svg", svgRenderer{})
+}
+
+func (svgRenderer) Extension() string {
+	return "svg"
+}
+
+func (svgRenderer) Render(w io.Writer, view render.View) error {
+	heat := view.Files
+	cells := treemapCells(heat)
+
+	fmt.Fprintf(w, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" font-family="sans-serif" font-size="11">`+"\n",
+		treemapWidth, treemapHeight, treemapWidth, treemapHeight)
+	for i, h := range heat {
+		c := cells[i]
+		if c.W <= 0 || c.H <= 0 {
+			continue
+		}
+
+		repo, file := viewName(h)
+		name := file
+		if repo != "" {
+			name = repo + "/" + file
+		}
+
+		fmt.Fprintf(w, "<g><title>%s\nscore %.2f, risk %.1f, bugs %d, PRs %d, changes %d%s</title>",
+			svgText(name), h.Score, h.Risk, h.Bugs, h.PRs, h.Changes, seenSince(h))
+		fmt.Fprintf(w, `<rect x="%.1f" y="%.1f" width="%.1f" height="%.1f" fill="%s" stroke="#fff"/>`,
+			c.X, c.Y, c.W, c.H, heatHex(h.Risk))
+		if c.W > 40 && c.H > 14 {
+			fmt.Fprintf(w, `<text x="%.1f" y="%.1f" clip-path="inset(0)">%s</text>`,
+				c.X+3, c.Y+12, svgText(path.Base(file)))
+		}
+		fmt.Fprintln(w, "</g>")
+	}
+	_, err := fmt.Fprintln(w, "</svg>")
+
+	return err
+}
+
+// seenSince describes since when the file is hot
+func seenSince(h render.Heat) string {
+	if h.FirstSeen.IsZero() {
+		return ""
+	}
+
+	return fmt.Sprintf("\nhot since %s, last bug %s", h.FirstSeen.Format("2006-01-02"), h.LastSeen.Format("2006-01-02"))
+}
+
+// heatColor interpolates from yellow to red by the risk index
+func heatColor(risk float64) color.RGBA {
+	t := math.Max(0, math.Min(1, risk/100))
+	return color.RGBA{R: 255, G: uint8(220 * (1 - t)), B: uint8(80 * (1 - t)), A: 255}
+}
+
+// heatHex returns the heat color in the hex notation
+func heatHex(risk float64) string {
+	c := heatColor(risk)
+	return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+}
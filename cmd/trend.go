@@ -0,0 +1,181 @@
+package cmd
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+const (
+	defaultReportTZ        = "UTC"
+	defaultReportWeekStart = "monday"
+)
+
+// trendPoint represents the number of the bugs whose fixes touched a
+// file in a period
+type trendPoint struct {
+	Period string    `json:"period"`
+	Start  time.Time `json:"start"`
+	Repo   Repo      `json:"repo"`
+	File   string    `json:"file"`
+	Group  string    `json:"group,omitempty"`
+	Bugs   int       `json:"bugs"`
+}
+
+// trendCalendar represents the calendar the periods are aligned to
+type trendCalendar struct {
+	loc       *time.Location
+	weekStart time.Weekday
+}
+
+var (
+	reportTrend     string
+	reportTZ        string
+	reportWeekStart string
+)
+
+// reportCalendar returns the calendar of --tz and --week-start, which
+// default to the report.tz and report.week_start config keys
+func reportCalendar() (trendCalendar, error) {
+	viper.SetDefault("report.tz", defaultReportTZ)
+	viper.SetDefault("report.week_start", defaultReportWeekStart)
+
+	tz, weekStart := reportTZ, reportWeekStart
+	if tz == "" {
+		tz = viper.GetString("report.tz")
+	}
+	if weekStart == "" {
+		weekStart = viper.GetString("report.week_start")
+	}
+
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		return trendCalendar{}, fmt.Errorf("unknown time zone %q", tz)
+	}
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		if strings.EqualFold(d.String(), weekStart) {
+			return trendCalendar{loc: loc, weekStart: d}, nil
+		}
+	}
+
+	return trendCalendar{}, fmt.Errorf("unknown week start %q", weekStart)
+}
+
+// periodStart returns the start of the week or the month of t, at
+// midnight of the time zone of the calendar
+func (c trendCalendar) periodStart(t time.Time, period string) (time.Time, error) {
+	t = t.In(c.loc)
+	switch period {
+	case "week":
+		days := (int(t.Weekday()) - int(c.weekStart) + 7) % 7
+		return time.Date(t.Year(), t.Month(), t.Day()-days, 0, 0, 0, 0, c.loc), nil
+	case "month":
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, c.loc), nil
+	default:
+		return time.Time{}, fmt.Errorf("unknown trend period %q", period)
+	}
+}
+
+// periodName names the period starting at the given time
+func periodName(start time.Time, period string) string {
+	if period == "month" {
+		return start.Format("2006-01")
+	}
+
+	return start.Format("2006-01-02")
+}
+
+// heatTrend counts the bugs of every file by the period of the last time
+// their fixes touched it. The bugs of an unknown time are left out.
+func heatTrend(heat []fileHeat, period string, cal trendCalendar) ([]trendPoint, error) {
+	points := make([]trendPoint, 0)
+	for _, h := range heat {
+		byStart := make(map[time.Time]int)
+		for _, t := range h.bugs {
+			if t.IsZero() {
+				continue
+			}
+			start, err := cal.periodStart(t, period)
+			if err != nil {
+				return nil, err
+			}
+			byStart[start]++
+		}
+
+		for start, n := range byStart {
+			points = append(points, trendPoint{Period: periodName(start, period), Start: start, Repo: h.Repo, File: h.File, Group: h.Group, Bugs: n})
+		}
+	}
+
+	// The files keep the order of the report within a period
+	order := make(map[string]int, len(heat))
+	for i, h := range heat {
+		order[fileKey(h.Repo, h.File)+"#"+h.Group] = i
+	}
+	sort.SliceStable(points, func(i, j int) bool {
+		if !points[i].Start.Equal(points[j].Start) {
+			return points[i].Start.Before(points[j].Start)
+		}
+		return order[fileKey(points[i].Repo, points[i].File)+"#"+points[i].Group] < order[fileKey(points[j].Repo, points[j].File)+"#"+points[j].Group]
+	})
+
+	return points, nil
+}
+
+// trendWriters holds the writers of the trend in the report formats. The
+// unit names the column of the periods, e.g. period or release.
+var trendWriters = map[string]func(w io.Writer, unit string, points []trendPoint) error{
+	"table": writeTrendTable,
+	"json":  writeTrendJSON,
+	"csv":   writeTrendCSV,
+}
+
+func writeTrendTable(w io.Writer, unit string, points []trendPoint) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "%s\tBUGS\tREPO\tFILE\n", strings.ToUpper(unit))
+	for _, p := range points {
+		repo, file := heatName(fileHeat{Repo: p.Repo, File: p.File, Group: p.Group})
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%s\n", p.Period, p.Bugs, repo, file)
+	}
+
+	return tw.Flush()
+}
+
+func writeTrendJSON(w io.Writer, unit string, points []trendPoint) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+
+	return enc.Encode(points)
+}
+
+func writeTrendCSV(w io.Writer, unit string, points []trendPoint) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{unit, "start", "bugs", "owner", "repo", "file", "group"})
+	for _, p := range points {
+		// The releases not released yet have no start
+		start := ""
+		if !p.Start.IsZero() {
+			start = p.Start.Format(time.RFC3339)
+		}
+		cw.Write([]string{
+			p.Period,
+			start,
+			strconv.Itoa(p.Bugs),
+			p.Repo.Owner,
+			p.Repo.Name,
+			p.File,
+			p.Group,
+		})
+	}
+	cw.Flush()
+
+	return cw.Error()
+}
@@ -0,0 +1,491 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"os"
+	"os/exec"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"golang.org/x/term"
+)
+
+// tuiCmd represents the tui command
+var tuiCmd = &cobra.Command{
+	Use:   "tui",
+	Short: "Browses the heat in the terminal",
+	Long: `Opens an interactive browser of the heat in the terminal: the
+repos and their directories, colored by the highest risk of their
+files and ordered by the sum of their scores. A file opens the list
+of the bugs and the PRs which heated it up; Enter on one of them
+opens its page in Jira or on the provider in the browser, and its
+URL is shown at the bottom.
+
+  up/down, k/j   move
+  right, l       open the directory or the file
+  left, h        go back up
+  Enter          open the directory, the file, or the page of the
+                 bug or the PR
+  PgUp/PgDn      move by a page
+  q, Ctrl-C      quit
+
+The heat is computed like the one of report, with its config, e.g.
+heat.priority_weights. It needs a terminal.`,
+	Args: cobra.NoArgs,
+	RunE: runTUI,
+}
+
+func init() {
+	rootCmd.AddCommand(tuiCmd)
+}
+
+// tuiNode represents a repo, a directory or a file of the browser
+type tuiNode struct {
+	name     string
+	parent   *tuiNode
+	children []*tuiNode
+	file     *fileHeat
+	score    float64
+	risk     float64
+}
+
+func (n *tuiNode) child(name string) *tuiNode {
+	for _, c := range n.children {
+		if c.name == name {
+			return c
+		}
+	}
+	c := &tuiNode{name: name, parent: n}
+	n.children = append(n.children, c)
+
+	return c
+}
+
+// path returns the names from the root to the node
+func (n *tuiNode) path() string {
+	names := make([]string, 0)
+	for ; n.parent != nil; n = n.parent {
+		names = append([]string{n.name}, names...)
+	}
+
+	return strings.Join(names, "/")
+}
+
+// buildTUITree adds every file under its repo and its directories. The
+// directories sum the scores and take the highest risk of their files.
+func buildTUITree(heat []fileHeat) *tuiNode {
+	root := &tuiNode{}
+	for i := range heat {
+		h := &heat[i]
+		n := root.child(h.Repo.Owner + "/" + h.Repo.Name)
+		for _, segment := range strings.Split(h.File, "/") {
+			n = n.child(segment)
+		}
+		n.file = h
+		for ; n != nil; n = n.parent {
+			n.score += h.Score
+			n.risk = math.Max(n.risk, h.Risk)
+		}
+	}
+	sortTUITree(root)
+
+	return root
+}
+
+func sortTUITree(n *tuiNode) {
+	sort.Slice(n.children, func(i, j int) bool {
+		if n.children[i].score != n.children[j].score {
+			return n.children[i].score > n.children[j].score
+		}
+		return n.children[i].name < n.children[j].name
+	})
+	for _, c := range n.children {
+		sortTUITree(c)
+	}
+}
+
+// tuiItem represents a bug or a PR of the detail of a file
+type tuiItem struct {
+	label string
+	url   string
+}
+
+// tuiLinks builds the items of the bugs and the PRs of the files
+type tuiLinks struct {
+	issues   map[string]mongoMapping
+	provider string
+}
+
+func newTUILinks(mappings []mongoMapping) tuiLinks {
+	viper.SetDefault("vcs.provider", defaultVCSProvider)
+
+	l := tuiLinks{
+		issues:   make(map[string]mongoMapping, len(mappings)),
+		provider: viper.GetString("vcs.provider"),
+	}
+	for _, m := range mappings {
+		l.issues[m.bugKey()] = m
+	}
+
+	return l
+}
+
+// issueURL returns the page of the bug in its tracker
+func (l tuiLinks) issueURL(m mongoMapping) string {
+	switch m.Tracker {
+	case "azure":
+		viper.SetDefault("azure.host", defaultAzureHost)
+		return fmt.Sprintf("%s/%s/%s/_workitems/edit/%d", strings.TrimSuffix(viper.GetString("azure.host"), "/"), viper.GetString("azure.organization"), m.Project, m.IssueID)
+	case "linear":
+		if m.IssueKey == "" || viper.GetString("linear.workspace") == "" {
+			return ""
+		}
+		return fmt.Sprintf("https://linear.app/%s/issue/%s", viper.GetString("linear.workspace"), m.IssueKey)
+	default:
+		if m.IssueKey == "" || viper.GetString("jira.host") == "" {
+			return ""
+		}
+		return strings.TrimSuffix(viper.GetString("jira.host"), "/") + "/browse/" + m.IssueKey
+	}
+}
+
+// prURL returns the page of the PR on the provider
+func (l tuiLinks) prURL(repo Repo, id int) string {
+	switch l.provider {
+	case "gitlab":
+		viper.SetDefault("gitlab.host", defaultGitLabHost)
+		return fmt.Sprintf("%s/%s/%s/-/merge_requests/%d", strings.TrimSuffix(viper.GetString("gitlab.host"), "/"), repo.Owner, repo.Name, id)
+	case "bitbucket":
+		return fmt.Sprintf("https://bitbucket.org/%s/%s/pull-requests/%d", repo.Owner, repo.Name, id)
+	default:
+		return githubPRPage(repo, id)
+	}
+}
+
+// items returns the bugs of the file, the heaviest first, and its PRs
+func (l tuiLinks) items(h fileHeat) []tuiItem {
+	bugs := make([]string, 0, len(h.bugs))
+	for b := range h.bugs {
+		bugs = append(bugs, b)
+	}
+	sort.Slice(bugs, func(i, j int) bool {
+		if h.weight(bugs[i]) != h.weight(bugs[j]) {
+			return h.weight(bugs[i]) > h.weight(bugs[j])
+		}
+		return bugs[i] < bugs[j]
+	})
+
+	items := make([]tuiItem, 0, len(h.bugs)+len(h.prs))
+	for _, b := range bugs {
+		m, ok := l.issues[b]
+		if !ok {
+			items = append(items, tuiItem{label: "bug " + b})
+			continue
+		}
+		key := m.IssueKey
+		if key == "" {
+			key = strconv.Itoa(m.IssueID)
+		}
+		label := fmt.Sprintf("bug %s", key)
+		if m.Priority != "" {
+			label += " [" + m.Priority + "]"
+		}
+		if m.Summary != "" {
+			label += " " + m.Summary
+		}
+		items = append(items, tuiItem{label: label, url: l.issueURL(m)})
+	}
+
+	prs := make([]string, 0, len(h.prs))
+	for p := range h.prs {
+		prs = append(prs, p)
+	}
+	sort.Strings(prs)
+	for _, p := range prs {
+		item := tuiItem{label: "PR  " + p}
+		if repo, id, err := parsePRKey(p); err == nil {
+			item.url = l.prURL(repo, id)
+		}
+		items = append(items, item)
+	}
+
+	return items
+}
+
+// tuiState represents the screen of the browser: the node whose children
+// are listed or, for a file, its bugs and PRs
+type tuiState struct {
+	root   *tuiNode
+	node   *tuiNode
+	links  tuiLinks
+	items  []tuiItem
+	cursor map[*tuiNode]int
+	status string
+}
+
+func (s *tuiState) rows() int {
+	if s.node.file != nil {
+		return len(s.items)
+	}
+
+	return len(s.node.children)
+}
+
+func (s *tuiState) move(delta int) {
+	c := s.cursor[s.node] + delta
+	if c >= s.rows() {
+		c = s.rows() - 1
+	}
+	if c < 0 {
+		c = 0
+	}
+	s.cursor[s.node] = c
+}
+
+func (s *tuiState) open() {
+	c := s.cursor[s.node]
+	if s.node.file != nil {
+		if c < len(s.items) {
+			s.openURL(s.items[c].url)
+		}
+		return
+	}
+	if c < len(s.node.children) {
+		s.node = s.node.children[c]
+		if s.node.file != nil {
+			s.items = s.links.items(*s.node.file)
+		}
+		s.status = ""
+	}
+}
+
+func (s *tuiState) back() {
+	if s.node.parent != nil {
+		s.node = s.node.parent
+		s.status = ""
+	}
+}
+
+// openURL opens the page in the browser and shows its URL, which most
+// terminals make clickable
+func (s *tuiState) openURL(url string) {
+	if url == "" {
+		s.status = "no link, set jira.host for the bugs"
+		return
+	}
+
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("open", url)
+	case "windows":
+		cmd = exec.Command("rundll32", "url.dll,FileProtocolHandler", url)
+	default:
+		cmd = exec.Command("xdg-open", url)
+	}
+	if err := cmd.Start(); err != nil {
+		s.status = url
+		return
+	}
+	go cmd.Wait()
+	s.status = "opened " + url
+}
+
+// render draws the screen of the given size
+func (s *tuiState) render(w *bufio.Writer, width, height int) {
+	w.WriteString("\x1b[H\x1b[2J")
+
+	title := "heatmap"
+	if p := s.node.path(); p != "" {
+		title += "  " + p
+	}
+	if f := s.node.file; f != nil {
+		title += fmt.Sprintf("  score %.2f, risk %.1f, %d bugs, %d PRs, %d changes", f.Score, f.Risk, f.Bugs, f.PRs, f.Changes)
+	}
+	writeTUILine(w, "\x1b[1m"+tuiCut(title, width)+"\x1b[0m")
+
+	// The title and the two lines of the footer are always shown
+	page := height - 3
+	if page < 1 {
+		page = 1
+	}
+	c := s.cursor[s.node]
+	first := 0
+	if c >= page {
+		first = c - page + 1
+	}
+
+	for i := first; i < s.rows() && i < first+page; i++ {
+		line := ""
+		if s.node.file != nil {
+			line = tuiCut("  "+s.items[i].label, width)
+		} else {
+			n := s.node.children[i]
+			name := n.name
+			if n.file == nil {
+				name += "/"
+			}
+			col := heatColor(n.risk)
+			line = fmt.Sprintf("\x1b[38;2;%d;%d;%dm%s\x1b[39m %9.2f  %s", col.R, col.G, col.B, "██", n.score, tuiCut(name, width-15))
+		}
+		if i == c {
+			line = "\x1b[7m" + line + "\x1b[27m"
+		}
+		writeTUILine(w, line)
+	}
+	if s.rows() == 0 {
+		writeTUILine(w, "  (empty)")
+	}
+
+	w.WriteString(fmt.Sprintf("\x1b[%d;1H", height-1))
+	writeTUILine(w, "\x1b[2m"+tuiCut("up/down move  right open  left back  Enter open the page  q quit", width)+"\x1b[0m")
+	w.WriteString(tuiCut(s.status, width))
+	w.Flush()
+}
+
+func writeTUILine(w *bufio.Writer, line string) {
+	w.WriteString(line)
+	w.WriteString("\x1b[K\r\n")
+}
+
+// tuiCut cuts the text to the width of the screen in columns, ending it
+// with an ellipsis. A wide character which doesn't fit whole is left out.
+func tuiCut(s string, width int) string {
+	if width <= 0 || displayWidth(s) <= width {
+		return s
+	}
+
+	w := 0
+	for i, r := range s {
+		if w+runeWidth(r) > width-1 {
+			return s[:i] + "…"
+		}
+		w += runeWidth(r)
+	}
+
+	return s
+}
+
+// readTUIKey reads a key press and names the keys of the escape
+// sequences
+func readTUIKey(in *bufio.Reader) (string, error) {
+	b, err := in.ReadByte()
+	if err != nil {
+		return "", err
+	}
+	switch b {
+	case 3:
+		return "q", nil
+	case '\r', '\n':
+		return "enter", nil
+	case 127, 8:
+		return "left", nil
+	case 27:
+	default:
+		return string(b), nil
+	}
+
+	// An escape alone or a CSI sequence, e.g. ESC [ A
+	if in.Buffered() == 0 {
+		return "esc", nil
+	}
+	if b, _ := in.ReadByte(); b != '[' && b != 'O' {
+		return "esc", nil
+	}
+	seq := ""
+	for {
+		b, err := in.ReadByte()
+		if err != nil {
+			return "", err
+		}
+		seq += string(b)
+		if b >= 0x40 && b <= 0x7e {
+			break
+		}
+	}
+	keys := map[string]string{"A": "up", "B": "down", "C": "right", "D": "left", "5~": "pgup", "6~": "pgdn", "H": "home", "F": "end"}
+
+	return keys[seq], nil
+}
+
+func runTUI(cmd *cobra.Command, args []string) error {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) || !term.IsTerminal(int(os.Stdout.Fd())) {
+		return configError(fmt.Errorf("tui needs a terminal, use report for the output of a pipe"))
+	}
+
+	ctx, cancel, st, err := openReadStore()
+	if err != nil {
+		return err
+	}
+	defer cancel()
+	defer closeStore(ctx, st)
+
+	heat, _, err := loadHeat(ctx, st)
+	if err != nil {
+		return err
+	}
+	mappings, err := st.Mappings(ctx)
+	if err != nil {
+		return storageError(fmt.Errorf("reading mappings failed: %w", err))
+	}
+
+	root := buildTUITree(heat)
+	s := &tuiState{root: root, node: root, links: newTUILinks(mappings), cursor: make(map[*tuiNode]int)}
+
+	state, err := term.MakeRaw(fd)
+	if err != nil {
+		return fmt.Errorf("switching the terminal to raw mode failed: %w", err)
+	}
+	out := bufio.NewWriter(os.Stdout)
+	// The alternate screen keeps the scrollback of the shell
+	out.WriteString("\x1b[?1049h\x1b[?25l")
+	defer func() {
+		out.WriteString("\x1b[?25h\x1b[?1049l")
+		out.Flush()
+		term.Restore(fd, state)
+	}()
+
+	in := bufio.NewReader(os.Stdin)
+	for {
+		width, height, err := term.GetSize(int(os.Stdout.Fd()))
+		if err != nil {
+			width, height = 80, 24
+		}
+		s.render(out, width, height)
+
+		key, err := readTUIKey(in)
+		if err != nil {
+			return err
+		}
+		switch key {
+		case "q":
+			return nil
+		case "up", "k":
+			s.move(-1)
+		case "down", "j":
+			s.move(1)
+		case "pgup":
+			s.move(-(height - 3))
+		case "pgdn":
+			s.move(height - 3)
+		case "home", "g":
+			s.move(-s.rows())
+		case "end", "G":
+			s.move(s.rows())
+		case "right", "l", "enter":
+			if key != "enter" && s.node.file != nil {
+				break
+			}
+			s.open()
+		case "left", "h", "esc":
+			s.back()
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package cmd
+
+import "testing"
+
+func TestTUICut(t *testing.T) {
+	tests := []struct {
+		name  string
+		s     string
+		width int
+		want  string
+	}{
+		{"fits", "main.go", 10, "main.go"},
+		{"exact", "main.go", 7, "main.go"},
+		{"ascii", "internal/main.go", 8, "interna…"},
+		{"no width", "internal/main.go", 0, "internal/main.go"},
+		{"cjk fits", "文档说明", 8, "文档说明"},
+		{"cjk", "文档说明文档", 7, "文档说…"},
+		{"cjk straddling", "文档说明文档", 6, "文档…"},
+		{"emoji", "🔥🔥🔥🔥", 5, "🔥🔥…"},
+		{"emoji straddling", "a🔥🔥🔥", 5, "a🔥…"},
+		{"combining mark kept", "cafe\u0301s", 5, "cafe\u0301s"},
+		{"combining mark", "cafe\u0301-bar", 5, "cafe\u0301…"},
+		{"width of one", "main.go", 1, "…"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tuiCut(tt.s, tt.width)
+			if got != tt.want {
+				t.Errorf("tuiCut(%q, %d) = %q, want %q", tt.s, tt.width, got, tt.want)
+			}
+			if tt.width > 0 && displayWidth(got) > tt.width {
+				t.Errorf("tuiCut(%q, %d) is %d columns wide", tt.s, tt.width, displayWidth(got))
+			}
+		})
+	}
+}
@@ -0,0 +1,204 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/github"
+	"github.com/spf13/viper"
+)
+
+const defaultVCSProvider = "github"
+
+// vcsProvider represents a version control system hosting the PRs
+// that are linked to the Jira issues
+type vcsProvider interface {
+	// applicationType returns the Jira dev-status application type
+	applicationType() string
+	// parsePR extracts the repo and the PR number of a dev-status PR
+	parsePR(p jiraPR) (Repo, int, error)
+	// listFiles returns the diff of every file changed by a PR
+	listFiles(ctx context.Context, repo Repo, id int) ([]diff, error)
+	// info returns the author, the merge time and the base branch of a PR
+	info(ctx context.Context, repo Repo, id int) (prInfo, error)
+	// usage returns the API usage observed so far
+	usage() apiUsage
+}
+
+// apiUsage represents the number of requests made to a provider's API
+// and its last reported rate limit
+type apiUsage struct {
+	Requests  int
+	Remaining int
+	Limit     int
+}
+
+// prInfo represents the details of a PR besides its diff
+type prInfo struct {
+	Author     string
+	MergedAt   time.Time
+	BaseBranch string
+}
+
+// deploymentLister is implemented by the providers which know the
+// deployments of the repos
+type deploymentLister interface {
+	// deployments returns the successful deployments of a repo to an
+	// environment, from the oldest one
+	deployments(ctx context.Context, repo Repo, environment string) ([]deployment, error)
+}
+
+// commitLister is implemented by the providers which list the commits of
+// the PRs
+type commitLister interface {
+	// commits returns the commits of a PR with their full messages
+	commits(ctx context.Context, repo Repo, id int) ([]prCommit, error)
+	// commitFiles returns the diff of every file changed by a commit
+	commitFiles(ctx context.Context, repo Repo, sha string) ([]diff, error)
+}
+
+// prSearcher is implemented by the providers which search the PRs by
+// the issue keys they mention
+type prSearcher interface {
+	// searchPRs returns the merged PRs within the scope of the search
+	// qualifiers, e.g. org:acme, whose title or body or, with commits set,
+	// one of whose commits mentions the key
+	searchPRs(ctx context.Context, key string, scope []string, commits bool) ([]jiraPR, error)
+}
+
+// pinger is implemented by the services whose connectivity and
+// credentials status checks
+type pinger interface {
+	// ping sends the cheapest authenticated request of the API
+	ping(ctx context.Context) error
+}
+
+// codeownersReader is implemented by the providers which read the
+// CODEOWNERS of the repos
+type codeownersReader interface {
+	// codeowners returns the first CODEOWNERS of codeownersPaths in the
+	// default branch of the repo, nil if there's none
+	codeowners(ctx context.Context, repo Repo) ([]byte, error)
+}
+
+// repoDescriber is implemented by the providers reading the metadata of
+// the repos
+type repoDescriber interface {
+	// describeRepo returns the topics, the languages and the archived
+	// state of the repo
+	describeRepo(ctx context.Context, repo Repo) (repoMeta, error)
+}
+
+// fileReader is implemented by the providers reading the files of the
+// repos as of a commit
+type fileReader interface {
+	// headSHA returns the head commit of the PR
+	headSHA(ctx context.Context, repo Repo, id int) (string, error)
+	// fileAt returns the file as of the commit, nil if it's not found
+	fileAt(ctx context.Context, repo Repo, sha, file string) ([]byte, error)
+}
+
+// patchFetcher is implemented by the providers which return the unified
+// diff of a whole PR, for the files listed without their patches
+type patchFetcher interface {
+	// patch returns the unified diff of the PR
+	patch(ctx context.Context, repo Repo, id int) (string, error)
+}
+
+// deployment represents a successful deployment of a commit
+type deployment struct {
+	SHA        string
+	DeployedAt time.Time
+}
+
+// prNumberPattern matches the number at the end of the ID of a
+// dev-status PR, e.g. #123, !123, PR-123 or a plain 123
+var prNumberPattern = regexp.MustCompile(`([0-9]+)$`)
+
+// prNumber returns the number of a PR parsed from its URL, checking it
+// against the number of its dev-status ID. The providers prefix the IDs
+// differently, so only their numbers are compared; an ID without one
+// isn't checked.
+func prNumber(p jiraPR, fromURL string) (int, error) {
+	id, err := strconv.Atoi(fromURL)
+	if err != nil || id <= 0 {
+		return 0, fmt.Errorf("invalid PR number %q in %s", fromURL, p.URL)
+	}
+
+	if m := prNumberPattern.FindStringSubmatch(strings.TrimSpace(p.ID)); m != nil {
+		if n, err := strconv.Atoi(m[1]); err != nil || n != id {
+			return 0, fmt.Errorf("PR ID %q doesn't match the number %d of %s", p.ID, id, p.URL)
+		}
+	}
+
+	return id, nil
+}
+
+// newVCSProvider creates the provider selected by the vcs.provider config key
+func newVCSProvider(ctx context.Context) (vcsProvider, error) {
+	viper.SetDefault("vcs.provider", defaultVCSProvider)
+
+	switch name := viper.GetString("vcs.provider"); name {
+	case "github":
+		return newGitHubProvider(ctx)
+	case "gitlab":
+		return newGitLabProvider(), nil
+	case "bitbucket":
+		return newBitbucketProvider(), nil
+	default:
+		return nil, fmt.Errorf("unknown vcs provider %q", name)
+	}
+}
+
+// The fetch statuses of the PRs which can't be read with the credentials
+const (
+	fetchForbidden = "forbidden"
+	fetchNotFound  = "not_found"
+)
+
+// statusError represents an unexpected status of a response of a provider
+type statusError struct {
+	provider string
+	code     int
+	status   string
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("%s responded with %s", e.provider, e.status)
+}
+
+// responseError returns the error of an unexpected status of the response
+func responseError(provider string, resp *http.Response) error {
+	return &statusError{provider: provider, code: resp.StatusCode, status: resp.Status}
+}
+
+// fetchStatus returns the fetch status of a PR whose request failed with
+// the error: forbidden if the repo is private to the credentials,
+// not_found if it's deleted, else none. The rate limits aren't
+// forbidden, GitHub reports them with their own errors.
+func fetchStatus(err error) string {
+	code := 0
+	var gh *github.ErrorResponse
+	var se *statusError
+	switch {
+	case errors.As(err, &gh) && gh.Response != nil:
+		code = gh.Response.StatusCode
+	case errors.As(err, &se):
+		code = se.code
+	}
+
+	switch code {
+	case http.StatusForbidden:
+		return fetchForbidden
+	case http.StatusNotFound, http.StatusGone:
+		return fetchNotFound
+	}
+
+	return ""
+}
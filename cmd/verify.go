@@ -0,0 +1,168 @@
+package cmd
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// verifyCmd represents the verify-idempotency command
+var verifyCmd = &cobra.Command{
+	Use:   "verify-idempotency",
+	Short: "Checks that a second run of the pipeline writes nothing",
+	Long: `Runs backfill and collectDiffs twice, like sync, and fails with
+the exit code of a failed gate if the second run writes any
+mapping or diff or if the contents of the collections differ
+after it. It's a safety check after changing the storage or the
+pipeline, best run against a staging Jira project.
+
+The runs keep the data in memory, like --ephemeral, unless
+--in-place is set; then the configured store is used and its
+data takes part in the check. The moves of the watermarks don't
+count as writes, since every run moves them.`,
+	RunE: verifyIdempotency,
+}
+
+var verifyInPlace bool
+
+// verifiedCollections are the collections the pipeline writes
+var verifiedCollections = []string{"mappings", "prs"}
+
+func init() {
+	rootCmd.AddCommand(verifyCmd)
+	verifyCmd.Flags().StringVarP(&jiraProject, "project", "p", "Memberships", "Jira project name or a comma separated list of names")
+	verifyCmd.Flags().IntVar(&concurrency, "concurrency", defaultConcurrency, "number of parallel dev-status requests and of PRs fetched in parallel")
+	verifyCmd.Flags().BoolVar(&full, "full", false, "check all bugs in both runs, ignoring the watermarks")
+	verifyCmd.Flags().BoolVar(&verifyInPlace, "in-place", false, "run against the configured store instead of an empty one in memory")
+}
+
+// countingStore counts the documents written through it
+type countingStore struct {
+	store
+
+	mu         sync.Mutex
+	written    map[string]int
+	watermarks int
+}
+
+func newCountingStore(st store) *countingStore {
+	return &countingStore{store: st, written: make(map[string]int)}
+}
+
+func (s *countingStore) count(collection string, n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.written[collection] += n
+}
+
+// reset returns the counts so far and starts over
+func (s *countingStore) reset() (map[string]int, int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	written, watermarks := s.written, s.watermarks
+	s.written, s.watermarks = make(map[string]int), 0
+
+	return written, watermarks
+}
+
+func (s *countingStore) InsertMappings(ctx context.Context, mappings []mongoMapping) error {
+	s.count("mappings", len(mappings))
+	return s.store.InsertMappings(ctx, mappings)
+}
+
+func (s *countingStore) InsertPRs(ctx context.Context, prs []pr) error {
+	s.count("prs", len(prs))
+	return s.store.InsertPRs(ctx, prs)
+}
+
+func (s *countingStore) SetWatermark(ctx context.Context, project string, t time.Time) error {
+	s.mu.Lock()
+	s.watermarks++
+	s.mu.Unlock()
+
+	return s.store.SetWatermark(ctx, project, t)
+}
+
+// collectionDigest hashes the documents of the collection in their
+// stable export order and returns the hash and their number
+func collectionDigest(ctx context.Context, st store, collection string) (string, int, error) {
+	h := sha256.New()
+	n := 0
+	err := st.Export(ctx, collection, "", func(doc []byte, token string) error {
+		h.Write(doc)
+		h.Write([]byte{'\n'})
+		n++
+		return nil
+	})
+	if err != nil {
+		return "", 0, storageError(fmt.Errorf("reading %s failed: %w", collection, err))
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), n, nil
+}
+
+func verifyIdempotency(cmd *cobra.Command, args []string) error {
+	if !verifyInPlace {
+		ephemeral = true
+	}
+
+	ctx, cancel, backend, err := openStore()
+	if err != nil {
+		return err
+	}
+	defer cancel()
+	defer closeStore(ctx, backend)
+
+	st := newCountingStore(backend)
+	projects := backfillProjects(cmd)
+	digests := make([]map[string]string, 2)
+	for run := range digests {
+		name := fmt.Sprintf("run %d", run+1)
+		if err := syncStage(name+" backfill", func() (int, error) { return runBackfill(ctx, st, projects) }, "new_mappings"); err != nil {
+			return err
+		}
+		if err := syncStage(name+" collectDiffs", func() (int, error) { return runCollectDiffs(ctx, st) }, "new_prs"); err != nil {
+			return err
+		}
+
+		digests[run] = make(map[string]string)
+		for _, c := range verifiedCollections {
+			digest, n, err := collectionDigest(ctx, st, c)
+			if err != nil {
+				return err
+			}
+			digests[run][c] = digest
+			slog.Info("collection digested", "run", run+1, "collection", c, "docs", n, "sha256", digest[:12])
+		}
+
+		written, watermarks := st.reset()
+		if run == 0 {
+			continue
+		}
+
+		problems := make([]string, 0)
+		for _, c := range verifiedCollections {
+			if written[c] > 0 {
+				problems = append(problems, fmt.Sprintf("%d %s written", written[c], c))
+			}
+			if digests[0][c] != digests[1][c] {
+				problems = append(problems, fmt.Sprintf("%s changed", c))
+			}
+		}
+		if len(problems) > 0 {
+			return gateError(fmt.Errorf("the second run isn't idempotent: %s", strings.Join(problems, ", ")))
+		}
+		slog.Info("the second run wrote nothing", "watermarks_moved", watermarks)
+	}
+
+	return nil
+}
@@ -0,0 +1,118 @@
+package cmd
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"rdlf0/heatmap/render"
+)
+
+// visualizeCmd represents the visualize command
+var visualizeCmd = &cobra.Command{
+	Use:   "visualize",
+	Short: "Renders the bug heat into a file",
+	Long: `Computes the heat like the report command and renders the
+hottest files in the format of --format, by default the one of
+the extension of --output:
+  svg  a standalone treemap; the area of a cell is proportional
+       to the score of the file and its color to the risk index
+  png  the same treemap as an image, without the labels
+  dot, mermaid
+       a Graphviz or Mermaid graph of the directories of --depth
+       segments, linked by the number of PRs changing both
+  html the page of report --format html
+The file can be embedded in wikis or kept as a CI artifact. Further
+formats can be registered by the packages built into heatmap, see
+the render package.`,
+	RunE: visualize,
+}
+
+var (
+	visualizeOutput string
+	visualizeFormat string
+	visualizeTop    int
+)
+
+const (
+	defaultVisualizeFormat = "svg"
+	defaultVisualizeTop    = 100
+)
+
+func init() {
+	rootCmd.AddCommand(visualizeCmd)
+	visualizeCmd.Flags().StringVarP(&visualizeOutput, "output", "o", "", "file to write to (default is heatmap.<extension of the format>)")
+	visualizeCmd.Flags().StringVar(&visualizeFormat, "format", "", "output format, one of the registered renderers (default is the one of the extension of --output or svg)")
+	visualizeCmd.Flags().IntVar(&visualizeTop, "top", defaultVisualizeTop, "number of files to render (0 renders all)")
+	visualizeCmd.Flags().StringVar(&reportGroup, "group-by", "file", "unit of the treemap: file, dir, team or branch")
+	visualizeCmd.Flags().IntVar(&reportDepth, "depth", defaultReportDepth, "number of leading path segments of the directory buckets of --group-by dir")
+	visualizeCmd.Flags().StringVar(&reportHalfLife, "half-life", "", "decay the weight of a bug by half every period since its fix, e.g. 90d")
+	visualizeCmd.Flags().StringSliceVar(&reportBranch, "branch", nil, "only count the PRs merged into the base branches matching these patterns, e.g. release/*")
+	issueFilterFlags(visualizeCmd)
+}
+
+func visualize(cmd *cobra.Command, args []string) error {
+	r, output, err := visualizeRenderer()
+	if err != nil {
+		return configError(err)
+	}
+
+	ctx, cancel, st, err := openReadStore()
+	if err != nil {
+		return err
+	}
+	defer cancel()
+	defer closeStore(ctx, st)
+
+	heat, prs, err := loadHeat(ctx, st)
+	if err != nil {
+		return err
+	}
+	if visualizeTop > 0 && len(heat) > visualizeTop {
+		heat = heat[:visualizeTop]
+	}
+
+	f, err := os.Create(output)
+	if err != nil {
+		return err
+	}
+
+	if err := r.Render(f, newHeatView(heat, prs)); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	slog.Info("heat rendered", "files", len(heat), "output", output)
+
+	return nil
+}
+
+// visualizeRenderer resolves the renderer and the output file of the flags
+func visualizeRenderer() (render.Renderer, string, error) {
+	format := visualizeFormat
+	if format == "" {
+		format = strings.TrimPrefix(filepath.Ext(visualizeOutput), ".")
+		if _, ok := render.Lookup(format); !ok {
+			format = defaultVisualizeFormat
+		}
+	}
+
+	r, ok := render.Lookup(format)
+	if !ok {
+		return nil, "", fmt.Errorf("unknown format %q, use one of %s", format, strings.Join(render.Names(), ", "))
+	}
+
+	output := visualizeOutput
+	if output == "" {
+		output = "heatmap." + r.Extension()
+	}
+
+	return r, output, nil
+}
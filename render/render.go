@@ -0,0 +1,188 @@
+// Package render holds the output formats of the visualize command and of
+// report --format html. A format implements Renderer and registers itself
+// under its name from an init function, e.g.
+//
+//	func init() {
+//		render.Register("csv-tree", csvTree{})
+//	}
+//
+// so a build of heatmap importing the package of the format offers it
+// without any change to the commands.
+package render
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Renderer represents an output format
+type Renderer interface {
+	// Extension returns the extension of the rendered files
+	Extension() string
+	// Render writes the visualization of the heat to w
+	Render(w io.Writer, view View) error
+}
+
+// View represents the input of the renderers: the files sorted from the
+// hottest one, the same files aggregated into a tree of repos and
+// directories, and the PRs they were computed from. Grouping and Depth
+// are the ones of the --group-by and --depth flags.
+type View struct {
+	Files    []Heat
+	Tree     *Node
+	PRs      []PR
+	Grouping string
+	Depth    int
+}
+
+// Repo represents a pair of a repo owner (or GitLab namespace) and name
+type Repo struct {
+	Owner string
+	Name  string
+}
+
+// Heat represents the heat of a file, or of a group of files if Group is
+// set
+type Heat struct {
+	Repo        Repo
+	File        string
+	Group       string
+	Bugs        int
+	PRs         int
+	Additions   int
+	Deletions   int
+	Changes     int
+	Score       float64
+	Risk        float64
+	SLABreaches int
+
+	// FirstSeen and LastSeen are the times the fixes first and last
+	// touched the file, zero if they're not known
+	FirstSeen time.Time
+	LastSeen  time.Time
+
+	// Historical is set for the files of the archived repos
+	Historical bool
+	// AcceptedRisk is the note of the accepted risk of the file
+	AcceptedRisk string
+
+	// Events holds the fixes of the bugs touching the file
+	Events []Event
+	// CreateURL pre-fills a tech debt ticket of the file, if
+	// report.tech_debt is set
+	CreateURL string
+}
+
+// Event represents a fix of a bug touching a file
+type Event struct {
+	Time     time.Time
+	Issue    string
+	PR       string
+	Lines    int
+	Releases []Release
+}
+
+// Release represents a fix version of a bug, Released being zero until
+// it's released
+type Release struct {
+	Name     string
+	Released time.Time
+}
+
+// PR represents a PR the heat was computed from, with the files it
+// changed
+type PR struct {
+	Repo  Repo
+	Files []string
+}
+
+// Node represents a repo, a directory or a file of the heat tree. The
+// metrics of a directory are the sums of its files, so a bug fixed in
+// several of them counts several times; its risk is the highest one.
+type Node struct {
+	Name     string
+	Path     string
+	Heat     Heat
+	Children []*Node
+}
+
+// renderers holds the registered output formats by name
+var renderers = map[string]Renderer{}
+
+// Register adds an output format, panicking on a duplicate name
+func Register(name string, r Renderer) {
+	if _, ok := renderers[name]; ok {
+		panic(fmt.Sprintf("renderer %q registered twice", name))
+	}
+	renderers[name] = r
+}
+
+// Lookup returns the format of the name
+func Lookup(name string) (Renderer, bool) {
+	r, ok := renderers[name]
+
+	return r, ok
+}
+
+// Names returns the sorted names of the registered formats
+func Names() []string {
+	names := make([]string, 0, len(renderers))
+	for name := range renderers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// NewView builds the tree of the files. The groups of a grouped report
+// are the leaves of the root.
+func NewView(files []Heat, prs []PR, grouping string, depth int) View {
+	root := &Node{}
+	for _, h := range files {
+		var segments []string
+		if h.Group != "" {
+			segments = []string{h.Group}
+		} else {
+			segments = append([]string{h.Repo.Owner + "/" + h.Repo.Name}, strings.Split(h.File, "/")...)
+		}
+
+		node := root
+		node.add(h)
+		for i, s := range segments {
+			node = node.child(s, strings.Join(segments[:i+1], "/"))
+			node.add(h)
+		}
+		node.Heat = h
+	}
+
+	return View{Files: files, Tree: root, PRs: prs, Grouping: grouping, Depth: depth}
+}
+
+func (n *Node) child(name, path string) *Node {
+	for _, c := range n.Children {
+		if c.Name == name {
+			return c
+		}
+	}
+
+	c := &Node{Name: name, Path: path}
+	n.Children = append(n.Children, c)
+
+	return c
+}
+
+func (n *Node) add(h Heat) {
+	n.Heat.Bugs += h.Bugs
+	n.Heat.PRs += h.PRs
+	n.Heat.Additions += h.Additions
+	n.Heat.Deletions += h.Deletions
+	n.Heat.Changes += h.Changes
+	n.Heat.Score += h.Score
+	if h.Risk > n.Heat.Risk {
+		n.Heat.Risk = h.Risk
+	}
+}